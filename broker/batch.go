@@ -0,0 +1,261 @@
+package broker
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Defaults of the batching limits of a batch subscription.
+const (
+	// DefaultBatchSize is the batch size of subscriptions that do not set one
+	// with BatchSize.
+	DefaultBatchSize = 100
+	// DefaultBatchLinger is the batch linger of subscriptions that do not set
+	// one with BatchLinger.
+	DefaultBatchLinger = 100 * time.Millisecond
+)
+
+type (
+	// BatchHandler is the signature of a batch subscription handler.
+	BatchHandler[T any] func([]Event[T]) error
+
+	// BatchPublisher is implemented by brokers that publish several messages
+	// in one round trip. Use PublishBatch to publish through any Broker.
+	BatchPublisher[T any] interface {
+		// PublishBatch sends the messages ms to topic. The options apply to
+		// every message; each message still gets its own HeaderMessageID
+		// unless one is set by the options.
+		PublishBatch(ctx context.Context, topic string, ms []*T, opts ...PublishOption) error
+	}
+
+	// BatchSubscriber is implemented by brokers that consume messages in
+	// batches natively. Use SubscribeBatch to subscribe through any Broker.
+	BatchSubscriber[T any] interface {
+		// SubscribeBatch registers h to consume messages from topic in
+		// batches of up to SubscribeOptions.BatchSize events, waiting at most
+		// SubscribeOptions.BatchLinger for a batch to fill up.
+		SubscribeBatch(ctx context.Context, topic string, h BatchHandler[T], opts ...SubscribeOption) (Subscriber, error)
+	}
+
+	// Batcher groups events into batches, handing a batch to its flush
+	// function once it holds size events or linger after its first event
+	// arrived, whichever comes first. Batches are flushed one at a time, so
+	// Add blocks while a flush is running. It helps Broker implementations
+	// without native batch consumption implement BatchSubscriber.
+	Batcher[T any] struct {
+		size   int
+		linger time.Duration
+		flush  func([]Event[T])
+		in     chan Event[T]
+		done   chan struct{}
+		mu     sync.RWMutex
+		closed bool
+	}
+
+	// batchSubscriber is a subscription made by the SubscribeBatch fallback.
+	batchSubscriber[T any] struct {
+		Subscriber
+		batcher *Batcher[T]
+	}
+)
+
+// PublishBatch publishes ms to topic through b, in a single call if b
+// implements BatchPublisher, and one message at a time otherwise, stopping at
+// the first error.
+func PublishBatch[T any](ctx context.Context, b Broker[T], topic string, ms []*T, opts ...PublishOption) error {
+	if p, ok := b.(BatchPublisher[T]); ok {
+		return p.PublishBatch(ctx, topic, ms, opts...)
+	}
+	for _, m := range ms {
+		if err := b.Publish(ctx, topic, m, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SubscribeBatch registers h to consume messages from topic through b in
+// batches, natively if b implements BatchSubscriber. Otherwise it subscribes
+// with auto-ack disabled and groups the events with a Batcher; under auto-ack
+// the events of a batch are then acknowledged once HandleBatch returns nil,
+// and left to the broker's redelivery of unacknowledged messages otherwise.
+// Dead-lettered batches are acknowledged either way.
+// Unsubscribe hands the pending events to h before returning.
+func SubscribeBatch[T any](ctx context.Context, b Broker[T], topic string, h BatchHandler[T], opts ...SubscribeOption) (Subscriber, error) {
+	if s, ok := b.(BatchSubscriber[T]); ok {
+		return s.SubscribeBatch(ctx, topic, h, opts...)
+	}
+	o := SubscribeOptions{AutoAck: true}
+	o.Apply(opts...)
+	publish := PublishFunc[T](b.Publish)
+	batcher := NewBatcher(o.BatchSize, o.BatchLinger, func(events []Event[T]) {
+		if err := HandleBatch(ctx, events, h, &o, publish); (err == nil && o.AutoAck) || errors.Is(err, ErrDeadLettered) {
+			for _, e := range events {
+				_ = e.Ack()
+			}
+		}
+	})
+	sub, err := b.Subscribe(ctx, topic, func(e Event[T]) error {
+		batcher.Add(e)
+		return nil
+	}, append(opts[:len(opts):len(opts)], DisableAutoAck())...)
+	if err != nil {
+		batcher.Close()
+		return nil, err
+	}
+	return &batchSubscriber[T]{Subscriber: sub, batcher: batcher}, nil
+}
+
+// HandleBatch is the batch counterpart of Handle: it hands events to h
+// honoring the redelivery policy in opts, and is meant to be called by
+// BatchSubscriber implementations for every batch.
+//
+// h is invoked up to opts.MaxAttempts times with the whole batch, waiting
+// opts.Backoff(attempt) between failed attempts. If every attempt fails and
+// opts.DeadLetter is set, each event is republished to that topic as Handle
+// does, except events carrying a decoding error, and HandleBatch returns
+// ErrDeadLettered, or the joined publish errors. Otherwise it returns the
+// last handler error.
+func HandleBatch[T any](ctx context.Context, events []Event[T], h BatchHandler[T], opts *SubscribeOptions, publish PublishFunc[T]) error {
+	if len(events) == 0 {
+		return nil
+	}
+	attempts := max(opts.MaxAttempts, 1)
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		batch := events
+		if attempt > 1 {
+			batch = make([]Event[T], len(events))
+			for i, e := range events {
+				batch[i] = &retryEvent[T]{Event: e, attempt: e.Attempt() + attempt - 1}
+			}
+		}
+		if err = h(batch); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		if opts.Backoff != nil {
+			if sleep(ctx, opts.Backoff(attempt)) != nil {
+				return err
+			}
+		}
+	}
+	if opts.DeadLetter == "" || publish == nil {
+		return err
+	}
+	var errs []error
+	for _, e := range events {
+		if e.Error() != nil {
+			continue
+		}
+		errs = append(errs, deadLetter(ctx, e, err, attempt, opts.DeadLetter, publish))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return ErrDeadLettered
+}
+
+// NewBatcher starts a Batcher handing batches to flush. A size or linger of
+// zero or below means DefaultBatchSize or DefaultBatchLinger.
+func NewBatcher[T any](size int, linger time.Duration, flush func([]Event[T])) *Batcher[T] {
+	b := &Batcher[T]{
+		size:   cmp.Or(max(size, 0), DefaultBatchSize),
+		linger: cmp.Or(max(linger, 0), DefaultBatchLinger),
+		flush:  flush,
+		in:     make(chan Event[T]),
+		done:   make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add adds e to the current batch and reports whether it was added, which
+// it is not once the Batcher is closed.
+func (b *Batcher[T]) Add(e Event[T]) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return false
+	}
+	b.in <- e
+	return true
+}
+
+// Close flushes the current batch and stops the Batcher, waiting for the
+// flush to return.
+func (b *Batcher[T]) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.in)
+	}
+	b.mu.Unlock()
+	<-b.done
+}
+
+func (b *Batcher[T]) run() {
+	defer close(b.done)
+	var (
+		batch  []Event[T]
+		linger <-chan time.Time
+	)
+	flush := func() {
+		if len(batch) > 0 {
+			b.flush(batch)
+		}
+		batch, linger = nil, nil
+	}
+	for {
+		select {
+		case e, ok := <-b.in:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) == 1 {
+				linger = time.After(b.linger)
+			}
+			if len(batch) >= b.size {
+				flush()
+			}
+		case <-linger:
+			flush()
+		}
+	}
+}
+
+// Unsubscribe implements Subscriber. It hands the pending events to the
+// handler before returning.
+func (s *batchSubscriber[T]) Unsubscribe() error {
+	err := s.Subscriber.Unsubscribe()
+	s.batcher.Close()
+	return err
+}
+
+// Pause implements LifecycleSubscriber when the underlying subscription
+// does.
+func (s *batchSubscriber[T]) Pause() error {
+	return Pause(s.Subscriber)
+}
+
+// Resume implements LifecycleSubscriber when the underlying subscription
+// does.
+func (s *batchSubscriber[T]) Resume() error {
+	return Resume(s.Subscriber)
+}
+
+// Drain implements LifecycleSubscriber. It drains the underlying
+// subscription and then hands the pending events to the handler.
+func (s *batchSubscriber[T]) Drain(ctx context.Context) error {
+	err := Drain(ctx, s.Subscriber)
+	s.batcher.Close()
+	return err
+}
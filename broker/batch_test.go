@@ -0,0 +1,148 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+// batchStubBroker is a stubBroker whose subscriptions can be unsubscribed.
+type batchStubBroker struct {
+	stubBroker
+	opts         broker.SubscribeOptions
+	unsubscribed bool
+}
+
+func (b *batchStubBroker) Subscribe(ctx context.Context, topic string, h func(broker.Event[string]) error, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	b.handler = h
+	b.opts.Apply(opts...)
+	return b, nil
+}
+
+func (b *batchStubBroker) Topic() string { return "orders" }
+
+func (b *batchStubBroker) Unsubscribe() error {
+	b.unsubscribed = true
+	return nil
+}
+
+type ackEvent struct {
+	testEvent
+	acks *atomic.Int32
+}
+
+func (e *ackEvent) Ack() error {
+	e.acks.Add(1)
+	return nil
+}
+
+func TestPublishBatch_FallsBackToPublish(t *testing.T) {
+	b := &stubBroker{}
+	a, c := "a", "c"
+	if err := broker.PublishBatch[string](context.Background(), b, "orders", []*string{&a, &c}, broker.Header("k", "v")); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.published) != 2 || b.published[0].msg != &a || b.published[1].msg != &c || b.published[1].headers["k"] != "v" {
+		t.Fatalf("published %+v, want both messages in order with the header", b.published)
+	}
+}
+
+func TestSubscribeBatch_FallbackFlushesOnSizeAndLinger(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := &batchStubBroker{opts: broker.SubscribeOptions{AutoAck: true}}
+		batches := make(chan []string, 3)
+		sub, err := broker.SubscribeBatch[string](context.Background(), b, "orders", func(events []broker.Event[string]) error {
+			var msgs []string
+			for _, e := range events {
+				msgs = append(msgs, *e.Message())
+			}
+			batches <- msgs
+			return nil
+		}, broker.BatchSize(2), broker.BatchLinger(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.opts.AutoAck {
+			t.Fatal("fallback subscribed with auto-ack, want the batch to settle events")
+		}
+
+		var acks atomic.Int32
+		for _, m := range []string{"a", "b", "c"} {
+			if err := b.handler(&ackEvent{testEvent: testEvent{topic: "orders", msg: &m}, acks: &acks}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := fmt.Sprint(<-batches); got != "[a b]" {
+			t.Fatalf("got first batch %s, want [a b] once full", got)
+		}
+		start := time.Now()
+		if got := fmt.Sprint(<-batches); got != "[c]" || time.Since(start) != time.Second {
+			t.Fatalf("got batch %s after %v, want [c] after the 1s linger", got, time.Since(start))
+		}
+		synctest.Wait()
+		if acks.Load() != 3 {
+			t.Fatalf("acked %d events, want 3", acks.Load())
+		}
+
+		// Pending events are flushed by Unsubscribe.
+		d := "d"
+		if err := b.handler(&ackEvent{testEvent: testEvent{topic: "orders", msg: &d}, acks: &acks}); err != nil {
+			t.Fatal(err)
+		}
+		if err := sub.Unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(<-batches); got != "[d]" || !b.unsubscribed {
+			t.Fatalf("got batch %s, unsubscribed %v, want [d] flushed on Unsubscribe", got, b.unsubscribed)
+		}
+	})
+}
+
+func TestSubscribeBatch_FallbackLeavesTheCallersOptionsUntouched(t *testing.T) {
+	b := &batchStubBroker{}
+	// Spare capacity lets an unclipped append write into the caller's array.
+	opts := make([]broker.SubscribeOption, 1, 2)
+	opts[0] = broker.Queue("workers")
+	spare := opts[:2]
+	sub, err := broker.SubscribeBatch[string](context.Background(), b, "orders", func([]broker.Event[string]) error { return nil }, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	if spare[1] != nil {
+		t.Error("SubscribeBatch() appended to the caller's option slice")
+	}
+}
+
+func TestHandleBatch_RetriesThenDeadLettersEachEvent(t *testing.T) {
+	a, c := "a", "c"
+	events := []broker.Event[string]{
+		&testEvent{topic: "orders", msg: &a},
+		&testEvent{topic: "orders", err: errors.New("bad payload")},
+		&testEvent{topic: "orders", msg: &c},
+	}
+	var attempts []int
+	var dlq []published
+	opts := &broker.SubscribeOptions{}
+	opts.Apply(broker.MaxAttempts(2), broker.DeadLetter("orders.dlq"))
+
+	err := broker.HandleBatch(context.Background(), events, func(batch []broker.Event[string]) error {
+		attempts = append(attempts, batch[0].Attempt())
+		return errors.New("boom")
+	}, opts, capture(&dlq))
+	if !errors.Is(err, broker.ErrDeadLettered) {
+		t.Fatalf("HandleBatch() error = %v, want %v", err, broker.ErrDeadLettered)
+	}
+	if fmt.Sprint(attempts) != "[1 2]" {
+		t.Errorf("got attempts %v, want [1 2]", attempts)
+	}
+	if len(dlq) != 2 || dlq[0].msg != &a || dlq[1].msg != &c || dlq[0].headers[broker.HeaderDeadLetterAttempts] != "2" {
+		t.Fatalf("dead-lettered %+v, want the two decoded messages after 2 attempts", dlq)
+	}
+}
@@ -3,6 +3,7 @@ package broker
 
 import (
 	"context"
+	"time"
 )
 
 type (
@@ -21,30 +22,56 @@ type (
 		Publish(ctx context.Context, topic string, m *T, opts ...PublishOption) error
 
 		// Subscribe registers a handler h to consume messages from the specified topic.
+		// The topic may be a pattern matching several topics, see MatchTopic; brokers
+		// that cannot subscribe to patterns return ErrPatternUnsupported.
 		// Additional SubscribeOption(s) can be provided to customize subscription behavior.
 		// Returns a Subscriber for managing the subscription and an error if subscription fails.
 		Subscribe(ctx context.Context, topic string, h func(Event[T]) error, opts ...SubscribeOption) (Subscriber, error)
 
-		// Close flushes all in-flight messages and closes the underlying connection.
-		// The provided context controls the duration of the flush/close operation.
-		// If the context does not have a deadline, a default deadline of 5 seconds is applied.
-		// Returns an error if closing fails.
+		// Close stops the subscriptions from taking new messages, waits for the
+		// handlers in flight, flushes all in-flight messages and closes the
+		// underlying connection. The provided context controls the duration of
+		// the whole operation. If the context does not have a deadline,
+		// DefaultCloseTimeout applies. Returns an error if closing fails or the
+		// handlers did not return in time.
 		Close(context.Context) error
 	}
 
 	// Event is provided to a subscription handler for processing.
 	// It represents a message event received from a topic.
 	Event[T any] interface {
-		// Topic returns the topic name of the event.
+		// Topic returns the topic name of the event, the one it was
+		// published to even if it was received through a topic pattern.
 		Topic() string
 
 		// Message returns the message payload of the event.
 		Message() *T
 
+		// Headers returns the transport-level headers the message was
+		// published with. The returned map must not be modified.
+		Headers() map[string]string
+
+		// ID returns the unique ID of the message, carried in the
+		// HeaderMessageID header.
+		ID() string
+
+		// Timestamp returns the time the message was published, or the zero
+		// time if it is unknown.
+		Timestamp() time.Time
+
+		// Attempt returns the delivery attempt of the message, starting at 1.
+		Attempt() int
+
 		// Ack acknowledges successful processing of the event.
 		// Returns an error if acknowledgment fails.
 		Ack() error
 
+		// Nack negatively acknowledges the event: the message is redelivered
+		// after delay, or as soon as possible if delay is zero. Acknowledging
+		// or nacking an event that was already acknowledged or nacked has no
+		// effect. Returns an error if the negative acknowledgment fails.
+		Nack(delay time.Duration) error
+
 		// Error returns any error encountered during event processing.
 		Error() error
 
@@ -52,13 +79,17 @@ type (
 		Reason() Reason
 	}
 
+	// PublishFunc has the signature of Broker.Publish. It lets helpers such as
+	// Handle publish through a broker without depending on the whole interface.
+	PublishFunc[T any] func(ctx context.Context, topic string, m *T, opts ...PublishOption) error
+
 	// Reason represents the reason code for event errors.
 	Reason int
 
 	// Subscriber is a convenience return type for the Subscribe method.
 	// It allows management of the subscription.
 	Subscriber interface {
-		// Topic returns the topic name of the subscription.
+		// Topic returns the topic name, or pattern, of the subscription.
 		Topic() string
 
 		// Unsubscribe cancels the subscription.
@@ -84,3 +115,16 @@ const (
 	// ReasonSubscriptionFailure indicates a failure to subscribe to a topic.
 	ReasonSubscriptionFailure
 )
+
+// String returns the name of r used in metric labels: "unmarshal" or
+// "subscription".
+func (r Reason) String() string {
+	switch r {
+	case ReasonUnmarshalFailure:
+		return "unmarshal"
+	case ReasonSubscriptionFailure:
+		return "subscription"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,416 @@
+// Package brokertest provides a conformance suite for implementations of
+// broker.Broker, so that every implementation agrees on the semantics the
+// broker package documents:
+//
+//   - messages published to a topic reach every subscription of the topic,
+//     with their headers, a message ID, a timestamp and the topic;
+//   - subscriptions sharing a broker.Queue receive every message once;
+//   - Unsubscribe stops the deliveries;
+//   - messages are acknowledged when the handler returns nil by default,
+//     with broker.DisableAutoAck the handler settles them with Event.Ack,
+//     and Event.Nack redelivers them;
+//   - a message dead-lettered with broker.DisableAutoAck is settled, and
+//     dead-lettered once;
+//   - Close may be called while messages are published, and before Open.
+//
+// Implementations run it from their tests:
+//
+//	func TestConformance(t *testing.T) {
+//		brokertest.Run(t, func(t *testing.T) broker.Broker[brokertest.Message] {
+//			return memory.New[brokertest.Message]()
+//		})
+//	}
+package brokertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+type (
+	// Message is the message type of the brokers under test.
+	Message struct {
+		Seq  int    `json:"seq"`
+		Body string `json:"body"`
+	}
+
+	// Factory returns a new broker, not opened yet. The suite opens it and
+	// closes it at the end of the test. Brokers that share state across
+	// instances, such as a server, are given a unique topic per test.
+	Factory func(t *testing.T) broker.Broker[Message]
+
+	// Option is an optional configuration of the suite.
+	Option func(*suite)
+
+	suite struct {
+		factory Factory
+		settle  time.Duration
+		timeout time.Duration
+		quiet   time.Duration
+		noQueue bool
+	}
+)
+
+// seq makes the topics of the suite unique within the process.
+var seq atomic.Int64
+
+// Settle sets how long the suite waits after subscribing before publishing,
+// for brokers whose subscriptions get ready asynchronously, such as Kafka
+// consumer groups joining. Default is zero.
+func Settle(d time.Duration) Option {
+	return func(s *suite) {
+		s.settle = d
+	}
+}
+
+// Timeout sets how long the suite waits for an expected delivery. Default is
+// 5 seconds.
+func Timeout(d time.Duration) Option {
+	return func(s *suite) {
+		s.timeout = d
+	}
+}
+
+// Quiet sets how long the suite waits to check that a message is not
+// delivered, or not delivered again. Default is 200 milliseconds.
+func Quiet(d time.Duration) Option {
+	return func(s *suite) {
+		s.quiet = d
+	}
+}
+
+// WithoutQueue skips the queue group test, for brokers that ignore
+// broker.Queue.
+func WithoutQueue() Option {
+	return func(s *suite) {
+		s.noQueue = true
+	}
+}
+
+// Run runs the conformance suite against the brokers returned by factory,
+// each test in its own subtest.
+func Run(t *testing.T, factory Factory, opts ...Option) {
+	s := &suite{
+		factory: factory,
+		timeout: 5 * time.Second,
+		quiet:   200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for _, tc := range []struct {
+		name string
+		test func(*testing.T)
+	}{
+		{"PublishSubscribe", s.testPublishSubscribe},
+		{"Headers", s.testHeaders},
+		{"FanOut", s.testFanOut},
+		{"QueueGroup", s.testQueueGroup},
+		{"Unsubscribe", s.testUnsubscribe},
+		{"AutoAck", s.testAutoAck},
+		{"ManualAck", s.testManualAck},
+		{"Nack", s.testNack},
+		{"DeadLetter", s.testDeadLetter},
+		{"CloseWhilePublishing", s.testCloseWhilePublishing},
+		{"CloseWithoutOpen", s.testCloseWithoutOpen},
+	} {
+		t.Run(tc.name, tc.test)
+	}
+}
+
+func (s *suite) testPublishSubscribe(t *testing.T) {
+	b, topic := s.open(t)
+	events := make(chan broker.Event[Message], 10)
+	sub := s.subscribe(t, b, topic, events)
+	if sub.Topic() != topic {
+		t.Errorf("Subscriber.Topic() = %q, want %q", sub.Topic(), topic)
+	}
+	s.publish(t, b, topic, 1, 2, 3)
+	got := make(map[int]bool)
+	for _, e := range s.receive(t, events, 3) {
+		if e.Error() != nil {
+			t.Fatalf("event error = %v, reason %v", e.Error(), e.Reason())
+		}
+		if e.Topic() != topic {
+			t.Errorf("Event.Topic() = %q, want %q", e.Topic(), topic)
+		}
+		if e.ID() == "" || e.Timestamp().IsZero() || e.Attempt() != 1 {
+			t.Errorf("got ID %q, timestamp %v and attempt %d, want a message ID, a publish time and attempt 1", e.ID(), e.Timestamp(), e.Attempt())
+		}
+		if m := e.Message(); m.Body != body(m.Seq) {
+			t.Errorf("got message %+v, want body %q", *m, body(m.Seq))
+		}
+		got[e.Message().Seq] = true
+	}
+	if len(got) != 3 {
+		t.Errorf("received messages %v, want 1, 2 and 3", got)
+	}
+}
+
+func (s *suite) testHeaders(t *testing.T) {
+	b, topic := s.open(t)
+	events := make(chan broker.Event[Message], 1)
+	s.subscribe(t, b, topic, events)
+	m := &Message{Seq: 1, Body: body(1)}
+	if err := b.Publish(context.Background(), topic, m, broker.Header("x-brokertest", "value"), broker.Header(broker.HeaderMessageID, "brokertest-1")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	e := s.receive(t, events, 1)[0]
+	if got := e.Headers()["x-brokertest"]; got != "value" {
+		t.Errorf("header x-brokertest = %q, want value, got headers %v", got, e.Headers())
+	}
+	if e.ID() != "brokertest-1" {
+		t.Errorf("Event.ID() = %q, want the published message ID brokertest-1", e.ID())
+	}
+}
+
+func (s *suite) testFanOut(t *testing.T) {
+	b, topic := s.open(t)
+	first := make(chan broker.Event[Message], 10)
+	second := make(chan broker.Event[Message], 10)
+	s.subscribe(t, b, topic, first)
+	s.subscribe(t, b, topic, second)
+	s.publish(t, b, topic, 1, 2)
+	s.receive(t, first, 2)
+	s.receive(t, second, 2)
+}
+
+func (s *suite) testQueueGroup(t *testing.T) {
+	if s.noQueue {
+		t.Skip("the broker does not support queue groups")
+	}
+	b, topic := s.open(t)
+	events := make(chan broker.Event[Message], 20)
+	s.subscribe(t, b, topic, events, broker.Queue("brokertest"))
+	s.subscribe(t, b, topic, events, broker.Queue("brokertest"))
+	const n = 10
+	seqs := make([]int, n)
+	for i := range seqs {
+		seqs[i] = i + 1
+	}
+	s.publish(t, b, topic, seqs...)
+	got := make(map[int]int)
+	for _, e := range s.receive(t, events, n) {
+		got[e.Message().Seq]++
+	}
+	s.expectNone(t, events)
+	for _, seq := range seqs {
+		if got[seq] != 1 {
+			t.Errorf("message %d received %d times by the queue group, want once", seq, got[seq])
+		}
+	}
+}
+
+func (s *suite) testUnsubscribe(t *testing.T) {
+	b, topic := s.open(t)
+	events := make(chan broker.Event[Message], 10)
+	sub := s.subscribe(t, b, topic, events)
+	s.publish(t, b, topic, 1)
+	s.receive(t, events, 1)
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe() error = %v", err)
+	}
+	s.publish(t, b, topic, 2)
+	s.expectNone(t, events)
+}
+
+func (s *suite) testAutoAck(t *testing.T) {
+	b, topic := s.open(t)
+	events := make(chan broker.Event[Message], 10)
+	s.subscribe(t, b, topic, events)
+	s.publish(t, b, topic, 1)
+	s.receive(t, events, 1)
+	// Handled without error: acknowledged, never redelivered.
+	s.expectNone(t, events)
+}
+
+func (s *suite) testManualAck(t *testing.T) {
+	b, topic := s.open(t)
+	events := make(chan broker.Event[Message], 10)
+	s.subscribeFunc(t, b, topic, func(e broker.Event[Message]) error {
+		events <- e
+		return e.Ack()
+	}, broker.DisableAutoAck())
+	s.publish(t, b, topic, 1)
+	e := s.receive(t, events, 1)[0]
+	if err := e.Ack(); err != nil {
+		t.Errorf("second Ack() error = %v, want none", err)
+	}
+	s.expectNone(t, events)
+}
+
+func (s *suite) testNack(t *testing.T) {
+	b, topic := s.open(t)
+	events := make(chan broker.Event[Message], 10)
+	var nacked atomic.Bool
+	s.subscribeFunc(t, b, topic, func(e broker.Event[Message]) error {
+		events <- e
+		if nacked.CompareAndSwap(false, true) {
+			return e.Nack(0)
+		}
+		return e.Ack()
+	}, broker.DisableAutoAck())
+	s.publish(t, b, topic, 1)
+	got := s.receive(t, events, 2)
+	if got[1].Message().Seq != 1 {
+		t.Errorf("got message %d after Nack, want message 1 again", got[1].Message().Seq)
+	}
+	s.expectNone(t, events)
+}
+
+func (s *suite) testDeadLetter(t *testing.T) {
+	b, topic := s.open(t)
+	dlq := topic + "-dlq"
+	dead := make(chan broker.Event[Message], 10)
+	s.subscribe(t, b, dlq, dead)
+	h := func(broker.Event[Message]) error {
+		return errors.New("brokertest: poison message")
+	}
+	opts := []broker.SubscribeOption{broker.DisableAutoAck(), broker.MaxAttempts(2), broker.DeadLetter(dlq)}
+	// A short ack wait redelivers the message within the quiet period unless
+	// it was settled; brokers without one rely on their own.
+	if _, err := b.Subscribe(context.Background(), topic, h, append(opts, broker.AckWait(s.quiet/4))...); errors.Is(err, errors.ErrUnsupported) {
+		s.subscribeFunc(t, b, topic, h, opts...)
+	} else if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	} else {
+		time.Sleep(s.settle)
+	}
+	s.publish(t, b, topic, 1)
+	e := s.receive(t, dead, 1)[0]
+	if e.Message().Seq != 1 || e.Headers()[broker.HeaderDeadLetterAttempts] != "2" {
+		t.Errorf("dead-lettered message %d after %s attempts, want message 1 after 2", e.Message().Seq, e.Headers()[broker.HeaderDeadLetterAttempts])
+	}
+	s.expectNone(t, dead)
+}
+
+func (s *suite) testCloseWhilePublishing(t *testing.T) {
+	b, topic := s.open(t)
+	events := make(chan broker.Event[Message], 1)
+	s.subscribeFunc(t, b, topic, func(e broker.Event[Message]) error {
+		select {
+		case events <- e:
+		default:
+		}
+		return nil
+	})
+	s.publish(t, b, topic, 1)
+	s.receive(t, events, 1)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Publishing after Close may fail, but must not panic.
+				if b.Publish(context.Background(), topic, &Message{Seq: i, Body: body(i)}) != nil {
+					return
+				}
+			}
+		})
+	}
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if err := b.Close(ctx); err != nil {
+		t.Errorf("Close() error = %v while publishing", err)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func (s *suite) testCloseWithoutOpen(t *testing.T) {
+	b := s.factory(t)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v before Open", err)
+	}
+}
+
+// open returns an open broker, closed at the end of the test, and a topic
+// unique to the test.
+func (s *suite) open(t *testing.T) (broker.Broker[Message], string) {
+	t.Helper()
+	b := s.factory(t)
+	if err := b.Open(context.Background()); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		_ = b.Close(ctx)
+	})
+	name := strings.ToLower(t.Name()[strings.LastIndexByte(t.Name(), '/')+1:])
+	return b, fmt.Sprintf("brokertest-%s-%d-%d", name, time.Now().UnixNano(), seq.Add(1))
+}
+
+// subscribe subscribes a handler sending the events to events.
+func (s *suite) subscribe(t *testing.T, b broker.Broker[Message], topic string, events chan<- broker.Event[Message], opts ...broker.SubscribeOption) broker.Subscriber {
+	t.Helper()
+	return s.subscribeFunc(t, b, topic, func(e broker.Event[Message]) error {
+		events <- e
+		return nil
+	}, opts...)
+}
+
+func (s *suite) subscribeFunc(t *testing.T, b broker.Broker[Message], topic string, h func(broker.Event[Message]) error, opts ...broker.SubscribeOption) broker.Subscriber {
+	t.Helper()
+	sub, err := b.Subscribe(context.Background(), topic, h, opts...)
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	time.Sleep(s.settle)
+	return sub
+}
+
+func (s *suite) publish(t *testing.T, b broker.Broker[Message], topic string, seqs ...int) {
+	t.Helper()
+	for _, seq := range seqs {
+		if err := b.Publish(context.Background(), topic, &Message{Seq: seq, Body: body(seq)}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+}
+
+// receive returns the next n events, failing the test if they do not arrive
+// within the timeout.
+func (s *suite) receive(t *testing.T, events <-chan broker.Event[Message], n int) []broker.Event[Message] {
+	t.Helper()
+	timeout := time.After(s.timeout)
+	got := make([]broker.Event[Message], 0, n)
+	for len(got) < n {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-timeout:
+			t.Fatalf("received %d events, want %d", len(got), n)
+		}
+	}
+	return got
+}
+
+// expectNone fails the test if an event arrives within the quiet period.
+func (s *suite) expectNone(t *testing.T, events <-chan broker.Event[Message]) {
+	t.Helper()
+	select {
+	case e := <-events:
+		t.Fatalf("received message %+v, want none", *e.Message())
+	case <-time.After(s.quiet):
+	}
+}
+
+func body(seq int) string {
+	return fmt.Sprintf("message %d", seq)
+}
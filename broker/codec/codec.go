@@ -0,0 +1,207 @@
+// Package codec provides the message codecs shared by broker implementations:
+// JSON, binary protobuf, protobuf JSON and a codec negotiating between them
+// through the content-type header.
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/pthethanh/nano/broker"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Media types written to the broker.HeaderContentType header.
+const (
+	ContentTypeJSON      = "application/json"
+	ContentTypeProto     = "application/protobuf"
+	ContentTypeProtoJSON = "application/protobuf+json"
+)
+
+type (
+	// ContentCodec is a broker.Codec that declares the media type of its
+	// wire format. Brokers publish that media type in the
+	// broker.HeaderContentType header.
+	ContentCodec[T any] interface {
+		broker.Codec[T]
+		ContentType() string
+	}
+
+	// HeaderUnmarshaler is implemented by codecs whose decoding depends on
+	// the headers of the message, such as Negotiator.
+	HeaderUnmarshaler[T any] interface {
+		UnmarshalHeaders(headers map[string]string, data []byte, v *T) error
+	}
+
+	// JSON encodes messages with encoding/json.
+	JSON[T any] struct{}
+
+	// Proto encodes messages in the protobuf binary format. *T must be a
+	// proto.Message.
+	Proto[T any] struct {
+		MarshalOptions   proto.MarshalOptions
+		UnmarshalOptions proto.UnmarshalOptions
+	}
+
+	// ProtoJSON encodes messages in the protobuf JSON format. *T must be a
+	// proto.Message.
+	ProtoJSON[T any] struct {
+		MarshalOptions   protojson.MarshalOptions
+		UnmarshalOptions protojson.UnmarshalOptions
+	}
+
+	// Negotiator encodes messages with its first codec and decodes them with
+	// the codec matching their content-type header.
+	Negotiator[T any] struct {
+		codecs []ContentCodec[T]
+	}
+)
+
+var (
+	_ ContentCodec[any]      = JSON[any]{}
+	_ ContentCodec[any]      = Proto[any]{}
+	_ ContentCodec[any]      = ProtoJSON[any]{}
+	_ ContentCodec[any]      = (*Negotiator[any])(nil)
+	_ HeaderUnmarshaler[any] = (*Negotiator[any])(nil)
+
+	// ErrUnsupportedContentType is returned by Negotiator when no codec
+	// handles the content-type header of a message.
+	ErrUnsupportedContentType = errors.New("codec: unsupported content type")
+)
+
+// Marshal implements broker.Codec.
+func (JSON[T]) Marshal(v *T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements broker.Codec.
+func (JSON[T]) Unmarshal(data []byte, v *T) error {
+	return json.Unmarshal(data, v)
+}
+
+// ContentType implements ContentCodec.
+func (JSON[T]) ContentType() string {
+	return ContentTypeJSON
+}
+
+// Marshal implements broker.Codec.
+func (c Proto[T]) Marshal(v *T) ([]byte, error) {
+	m, err := message(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.MarshalOptions.Marshal(m)
+}
+
+// Unmarshal implements broker.Codec.
+func (c Proto[T]) Unmarshal(data []byte, v *T) error {
+	m, err := message(v)
+	if err != nil {
+		return err
+	}
+	return c.UnmarshalOptions.Unmarshal(data, m)
+}
+
+// ContentType implements ContentCodec.
+func (Proto[T]) ContentType() string {
+	return ContentTypeProto
+}
+
+// Marshal implements broker.Codec.
+func (c ProtoJSON[T]) Marshal(v *T) ([]byte, error) {
+	m, err := message(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.MarshalOptions.Marshal(m)
+}
+
+// Unmarshal implements broker.Codec.
+func (c ProtoJSON[T]) Unmarshal(data []byte, v *T) error {
+	m, err := message(v)
+	if err != nil {
+		return err
+	}
+	return c.UnmarshalOptions.Unmarshal(data, m)
+}
+
+// ContentType implements ContentCodec. It differs from the JSON codec's so
+// that a Negotiator holding both tells their messages apart.
+func (ProtoJSON[T]) ContentType() string {
+	return ContentTypeProtoJSON
+}
+
+// Negotiate returns a Negotiator that encodes with the first of codecs and
+// decodes with any of them. It panics if codecs is empty.
+func Negotiate[T any](codecs ...ContentCodec[T]) *Negotiator[T] {
+	if len(codecs) == 0 {
+		panic("codec: Negotiate needs at least one codec")
+	}
+	return &Negotiator[T]{codecs: codecs}
+}
+
+// Marshal implements broker.Codec using the first codec.
+func (n *Negotiator[T]) Marshal(v *T) ([]byte, error) {
+	return n.codecs[0].Marshal(v)
+}
+
+// Unmarshal implements broker.Codec using the first codec.
+func (n *Negotiator[T]) Unmarshal(data []byte, v *T) error {
+	return n.codecs[0].Unmarshal(data, v)
+}
+
+// ContentType implements ContentCodec, returning the media type of the first
+// codec.
+func (n *Negotiator[T]) ContentType() string {
+	return n.codecs[0].ContentType()
+}
+
+// UnmarshalHeaders implements HeaderUnmarshaler. Messages without a
+// content-type header are decoded with the first codec; messages whose
+// content type no codec handles fail with ErrUnsupportedContentType.
+func (n *Negotiator[T]) UnmarshalHeaders(headers map[string]string, data []byte, v *T) error {
+	ct := headers[broker.HeaderContentType]
+	if ct == "" {
+		return n.Unmarshal(data, v)
+	}
+	for _, c := range n.codecs {
+		if c.ContentType() == ct {
+			return c.Unmarshal(data, v)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedContentType, ct)
+}
+
+// Marshal encodes v with c for publishing and, when c is a ContentCodec,
+// records its media type in headers unless the producer already set one.
+// headers must be non-nil when c is a ContentCodec; PublishOptions.Stamp
+// guarantees it.
+func Marshal[T any](c broker.Codec[T], v *T, headers map[string]string) ([]byte, error) {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if cc, ok := c.(ContentCodec[T]); ok && headers[broker.HeaderContentType] == "" {
+		headers[broker.HeaderContentType] = cc.ContentType()
+	}
+	return data, nil
+}
+
+// Unmarshal decodes data received with headers into v, letting c pick the
+// wire format from headers when it is a HeaderUnmarshaler.
+func Unmarshal[T any](c broker.Codec[T], headers map[string]string, data []byte, v *T) error {
+	if hu, ok := c.(HeaderUnmarshaler[T]); ok {
+		return hu.UnmarshalHeaders(headers, data, v)
+	}
+	return c.Unmarshal(data, v)
+}
+
+func message[T any](v *T) (proto.Message, error) {
+	m, ok := any(v).(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: %T is not a proto.Message", v)
+	}
+	return m, nil
+}
@@ -0,0 +1,119 @@
+package codec_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	for name, c := range map[string]codec.ContentCodec[broker.Message]{
+		"json":      codec.JSON[broker.Message]{},
+		"proto":     codec.Proto[broker.Message]{},
+		"protojson": codec.ProtoJSON[broker.Message]{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			in := &broker.Message{Header: map[string]string{"k": "v"}, Body: []byte("hello")}
+			data, err := c.Marshal(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out broker.Message
+			if err := c.Unmarshal(data, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(in, &out) {
+				t.Errorf("got %v, want %v", &out, in)
+			}
+		})
+	}
+}
+
+func TestProto_RejectsNonProtoMessages(t *testing.T) {
+	v := "hello"
+	if _, err := (codec.Proto[string]{}).Marshal(&v); err == nil {
+		t.Error("Marshal() accepted a message that is not a proto.Message")
+	}
+	if err := (codec.ProtoJSON[string]{}).Unmarshal([]byte(`"hello"`), &v); err == nil {
+		t.Error("Unmarshal() accepted a message that is not a proto.Message")
+	}
+}
+
+func TestMarshal_SetsContentType(t *testing.T) {
+	in := &broker.Message{Body: []byte("hello")}
+	headers := map[string]string{}
+	if _, err := codec.Marshal[broker.Message](codec.Proto[broker.Message]{}, in, headers); err != nil {
+		t.Fatal(err)
+	}
+	if got := headers[broker.HeaderContentType]; got != codec.ContentTypeProto {
+		t.Errorf("got content type %q, want %q", got, codec.ContentTypeProto)
+	}
+
+	headers = map[string]string{broker.HeaderContentType: "application/vnd.custom"}
+	if _, err := codec.Marshal[broker.Message](codec.Proto[broker.Message]{}, in, headers); err != nil {
+		t.Fatal(err)
+	}
+	if got := headers[broker.HeaderContentType]; got != "application/vnd.custom" {
+		t.Errorf("got content type %q, want the producer's one kept", got)
+	}
+}
+
+func TestNegotiator(t *testing.T) {
+	n := codec.Negotiate[broker.Message](codec.Proto[broker.Message]{}, codec.ProtoJSON[broker.Message]{})
+	in := &broker.Message{Body: []byte("hello")}
+
+	headers := map[string]string{}
+	data, err := codec.Marshal[broker.Message](n, in, headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers[broker.HeaderContentType] != codec.ContentTypeProto {
+		t.Errorf("got content type %q, want the first codec's %q", headers[broker.HeaderContentType], codec.ContentTypeProto)
+	}
+	var out broker.Message
+	if err := codec.Unmarshal[broker.Message](n, headers, data, &out); err != nil || !proto.Equal(in, &out) {
+		t.Fatalf("got %v, %v, want %v", &out, err, in)
+	}
+
+	jsonData, err := codec.ProtoJSON[broker.Message]{}.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = broker.Message{}
+	if err := codec.Unmarshal[broker.Message](n, map[string]string{broker.HeaderContentType: codec.ContentTypeProtoJSON}, jsonData, &out); err != nil || !proto.Equal(in, &out) {
+		t.Fatalf("got %v, %v, want %v decoded by content type", &out, err, in)
+	}
+
+	// Without a content type the first codec decodes.
+	out = broker.Message{}
+	if err := codec.Unmarshal[broker.Message](n, nil, data, &out); err != nil || !proto.Equal(in, &out) {
+		t.Fatalf("got %v, %v, want %v", &out, err, in)
+	}
+
+	err = codec.Unmarshal[broker.Message](n, map[string]string{broker.HeaderContentType: "text/plain"}, data, &out)
+	if !errors.Is(err, codec.ErrUnsupportedContentType) {
+		t.Errorf("got err=%v, want ErrUnsupportedContentType", err)
+	}
+}
+
+func TestNegotiator_TellsJSONAndProtoJSONApart(t *testing.T) {
+	n := codec.Negotiate[broker.Message](codec.JSON[broker.Message]{}, codec.ProtoJSON[broker.Message]{})
+	in := &broker.Message{Header: map[string]string{"k": "v"}, Body: []byte("hello")}
+	for _, c := range []codec.ContentCodec[broker.Message]{codec.JSON[broker.Message]{}, codec.ProtoJSON[broker.Message]{}} {
+		headers := map[string]string{}
+		data, err := codec.Marshal(c, in, headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out broker.Message
+		if err := codec.Unmarshal[broker.Message](n, headers, data, &out); err != nil || !proto.Equal(in, &out) {
+			t.Errorf("%T: got %v, %v, want %v decoded by content type %q", c, &out, err, in, headers[broker.HeaderContentType])
+		}
+	}
+	if (codec.ProtoJSON[broker.Message]{}).ContentType() == (codec.JSON[broker.Message]{}).ContentType() {
+		t.Error("ProtoJSON and JSON share a content type")
+	}
+}
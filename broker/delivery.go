@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAckWait is the ack wait of brokers that track deliveries in-process
+// when a subscription does not set one with AckWait.
+const DefaultAckWait = 30 * time.Second
+
+// Delivery tracks the settlement of a single delivery of a message. It helps
+// Broker implementations without native negative acknowledgement or ack
+// deadlines implement Event.Ack and Event.Nack the same way: a delivery is
+// settled by the first Ack or Nack, and redeliver is called when it is nacked
+// or, if an ack wait is set, not settled in time.
+//
+// A nil *Delivery is never settled by Ack or Nack.
+type Delivery struct {
+	settled   atomic.Bool
+	timer     *time.Timer
+	redeliver func(delay time.Duration)
+}
+
+// NewDelivery starts tracking a delivery. If ackWait is positive and the
+// delivery is not settled within it, redeliver is called with a zero delay.
+func NewDelivery(ackWait time.Duration, redeliver func(delay time.Duration)) *Delivery {
+	d := &Delivery{redeliver: redeliver}
+	if ackWait > 0 {
+		d.timer = time.AfterFunc(ackWait, func() {
+			if d.settled.CompareAndSwap(false, true) {
+				d.redeliver(0)
+			}
+		})
+	}
+	return d
+}
+
+// Ack settles the delivery and reports whether this call settled it.
+func (d *Delivery) Ack() bool {
+	return d.settle()
+}
+
+// Nack settles the delivery, requesting redelivery after delay, and reports
+// whether this call settled it.
+func (d *Delivery) Nack(delay time.Duration) bool {
+	if !d.settle() {
+		return false
+	}
+	d.redeliver(delay)
+	return true
+}
+
+func (d *Delivery) settle() bool {
+	if d == nil || !d.settled.CompareAndSwap(false, true) {
+		return false
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package broker_test
+
+import (
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+func TestDelivery_SettlesOnce(t *testing.T) {
+	var delays []time.Duration
+	d := broker.NewDelivery(0, func(delay time.Duration) { delays = append(delays, delay) })
+	if !d.Nack(time.Second) {
+		t.Fatal("Nack() = false, want the first call to settle the delivery")
+	}
+	if d.Ack() || d.Nack(0) {
+		t.Error("got a second settlement, want Ack and Nack to be no-ops once settled")
+	}
+	if len(delays) != 1 || delays[0] != time.Second {
+		t.Errorf("redelivered with %v, want [1s]", delays)
+	}
+}
+
+func TestDelivery_RedeliversAfterAckWait(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		redelivered := 0
+		d := broker.NewDelivery(time.Minute, func(time.Duration) { redelivered++ })
+		time.Sleep(time.Minute + time.Millisecond)
+		synctest.Wait()
+		if redelivered != 1 {
+			t.Fatalf("got %d redeliveries, want 1 after the ack wait", redelivered)
+		}
+		if d.Ack() {
+			t.Error("Ack() = true after the ack wait expired, want false")
+		}
+
+		acked := broker.NewDelivery(time.Minute, func(time.Duration) { redelivered++ })
+		if !acked.Ack() {
+			t.Fatal("Ack() = false, want true")
+		}
+		time.Sleep(2 * time.Minute)
+		synctest.Wait()
+		if redelivered != 1 {
+			t.Errorf("got %d redeliveries, want an acknowledged delivery not to be redelivered", redelivered)
+		}
+	})
+}
+
+func TestDelivery_Nil(t *testing.T) {
+	var d *broker.Delivery
+	if d.Ack() || d.Nack(0) {
+		t.Error("nil Delivery settled, want Ack and Nack to report false")
+	}
+}
@@ -0,0 +1,293 @@
+// Package file provides a durable message broker backed by an append-only
+// segment log on local disk.
+//
+// Every topic is stored as a sequence of segment files under the broker's
+// directory. Subscribers sharing a broker.Queue form a durable consumer
+// group: its position in the log is persisted after every acknowledged
+// message, so a restarted process resumes where it stopped and redelivers
+// anything that was not acknowledged (at-least-once delivery): the position
+// only moves past a message once it and every message before it were
+// acknowledged. Under auto-ack, a message is acknowledged once its handler
+// returns, even when it failed. A new queue group starts from the beginning
+// of the log, or from its oldest segment kept by the Retention option.
+// Subscribers without a queue are ephemeral: they only receive messages
+// published after they subscribed and their position is not persisted.
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+type (
+	// Broker is a file-backed message broker.
+	Broker[T any] struct {
+		dir         string
+		codec       broker.Codec[T]
+		segmentSize int64
+		retention   time.Duration
+		fsync       bool
+		metrics     *broker.Metrics
+
+		mu     sync.Mutex
+		topics map[string]*segmentLog
+		groups map[groupKey]*group[T]
+		opened atomic.Bool
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	}
+
+	groupKey struct {
+		topic string
+		queue string
+	}
+
+	// Option is an optional configuration of a Broker.
+	Option[T any] func(*Broker[T])
+)
+
+var (
+	_ broker.Broker[any] = (*Broker[any])(nil)
+
+	// ErrInvalidConnectionState indicate that the broker has not been opened properly.
+	ErrInvalidConnectionState = errors.New("invalid connection state")
+
+	// ErrInvalidName is returned for topics and queues that cannot name a
+	// directory or file under the broker's directory: empty, "." and "..".
+	ErrInvalidName = errors.New("file: invalid name")
+
+	// ErrAckWaitUnsupported is returned by Subscribe when broker.AckWait is
+	// set: unacknowledged messages are redelivered to their queue group only
+	// after the next Open, never after an ack wait.
+	ErrAckWaitUnsupported = fmt.Errorf("file: ack wait: %w", errors.ErrUnsupported)
+)
+
+// New returns a new file-backed broker storing its data under dir.
+func New[T any](dir string, opts ...Option[T]) *Broker[T] {
+	br := &Broker[T]{
+		dir:         dir,
+		codec:       codec.JSON[T]{},
+		segmentSize: 64 << 20,
+		topics:      make(map[string]*segmentLog),
+		groups:      make(map[groupKey]*group[T]),
+	}
+	for _, opt := range opts {
+		opt(br)
+	}
+	return br
+}
+
+// Open implements broker.Broker interface.
+func (br *Broker[T]) Open(ctx context.Context) error {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if br.opened.Load() {
+		return nil
+	}
+	br.ctx, br.cancel = context.WithCancel(context.Background())
+	br.opened.Store(true)
+	return nil
+}
+
+// Publish implements broker.Broker interface. The message is durable once
+// Publish returns, subject to the Fsync option. Delayed delivery is not
+// supported: delayed messages are rejected with broker.ErrDelayUnsupported.
+func (br *Broker[T]) Publish(ctx context.Context, topic string, m *T, opts ...broker.PublishOption) error {
+	start := time.Now()
+	err := br.publish(topic, m, opts)
+	br.metrics.Publish(topic, 1, start, err)
+	return err
+}
+
+func (br *Broker[T]) publish(topic string, m *T, opts []broker.PublishOption) error {
+	if !br.opened.Load() {
+		return ErrInvalidConnectionState
+	}
+	var popts broker.PublishOptions
+	popts.Apply(opts...)
+	if popts.Delayed() {
+		return broker.ErrDelayUnsupported
+	}
+	popts.Stamp()
+	body, err := codec.Marshal(br.codec, m, popts.Headers)
+	if err != nil {
+		return err
+	}
+	data, err := proto.Marshal(&broker.Message{Header: popts.Headers, Body: body})
+	if err != nil {
+		return err
+	}
+	l, err := br.log(topic)
+	if err != nil {
+		return err
+	}
+	return l.Append(data)
+}
+
+// Subscribe implements broker.Broker interface. Topic patterns are not
+// supported and return broker.ErrPatternUnsupported; broker.AckWait is not
+// supported either and returns ErrAckWaitUnsupported.
+func (br *Broker[T]) Subscribe(ctx context.Context, topic string, h func(broker.Event[T]) error, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	if !br.opened.Load() {
+		return nil, ErrInvalidConnectionState
+	}
+	if broker.IsPattern(topic) {
+		return nil, broker.ErrPatternUnsupported
+	}
+	subOpts := &broker.SubscribeOptions{
+		AutoAck: true,
+	}
+	subOpts.Apply(opts...)
+	if subOpts.AckWait > 0 {
+		return nil, ErrAckWaitUnsupported
+	}
+	if subOpts.Queue != "" {
+		if err := validName("queue", subOpts.Queue); err != nil {
+			return nil, err
+		}
+	}
+	l, err := br.log(topic)
+	if err != nil {
+		return nil, err
+	}
+	sub := &subscriber[T]{
+		id:   uuid.New().String(),
+		t:    topic,
+		h:    broker.MeasureHandler(br.metrics, h),
+		opts: subOpts,
+	}
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	key := groupKey{topic: topic, queue: subOpts.Queue}
+	g := br.groups[key]
+	if subOpts.Queue == "" {
+		// Every subscriber without a queue gets its own ephemeral group.
+		key.queue = sub.id
+		g = nil
+	}
+	if g == nil {
+		g, err = newGroup(br, l, topic, subOpts.Queue)
+		if err != nil {
+			return nil, err
+		}
+		br.groups[key] = g
+		br.wg.Go(g.run)
+	}
+	g.add(sub)
+	sub.wake = g.wake
+	sub.leave = func() func() {
+		br.mu.Lock()
+		defer br.mu.Unlock()
+		if g.remove(sub) == 0 {
+			delete(br.groups, key)
+			return g.stop
+		}
+		return func() {}
+	}
+	return sub, nil
+}
+
+// log returns the log of the given topic, opening it on first use.
+func (br *Broker[T]) log(topic string) (*segmentLog, error) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if l, ok := br.topics[topic]; ok {
+		return l, nil
+	}
+	if err := validName("topic", topic); err != nil {
+		return nil, err
+	}
+	l, err := openLog(filepath.Join(br.dir, url.PathEscape(topic)), br.segmentSize, br.retention, br.fsync)
+	if err != nil {
+		return nil, err
+	}
+	br.topics[topic] = l
+	return l, nil
+}
+
+// validName checks that name, escaped, is a single path element that stays
+// in its parent directory.
+func validName(kind, name string) error {
+	switch name {
+	case "", ".", "..":
+		return fmt.Errorf("%w: %s %q", ErrInvalidName, kind, name)
+	}
+	return nil
+}
+
+// CheckHealth implements health.Checker interface.
+func (br *Broker[T]) CheckHealth(ctx context.Context) error {
+	if !br.opened.Load() {
+		return ErrInvalidConnectionState
+	}
+	return nil
+}
+
+// Close implements broker.Broker interface. It stops delivering new
+// messages, waits for in-flight handlers up to the context deadline
+// (broker.DefaultCloseTimeout if there is none) and closes all segment files. Messages that were
+// not acknowledged are redelivered to their queue group after the next Open.
+func (br *Broker[T]) Close(ctx context.Context) error {
+	br.mu.Lock()
+	if !br.opened.Load() {
+		br.mu.Unlock()
+		return nil
+	}
+	br.opened.Store(false)
+	var subs []*subscriber[T]
+	for _, g := range br.groups {
+		g.mu.Lock()
+		subs = append(subs, g.members...)
+		g.mu.Unlock()
+	}
+	br.mu.Unlock()
+
+	ctx, cancel := broker.CloseContext(ctx)
+	defer cancel()
+	// Stop delivering and let the handlers in flight return before stopping
+	// the groups, which cuts their retries short.
+	for _, sub := range subs {
+		sub.gate.Close()
+	}
+	var err error
+	for _, sub := range subs {
+		if err = sub.gate.Wait(ctx); err != nil {
+			break
+		}
+	}
+	br.cancel()
+	done := make(chan struct{})
+	go func() {
+		br.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	for _, g := range br.groups {
+		g.closeSubscribers()
+	}
+	clear(br.groups)
+	for _, l := range br.topics {
+		err = errors.Join(err, l.Close())
+	}
+	clear(br.topics)
+	return err
+}
@@ -0,0 +1,496 @@
+package file_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/file"
+)
+
+func open[T any](t *testing.T, dir string, opts ...file.Option[T]) *file.Broker[T] {
+	t.Helper()
+	b := file.New(dir, opts...)
+	if err := b.Open(context.Background()); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	return b
+}
+
+func receive(t *testing.T, ch <-chan string, n int) []string {
+	t.Helper()
+	var got []string
+	for range n {
+		select {
+		case m := <-ch:
+			got = append(got, m)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %v, want %d messages", got, n)
+		}
+	}
+	return got
+}
+
+func TestBroker_PublishSubscribe(t *testing.T) {
+	b := open[string](t, t.TempDir())
+	defer b.Close(context.Background())
+	if err := b.CheckHealth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	fanout := make(chan string, 10)
+	queue := make(chan string, 10)
+	sub, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		fanout <- *e.Message()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Topic() != "topic" {
+		t.Errorf("got topic=%s, want topic", sub.Topic())
+	}
+	for range 2 {
+		if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+			queue <- *e.Message()
+			return nil
+		}, broker.Queue("q1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 4 {
+		msg := fmt.Sprint(i)
+		if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"0", "1", "2", "3"}
+	if got := receive(t, fanout, 4); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("fan-out subscriber got %v, want %v", got, want)
+	}
+	if got := receive(t, queue, 4); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("queue group got %v, want %v exactly once and in order", got, want)
+	}
+}
+
+func TestBroker_QueueGroupResumesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	b := open[string](t, dir)
+	for i := range 3 {
+		msg := fmt.Sprint(i)
+		if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Acknowledge only the first two messages.
+	ch := make(chan string, 10)
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		if *e.Message() != "2" {
+			e.Ack()
+		}
+		ch <- *e.Message()
+		return nil
+	}, broker.Queue("q1"), broker.DisableAutoAck()); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, ch, 3); fmt.Sprint(got) != "[0 1 2]" {
+		t.Fatalf("got %v, want messages published before subscribing to be replayed", got)
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b = open[string](t, dir)
+	defer b.Close(context.Background())
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		ch <- *e.Message()
+		return nil
+	}, broker.Queue("q1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, ch, 1); got[0] != "2" {
+		t.Fatalf("got %v after restart, want only the unacknowledged message 2", got)
+	}
+	select {
+	case m := <-ch:
+		t.Fatalf("got unexpected redelivery of %q", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBroker_QueuePositionsDoNotShareFiles(t *testing.T) {
+	dir := t.TempDir()
+	b := open[string](t, dir)
+	for i := range 3 {
+		msg := fmt.Sprint(i)
+		if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The offset file of "q.tmp" is named like a temporary file of "q".
+	ch := make(chan string, 10)
+	for _, q := range []string{"q.tmp", "q"} {
+		sub, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+			ch <- *e.Message()
+			return nil
+		}, broker.Queue(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		receive(t, ch, 3)
+		if err := sub.Unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b = open[string](t, dir)
+	defer b.Close(context.Background())
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		ch <- *e.Message()
+		return nil
+	}, broker.Queue("q.tmp")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-ch:
+		t.Fatalf("got %q after restart, want the position of q.tmp kept", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBroker_SegmentRolling(t *testing.T) {
+	dir := t.TempDir()
+	b := open(t, dir, file.SegmentSize[string](64))
+	defer b.Close(context.Background())
+	n := 20
+	for i := range n {
+		msg := fmt.Sprintf("message-%02d", i)
+		if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "topic", "*.log"))
+	if len(segments) < 2 {
+		t.Fatalf("got %d segment files, want the log to roll over", len(segments))
+	}
+	ch := make(chan string, n)
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		ch <- *e.Message()
+		return nil
+	}, broker.Queue("q1")); err != nil {
+		t.Fatal(err)
+	}
+	got := receive(t, ch, n)
+	for i, m := range got {
+		if want := fmt.Sprintf("message-%02d", i); m != want {
+			t.Fatalf("got message %d = %q, want %q", i, m, want)
+		}
+	}
+}
+
+func TestBroker_TruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	b := open[string](t, dir)
+	msg := "complete"
+	if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+		t.Fatal(err)
+	}
+	b.Close(context.Background())
+
+	// Simulate a crash in the middle of writing a record.
+	segments, _ := filepath.Glob(filepath.Join(dir, "topic", "*.log"))
+	f, err := os.OpenFile(segments[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 42, 1, 2})
+	f.Close()
+
+	b = open[string](t, dir)
+	defer b.Close(context.Background())
+	next := "next"
+	if err := b.Publish(context.Background(), "topic", &next); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan string, 2)
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		if e.Error() != nil {
+			t.Errorf("unexpected event error: %v", e.Error())
+		}
+		ch <- *e.Message()
+		return nil
+	}, broker.Queue("q1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, ch, 2); fmt.Sprint(got) != "[complete next]" {
+		t.Fatalf("got %v, want [complete next]", got)
+	}
+}
+
+func TestBroker_InvalidState(t *testing.T) {
+	b := file.New[string](t.TempDir())
+	msg := "m"
+	if err := b.Publish(context.Background(), "topic", &msg); err != file.ErrInvalidConnectionState {
+		t.Errorf("Publish() before Open() error = %v, want %v", err, file.ErrInvalidConnectionState)
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Errorf("Close() without Open() error = %v", err)
+	}
+}
+
+func TestBroker_RejectsDelayedMessages(t *testing.T) {
+	b := open[string](t, t.TempDir())
+	defer b.Close(context.Background())
+	msg := "m"
+	if err := b.Publish(context.Background(), "topic", &msg, broker.Delay(time.Minute)); !errors.Is(err, broker.ErrDelayUnsupported) {
+		t.Errorf("Publish() error = %v, want %v", err, broker.ErrDelayUnsupported)
+	}
+}
+
+func TestBroker_RejectsAckWait(t *testing.T) {
+	b := open[string](t, t.TempDir())
+	defer b.Close(context.Background())
+	if _, err := b.Subscribe(context.Background(), "topic", func(broker.Event[string]) error { return nil },
+		broker.Queue("q1"), broker.DisableAutoAck(), broker.AckWait(time.Minute)); !errors.Is(err, file.ErrAckWaitUnsupported) {
+		t.Errorf("Subscribe() error = %v, want %v", err, file.ErrAckWaitUnsupported)
+	}
+}
+
+func TestBroker_NackRedeliversBeforeLaterMessages(t *testing.T) {
+	b := open[string](t, t.TempDir())
+	defer b.Close(context.Background())
+	ch := make(chan string, 10)
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		ch <- fmt.Sprintf("%s#%d", *e.Message(), e.Attempt())
+		if *e.Message() == "0" && e.Attempt() == 1 {
+			return e.Nack(10 * time.Millisecond)
+		}
+		return nil
+	}, broker.Queue("q1")); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		msg := fmt.Sprint(i)
+		if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := receive(t, ch, 3); fmt.Sprint(got) != "[0#1 0#2 1#1]" {
+		t.Errorf("got %v, want the nacked message redelivered before the next one", got)
+	}
+}
+
+func TestBroker_PauseResumeDrain(t *testing.T) {
+	b := open[string](t, t.TempDir())
+	defer b.Close(context.Background())
+	ch := make(chan string, 10)
+	var subs []broker.Subscriber
+	for _, name := range []string{"a", "b"} {
+		sub, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+			ch <- name + *e.Message()
+			return nil
+		}, broker.Queue("q1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		subs = append(subs, sub)
+	}
+	publish := func(msgs ...string) {
+		t.Helper()
+		for _, msg := range msgs {
+			if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The records go to the member left running.
+	if err := broker.Pause(subs[0]); err != nil {
+		t.Fatal(err)
+	}
+	publish("0", "1")
+	if got := receive(t, ch, 2); fmt.Sprint(got) != "[b0 b1]" {
+		t.Errorf("got %v, want the running member to get both records", got)
+	}
+
+	// The records wait while every member is paused.
+	if err := broker.Pause(subs[1]); err != nil {
+		t.Fatal(err)
+	}
+	publish("2")
+	select {
+	case m := <-ch:
+		t.Fatalf("got %s while every member is paused", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := broker.Resume(subs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, ch, 1); fmt.Sprint(got) != "[a2]" {
+		t.Errorf("got %v, want the resumed member to get the waiting record", got)
+	}
+
+	if err := broker.Drain(context.Background(), subs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Resume(subs[1]); err != nil {
+		t.Fatal(err)
+	}
+	publish("3")
+	if got := receive(t, ch, 1); fmt.Sprint(got) != "[b3]" {
+		t.Errorf("got %v, want the record to skip the drained member", got)
+	}
+}
+
+func TestBroker_RejectsNamesEscapingTheDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	b := open[string](t, dir)
+	defer b.Close(context.Background())
+	msg := "m"
+	for _, topic := range []string{"", ".", ".."} {
+		if err := b.Publish(context.Background(), topic, &msg); !errors.Is(err, file.ErrInvalidName) {
+			t.Errorf("Publish(%q) error = %v, want %v", topic, err, file.ErrInvalidName)
+		}
+	}
+	for _, queue := range []string{".", ".."} {
+		if _, err := b.Subscribe(context.Background(), "topic", func(broker.Event[string]) error { return nil }, broker.Queue(queue)); !errors.Is(err, file.ErrInvalidName) {
+			t.Errorf("Subscribe() with queue %q error = %v, want %v", queue, err, file.ErrInvalidName)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(dir)); len(entries) > 1 {
+		t.Errorf("got %d entries next to the broker directory, want none", len(entries)-1)
+	}
+}
+
+func TestBroker_UnackedMessageHoldsTheQueuePosition(t *testing.T) {
+	dir := t.TempDir()
+	b := open[string](t, dir)
+	for i := range 4 {
+		msg := fmt.Sprint(i)
+		if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Message 1 is left unacknowledged while later ones are acknowledged.
+	ch := make(chan string, 10)
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		if *e.Message() != "1" {
+			e.Ack()
+		}
+		ch <- *e.Message()
+		return nil
+	}, broker.Queue("q1"), broker.DisableAutoAck()); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, ch, 4)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b = open[string](t, dir)
+	defer b.Close(context.Background())
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		ch <- *e.Message()
+		return nil
+	}, broker.Queue("q1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, ch, 3); fmt.Sprint(got) != "[1 2 3]" {
+		t.Fatalf("got %v after restart, want the unacknowledged message 1 and the messages after it", got)
+	}
+}
+
+func TestBroker_FailedMessageDoesNotHoldTheQueuePositionUnderAutoAck(t *testing.T) {
+	dir := t.TempDir()
+	b := open[string](t, dir)
+	for i := range 3 {
+		msg := fmt.Sprint(i)
+		if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch := make(chan string, 10)
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		ch <- *e.Message()
+		if *e.Message() == "0" {
+			return errors.New("boom")
+		}
+		return nil
+	}, broker.Queue("q1")); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, ch, 3)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b = open[string](t, dir)
+	defer b.Close(context.Background())
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		ch <- *e.Message()
+		return nil
+	}, broker.Queue("q1")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-ch:
+		t.Fatalf("got %q after restart, want the position past the failed message", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBroker_RetentionDeletesOldSegments(t *testing.T) {
+	dir := t.TempDir()
+	b := open(t, dir, file.SegmentSize[string](64), file.Retention[string](time.Hour))
+	defer b.Close(context.Background())
+	publish := func(n int) {
+		for i := range n {
+			msg := fmt.Sprint(i)
+			if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	publish(10)
+	segments := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(dir, "topic", "*.log"))
+		return matches
+	}
+	old := segments()
+	if len(old) < 3 {
+		t.Fatalf("got %d segments, want several", len(old))
+	}
+	past := time.Now().Add(-2 * time.Hour)
+	for _, s := range old[:len(old)-1] {
+		if err := os.Chtimes(s, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rolling over looks for expired segments.
+	publish(3)
+	for _, s := range old[:len(old)-1] {
+		if _, err := os.Stat(s); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("segment %s older than the retention was kept", filepath.Base(s))
+		}
+	}
+
+	// A new queue group starts from the oldest segment left.
+	ch := make(chan string, 20)
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		ch <- *e.Message()
+		return nil
+	}, broker.Queue("q1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, ch, 1); got[0] == "0" {
+		t.Errorf("got message %s, want the messages of deleted segments skipped", got[0])
+	}
+}
@@ -0,0 +1,352 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+const offsetsDir = "offsets"
+
+// group delivers the records of a topic log to its member subscribers, one
+// record at a time and in log order, handing each record to the next member
+// in turn.
+type group[T any] struct {
+	br     *Broker[T]
+	log    *segmentLog
+	topic  string
+	path   string // offset file; empty for ephemeral groups
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	members   []*subscriber[T]
+	next      int
+	woken     chan struct{} // closed by wake
+	inflight  []*record     // delivered records not committed past, in log order
+	committed int64
+	start     int64
+}
+
+// record is a record delivered by a durable group, tracked until the group
+// commits past it.
+type record struct {
+	next  int64 // offset of the record after it
+	acked bool
+}
+
+func newGroup[T any](br *Broker[T], l *segmentLog, topic, queue string) (*group[T], error) {
+	ctx, cancel := context.WithCancel(br.ctx)
+	g := &group[T]{
+		br:     br,
+		log:    l,
+		topic:  topic,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	end, _ := l.End()
+	if queue == "" {
+		g.start = end
+		return g, nil
+	}
+	if err := validName("queue", queue); err != nil {
+		cancel()
+		return nil, err
+	}
+	g.path = filepath.Join(l.dir, offsetsDir, url.PathEscape(queue))
+	b, err := os.ReadFile(g.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		cancel()
+		return nil, err
+	default:
+		if g.start, err = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	// The log may have lost a torn tail since the offset was written.
+	g.start = min(g.start, end)
+	g.committed = g.start
+	return g, nil
+}
+
+func (g *group[T]) add(sub *subscriber[T]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.members = append(g.members, sub)
+	g.wakeLocked()
+}
+
+// wake wakes the group up if it waits for one of its paused members to
+// resume.
+func (g *group[T]) wake() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.wakeLocked()
+}
+
+func (g *group[T]) wakeLocked() {
+	if g.woken != nil {
+		close(g.woken)
+		g.woken = nil
+	}
+}
+
+// remove removes sub from the group and returns the number of members left.
+func (g *group[T]) remove(sub *subscriber[T]) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, m := range g.members {
+		if m == sub {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	return len(g.members)
+}
+
+// member returns the member that should receive the next record, skipping
+// paused and closed members. If every open member is paused, it returns a
+// channel closed once one resumes instead.
+func (g *group[T]) member() (*subscriber[T], <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	paused := false
+	for range g.members {
+		g.next = (g.next + 1) % len(g.members)
+		m := g.members[g.next]
+		switch {
+		case m.gate.Closed():
+		case m.gate.Paused():
+			paused = true
+		default:
+			return m, nil
+		}
+	}
+	if !paused {
+		return nil, nil
+	}
+	if g.woken == nil {
+		g.woken = make(chan struct{})
+	}
+	return nil, g.woken
+}
+
+// enter returns the member that may handle the next record, preferring sub,
+// once its gate lets it in. The caller must call Leave on the gate of the
+// member when done. It returns nil once the group has no open member or is
+// stopped.
+func (g *group[T]) enter(sub *subscriber[T]) *subscriber[T] {
+	for {
+		if sub == nil {
+			var woken <-chan struct{}
+			if sub, woken = g.member(); sub == nil {
+				if woken == nil {
+					return nil
+				}
+				select {
+				case <-woken:
+					continue
+				case <-g.ctx.Done():
+					return nil
+				}
+			}
+		}
+		if sub.gate.Enter(g.ctx) {
+			return sub
+		}
+		if g.ctx.Err() != nil {
+			return nil
+		}
+		sub = nil
+	}
+}
+
+func (g *group[T]) stop() {
+	g.cancel()
+}
+
+func (g *group[T]) closeSubscribers() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, m := range g.members {
+		m.closed.Store(true)
+		m.gate.Close()
+	}
+	g.members = nil
+}
+
+func (g *group[T]) run() {
+	r := g.log.Reader(g.start)
+	defer r.Close()
+	for {
+		_, appended := g.log.End()
+		data, next, err := r.Next()
+		if err == io.EOF {
+			select {
+			case <-appended:
+				continue
+			case <-g.ctx.Done():
+				return
+			}
+		}
+		if g.ctx.Err() != nil {
+			return
+		}
+		sub := g.enter(nil)
+		if sub == nil {
+			return
+		}
+		if err != nil {
+			// The log is unreadable past this point.
+			sub.h(&event[T]{t: g.topic, err: err, reason: broker.ReasonSubscriptionFailure})
+			sub.gate.Leave()
+			return
+		}
+		g.deliver(sub, data, next)
+	}
+}
+
+// deliver hands a record to sub, which the caller entered. A record nacked
+// while its handler runs is redelivered after the requested delay before the
+// group moves on, keeping log order. Under auto-ack, the record is
+// acknowledged once the handler returns, even when it failed, dropping it as
+// the other brokers do: it would otherwise hold the position of the group
+// back for good.
+func (g *group[T]) deliver(sub *subscriber[T], data []byte, next int64) {
+	var (
+		m      T
+		msg    broker.Message
+		err    error
+		reason broker.Reason
+	)
+	if err = proto.Unmarshal(data, &msg); err != nil {
+		reason = broker.ReasonUnmarshalFailure
+	} else if err = codec.Unmarshal(g.br.codec, msg.Header, msg.Body, &m); err != nil {
+		reason = broker.ReasonUnmarshalFailure
+	}
+	rec := g.track(next)
+	for attempt := 1; ; attempt++ {
+		nacks := make(chan time.Duration, 1)
+		e := &event[T]{
+			t:        g.topic,
+			msg:      &m,
+			headers:  msg.Header,
+			err:      err,
+			reason:   reason,
+			attempt:  attempt,
+			ack:      func() error { return g.ack(rec) },
+			delivery: broker.NewDelivery(0, func(delay time.Duration) { nacks <- delay }),
+		}
+		if err := broker.Handle(g.ctx, e, sub.h, sub.opts, g.br.Publish); sub.opts.AutoAck || errors.Is(err, broker.ErrDeadLettered) {
+			_ = e.Ack()
+		}
+		sub.gate.Leave()
+		select {
+		case delay := <-nacks:
+			if sleep(g.ctx, delay) != nil {
+				return
+			}
+			if sub = g.enter(sub); sub == nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// track starts tracking a record delivered by a durable group.
+func (g *group[T]) track(next int64) *record {
+	if g.path == "" {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rec := &record{next: next}
+	g.inflight = append(g.inflight, rec)
+	return rec
+}
+
+// ack acknowledges rec and commits the position after the acknowledged
+// records at the head of the log: a record that is not acknowledged holds
+// the position back, so that it is redelivered after a restart even when
+// later records were acknowledged.
+func (g *group[T]) ack(rec *record) error {
+	if rec == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if rec.acked {
+		return nil
+	}
+	rec.acked = true
+	pos := g.committed
+	for len(g.inflight) > 0 && g.inflight[0].acked {
+		pos = g.inflight[0].next
+		g.inflight = g.inflight[1:]
+	}
+	if pos == g.committed {
+		return nil
+	}
+	g.committed = pos
+	return g.persist(pos)
+}
+
+// persist writes pos to the offset file of the group, atomically, and to
+// stable storage under the Fsync option.
+func (g *group[T]) persist(pos int64) error {
+	dir := filepath.Dir(g.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// "%t" is no escape sequence, so url.PathEscape never names a queue's
+	// offset file like a temporary one, even one left behind by a crash.
+	f, err := os.CreateTemp(dir, "%tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strconv.FormatInt(pos, 10))
+	if err == nil && g.br.fsync {
+		err = f.Sync()
+	}
+	if err = errors.Join(err, f.Close()); err == nil {
+		err = os.Rename(f.Name(), g.path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if g.br.fsync {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,345 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExt = ".log"
+	// frameHeader is the size of the length and CRC-32 prefix of a record.
+	frameHeader = 8
+)
+
+var errCorrupt = errors.New("file: corrupt record")
+
+type (
+	// segmentLog is an append-only log split into segment files. A record is
+	// addressed by its logical offset, the byte position of its frame across
+	// all segments; each segment file is named after the offset of its first
+	// record.
+	segmentLog struct {
+		dir       string
+		maxSize   int64
+		retention time.Duration
+		fsync     bool
+
+		mu       sync.RWMutex
+		bases    []int64
+		active   *os.File
+		end      int64
+		appended chan struct{}
+	}
+
+	// logReader reads records sequentially from a segmentLog.
+	logReader struct {
+		log    *segmentLog
+		offset int64
+		base   int64
+		f      *os.File
+	}
+)
+
+// openLog opens or creates the log in dir, truncating a torn record left at
+// the tail of the last segment by an interrupted write, and deleting the
+// segments past retention, if positive.
+func openLog(dir string, maxSize int64, retention time.Duration, fsync bool) (*segmentLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &segmentLog{
+		dir:       dir,
+		maxSize:   maxSize,
+		retention: retention,
+		fsync:     fsync,
+		appended:  make(chan struct{}),
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.bases = append(l.bases, base)
+	}
+	slices.Sort(l.bases)
+	if len(l.bases) == 0 {
+		l.bases = []int64{0}
+	}
+	last := l.bases[len(l.bases)-1]
+	f, err := os.OpenFile(l.segmentPath(last), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	size, err := validSize(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fsync {
+		if err := syncDir(dir); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	l.active = f
+	l.end = last + size
+	l.expire()
+	return l, nil
+}
+
+// validSize returns the length of the longest prefix of f made of complete,
+// uncorrupted records.
+func validSize(f *os.File) (int64, error) {
+	var pos int64
+	for {
+		n, err := frameAt(f, pos, nil)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, errCorrupt) {
+			return pos, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		pos += n
+	}
+}
+
+// frameAt reads the record at pos in f. If payload is non-nil the record
+// data is stored into it. It returns the total size of the frame.
+func frameAt(f *os.File, pos int64, payload *[]byte) (int64, error) {
+	var hdr [frameHeader]byte
+	if n, err := f.ReadAt(hdr[:], pos); err != nil {
+		if err == io.EOF && n > 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	size := binary.BigEndian.Uint32(hdr[0:4])
+	sum := binary.BigEndian.Uint32(hdr[4:8])
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, pos+frameHeader); err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	if crc32.ChecksumIEEE(data) != sum {
+		return 0, errCorrupt
+	}
+	if payload != nil {
+		*payload = data
+	}
+	return frameHeader + int64(size), nil
+}
+
+func (l *segmentLog) segmentPath(base int64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+// Append writes data as a new record and wakes up readers waiting for it.
+func (l *segmentLog) Append(data []byte) error {
+	frame := make([]byte, frameHeader+len(data))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(data))
+	copy(frame[frameHeader:], data)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active == nil {
+		return ErrInvalidConnectionState
+	}
+	base := l.bases[len(l.bases)-1]
+	if l.end > base && l.end-base+int64(len(frame)) > l.maxSize {
+		if err := l.roll(); err != nil {
+			return err
+		}
+	}
+	if _, err := l.active.Write(frame); err != nil {
+		return err
+	}
+	if l.fsync {
+		if err := l.active.Sync(); err != nil {
+			return err
+		}
+	}
+	l.end += int64(len(frame))
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return nil
+}
+
+func (l *segmentLog) roll() error {
+	f, err := os.OpenFile(l.segmentPath(l.end), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := l.active.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if l.fsync {
+		if err := syncDir(l.dir); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	l.active = f
+	l.bases = append(l.bases, l.end)
+	l.expire()
+	return nil
+}
+
+// expire deletes the oldest segments last written more than the retention
+// ago, never the active one. The caller holds the write lock, or has the
+// log to itself.
+func (l *segmentLog) expire() {
+	if l.retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-l.retention)
+	for len(l.bases) > 1 {
+		path := l.segmentPath(l.bases[0])
+		if fi, err := os.Stat(path); err == nil && fi.ModTime().After(cutoff) {
+			return
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		l.bases = l.bases[1:]
+	}
+}
+
+// syncDir flushes the entries of dir to stable storage, so that files
+// created or renamed in it survive an operating system crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.Sync(), d.Close())
+}
+
+// End returns the offset one past the last record, and a channel closed on
+// the next Append.
+func (l *segmentLog) End() (int64, <-chan struct{}) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.end, l.appended
+}
+
+// Reader returns a reader positioned at offset.
+func (l *segmentLog) Reader(offset int64) *logReader {
+	return &logReader{log: l, offset: offset, base: -1}
+}
+
+// Close flushes and closes the active segment.
+func (l *segmentLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active == nil {
+		return nil
+	}
+	err := errors.Join(l.active.Sync(), l.active.Close())
+	l.active = nil
+	return err
+}
+
+// segmentFor returns the base of the segment containing offset.
+func (l *segmentLog) segmentFor(offset int64) int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i := sort.Search(len(l.bases), func(i int) bool { return l.bases[i] > offset })
+	if i == 0 {
+		return l.bases[0]
+	}
+	return l.bases[i-1]
+}
+
+// Next returns the record at the reader's offset and the offset of the
+// record after it. It returns io.EOF when there is no record to read yet.
+func (r *logReader) Next() (data []byte, next int64, err error) {
+	end, _ := r.log.End()
+	for {
+		if r.offset >= end {
+			return nil, 0, io.EOF
+		}
+		base := r.log.segmentFor(r.offset)
+		if r.offset < base {
+			// The segment was deleted by retention: skip its records.
+			r.offset = base
+		}
+		if base != r.base || r.f == nil {
+			r.Close()
+			f, err := os.Open(r.log.segmentPath(base))
+			if errors.Is(err, os.ErrNotExist) && base != r.log.segmentFor(r.offset) {
+				// Deleted by retention meanwhile.
+				continue
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			r.f, r.base = f, base
+		}
+		n, err := frameAt(r.f, r.offset-base, &data)
+		if err == io.EOF {
+			// The end of a rolled segment: continue with the next one.
+			r.offset = r.nextBase(base)
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		r.offset += n
+		return data, r.offset, nil
+	}
+}
+
+func (r *logReader) nextBase(base int64) int64 {
+	r.log.mu.RLock()
+	defer r.log.mu.RUnlock()
+	i := sort.Search(len(r.log.bases), func(i int) bool { return r.log.bases[i] > base })
+	if i == len(r.log.bases) {
+		return r.log.end
+	}
+	return r.log.bases[i]
+}
+
+// Close releases the reader's open segment file.
+func (r *logReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,58 @@
+package file
+
+import (
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/metric"
+)
+
+// Codec is an option to provide a custom codec. Default is codec.JSON.
+// Codecs from the broker/codec package also record the content-type header.
+func Codec[T any](c broker.Codec[T]) Option[T] {
+	return func(b *Broker[T]) {
+		b.codec = c
+	}
+}
+
+// SegmentSize is an option to set the size in bytes after which a topic log
+// rolls over to a new segment file. Default is 64MiB.
+func SegmentSize[T any](size int64) Option[T] {
+	return func(b *Broker[T]) {
+		if size > 0 {
+			b.segmentSize = size
+		}
+	}
+}
+
+// Retention is an option to delete the segments of a topic log once they
+// were last written more than maxAge ago. The active segment is never
+// deleted, and expired segments are looked for when the log opens and when
+// it rolls over to a new segment. Queue groups positioned in a deleted
+// segment resume from the oldest segment left. Default is to keep every
+// segment.
+func Retention[T any](maxAge time.Duration) Option[T] {
+	return func(b *Broker[T]) {
+		if maxAge > 0 {
+			b.retention = maxAge
+		}
+	}
+}
+
+// Fsync is an option to sync every published message to stable storage
+// before Publish returns, along with the offset files of queue groups and the
+// directory entries of new files. Without it, a published message survives a
+// process crash but not necessarily an operating system crash.
+func Fsync[T any]() Option[T] {
+	return func(b *Broker[T]) {
+		b.fsync = true
+	}
+}
+
+// Metrics is an option to report the standard broker metrics through r, see
+// broker.Metrics. The file broker reports no lag.
+func Metrics[T any](r metric.Reporter) Option[T] {
+	return func(b *Broker[T]) {
+		b.metrics = broker.NewMetrics(r)
+	}
+}
@@ -0,0 +1,131 @@
+package file
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+type (
+	subscriber[T any] struct {
+		id     string
+		t      string
+		h      func(broker.Event[T]) error
+		opts   *broker.SubscribeOptions
+		gate   broker.Gate
+		closed atomic.Bool
+		// leave removes the subscriber from its group and returns the
+		// function stopping the group, a no-op while it has other members.
+		leave func() (stop func())
+		// wake wakes the group up after Resume.
+		wake func()
+	}
+
+	event[T any] struct {
+		t        string
+		msg      *T
+		headers  map[string]string
+		err      error
+		reason   broker.Reason
+		attempt  int
+		ack      func() error
+		delivery *broker.Delivery
+	}
+)
+
+func (e *event[T]) Topic() string {
+	return e.t
+}
+
+func (e *event[T]) Message() *T {
+	return e.msg
+}
+
+func (e *event[T]) Headers() map[string]string {
+	return e.headers
+}
+
+func (e *event[T]) ID() string {
+	return e.headers[broker.HeaderMessageID]
+}
+
+func (e *event[T]) Timestamp() time.Time {
+	return broker.TimestampFrom(e.headers)
+}
+
+// Attempt counts redeliveries after Nack. Messages of a queue group left
+// unacknowledged are redelivered after a restart without a record of earlier
+// attempts.
+func (e *event[T]) Attempt() int {
+	return e.attempt
+}
+
+// Ack commits the group's position past this message. Unacknowledged
+// messages of a queue group are redelivered after a restart.
+func (e *event[T]) Ack() error {
+	if e.ack == nil || !e.delivery.Ack() {
+		return nil
+	}
+	return e.ack()
+}
+
+// Nack redelivers the message after delay, holding back later messages of
+// the group until then. It only has an effect while the handler runs.
+func (e *event[T]) Nack(delay time.Duration) error {
+	e.delivery.Nack(delay)
+	return nil
+}
+
+func (e *event[T]) Error() error {
+	return e.err
+}
+
+func (e *event[T]) Reason() broker.Reason {
+	return e.reason
+}
+
+// Topic implements broker.Subscriber interface.
+func (sub *subscriber[T]) Topic() string {
+	return sub.t
+}
+
+// Unsubscribe implements broker.Subscriber interface.
+func (sub *subscriber[T]) Unsubscribe() error {
+	if sub.closed.Swap(true) {
+		return nil
+	}
+	sub.gate.Close()
+	sub.leave()()
+	return nil
+}
+
+// Pause implements broker.LifecycleSubscriber interface. The records of a
+// queue group go to the other members meanwhile, and wait in the log while
+// every member is paused.
+func (sub *subscriber[T]) Pause() error {
+	sub.gate.Pause()
+	return nil
+}
+
+// Resume implements broker.LifecycleSubscriber interface.
+func (sub *subscriber[T]) Resume() error {
+	sub.gate.Resume()
+	sub.wake()
+	return nil
+}
+
+// Drain implements broker.LifecycleSubscriber interface. Records not yet
+// delivered stay in the log for the other members of the queue group, or for
+// the group after a restart if they were not acknowledged.
+func (sub *subscriber[T]) Drain(ctx context.Context) error {
+	if sub.closed.Swap(true) {
+		return nil
+	}
+	sub.gate.Close()
+	stop := sub.leave()
+	err := sub.gate.Wait(ctx)
+	stop()
+	return err
+}
@@ -0,0 +1,37 @@
+package broker
+
+import "time"
+
+// Well-known header keys shared by helpers and Broker implementations.
+const (
+	// HeaderMessageID carries the unique ID of a message. Publish sets it
+	// unless the producer already did; producers that may publish the same
+	// message more than once, such as an outbox relay, set it themselves so
+	// consumers can discard duplicates.
+	HeaderMessageID = "message-id"
+
+	// HeaderTimestamp carries the time the message was first published, in
+	// RFC 3339 format with nanoseconds.
+	HeaderTimestamp = "timestamp"
+
+	// HeaderCorrelationID carries the ID that ties a reply to its request.
+	HeaderCorrelationID = "correlation-id"
+
+	// HeaderReplyTo carries the topic a reply to the message is published
+	// to.
+	HeaderReplyTo = "reply-to"
+
+	// HeaderContentType carries the media type of the encoded message, such
+	// as "application/json". Brokers set it from codecs that declare one.
+	HeaderContentType = "content-type"
+)
+
+// TimestampFrom returns the time recorded in the HeaderTimestamp header, or
+// the zero time if it is missing or malformed.
+func TimestampFrom(headers map[string]string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, headers[HeaderTimestamp])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,189 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultCloseTimeout bounds Broker.Close when its context has no deadline.
+const DefaultCloseTimeout = 5 * time.Second
+
+type (
+	// LifecycleSubscriber is implemented by subscriptions that can be paused
+	// and drained, e.g. to stop taking messages during a deploy. Use Pause,
+	// Resume and Drain to control any Subscriber.
+	LifecycleSubscriber interface {
+		Subscriber
+
+		// Pause stops handing messages to the handler until Resume. Handlers
+		// already running finish; new messages wait in the broker, or in
+		// the process for brokers that push them, as documented by each
+		// implementation.
+		Pause() error
+
+		// Resume undoes Pause.
+		Resume() error
+
+		// Drain stops the subscription from taking new messages, waits
+		// until the handlers in flight return and then unsubscribes. Brokers
+		// that buffer messages in the process, where they would be lost,
+		// handle those first. If ctx is done before, Drain unsubscribes
+		// right away, leaving running handlers to finish on their own, and
+		// returns the context error.
+		Drain(ctx context.Context) error
+	}
+
+	// Gate pauses and tracks the handler calls of a subscription. It helps
+	// Broker implementations implement LifecycleSubscriber the same way: every
+	// handler call is made between Enter and Leave, Pause holds back Enter
+	// until Resume, and Wait waits for the calls in flight. The zero value is
+	// an open Gate.
+	Gate struct {
+		mu     sync.Mutex
+		paused chan struct{} // closed by Resume
+		closed bool
+		active int
+		idle   chan struct{} // closed when active drops to zero
+	}
+)
+
+// Pause pauses s if it implements LifecycleSubscriber, and returns
+// errors.ErrUnsupported otherwise.
+func Pause(s Subscriber) error {
+	if l, ok := s.(LifecycleSubscriber); ok {
+		return l.Pause()
+	}
+	return errors.ErrUnsupported
+}
+
+// Resume resumes s if it implements LifecycleSubscriber, and returns
+// errors.ErrUnsupported otherwise.
+func Resume(s Subscriber) error {
+	if l, ok := s.(LifecycleSubscriber); ok {
+		return l.Resume()
+	}
+	return errors.ErrUnsupported
+}
+
+// Drain drains s if it implements LifecycleSubscriber, and unsubscribes it
+// otherwise.
+func Drain(ctx context.Context, s Subscriber) error {
+	if l, ok := s.(LifecycleSubscriber); ok {
+		return l.Drain(ctx)
+	}
+	return s.Unsubscribe()
+}
+
+// CloseContext returns ctx bounded by DefaultCloseTimeout if it has no
+// deadline, for Broker.Close implementations.
+func CloseContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, DefaultCloseTimeout)
+}
+
+// Enter waits while the gate is paused and reports whether the caller may
+// call the handler: it may not once the gate is closed or ctx is done. Every
+// Enter that returns true must be followed by Leave.
+func (g *Gate) Enter(ctx context.Context) bool {
+	for {
+		g.mu.Lock()
+		if g.closed {
+			g.mu.Unlock()
+			return false
+		}
+		paused := g.paused
+		if paused == nil {
+			g.active++
+			g.mu.Unlock()
+			return true
+		}
+		g.mu.Unlock()
+		select {
+		case <-paused:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// Leave ends a handler call started by Enter.
+func (g *Gate) Leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.active == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// Pause holds back Enter until Resume.
+func (g *Gate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused == nil && !g.closed {
+		g.paused = make(chan struct{})
+	}
+}
+
+// Resume releases the callers of Enter held back by Pause.
+func (g *Gate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.resume()
+}
+
+// Paused reports whether the gate is paused.
+func (g *Gate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused != nil
+}
+
+// Close makes Enter return false from now on, including for the callers
+// held back by Pause.
+func (g *Gate) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	g.resume()
+}
+
+// Closed reports whether the gate is closed.
+func (g *Gate) Closed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closed
+}
+
+// Wait waits until no handler call is in flight or ctx is done, returning
+// the context error then.
+func (g *Gate) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	if g.active == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	g.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *Gate) resume() {
+	if g.paused != nil {
+		close(g.paused)
+		g.paused = nil
+	}
+}
@@ -0,0 +1,67 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+func TestLifecycle_FallsBackForPlainSubscribers(t *testing.T) {
+	b := &batchStubBroker{}
+	if err := broker.Pause(b); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("Pause() error = %v, want errors.ErrUnsupported", err)
+	}
+	if err := broker.Resume(b); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("Resume() error = %v, want errors.ErrUnsupported", err)
+	}
+	if err := broker.Drain(context.Background(), b); err != nil || !b.unsubscribed {
+		t.Fatalf("Drain() error = %v, unsubscribed %v, want the subscriber unsubscribed", err, b.unsubscribed)
+	}
+}
+
+func TestGate(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var g broker.Gate
+		if !g.Enter(context.Background()) {
+			t.Fatal("Enter() = false on an open gate")
+		}
+		g.Pause()
+		entered := make(chan bool)
+		go func() { entered <- g.Enter(context.Background()) }()
+		synctest.Wait()
+		select {
+		case <-entered:
+			t.Fatal("Enter() returned while paused")
+		default:
+		}
+
+		// Wait waits for the call in flight.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := g.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Wait() error = %v, want the deadline while a call is in flight", err)
+		}
+		g.Leave()
+		if err := g.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		g.Resume()
+		if !<-entered {
+			t.Fatal("Enter() = false after Resume")
+		}
+		g.Leave()
+
+		g.Pause()
+		go func() { entered <- g.Enter(context.Background()) }()
+		synctest.Wait()
+		g.Close()
+		if <-entered || g.Enter(context.Background()) || !g.Closed() {
+			t.Fatal("Enter() = true on a closed gate")
+		}
+	})
+}
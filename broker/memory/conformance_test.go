@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/brokertest"
+	"github.com/pthethanh/nano/broker/memory"
+)
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) broker.Broker[brokertest.Message] {
+		return memory.New[brokertest.Message]()
+	})
+}
@@ -0,0 +1,278 @@
+package memory
+
+import (
+	"context"
+	"sync"
+)
+
+// OverflowPolicy decides what Publish does with a message for a subscriber
+// whose queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Publish wait for room in the queue until its
+	// context is done, returning the context error then. It is the default.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest message waiting in the queue to
+	// make room.
+	OverflowDropOldest
+	// OverflowDropNewest discards the published message.
+	OverflowDropNewest
+	// OverflowError makes Publish return ErrQueueFull.
+	OverflowError
+)
+
+type (
+	// inbox holds the messages waiting for a subscriber, up to a capacity.
+	// Messages are spread over lanes, each drained by at most one goroutine
+	// at a time, started on demand. Messages with a key always go to the
+	// same lane, so they are handled in publish order; the others go to the
+	// least busy lane.
+	inbox[T any] struct {
+		handle func(*event[T])
+
+		mu      sync.Mutex
+		lanes   []lane[T]
+		size    int
+		cap     int
+		seq     uint64
+		next    int
+		closed  bool
+		waiters int
+		space   chan struct{} // closed and replaced when room is made
+		paused  chan struct{} // closed by resume
+		running int           // lanes being drained
+		idle    chan struct{} // closed when running drops to zero
+	}
+
+	lane[T any] struct {
+		items   []item[T]
+		running bool
+	}
+
+	item[T any] struct {
+		seq uint64
+		env *event[T]
+	}
+)
+
+func newInbox[T any](lanes, capacity int, handle func(*event[T])) *inbox[T] {
+	return &inbox[T]{
+		handle: handle,
+		lanes:  make([]lane[T], lanes),
+		cap:    capacity,
+		space:  make(chan struct{}),
+	}
+}
+
+// push adds env to the queue, applying policy if it is full. Messages pushed
+// to a closed queue are discarded.
+func (q *inbox[T]) push(ctx context.Context, env *event[T], policy OverflowPolicy) error {
+	q.mu.Lock()
+	for q.size >= q.cap && !q.closed {
+		switch policy {
+		case OverflowDropOldest:
+			q.dropOldest()
+		case OverflowDropNewest:
+			q.mu.Unlock()
+			return nil
+		case OverflowError:
+			q.mu.Unlock()
+			return ErrQueueFull
+		default:
+			space := q.space
+			q.waiters++
+			q.mu.Unlock()
+			var err error
+			select {
+			case <-space:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			q.mu.Lock()
+			q.waiters--
+			if err != nil {
+				q.mu.Unlock()
+				return err
+			}
+		}
+	}
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	i := q.lane(env.key)
+	q.seq++
+	q.lanes[i].items = append(q.lanes[i].items, item[T]{seq: q.seq, env: env})
+	q.size++
+	start := !q.lanes[i].running
+	if start {
+		q.lanes[i].running = true
+		q.running++
+	}
+	q.mu.Unlock()
+	if start {
+		go q.drain(i)
+	}
+	return nil
+}
+
+// lane returns the lane for a message with key. The caller must hold q.mu.
+func (q *inbox[T]) lane(key string) int {
+	if key != "" {
+		return int(hash(key) % uint32(len(q.lanes)))
+	}
+	best, load := 0, -1
+	for n := range q.lanes {
+		i := (q.next + n) % len(q.lanes)
+		l := len(q.lanes[i].items)
+		if q.lanes[i].running {
+			l++
+		}
+		if load < 0 || l < load {
+			best, load = i, l
+		}
+		if l == 0 {
+			break
+		}
+	}
+	q.next = (best + 1) % len(q.lanes)
+	return best
+}
+
+// dropOldest discards the message that has waited longest. The caller must
+// hold q.mu.
+func (q *inbox[T]) dropOldest() {
+	oldest := -1
+	for i, l := range q.lanes {
+		if len(l.items) > 0 && (oldest < 0 || l.items[0].seq < q.lanes[oldest].items[0].seq) {
+			oldest = i
+		}
+	}
+	if oldest >= 0 {
+		q.pop(oldest)
+	}
+}
+
+// pop removes the first message of lane i. The caller must hold q.mu.
+func (q *inbox[T]) pop(i int) *event[T] {
+	l := &q.lanes[i]
+	env := l.items[0].env
+	l.items[0] = item[T]{}
+	l.items = l.items[1:]
+	q.size--
+	q.signal()
+	return env
+}
+
+// signal wakes up the publishers waiting for room. The caller must hold
+// q.mu.
+func (q *inbox[T]) signal() {
+	if q.waiters > 0 {
+		close(q.space)
+		q.space = make(chan struct{})
+	}
+}
+
+func (q *inbox[T]) drain(i int) {
+	for {
+		q.mu.Lock()
+		for q.paused != nil {
+			paused := q.paused
+			q.mu.Unlock()
+			<-paused
+			q.mu.Lock()
+		}
+		if len(q.lanes[i].items) == 0 {
+			q.lanes[i].running = false
+			q.running--
+			if q.running == 0 && q.idle != nil {
+				close(q.idle)
+				q.idle = nil
+			}
+			q.mu.Unlock()
+			return
+		}
+		env := q.pop(i)
+		q.mu.Unlock()
+		q.handle(env)
+	}
+}
+
+// len returns the number of messages waiting in the queue.
+func (q *inbox[T]) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// count returns the number of messages published to topic waiting in the
+// queue.
+func (q *inbox[T]) count(topic string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, l := range q.lanes {
+		for _, it := range l.items {
+			if it.env.t == topic {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// pause stops the lanes from taking messages until resume.
+func (q *inbox[T]) pause() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.paused == nil && !q.closed {
+		q.paused = make(chan struct{})
+	}
+}
+
+func (q *inbox[T]) resume() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.paused != nil {
+		close(q.paused)
+		q.paused = nil
+	}
+}
+
+// close stops the queue from taking new messages, resumes it and releases
+// the publishers waiting for room. Waiting messages are still handled unless
+// discard is set.
+func (q *inbox[T]) close(discard bool) {
+	q.mu.Lock()
+	q.closed = true
+	if discard {
+		for i := range q.lanes {
+			q.lanes[i].items = nil
+		}
+		q.size = 0
+	}
+	q.signal()
+	q.mu.Unlock()
+	q.resume()
+}
+
+// wait waits until no lane is being drained or ctx is done.
+func (q *inbox[T]) wait(ctx context.Context) error {
+	q.mu.Lock()
+	if q.running == 0 {
+		q.mu.Unlock()
+		return nil
+	}
+	if q.idle == nil {
+		q.idle = make(chan struct{})
+	}
+	idle := q.idle
+	q.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
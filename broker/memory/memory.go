@@ -2,14 +2,20 @@
 package memory
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"hash/fnv"
 	"math/rand"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
 )
 
 type (
@@ -18,54 +24,81 @@ type (
 	id    = string
 	// Broker is a memory message broker.
 	Broker[T any] struct {
-		subs   map[topic]map[queue]map[id]*subscriber[T]
-		mu     *sync.RWMutex
-		ch     chan func() error
-		worker int
-		buf    int
-		wg     *sync.WaitGroup
-		opened atomic.Bool
+		subs     map[topic]map[queue]map[id]*subscriber[T]
+		patterns map[topic]bool // topics of subs that are patterns
+		mu       *sync.RWMutex
+		worker   int
+		buf      int
+		overflow OverflowPolicy
+		codec    broker.Codec[T]
+		schedule *broker.Scheduler[T]
+		metrics  *broker.Metrics
+		opened   atomic.Bool
+		ctx      context.Context
+		cancel   context.CancelFunc
 	}
 
 	subscriber[T any] struct {
-		id     string
-		t      string
-		h      func(broker.Event[T]) error
-		opts   *broker.SubscribeOptions
-		closed int32
-		close  func()
+		id   string
+		t    string
+		h    func(broker.Event[T]) error
+		opts *broker.SubscribeOptions
+		// inbox holds the messages waiting for the handler.
+		inbox *inbox[T]
+		// lag reports the length of inbox.
+		lag *broker.Lag
+		// batcher groups the events of a SubscribeBatch subscription.
+		batcher *broker.Batcher[T]
+		closed  int32
+		close   func()
 	}
 
 	event[T any] struct {
-		t      string
-		msg    *T
-		err    error
-		reason broker.Reason
+		t        string
+		msg      *T
+		headers  map[string]string
+		key      string
+		err      error
+		reason   broker.Reason
+		attempt  int
+		delivery *broker.Delivery
 	}
 
 	Option[T any] func(*Broker[T])
 )
 
 var (
-	_ broker.Broker[any] = (*Broker[any])(nil)
+	_ broker.Broker[any]          = (*Broker[any])(nil)
+	_ broker.BatchPublisher[any]  = (*Broker[any])(nil)
+	_ broker.BatchSubscriber[any] = (*Broker[any])(nil)
+	_ broker.Canceler             = (*Broker[any])(nil)
+
+	_ broker.LifecycleSubscriber = (*subscriber[any])(nil)
 
 	// ErrInvalidConnectionState indicate that the connection has not been opened properly.
 	ErrInvalidConnectionState = errors.New("invalid connection state")
+
+	// ErrQueueFull is returned by Publish under OverflowError when the queue
+	// of a subscriber is full.
+	ErrQueueFull = errors.New("subscriber queue is full")
 )
 
 // New return new memory broker.
 func New[T any](opts ...Option[T]) *Broker[T] {
 	br := &Broker[T]{
-		subs:   make(map[topic]map[queue]map[id]*subscriber[T]),
-		mu:     &sync.RWMutex{},
-		worker: 100,
-		buf:    10_000,
-		wg:     &sync.WaitGroup{},
+		subs:     make(map[topic]map[queue]map[id]*subscriber[T]),
+		patterns: make(map[topic]bool),
+		mu:       &sync.RWMutex{},
+		worker:   100,
+		buf:      10_000,
 	}
+	br.ctx, br.cancel = context.WithCancel(context.Background())
 	for _, opt := range opts {
 		opt(br)
 	}
-	br.ch = make(chan func() error, br.buf)
+	br.schedule = broker.NewScheduler(func(ctx context.Context, topic string, m *T, opts ...broker.PublishOption) error {
+		return br.publish(ctx, topic, []*T{m}, opts)
+	}, 0)
 	return br
 }
 
@@ -77,7 +110,35 @@ func (env *event[T]) Message() *T {
 	return env.msg
 }
 
+func (env *event[T]) Headers() map[string]string {
+	return env.headers
+}
+
+func (env *event[T]) ID() string {
+	return env.headers[broker.HeaderMessageID]
+}
+
+func (env *event[T]) Timestamp() time.Time {
+	return broker.TimestampFrom(env.headers)
+}
+
+// Key returns the ordering key the message was published with.
+func (env *event[T]) Key() string {
+	return env.key
+}
+
+func (env *event[T]) Attempt() int {
+	return env.attempt
+}
+
 func (env *event[T]) Ack() error {
+	env.delivery.Ack()
+	return nil
+}
+
+// Nack redelivers the message to the same subscriber after delay.
+func (env *event[T]) Nack(delay time.Duration) error {
+	env.delivery.Nack(delay)
 	return nil
 }
 
@@ -103,50 +164,203 @@ func (sub *subscriber[T]) Unsubscribe() error {
 	return nil
 }
 
+// Pause implements broker.LifecycleSubscriber interface. Messages published
+// meanwhile wait in the queue of the subscriber, subject to the Overflow
+// policy.
+func (sub *subscriber[T]) Pause() error {
+	sub.inbox.pause()
+	return nil
+}
+
+// Resume implements broker.LifecycleSubscriber interface.
+func (sub *subscriber[T]) Resume() error {
+	sub.inbox.resume()
+	return nil
+}
+
+// Drain implements broker.LifecycleSubscriber interface. The messages already
+// in the queue of the subscriber are handled before it unsubscribes; those
+// left when ctx is done are dropped.
+func (sub *subscriber[T]) Drain(ctx context.Context) error {
+	sub.inbox.close(false)
+	err := sub.inbox.wait(ctx)
+	_ = sub.Unsubscribe()
+	return err
+}
+
+// QueueDepth returns the number of messages waiting in the queue of the
+// subscriber.
+func (sub *subscriber[T]) QueueDepth() int {
+	return sub.inbox.len()
+}
+
 func (sub *subscriber[T]) isClosed() bool {
 	return atomic.LoadInt32(&sub.closed) > 0
 }
 
 // Open implements broker.Broker interface.
 func (br *Broker[T]) Open(ctx context.Context) error {
-	if !br.opened.CompareAndSwap(false, true) {
-		// Already open: don't spawn a second set of worker goroutines.
-		return nil
+	// Worker goroutines are started on demand by the subscriber queues.
+	br.opened.Store(true)
+	return nil
+}
+
+// Publish implements broker.Broker interface. The message is added to the
+// queue of every receiving subscriber; when a queue is full, the Overflow
+// policy applies, blocking until ctx is done by default. Publish returns the
+// first error of a queue after adding the message to the others.
+//
+// Messages published with broker.Key are handled in publish order: every
+// message of a key goes to the same member of a queue group and is handled
+// by the same worker of that subscriber. Nacked messages are redelivered
+// outside of that order.
+//
+// Messages delayed with broker.Delay or broker.DeliverAt are held by an
+// in-process broker.Scheduler until they are due, and are dropped by Close.
+func (br *Broker[T]) Publish(ctx context.Context, topic string, m *T, opts ...broker.PublishOption) error {
+	if !br.opened.Load() {
+		return ErrInvalidConnectionState
 	}
-	for i := 0; i < br.worker; i++ {
-		br.wg.Go(func() {
-			for h := range br.ch {
-				_ = h()
-			}
-		})
+	start := time.Now()
+	err := br.schedule.Publish(ctx, topic, m, opts...)
+	br.metrics.Publish(topic, 1, start, err)
+	return err
+}
+
+// PublishBatch implements broker.BatchPublisher interface. Either all
+// messages are published or, if one fails to encode, none is. Delayed
+// messages are scheduled one by one.
+func (br *Broker[T]) PublishBatch(ctx context.Context, topic string, ms []*T, opts ...broker.PublishOption) error {
+	start := time.Now()
+	err := br.publishBatch(ctx, topic, ms, opts)
+	br.metrics.Publish(topic, len(ms), start, err)
+	return err
+}
+
+func (br *Broker[T]) publishBatch(ctx context.Context, topic string, ms []*T, opts []broker.PublishOption) error {
+	var popts broker.PublishOptions
+	popts.Apply(opts...)
+	if !popts.Delayed() {
+		return br.publish(ctx, topic, ms, opts)
+	}
+	if !br.opened.Load() {
+		return ErrInvalidConnectionState
+	}
+	for _, m := range ms {
+		if err := br.schedule.Publish(ctx, topic, m, opts...); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// Publish implements broker.Broker interface.
-func (br *Broker[T]) Publish(ctx context.Context, topic string, m *T, opts ...broker.PublishOption) error {
+// Cancel implements broker.Canceler interface.
+func (br *Broker[T]) Cancel(ctx context.Context, id string) error {
+	return br.schedule.Cancel(id)
+}
+
+func (br *Broker[T]) publish(ctx context.Context, topic string, ms []*T, opts []broker.PublishOption) error {
 	if !br.opened.Load() {
 		return ErrInvalidConnectionState
 	}
+	type message struct {
+		m       *T
+		headers map[string]string
+		key     string
+		body    []byte
+	}
+	msgs := make([]message, len(ms))
+	for i, m := range ms {
+		var popts broker.PublishOptions
+		popts.Apply(opts...)
+		popts.Stamp()
+		msgs[i] = message{m: m, headers: popts.Headers, key: popts.Key}
+		if br.codec != nil {
+			b, err := codec.Marshal(br.codec, m, popts.Headers)
+			if err != nil {
+				return err
+			}
+			msgs[i].body = b
+		}
+	}
+	// Pick the receivers while holding the lock: Unsubscribe mutates the
+	// subscriber maps concurrently.
+	targets := make([][]*subscriber[T], len(msgs))
 	br.mu.RLock()
-	queueSubs := br.subs[topic]
+	for i := range msgs {
+		targets[i] = br.targets(topic, msgs[i].key)
+	}
 	br.mu.RUnlock()
-	env := &event[T]{
-		t:   topic,
-		msg: m,
+	var err error
+	for i, msg := range msgs {
+		for _, sub := range targets[i] {
+			env := &event[T]{
+				t:       topic,
+				msg:     msg.m,
+				headers: msg.headers,
+				key:     msg.key,
+				attempt: 1,
+			}
+			if br.codec != nil {
+				// Every subscriber decodes its own copy, as over a network.
+				var v T
+				env.msg = &v
+				if err := codec.Unmarshal(br.codec, msg.headers, msg.body, &v); err != nil {
+					env.msg, env.err, env.reason = nil, err, broker.ReasonUnmarshalFailure
+				}
+			}
+			if perr := sub.inbox.push(ctx, env, br.overflow); perr != nil && err == nil {
+				err = perr
+			}
+			sub.lag.Set(int64(sub.inbox.len()))
+		}
 	}
-	for queue, queueSub := range queueSubs {
-		switch queue {
-		case "":
+	return err
+}
+
+// targets returns the subscribers that should receive a message published
+// to topic with key, including those of matching patterns. The caller must
+// hold br.mu.
+func (br *Broker[T]) targets(topic, key string) []*subscriber[T] {
+	targets := br.appendTargets(nil, br.subs[topic], key)
+	for pattern := range br.patterns {
+		if broker.MatchTopic(pattern, topic) {
+			targets = br.appendTargets(targets, br.subs[pattern], key)
+		}
+	}
+	return targets
+}
+
+// appendTargets appends the subscribers of subs that should receive a
+// message with key to targets.
+func (br *Broker[T]) appendTargets(targets []*subscriber[T], subs map[queue]map[id]*subscriber[T], key string) []*subscriber[T] {
+	for queue, queueSub := range subs {
+		switch {
+		case queue == "":
 			// no queue, send to all subscribers in the list.
 			for _, sub := range queueSub {
-				if sub.isClosed() {
-					continue
+				if !sub.isClosed() {
+					targets = append(targets, sub)
+				}
+			}
+		case key != "":
+			// queue with a key, send to the subscriber the key maps to.
+			var members []*subscriber[T]
+			for _, sub := range queueSub {
+				if !sub.isClosed() {
+					members = append(members, sub)
 				}
-				br.ch <- func() error { return sub.h(env) }
 			}
+			if len(members) == 0 {
+				continue
+			}
+			slices.SortFunc(members, func(a, b *subscriber[T]) int { return strings.Compare(a.id, b.id) })
+			targets = append(targets, members[hash(key)%uint32(len(members))])
 		default:
 			// queue, send to only 1 single random subscriber in the list.
+			if len(queueSub) == 0 {
+				continue
+			}
 			idx := rand.Intn(len(queueSub))
 			i := 0
 			for _, sub := range queueSub {
@@ -154,44 +368,177 @@ func (br *Broker[T]) Publish(ctx context.Context, topic string, m *T, opts ...br
 					continue
 				}
 				if i == idx {
-					br.ch <- func() error { return sub.h(env) }
+					targets = append(targets, sub)
 					break
 				}
 				i++
 			}
 		}
 	}
-	return nil
+	return targets
 }
 
-// Subscribe implements broker.Broker interface.
+// handle delivers env to sub. Under auto-ack the delivery is settled once
+// the handler returns, dropping messages whose handler failed; otherwise it
+// is redelivered unless acknowledged within the ack wait, or dead-lettered.
+func (br *Broker[T]) handle(sub *subscriber[T], env *event[T]) error {
+	var ackWait time.Duration
+	if !sub.opts.AutoAck {
+		ackWait = cmp.Or(sub.opts.AckWait, broker.DefaultAckWait)
+	}
+	env.delivery = broker.NewDelivery(ackWait, func(delay time.Duration) {
+		br.redeliver(sub, env, delay)
+	})
+	if sub.batcher != nil {
+		// Settled by the flush of the batch, see SubscribeBatch.
+		sub.batcher.Add(env)
+		return nil
+	}
+	err := broker.Handle(br.ctx, env, sub.h, sub.opts, br.Publish)
+	if sub.opts.AutoAck || errors.Is(err, broker.ErrDeadLettered) {
+		env.delivery.Ack()
+	}
+	return err
+}
+
+// redeliver queues the message of env to sub again after delay. Pending
+// redeliveries are dropped when the subscriber or the broker is closed.
+func (br *Broker[T]) redeliver(sub *subscriber[T], env *event[T], delay time.Duration) {
+	next := &event[T]{
+		t:       env.t,
+		msg:     env.msg,
+		headers: env.headers,
+		key:     env.key,
+		attempt: env.attempt + 1,
+	}
+	time.AfterFunc(delay, func() {
+		if br.ctx.Err() != nil || sub.isClosed() {
+			return
+		}
+		_ = sub.inbox.push(br.ctx, next, OverflowBlock)
+		sub.lag.Set(int64(sub.inbox.len()))
+	})
+}
+
+// Subscribe implements broker.Broker interface. The topic may be a pattern,
+// see broker.MatchTopic; a queue group of a pattern is distinct from the
+// queue groups of the topics it matches.
 func (br *Broker[T]) Subscribe(ctx context.Context, topic string, h func(broker.Event[T]) error, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
 	if !br.opened.Load() {
 		return nil, ErrInvalidConnectionState
 	}
-	subOpts := &broker.SubscribeOptions{}
+	if err := broker.ValidatePattern(topic); err != nil {
+		return nil, err
+	}
+	sub := br.newSubscriber(topic, opts)
+	sub.h = broker.MeasureHandler(br.metrics, h)
+	br.add(sub)
+	return sub, nil
+}
+
+// SubscribeBatch implements broker.BatchSubscriber interface. Under auto-ack
+// the events of a batch are settled once the handler returns, dropping those
+// of batches whose handler failed, as Subscribe does. Unsubscribe hands the
+// pending events to h before returning.
+func (br *Broker[T]) SubscribeBatch(ctx context.Context, topic string, h broker.BatchHandler[T], opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	if !br.opened.Load() {
+		return nil, ErrInvalidConnectionState
+	}
+	if err := broker.ValidatePattern(topic); err != nil {
+		return nil, err
+	}
+	sub := br.newSubscriber(topic, opts)
+	h = broker.MeasureBatchHandler(br.metrics, h)
+	sub.batcher = broker.NewBatcher(sub.opts.BatchSize, sub.opts.BatchLinger, func(events []broker.Event[T]) {
+		err := broker.HandleBatch(br.ctx, events, h, sub.opts, br.Publish)
+		if sub.opts.AutoAck || errors.Is(err, broker.ErrDeadLettered) {
+			for _, e := range events {
+				_ = e.Ack()
+			}
+		}
+	})
+	closeSub := sub.close
+	sub.close = func() {
+		closeSub()
+		sub.batcher.Close()
+	}
+	br.add(sub)
+	return sub, nil
+}
+
+func (br *Broker[T]) newSubscriber(topic string, opts []broker.SubscribeOption) *subscriber[T] {
+	subOpts := &broker.SubscribeOptions{
+		AutoAck: true,
+	}
 	subOpts.Apply(opts...)
 	newSub := &subscriber[T]{
 		id:   uuid.New().String(),
 		t:    topic,
-		h:    h,
 		opts: subOpts,
+		lag:  br.metrics.Lag(topic),
 	}
+	newSub.inbox = newInbox(br.worker, br.buf, func(env *event[T]) {
+		newSub.lag.Set(int64(newSub.inbox.len()))
+		_ = br.handle(newSub, env)
+	})
 	newSub.close = func() {
+		newSub.inbox.close(true)
+		newSub.lag.Reset()
 		br.mu.Lock()
 		defer br.mu.Unlock()
-		delete(br.subs[topic][newSub.opts.Queue], newSub.id)
+		queue := newSub.opts.Queue
+		delete(br.subs[topic][queue], newSub.id)
+		if len(br.subs[topic][queue]) == 0 {
+			delete(br.subs[topic], queue)
+		}
+		if len(br.subs[topic]) == 0 {
+			delete(br.subs, topic)
+			delete(br.patterns, topic)
+		}
 	}
+	return newSub
+}
+
+func (br *Broker[T]) add(sub *subscriber[T]) {
 	br.mu.Lock()
 	defer br.mu.Unlock()
-	if br.subs[topic] == nil {
-		br.subs[topic] = make(map[queue]map[id]*subscriber[T])
+	if br.subs[sub.t] == nil {
+		br.subs[sub.t] = make(map[queue]map[id]*subscriber[T])
+		if broker.IsPattern(sub.t) {
+			br.patterns[sub.t] = true
+		}
+	}
+	if br.subs[sub.t][sub.opts.Queue] == nil {
+		br.subs[sub.t][sub.opts.Queue] = make(map[id]*subscriber[T])
+	}
+	br.subs[sub.t][sub.opts.Queue][sub.id] = sub
+}
+
+// QueueDepth returns the number of messages published to topic that are
+// waiting in the queues of its subscribers, including the subscribers of
+// matching patterns. The Subscriber returned by Subscribe reports its own
+// through a QueueDepth method.
+func (br *Broker[T]) QueueDepth(topic string) int {
+	br.mu.RLock()
+	defer br.mu.RUnlock()
+	n := 0
+	for _, queue := range br.subs[topic] {
+		for _, sub := range queue {
+			n += sub.inbox.len()
+		}
 	}
-	if br.subs[topic][newSub.opts.Queue] == nil {
-		br.subs[topic][newSub.opts.Queue] = make(map[id]*subscriber[T])
+	for pattern := range br.patterns {
+		if !broker.MatchTopic(pattern, topic) {
+			continue
+		}
+		// A pattern subscriber also queues the messages of other topics.
+		for _, queue := range br.subs[pattern] {
+			for _, sub := range queue {
+				n += sub.inbox.count(topic)
+			}
+		}
 	}
-	br.subs[topic][newSub.opts.Queue][newSub.id] = newSub
-	return newSub, nil
+	return n
 }
 
 // CheckHealth implements health.Checker interface.
@@ -202,18 +549,41 @@ func (br *Broker[T]) CheckHealth(ctx context.Context) error {
 	return nil
 }
 
-// Close implements broker.Broker interface.
+// Close implements broker.Broker interface. It stops accepting messages,
+// drops the delayed ones and drains every subscription, handling the messages already queued, until ctx
+// is done or for broker.DefaultCloseTimeout if ctx has no deadline.
 func (br *Broker[T]) Close(ctx context.Context) error {
 	br.opened.Store(false)
-	close(br.ch)
-	br.wg.Wait()
-	// unsubscribe all subscribers.
+	br.schedule.Close()
+	ctx, cancel := broker.CloseContext(ctx)
+	defer cancel()
+	var subs []*subscriber[T]
+	br.mu.RLock()
 	for _, queue := range br.subs {
 		for _, queue := range queue {
 			for _, sub := range queue {
-				sub.Unsubscribe()
+				subs = append(subs, sub)
 			}
 		}
 	}
-	return nil
+	br.mu.RUnlock()
+	// Stop taking messages everywhere first; the queues drain concurrently.
+	for _, sub := range subs {
+		sub.inbox.close(false)
+	}
+	var err error
+	for _, sub := range subs {
+		if derr := sub.Drain(ctx); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	// Stop the retries still waiting for their backoff.
+	br.cancel()
+	return err
+}
+
+func hash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
 }
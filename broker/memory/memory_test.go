@@ -2,14 +2,21 @@ package memory_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/synctest"
 	"time"
 
 	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
 	"github.com/pthethanh/nano/broker/memory"
+	metricmem "github.com/pthethanh/nano/metric/memory"
 )
 
 func TestBroker(t *testing.T) {
@@ -181,3 +188,643 @@ func BenchmarkBroker_FanOut_100(b *testing.B) {
 	broker := memory.New[string]()
 	runBenchmark(b, broker, "fan_out_topic_100", "", 100, 0)
 }
+
+func TestBroker_DeadLetter(t *testing.T) {
+	b := memory.New[string]()
+	if err := b.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close(context.Background())
+
+	dlq := make(chan broker.Event[string], 1)
+	if _, err := b.Subscribe(context.Background(), "orders.dlq", func(e broker.Event[string]) error {
+		dlq <- e
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	if _, err := b.Subscribe(context.Background(), "orders", func(e broker.Event[string]) error {
+		calls.Add(1)
+		return errors.New("boom")
+	}, broker.MaxAttempts(3), broker.DeadLetter("orders.dlq")); err != nil {
+		t.Fatal(err)
+	}
+	msg := "poison"
+	if err := b.Publish(context.Background(), "orders", &msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-dlq:
+		if got := *e.Message(); got != msg {
+			t.Errorf("got dead-lettered message=%q, want %q", got, msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not dead-lettered")
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("got %d handler attempts, want 3", got)
+	}
+}
+
+func TestBroker_EventMetadata(t *testing.T) {
+	b := memory.New[string]()
+	if err := b.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close(context.Background())
+
+	ch := make(chan broker.Event[string], 3)
+	if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+		ch <- e
+		if e.Attempt() < 2 {
+			return errors.New("retry")
+		}
+		return nil
+	}, broker.MaxAttempts(2)); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	msg := "m"
+	if err := b.Publish(context.Background(), "topic", &msg, broker.Header("trace-id", "abc"), broker.MessageID("m-1")); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		select {
+		case e := <-ch:
+			if e.Attempt() != attempt {
+				t.Errorf("got attempt %d, want %d", e.Attempt(), attempt)
+			}
+			if e.ID() != "m-1" || e.Headers()["trace-id"] != "abc" {
+				t.Errorf("got ID=%q, headers=%v, want the published ID and headers", e.ID(), e.Headers())
+			}
+			if ts := e.Timestamp(); ts.Before(before.Add(-time.Second)) || ts.After(time.Now()) {
+				t.Errorf("got timestamp %v, want the publish time", ts)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d was not delivered", attempt)
+		}
+	}
+}
+
+func TestBroker_Codec(t *testing.T) {
+	b := memory.New(memory.Codec[broker.Message](codec.Proto[broker.Message]{}))
+	if err := b.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close(context.Background())
+
+	ch := make(chan broker.Event[broker.Message], 2)
+	for range 2 {
+		if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[broker.Message]) error {
+			ch <- e
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg := &broker.Message{Body: []byte("hello")}
+	if err := b.Publish(context.Background(), "topic", msg); err != nil {
+		t.Fatal(err)
+	}
+	var got []*broker.Message
+	for range 2 {
+		select {
+		case e := <-ch:
+			if e.Error() != nil {
+				t.Fatalf("got error %v, want the decoded message", e.Error())
+			}
+			if ct := e.Headers()[broker.HeaderContentType]; ct != codec.ContentTypeProto {
+				t.Errorf("got content type %q, want %q", ct, codec.ContentTypeProto)
+			}
+			got = append(got, e.Message())
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+	}
+	if got[0] == msg || got[1] == msg || got[0] == got[1] || string(got[0].Body) != "hello" {
+		t.Errorf("got %v, want a decoded copy of the message per subscriber", got)
+	}
+}
+
+func TestBroker_ManualAck(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := memory.New[string]()
+		if err := b.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close(context.Background())
+
+		var (
+			mu       sync.Mutex
+			attempts []int
+		)
+		got := func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			return fmt.Sprint(attempts)
+		}
+		if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+			mu.Lock()
+			attempts = append(attempts, e.Attempt())
+			mu.Unlock()
+			switch e.Attempt() {
+			case 1:
+				// Neither acked nor nacked: redelivered after the ack wait.
+			case 2:
+				e.Nack(time.Second)
+			default:
+				e.Ack()
+			}
+			return nil
+		}, broker.DisableAutoAck(), broker.AckWait(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		msg := "m"
+		if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+			t.Fatal(err)
+		}
+		synctest.Wait()
+		if got() != "[1]" {
+			t.Fatalf("got attempts %v, want [1]", got())
+		}
+		time.Sleep(time.Minute)
+		synctest.Wait()
+		if got() != "[1 2]" {
+			t.Fatalf("got attempts %v, want a redelivery after the ack wait", got())
+		}
+		time.Sleep(time.Second)
+		synctest.Wait()
+		if got() != "[1 2 3]" {
+			t.Fatalf("got attempts %v, want a redelivery after the nack delay", got())
+		}
+		time.Sleep(time.Hour)
+		synctest.Wait()
+		if got() != "[1 2 3]" {
+			t.Errorf("got attempts %v, want no redelivery once acknowledged", got())
+		}
+	})
+}
+
+func TestBroker_NackUnderAutoAck(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := memory.New[string]()
+		if err := b.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close(context.Background())
+
+		var attempts atomic.Int32
+		if _, err := b.Subscribe(context.Background(), "topic", func(e broker.Event[string]) error {
+			attempts.Add(1)
+			if e.Attempt() == 1 {
+				return e.Nack(0)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		msg := "m"
+		if err := b.Publish(context.Background(), "topic", &msg); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Hour)
+		synctest.Wait()
+		if n := attempts.Load(); n != 2 {
+			t.Errorf("handler called %d times, want the nacked message redelivered once", n)
+		}
+	})
+}
+
+func TestBroker_Batch(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := memory.New[string]()
+		if err := b.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close(context.Background())
+
+		batches := make(chan []broker.Event[string], 4)
+		sub, err := broker.SubscribeBatch[string](context.Background(), b, "topic", func(events []broker.Event[string]) error {
+			batches <- events
+			return nil
+		}, broker.BatchSize(3), broker.BatchLinger(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs := []string{"a", "b", "c", "d"}
+		ms := make([]*string, len(msgs))
+		for i := range msgs {
+			ms[i] = &msgs[i]
+		}
+		if err := broker.PublishBatch[string](context.Background(), b, "topic", ms); err != nil {
+			t.Fatal(err)
+		}
+		synctest.Wait()
+		ids := make(map[string]bool)
+		first := <-batches
+		if len(first) != 3 {
+			t.Fatalf("got a batch of %d events, want a full batch of 3", len(first))
+		}
+		start := time.Now()
+		second := <-batches
+		if len(second) != 1 || time.Since(start) != time.Second {
+			t.Fatalf("got a batch of %d events after %v, want the last event after the 1s linger", len(second), time.Since(start))
+		}
+		for _, e := range append(first, second...) {
+			ids[e.ID()] = true
+		}
+		if len(ids) != 4 {
+			t.Errorf("got %d distinct message IDs, want one per message", len(ids))
+		}
+
+		// Pending events are flushed by Unsubscribe.
+		if err := b.Publish(context.Background(), "topic", &msgs[0]); err != nil {
+			t.Fatal(err)
+		}
+		synctest.Wait()
+		if err := sub.Unsubscribe(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case events := <-batches:
+			if len(events) != 1 || *events[0].Message() != "a" {
+				t.Fatalf("got %d events, want the pending event", len(events))
+			}
+		default:
+			t.Fatal("Unsubscribe() did not flush the pending event")
+		}
+	})
+}
+
+func TestBroker_KeyOrdering(t *testing.T) {
+	b := memory.New[int]()
+	if err := b.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close(context.Background())
+
+	type delivery struct {
+		sub int
+		seq int
+	}
+	var (
+		mu   sync.Mutex
+		got  = make(map[string][]delivery)
+		done sync.WaitGroup
+	)
+	const keys, perKey = 5, 50
+	done.Add(keys * perKey)
+	for i := range 3 {
+		if _, err := b.Subscribe(context.Background(), "orders", func(e broker.Event[int]) error {
+			mu.Lock()
+			defer mu.Unlock()
+			key := broker.KeyFrom(e)
+			got[key] = append(got[key], delivery{sub: i, seq: *e.Message()})
+			done.Done()
+			return nil
+		}, broker.Queue("workers")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for seq := range perKey {
+		for k := range keys {
+			if err := b.Publish(context.Background(), "orders", &seq, broker.Key(fmt.Sprint("order-", k))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	done.Wait()
+
+	for key, deliveries := range got {
+		for i, d := range deliveries {
+			if d.seq != i {
+				t.Fatalf("key %s: got message %d at position %d, want publish order", key, d.seq, i)
+			}
+			if d.sub != deliveries[0].sub {
+				t.Fatalf("key %s: delivered to subscribers %d and %d, want a single queue member", key, deliveries[0].sub, d.sub)
+			}
+		}
+	}
+	if len(got) != keys {
+		t.Errorf("got %d keys, want %d", len(got), keys)
+	}
+}
+
+func TestBroker_Overflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  memory.OverflowPolicy
+		wantErr error
+		want    string
+	}{
+		{name: "block", policy: memory.OverflowBlock, wantErr: context.DeadlineExceeded, want: "[1 2]"},
+		{name: "drop oldest", policy: memory.OverflowDropOldest, want: "[1 3]"},
+		{name: "drop newest", policy: memory.OverflowDropNewest, want: "[1 2]"},
+		{name: "error", policy: memory.OverflowError, wantErr: memory.ErrQueueFull, want: "[1 2]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				b := memory.New(memory.Worker[int](1, 1), memory.Overflow[int](tt.policy))
+				if err := b.Open(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				defer b.Close(context.Background())
+
+				release := make(chan struct{})
+				var got []int
+				sub, err := b.Subscribe(context.Background(), "slow", func(e broker.Event[int]) error {
+					<-release
+					got = append(got, *e.Message())
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				fast := make(chan int, 1)
+				if _, err := b.Subscribe(context.Background(), "fast", func(e broker.Event[int]) error {
+					fast <- *e.Message()
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				// 1 is being handled and 2 fills the queue.
+				for _, m := range []int{1, 2} {
+					if err := b.Publish(context.Background(), "slow", &m); err != nil {
+						t.Fatal(err)
+					}
+					synctest.Wait()
+				}
+				if b.QueueDepth("slow") != 1 || sub.(interface{ QueueDepth() int }).QueueDepth() != 1 {
+					t.Fatalf("got queue depth %d, want 1", b.QueueDepth("slow"))
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				m := 3
+				if err := b.Publish(ctx, "slow", &m); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Publish() error = %v, want %v", err, tt.wantErr)
+				}
+
+				// The slow subscriber does not hold up other topics.
+				if err := b.Publish(context.Background(), "fast", &m); err != nil {
+					t.Fatal(err)
+				}
+				<-fast
+
+				close(release)
+				synctest.Wait()
+				if fmt.Sprint(got) != tt.want {
+					t.Errorf("handled %v, want %v", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestBroker_PauseResumeDrain(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := memory.New[int]()
+		if err := b.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close(context.Background())
+
+		var got []int
+		sub, err := b.Subscribe(context.Background(), "orders", func(e broker.Event[int]) error {
+			time.Sleep(time.Second)
+			got = append(got, *e.Message())
+			return nil
+		}, broker.Queue("q"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := broker.Pause(sub); err != nil {
+			t.Fatal(err)
+		}
+		for m := range 3 {
+			if err := b.Publish(context.Background(), "orders", &m, broker.Key("k")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		synctest.Wait()
+		if len(got) != 0 || b.QueueDepth("orders") != 3 {
+			t.Fatalf("handled %v with %d queued while paused, want none handled and 3 queued", got, b.QueueDepth("orders"))
+		}
+		if err := broker.Resume(sub); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Second)
+		synctest.Wait()
+
+		// Drain handles the queued messages, then unsubscribes.
+		if err := broker.Drain(context.Background(), sub); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != "[0 1 2]" {
+			t.Fatalf("handled %v, want [0 1 2]", got)
+		}
+		m := 3
+		if err := b.Publish(context.Background(), "orders", &m); err != nil {
+			t.Fatal(err)
+		}
+		synctest.Wait()
+		if len(got) != 3 {
+			t.Fatalf("handled %v after Drain, want no more messages", got)
+		}
+	})
+}
+
+func TestBroker_CloseWaitsForHandlers(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := memory.New[int]()
+		if err := b.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		var handled atomic.Int32
+		if _, err := b.Subscribe(context.Background(), "orders", func(e broker.Event[int]) error {
+			time.Sleep(time.Duration(*e.Message()) * time.Second)
+			handled.Add(1)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range []int{1, 2, 10} {
+			if err := b.Publish(context.Background(), "orders", &m); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := b.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Close() error = %v, want the deadline to cut the 10s handlers short", err)
+		}
+		if handled.Load() != 2 {
+			t.Fatalf("handled %d messages by Close, want the 1s and 2s ones", handled.Load())
+		}
+		m := 1
+		if err := b.Publish(context.Background(), "orders", &m); !errors.Is(err, memory.ErrInvalidConnectionState) {
+			t.Fatalf("Publish() after Close error = %v, want ErrInvalidConnectionState", err)
+		}
+		// Let the handler cut short finish on its own.
+		time.Sleep(10 * time.Second)
+	})
+}
+
+func TestBroker_DelayedPublish(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := memory.New[int]()
+		if err := b.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close(context.Background())
+		received := make(chan time.Duration, 2)
+		start := time.Now()
+		if _, err := b.Subscribe(context.Background(), "reminders", func(e broker.Event[int]) error {
+			received <- time.Since(start)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		m := 1
+		if err := b.Publish(context.Background(), "reminders", &m, broker.Delay(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PublishBatch(context.Background(), "reminders", []*int{&m}, broker.Delay(time.Hour), broker.MessageID("cancelled")); err != nil {
+			t.Fatal(err)
+		}
+		if err := broker.Cancel[int](context.Background(), b, "cancelled"); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-received; got != time.Minute {
+			t.Fatalf("received after %v, want 1m", got)
+		}
+		time.Sleep(2 * time.Hour)
+		synctest.Wait()
+		if len(received) != 0 {
+			t.Fatal("received the cancelled message")
+		}
+	})
+}
+
+func TestBroker_TopicPatterns(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := memory.New[int]()
+		if err := b.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close(context.Background())
+		var mu sync.Mutex
+		got := map[string][]string{}
+		for _, pattern := range []string{"orders.*", "orders.>", "orders.eu.created"} {
+			if _, err := b.Subscribe(context.Background(), pattern, func(e broker.Event[int]) error {
+				mu.Lock()
+				defer mu.Unlock()
+				got[pattern] = append(got[pattern], e.Topic())
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := b.Subscribe(context.Background(), "orders.>.created", func(broker.Event[int]) error { return nil }); !errors.Is(err, broker.ErrInvalidPattern) {
+			t.Fatalf("Subscribe() error = %v, want ErrInvalidPattern", err)
+		}
+		for _, topic := range []string{"orders.created", "orders.eu.created", "orders", "payments.created"} {
+			m := 1
+			if err := b.Publish(context.Background(), topic, &m); err != nil {
+				t.Fatal(err)
+			}
+			synctest.Wait()
+		}
+		want := map[string][]string{
+			"orders.*":          {"orders.created"},
+			"orders.>":          {"orders.created", "orders.eu.created"},
+			"orders.eu.created": {"orders.eu.created"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got topics %v, want %v", got, want)
+		}
+	})
+}
+
+func TestBroker_QueueDepthIncludesPatternSubscribers(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		b := memory.New[int]()
+		if err := b.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close(context.Background())
+		sub, err := b.Subscribe(context.Background(), "orders.*", func(broker.Event[int]) error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := broker.Pause(sub); err != nil {
+			t.Fatal(err)
+		}
+		for m, topic := range []string{"orders.created", "orders.created", "orders.updated"} {
+			if err := b.Publish(context.Background(), topic, &m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		synctest.Wait()
+		if got := b.QueueDepth("orders.created"); got != 2 {
+			t.Errorf("got queue depth %d for orders.created, want 2", got)
+		}
+		if got := b.QueueDepth("orders.updated"); got != 1 {
+			t.Errorf("got queue depth %d for orders.updated, want 1", got)
+		}
+		if err := broker.Resume(sub); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestBroker_Metrics(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		reporter := metricmem.New()
+		b := memory.New(memory.Metrics[int](reporter))
+		if err := b.Open(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close(context.Background())
+		sub, err := b.Subscribe(context.Background(), "orders.*", func(e broker.Event[int]) error {
+			return errors.New("boom")
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := broker.Pause(sub); err != nil {
+			t.Fatal(err)
+		}
+		for m := range 2 {
+			if err := b.Publish(context.Background(), "orders.created", &m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		metrics := func() string {
+			rec := httptest.NewRecorder()
+			reporter.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			return rec.Body.String()
+		}
+		if body := metrics(); !strings.Contains(body, `broker_lag{topic="orders.*"} 2`) {
+			t.Fatalf("got:\n%s\nwant a lag of 2 while paused", body)
+		}
+		if err := broker.Resume(sub); err != nil {
+			t.Fatal(err)
+		}
+		synctest.Wait()
+		body := metrics()
+		for _, want := range []string{
+			`broker_published_total{status="ok",topic="orders.created"} 2`,
+			`broker_consumed_total{topic="orders.created"} 2`,
+			`broker_failed_total{reason="handler",topic="orders.created"} 2`,
+			`broker_lag{topic="orders.*"} 0`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("metrics lack %s, got:\n%s", want, body)
+			}
+		}
+	})
+}
@@ -1,19 +1,53 @@
 package memory
 
-// Worker is an option to override the default number of worker and buffer.
+import (
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/metric"
+)
+
+// Codec is an option to encode messages with c on publish and decode a copy
+// for every subscriber, as a network broker would. By default messages are
+// handed to subscribers as the published pointer, without encoding.
+func Codec[T any](c broker.Codec[T]) Option[T] {
+	return func(b *Broker[T]) {
+		b.codec = c
+	}
+}
+
+// Worker is an option to override the default number of worker and buffer
+// of every subscriber: up to worker goroutines handle the messages of a
+// subscriber concurrently, and up to buffer messages wait in its queue
+// before the Overflow policy applies.
+//
+// worker and buffer are clamped to a minimum of 1: a subscriber needs a
+// worker to make progress and room for the message it is handed. The
+// defaults are 100 workers and a buffer of 10,000 messages per subscriber.
 //
-// worker is clamped to a minimum of 1: a broker with zero worker goroutines
-// has nothing to drain the internal channel, so every Publish call would
-// block forever once the buffer fills. buffer is clamped to a minimum of 0.
+// Breaking change: worker and buffer used to bound the whole broker, a
+// single pool of goroutines and a single channel shared by every
+// subscriber. They now apply to each subscriber, so a broker with n
+// subscribers may run up to n times worker handlers at once and hold up to n
+// times buffer messages; lower them to keep the former totals.
 func Worker[T any](worker, buffer int) Option[T] {
 	return func(b *Broker[T]) {
-		if worker < 1 {
-			worker = 1
-		}
-		if buffer < 0 {
-			buffer = 0
-		}
-		b.worker = worker
-		b.buf = buffer
+		b.worker = max(worker, 1)
+		b.buf = max(buffer, 1)
+	}
+}
+
+// Overflow is an option to set what Publish does when the queue of a
+// subscriber is full. The default is OverflowBlock.
+func Overflow[T any](policy OverflowPolicy) Option[T] {
+	return func(b *Broker[T]) {
+		b.overflow = policy
+	}
+}
+
+// Metrics is an option to report the standard broker metrics through r, see
+// broker.Metrics. The lag of a subscription is the number of messages
+// waiting in its queue.
+func Metrics[T any](r metric.Reporter) Option[T] {
+	return func(b *Broker[T]) {
+		b.metrics = broker.NewMetrics(r)
 	}
 }
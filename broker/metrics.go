@@ -0,0 +1,150 @@
+package broker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pthethanh/nano/metric"
+)
+
+type (
+	// Metrics reports the standard metrics of a Broker implementation through
+	// a metric.Reporter:
+	//
+	//   - broker_published_total{topic,status}: messages published, with
+	//     status "ok" or "error".
+	//   - broker_publish_duration_seconds{topic,status}: publish latency.
+	//   - broker_consumed_total{topic}: handler calls, retries included.
+	//   - broker_handle_duration_seconds{topic,status}: handler duration.
+	//   - broker_failed_total{topic,reason}: failed handler calls, with reason
+	//     "handler", and events delivered with an error, with the Reason of
+	//     the event.
+	//   - broker_lag{topic}: messages waiting to be handled, as far as the
+	//     broker knows: the consumer lag of Kafka, the pending messages of a
+	//     NATS subscription or the queue depth of the memory broker.
+	//
+	// The published and duration metrics share their names and labels with
+	// the metrics middleware, so use one or the other. A nil *Metrics reports
+	// nothing, letting implementations call it unconditionally.
+	Metrics struct {
+		published       metric.Counter
+		publishDuration metric.Histogram
+		consumed        metric.Counter
+		handleDuration  metric.Histogram
+		failed          metric.Counter
+		lag             metric.Gauge
+	}
+
+	// Lag tracks the share of broker_lag contributed by one source, such as
+	// a subscription or a Kafka partition claim, so that several sources of
+	// the same topic add up. The zero value and a nil *Lag report nothing.
+	Lag struct {
+		mu    sync.Mutex
+		gauge metric.Gauge
+		last  float64
+	}
+)
+
+// durationBuckets are the histogram buckets of the duration metrics, in
+// seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// NewMetrics returns the Metrics reported through r, or nil if r is nil.
+func NewMetrics(r metric.Reporter) *Metrics {
+	if r == nil {
+		return nil
+	}
+	return &Metrics{
+		published:       r.Counter("broker_published_total", "topic", "status"),
+		publishDuration: r.Histogram("broker_publish_duration_seconds", durationBuckets, "topic", "status"),
+		consumed:        r.Counter("broker_consumed_total", "topic"),
+		handleDuration:  r.Histogram("broker_handle_duration_seconds", durationBuckets, "topic", "status"),
+		failed:          r.Counter("broker_failed_total", "topic", "reason"),
+		lag:             r.Gauge("broker_lag", "topic"),
+	}
+}
+
+// Publish records the publishing of n messages to topic started at start
+// and ending with err.
+func (m *Metrics) Publish(topic string, n int, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	s := status(err)
+	m.published.With("topic", topic, "status", s).Add(float64(n))
+	m.publishDuration.With("topic", topic, "status", s).Record(time.Since(start).Seconds())
+}
+
+// Lag returns the Lag of a source of messages of topic.
+func (m *Metrics) Lag(topic string) *Lag {
+	if m == nil {
+		return nil
+	}
+	return &Lag{gauge: m.lag.With("topic", topic)}
+}
+
+// MeasureHandler returns h recording the consumed, handle duration and
+// failed metrics of every call, labelled with the topic of the event.
+func MeasureHandler[T any](m *Metrics, h func(Event[T]) error) func(Event[T]) error {
+	if m == nil {
+		return h
+	}
+	return func(e Event[T]) error {
+		start := time.Now()
+		err := h(e)
+		recordHandle(m, e, start, err)
+		return err
+	}
+}
+
+// MeasureBatchHandler is the BatchHandler counterpart of MeasureHandler:
+// every event of a batch is recorded with the duration and outcome of the
+// batch.
+func MeasureBatchHandler[T any](m *Metrics, h BatchHandler[T]) BatchHandler[T] {
+	if m == nil {
+		return h
+	}
+	return func(events []Event[T]) error {
+		start := time.Now()
+		err := h(events)
+		for _, e := range events {
+			recordHandle(m, e, start, err)
+		}
+		return err
+	}
+}
+
+func recordHandle[T any](m *Metrics, e Event[T], start time.Time, err error) {
+	topic := e.Topic()
+	m.consumed.With("topic", topic).Add(1)
+	m.handleDuration.With("topic", topic, "status", status(err)).Record(time.Since(start).Seconds())
+	switch {
+	case e.Error() != nil:
+		m.failed.With("topic", topic, "reason", e.Reason().String()).Add(1)
+	case err != nil:
+		m.failed.With("topic", topic, "reason", "handler").Add(1)
+	}
+}
+
+// Set sets the number of messages waiting at the source to n.
+func (l *Lag) Set(n int64) {
+	if l == nil || l.gauge == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gauge.Add(float64(n) - l.last)
+	l.last = float64(n)
+}
+
+// Reset withdraws the share of the source, once it is gone.
+func (l *Lag) Reset() {
+	l.Set(0)
+}
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
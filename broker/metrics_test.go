@@ -0,0 +1,84 @@
+package broker_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/metric/memory"
+)
+
+func scrape(t *testing.T, r *memory.Reporter) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestMetrics_RecordsPublishHandleAndFailures(t *testing.T) {
+	reporter := memory.New()
+	m := broker.NewMetrics(reporter)
+	m.Publish("orders", 3, time.Now(), nil)
+
+	fail := errors.New("boom")
+	h := broker.MeasureHandler(m, func(e broker.Event[string]) error {
+		if e.Error() != nil {
+			return nil
+		}
+		return fail
+	})
+	msg := "a"
+	if err := h(&testEvent{topic: "orders", msg: &msg}); err != fail {
+		t.Fatalf("handler error = %v, want %v", err, fail)
+	}
+	_ = h(&testEvent{topic: "orders", err: errors.New("bad payload")})
+
+	body := scrape(t, reporter)
+	for _, want := range []string{
+		`broker_published_total{status="ok",topic="orders"} 3`,
+		`broker_consumed_total{topic="orders"} 2`,
+		`broker_failed_total{reason="handler",topic="orders"} 1`,
+		`broker_failed_total{reason="unmarshal",topic="orders"} 1`,
+		`broker_handle_duration_seconds_count{status="error",topic="orders"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %s, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetrics_LagAddsUpSources(t *testing.T) {
+	reporter := memory.New()
+	m := broker.NewMetrics(reporter)
+	a, b := m.Lag("orders"), m.Lag("orders")
+	a.Set(5)
+	b.Set(3)
+	a.Set(2)
+	if body := scrape(t, reporter); !strings.Contains(body, `broker_lag{topic="orders"} 5`) {
+		t.Fatalf("got:\n%s\nwant a lag of 2+3", body)
+	}
+	b.Reset()
+	if body := scrape(t, reporter); !strings.Contains(body, `broker_lag{topic="orders"} 2`) {
+		t.Fatalf("got:\n%s\nwant the lag of the remaining source", body)
+	}
+}
+
+func TestMetrics_NilReportsNothing(t *testing.T) {
+	var m *broker.Metrics
+	if broker.NewMetrics(nil) != nil {
+		t.Fatal("NewMetrics(nil) != nil")
+	}
+	m.Publish("orders", 1, time.Now(), nil)
+	m.Lag("orders").Set(1)
+	called := false
+	h := broker.MeasureHandler(m, func(broker.Event[string]) error {
+		called = true
+		return nil
+	})
+	if err := h(&testEvent{topic: "orders"}); err != nil || !called {
+		t.Fatalf("handler called=%v err=%v, want the handler itself", called, err)
+	}
+}
@@ -0,0 +1,103 @@
+package broker
+
+import "context"
+
+type (
+	// Handler is the signature of a subscription handler.
+	Handler[T any] func(Event[T]) error
+
+	// PublishMiddleware wraps Broker.Publish with cross-cutting logic.
+	PublishMiddleware[T any] func(next PublishFunc[T]) PublishFunc[T]
+
+	// HandlerMiddleware wraps a subscription handler with cross-cutting logic.
+	HandlerMiddleware[T any] func(next Handler[T]) Handler[T]
+
+	// Middleware groups the publish and subscribe sides of a middleware.
+	// Either side may be nil.
+	Middleware[T any] struct {
+		Publish PublishMiddleware[T]
+		Handler HandlerMiddleware[T]
+	}
+
+	// wrapped is a Broker decorated by Wrap.
+	wrapped[T any] struct {
+		Broker[T]
+		publish PublishFunc[T]
+		handler HandlerMiddleware[T]
+	}
+
+	// contextEvent is an Event carrying a context set by WithContext.
+	contextEvent[T any] struct {
+		Event[T]
+		ctx context.Context
+	}
+)
+
+// Wrap returns b with mws applied to Publish and to the handlers given to
+// Subscribe. The first middleware is the outermost: it sees a publish first
+// and a delivered event first.
+func Wrap[T any](b Broker[T], mws ...Middleware[T]) Broker[T] {
+	publish := PublishFunc[T](b.Publish)
+	handler := func(next Handler[T]) Handler[T] { return next }
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mw := mws[i].Publish; mw != nil {
+			publish = mw(publish)
+		}
+		if mw := mws[i].Handler; mw != nil {
+			inner := handler
+			handler = func(next Handler[T]) Handler[T] { return mw(inner(next)) }
+		}
+	}
+	return &wrapped[T]{
+		Broker:  b,
+		publish: publish,
+		handler: handler,
+	}
+}
+
+// Publish implements Broker.
+func (w *wrapped[T]) Publish(ctx context.Context, topic string, m *T, opts ...PublishOption) error {
+	return w.publish(ctx, topic, m, opts...)
+}
+
+// Subscribe implements Broker.
+func (w *wrapped[T]) Subscribe(ctx context.Context, topic string, h func(Event[T]) error, opts ...SubscribeOption) (Subscriber, error) {
+	return w.Broker.Subscribe(ctx, topic, w.handler(h), opts...)
+}
+
+// CheckHealth implements health.Checker interface when the wrapped broker
+// does.
+func (w *wrapped[T]) CheckHealth(ctx context.Context) error {
+	if c, ok := w.Broker.(interface{ CheckHealth(context.Context) error }); ok {
+		return c.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// Unwrap returns the broker decorated by Wrap.
+func (w *wrapped[T]) Unwrap() Broker[T] {
+	return w.Broker
+}
+
+// WithContext returns e carrying ctx, so a HandlerMiddleware can hand
+// values such as the span of the message to the handlers it wraps.
+func WithContext[T any](e Event[T], ctx context.Context) Event[T] {
+	return &contextEvent[T]{Event: e, ctx: ctx}
+}
+
+// ContextFrom returns the context carried by e, or context.Background() if
+// none was set with WithContext.
+func ContextFrom[T any](e Event[T]) context.Context {
+	if c, ok := e.(interface{ Context() context.Context }); ok {
+		return c.Context()
+	}
+	return context.Background()
+}
+
+func (e *contextEvent[T]) Context() context.Context {
+	return e.ctx
+}
+
+func (e *contextEvent[T]) Unwrap() Event[T] {
+	return e.Event
+}
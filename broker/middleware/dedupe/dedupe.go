@@ -0,0 +1,46 @@
+// Package dedupe provides a broker middleware that skips messages already
+// handled, making handlers idempotent over at-least-once delivery.
+package dedupe
+
+import (
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/cache"
+)
+
+// Middleware returns a middleware that records the ID of every message its
+// handler succeeds on in store, for the TTL window, and acknowledges
+// redeliveries of a recorded ID without calling the handler. Any
+// cache.Cacher works as store: cache/memory within a process, or the Redis
+// cache plugin to deduplicate across consumers.
+//
+// IDs are read from the HeaderMessageID header by default and scoped by
+// topic. Messages without an ID, and events carrying a decoding error, are
+// always handed to the handler. The store is only consulted before and
+// written after the handler, so duplicates delivered while the first copy is
+// still being handled may both be handled, and a store that cannot be read
+// lets the message through rather than losing it.
+func Middleware[T any](store cache.Cacher[string, bool], opts ...Option) broker.Middleware[T] {
+	o := newOptions(opts...)
+	return broker.Middleware[T]{
+		Handler: func(next broker.Handler[T]) broker.Handler[T] {
+			return func(e broker.Event[T]) error {
+				id := e.Headers()[o.header]
+				if id == "" || e.Error() != nil {
+					return next(e)
+				}
+				ctx := broker.ContextFrom(e)
+				key := o.prefix + e.Topic() + ":" + id
+				if seen, err := store.Get(ctx, key); err == nil && seen {
+					return e.Ack()
+				}
+				if err := next(e); err != nil {
+					return err
+				}
+				// The message was handled: failing to record it only means a
+				// redelivery would be handled again.
+				_ = store.Set(ctx, key, true, cache.TTL(o.ttl))
+				return nil
+			}
+		},
+	}
+}
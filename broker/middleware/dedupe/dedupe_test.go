@@ -0,0 +1,115 @@
+package dedupe_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/memory"
+	"github.com/pthethanh/nano/broker/middleware/dedupe"
+	cachememory "github.com/pthethanh/nano/cache/memory"
+)
+
+type event struct {
+	broker.Event[string]
+	topic string
+	id    string
+	acks  int
+}
+
+func (e *event) Topic() string              { return e.topic }
+func (e *event) ID() string                 { return e.id }
+func (e *event) Headers() map[string]string { return map[string]string{broker.HeaderMessageID: e.id} }
+func (e *event) Error() error               { return nil }
+func (e *event) Ack() error {
+	e.acks++
+	return nil
+}
+
+func TestMiddlewareSkipsAndAcksDuplicates(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		store := cachememory.New[string, bool]()
+		var handled []string
+		fail := true
+		h := dedupe.Middleware[string](store, dedupe.WithTTL(time.Minute)).Handler(func(e broker.Event[string]) error {
+			if e.ID() == "m-2" && fail {
+				fail = false
+				return errors.New("boom")
+			}
+			handled = append(handled, e.Topic()+"/"+e.ID())
+			return nil
+		})
+		deliver := func(topic, id string) *event {
+			e := &event{topic: topic, id: id}
+			_ = h(e)
+			return e
+		}
+
+		deliver("orders", "m-1")
+		if dup := deliver("orders", "m-1"); dup.acks != 1 {
+			t.Errorf("duplicate acked %d times, want 1", dup.acks)
+		}
+		// IDs are scoped by topic.
+		deliver("payments", "m-1")
+		// A failed message is not recorded, so its redelivery is handled.
+		deliver("orders", "m-2")
+		deliver("orders", "m-2")
+		// IDs are forgotten after the TTL.
+		time.Sleep(time.Minute + time.Second)
+		deliver("orders", "m-1")
+		// Messages without an ID are always handled.
+		deliver("orders", "")
+
+		want := []string{"orders/m-1", "payments/m-1", "orders/m-2", "orders/m-1", "orders/"}
+		if len(handled) != len(want) {
+			t.Fatalf("handled %v, want %v", handled, want)
+		}
+		for i := range want {
+			if handled[i] != want[i] {
+				t.Fatalf("handled %v, want %v", handled, want)
+			}
+		}
+	})
+}
+
+func TestMiddlewareOverMemoryBroker(t *testing.T) {
+	br := memory.New[string]()
+	if err := br.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b := broker.Wrap[string](br, dedupe.Middleware[string](cachememory.New[string, bool]()))
+	handled := make(chan string, 3)
+	if _, err := b.Subscribe(context.Background(), "orders", func(e broker.Event[string]) error {
+		handled <- *e.Message()
+		return nil
+	}, broker.Queue("q")); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{"first", "redelivered", "second"} {
+		id := "m-1"
+		if m == "second" {
+			id = "m-2"
+		}
+		if err := b.Publish(context.Background(), "orders", &m, broker.MessageID(id)); err != nil {
+			t.Fatal(err)
+		}
+		// Keep the copies of m-1 from being handled concurrently.
+		if m == "first" {
+			<-handled
+		}
+	}
+	if err := br.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(handled)
+	var got []string
+	for m := range handled {
+		got = append(got, m)
+	}
+	if len(got) != 1 || got[0] != "second" {
+		t.Errorf("handled %v after the first message, want only [second]", got)
+	}
+}
@@ -0,0 +1,55 @@
+package dedupe
+
+import (
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+type options struct {
+	header string
+	prefix string
+	ttl    time.Duration
+}
+
+// Option customizes the deduplication middleware.
+type Option func(*options)
+
+// WithHeader sets the header carrying the ID messages are deduplicated by.
+// The default is broker.HeaderMessageID.
+func WithHeader(name string) Option {
+	return func(o *options) {
+		o.header = name
+	}
+}
+
+// WithPrefix sets the prefix of the keys stored in the cache. Consumers that
+// each need to see every message, such as different queue groups, must use
+// different prefixes when they share a cache. The default is "dedupe:".
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithTTL sets how long a handled message ID is remembered. Redeliveries
+// arriving later are handled again. The default is 24 hours.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		if ttl > 0 {
+			o.ttl = ttl
+		}
+	}
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		header: broker.HeaderMessageID,
+		prefix: "dedupe:",
+		ttl:    24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
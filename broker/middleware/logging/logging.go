@@ -0,0 +1,67 @@
+// Package logging provides a broker middleware that logs published messages
+// and handled events.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+type logger interface {
+	Log(ctx context.Context, level slog.Level, msg string, args ...any)
+}
+
+// Middleware returns a middleware that logs every publish and every handled
+// event, including its error. Message payloads and headers are only logged
+// when enabled through options, since they may carry sensitive data.
+func Middleware[T any](logger logger, opts ...Option) broker.Middleware[T] {
+	o := newOpts(opts...)
+	return broker.Middleware[T]{
+		Publish: func(next broker.PublishFunc[T]) broker.PublishFunc[T] {
+			return func(ctx context.Context, topic string, m *T, popts ...broker.PublishOption) error {
+				t := time.Now()
+				err := next(ctx, topic, m, popts...)
+				var op broker.PublishOptions
+				op.Apply(popts...)
+				attrs := messageAttrs(o, topic, m, op.Headers)
+				logger.Log(ctx, slog.LevelInfo, "published message", append(attrs, resultAttrs(o, err, time.Since(t))...)...)
+				return err
+			}
+		},
+		Handler: func(next broker.Handler[T]) broker.Handler[T] {
+			return func(e broker.Event[T]) error {
+				t := time.Now()
+				err := next(e)
+				attrs := messageAttrs(o, e.Topic(), e.Message(), e.Headers())
+				attrs = append(attrs, "broker.message_id", e.ID(), "broker.attempt", e.Attempt())
+				logger.Log(broker.ContextFrom(e), slog.LevelInfo, "handled message", append(attrs, resultAttrs(o, err, time.Since(t))...)...)
+				return err
+			}
+		},
+	}
+}
+
+func messageAttrs[T any](o *options, topic string, m *T, headers map[string]string) []any {
+	attrs := []any{}
+	if o.logTopic {
+		attrs = append(attrs, "broker.topic", topic)
+	}
+	if o.logMessage {
+		attrs = append(attrs, "broker.message", m)
+	}
+	if o.logHeaders {
+		attrs = append(attrs, "broker.headers", headers)
+	}
+	return attrs
+}
+
+func resultAttrs(o *options, err error, duration time.Duration) []any {
+	attrs := []any{"broker.error", err}
+	if o.logDuration {
+		attrs = append(attrs, "broker.duration", duration.String())
+	}
+	return attrs
+}
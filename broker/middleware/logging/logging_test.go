@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+type capturingLogger struct {
+	calls []call
+}
+
+type call struct {
+	msg   string
+	attrs []any
+}
+
+func (l *capturingLogger) Log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	l.calls = append(l.calls, call{msg: msg, attrs: args})
+}
+
+func (l *capturingLogger) value(key string) (any, bool) {
+	for _, c := range l.calls {
+		for i := 0; i+1 < len(c.attrs); i += 2 {
+			if k, ok := c.attrs[i].(string); ok && k == key {
+				return c.attrs[i+1], true
+			}
+		}
+	}
+	return nil, false
+}
+
+type event struct {
+	broker.Event[string]
+	msg string
+}
+
+func (e *event) Topic() string              { return "topic" }
+func (e *event) Message() *string           { return &e.msg }
+func (e *event) Headers() map[string]string { return map[string]string{"k": "v"} }
+func (e *event) ID() string                 { return "id-1" }
+func (e *event) Attempt() int               { return 2 }
+
+func TestMiddleware_DefaultOptionsDoNotLogPayload(t *testing.T) {
+	logger := &capturingLogger{}
+	publish := Middleware[string](logger).Publish(func(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+		return nil
+	})
+
+	msg := "SENSITIVE_PAYLOAD"
+	if err := publish(context.Background(), "topic", &msg, broker.Header("k", "v")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logger.calls) != 1 || logger.calls[0].msg != "published message" {
+		t.Fatalf("got calls %+v, want one published message", logger.calls)
+	}
+	for _, key := range []string{"broker.message", "broker.headers"} {
+		if _, ok := logger.value(key); ok {
+			t.Fatalf("logged %s with default options, want it withheld unless enabled", key)
+		}
+	}
+}
+
+func TestMiddleware_HandlerLogsEventAndError(t *testing.T) {
+	logger := &capturingLogger{}
+	want := errors.New("failed")
+	h := Middleware[string](logger, All()).Handler(func(broker.Event[string]) error {
+		return want
+	})
+
+	if err := h(&event{msg: "payload"}); err != want {
+		t.Fatalf("got err=%v, want %v", err, want)
+	}
+
+	for key, want := range map[string]any{
+		"broker.topic":      "topic",
+		"broker.message_id": "id-1",
+		"broker.attempt":    2,
+		"broker.error":      want,
+	} {
+		if got, _ := logger.value(key); got != want {
+			t.Fatalf("got %s=%v, want %v", key, got, want)
+		}
+	}
+	if got, _ := logger.value("broker.message"); *got.(*string) != "payload" {
+		t.Fatalf("got broker.message=%v, want payload", got)
+	}
+}
@@ -0,0 +1,77 @@
+package logging
+
+// Option is a function that configures logging middleware options.
+type Option func(*options)
+
+type (
+	options struct {
+		logTopic    bool
+		logMessage  bool
+		logHeaders  bool
+		logDuration bool
+	}
+)
+
+// Topic returns an Option that enables or disables logging of topic names.
+// When called without arguments or with true, it enables topic logging.
+// When called with false, it disables topic logging.
+func Topic(enabled ...bool) Option {
+	enable := len(enabled) == 0 || len(enabled) > 0 && enabled[0]
+	return func(o *options) {
+		o.logTopic = enable
+	}
+}
+
+// Message returns an Option that enables or disables logging of message payloads.
+// When called without arguments or with true, it enables message logging.
+// When called with false, it disables message logging.
+func Message(enabled ...bool) Option {
+	enable := len(enabled) == 0 || len(enabled) > 0 && enabled[0]
+	return func(o *options) {
+		o.logMessage = enable
+	}
+}
+
+// Headers returns an Option that enables or disables logging of message headers.
+// When called without arguments or with true, it enables header logging.
+// When called with false, it disables header logging.
+func Headers(enabled ...bool) Option {
+	enable := len(enabled) == 0 || len(enabled) > 0 && enabled[0]
+	return func(o *options) {
+		o.logHeaders = enable
+	}
+}
+
+// Duration returns an Option that enables or disables logging of publish and handle duration.
+// When called without arguments or with true, it enables duration logging.
+// When called with false, it disables duration logging.
+func Duration(enabled ...bool) Option {
+	enable := len(enabled) == 0 || len(enabled) > 0 && enabled[0]
+	return func(o *options) {
+		o.logDuration = enable
+	}
+}
+
+// All returns an Option that enables all logging options:
+// topic, message, headers, and duration.
+func All() Option {
+	return func(o *options) {
+		o.logTopic = true
+		o.logMessage = true
+		o.logHeaders = true
+		o.logDuration = true
+	}
+}
+
+func newOpts(opts ...Option) *options {
+	o := &options{
+		logTopic:    false,
+		logMessage:  false,
+		logHeaders:  false,
+		logDuration: false,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -0,0 +1,96 @@
+// Package metrics provides a broker middleware that reports publish and
+// handle counts and durations through a metric.Reporter.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/metric"
+)
+
+type options struct {
+	prefix  string
+	buckets []float64
+}
+
+// Option customizes metric names and histogram buckets.
+type Option func(*options)
+
+// WithPrefix sets the metric name prefix.
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithDurationBuckets overrides the histogram buckets used for duration metrics.
+func WithDurationBuckets(buckets []float64) Option {
+	return func(o *options) {
+		if len(buckets) > 0 {
+			o.buckets = append([]float64(nil), buckets...)
+		}
+	}
+}
+
+// Middleware returns a middleware that records publish and handle counts and
+// durations, labelled by topic and by status ("ok" or "error").
+func Middleware[T any](reporter metric.Reporter, opts ...Option) broker.Middleware[T] {
+	o := newOptions(opts...)
+	published := reporter.Counter(o.prefix+"published_total", "topic", "status")
+	publishDuration := reporter.Histogram(o.prefix+"publish_duration_seconds", o.buckets, "topic", "status")
+	handled := reporter.Counter(o.prefix+"handled_total", "topic", "status")
+	handleDuration := reporter.Histogram(o.prefix+"handle_duration_seconds", o.buckets, "topic", "status")
+
+	return broker.Middleware[T]{
+		Publish: func(next broker.PublishFunc[T]) broker.PublishFunc[T] {
+			return func(ctx context.Context, topic string, m *T, popts ...broker.PublishOption) error {
+				start := time.Now()
+				err := next(ctx, topic, m, popts...)
+				published.With("topic", topic, "status", status(err)).Add(1)
+				publishDuration.With("topic", topic, "status", status(err)).Record(time.Since(start).Seconds())
+				return err
+			}
+		},
+		Handler: func(next broker.Handler[T]) broker.Handler[T] {
+			return func(e broker.Event[T]) error {
+				start := time.Now()
+				err := next(e)
+				handled.With("topic", e.Topic(), "status", status(err)).Add(1)
+				handleDuration.With("topic", e.Topic(), "status", status(err)).Record(time.Since(start).Seconds())
+				return err
+			}
+		},
+	}
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		prefix: "broker_",
+		buckets: []float64{
+			0.005,
+			0.01,
+			0.025,
+			0.05,
+			0.1,
+			0.25,
+			0.5,
+			1,
+			2.5,
+			5,
+			10,
+		},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,56 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/middleware/metrics"
+	"github.com/pthethanh/nano/metric/memory"
+)
+
+type event struct {
+	broker.Event[string]
+}
+
+func (event) Topic() string { return "orders" }
+
+func TestMiddlewareRecordsMetrics(t *testing.T) {
+	reporter := memory.New()
+	mw := metrics.Middleware[string](reporter)
+
+	publish := mw.Publish(func(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+		return nil
+	})
+	msg := "hello"
+	if err := publish(context.Background(), "orders", &msg); err != nil {
+		t.Fatal(err)
+	}
+	want := errors.New("failed")
+	h := mw.Handler(func(broker.Event[string]) error {
+		return want
+	})
+	if err := h(event{}); err != want {
+		t.Fatalf("got err=%v, want %v", err, want)
+	}
+
+	rec := httptest.NewRecorder()
+	reporter.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, name := range []string{
+		"broker_published_total",
+		"broker_publish_duration_seconds",
+		"broker_handled_total",
+		"broker_handle_duration_seconds",
+	} {
+		if !strings.Contains(body, name) {
+			t.Fatalf("expected %s in metrics output, got: %s", name, body)
+		}
+	}
+	if !strings.Contains(body, `status="error"`) {
+		t.Fatalf("expected the failed handle to be recorded with status=error, got: %s", body)
+	}
+}
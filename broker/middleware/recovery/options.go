@@ -0,0 +1,63 @@
+package recovery
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+)
+
+type (
+	// Option customizes the behavior of the recovery middleware.
+	Option func(*options)
+
+	// Handler is a function that recovers from the panic `p` by returning an `error`.
+	// The context is the one carried by the event, see broker.ContextFrom.
+	Handler func(ctx context.Context, p any) (err error)
+
+	options struct {
+		handler Handler
+	}
+
+	// Error is returned by StackHandler and carries the recovered panic
+	// value and stack trace.
+	Error struct {
+		Err   any
+		Stack []byte
+	}
+)
+
+// WithHandler customizes the function for recovering from a panic.
+func WithHandler(f Handler) Option {
+	return func(o *options) {
+		o.handler = f
+	}
+}
+
+// StackHandler builds a Handler that captures a stack trace of up to
+// stackSize bytes, logs the recovered panic and stack via slog and returns
+// them as an *Error.
+func StackHandler(stackSize int) Handler {
+	return func(ctx context.Context, p any) error {
+		stack := make([]byte, stackSize)
+		stack = stack[:runtime.Stack(stack, false)]
+		slog.ErrorContext(ctx, "panic recovered", "panic", p, "stack", string(stack))
+		return &Error{Err: p, Stack: stack}
+	}
+}
+
+func newOpts(opts ...Option) *options {
+	opt := &options{
+		handler: nil,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	return opt
+}
+
+// Error implements the error interface without including the panic value or
+// stack trace, since this message can end up in dead-letter headers and
+// logs outside the service. Use the Err and Stack fields directly.
+func (e *Error) Error() string {
+	return "panic recovered"
+}
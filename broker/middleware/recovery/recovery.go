@@ -0,0 +1,35 @@
+// Package recovery provides a broker middleware that recovers from panics in
+// subscription handlers.
+package recovery
+
+import (
+	"context"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+// Middleware returns a middleware that turns a panic in a subscription
+// handler into the error returned for the event, so the subscription keeps
+// running and the event follows the usual retry and dead-letter policy.
+func Middleware[T any](opts ...Option) broker.Middleware[T] {
+	o := newOpts(opts...)
+	return broker.Middleware[T]{
+		Handler: func(next broker.Handler[T]) broker.Handler[T] {
+			return func(e broker.Event[T]) (err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						err = recoverFrom(broker.ContextFrom(e), r, o.handler)
+					}
+				}()
+				return next(e)
+			}
+		},
+	}
+}
+
+func recoverFrom(ctx context.Context, p any, r Handler) error {
+	if r != nil {
+		return r(ctx, p)
+	}
+	return StackHandler(64<<10)(ctx, p)
+}
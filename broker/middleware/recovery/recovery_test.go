@@ -0,0 +1,56 @@
+package recovery_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/middleware/recovery"
+)
+
+type event struct {
+	broker.Event[string]
+}
+
+func TestMiddlewareRecoversPanic(t *testing.T) {
+	h := recovery.Middleware[string]().Handler(func(broker.Event[string]) error {
+		panic("boom")
+	})
+
+	err := h(event{})
+	var recovered *recovery.Error
+	if !errors.As(err, &recovered) {
+		t.Fatalf("got err=%v, want *recovery.Error", err)
+	}
+	if recovered.Err != "boom" || len(recovered.Stack) == 0 {
+		t.Fatalf("got %+v, want panic value and stack", recovered)
+	}
+	if got := err.Error(); got != "panic recovered" {
+		t.Errorf("got message %q, want the panic value left out", got)
+	}
+}
+
+func TestMiddlewareUsesCustomHandler(t *testing.T) {
+	want := errors.New("recovered")
+	h := recovery.Middleware[string](recovery.WithHandler(func(ctx context.Context, p any) error {
+		return want
+	})).Handler(func(broker.Event[string]) error {
+		panic("boom")
+	})
+
+	if err := h(event{}); err != want {
+		t.Fatalf("got err=%v, want %v", err, want)
+	}
+}
+
+func TestMiddlewarePassesThroughHandlerError(t *testing.T) {
+	want := errors.New("failed")
+	h := recovery.Middleware[string]().Handler(func(broker.Event[string]) error {
+		return want
+	})
+
+	if err := h(event{}); err != want {
+		t.Fatalf("got err=%v, want %v", err, want)
+	}
+}
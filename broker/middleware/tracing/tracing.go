@@ -0,0 +1,168 @@
+// Package tracing provides a broker middleware that propagates OpenTelemetry
+// traces through message headers.
+package tracing
+
+import (
+	"context"
+
+	"github.com/pthethanh/nano/broker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+type options struct {
+	tracerProvider oteltrace.TracerProvider
+	propagator     propagation.TextMapPropagator
+	system         string
+	attrs          []attribute.KeyValue
+}
+
+// Option customizes tracing middleware behavior.
+type Option func(*options)
+
+// WithTracerProvider overrides the tracer provider used by the middleware.
+func WithTracerProvider(provider oteltrace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = provider
+	}
+}
+
+// WithPropagator overrides the propagator used for header extraction and injection.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(o *options) {
+		o.propagator = propagator
+	}
+}
+
+// WithSystem sets the messaging.system attribute of every span, identifying
+// the messaging system in use, such as "kafka", "nats" or "memory".
+func WithSystem(system string) Option {
+	return func(o *options) {
+		o.system = system
+	}
+}
+
+// WithAttributes appends static attributes to every span created by the middleware.
+func WithAttributes(attrs ...attribute.KeyValue) Option {
+	return func(o *options) {
+		o.attrs = append(o.attrs, attrs...)
+	}
+}
+
+// Middleware returns a middleware that traces messages across broker hops,
+// following the OpenTelemetry messaging semantic conventions.
+//
+// Publish starts a producer span, child of the span in ctx, and injects its
+// context into the message headers. When ctx carries no span but the headers
+// already do, as for a record relayed from an outbox, that span becomes the
+// parent instead.
+//
+// Every handled event gets a consumer span linked to the producer span
+// extracted from its headers. Handlers get the consumer span, along with the
+// propagated baggage, through broker.ContextFrom.
+func Middleware[T any](opts ...Option) broker.Middleware[T] {
+	o := newOptions(opts...)
+	tracer := o.tracerProvider.Tracer("github.com/pthethanh/nano/broker/middleware/tracing")
+
+	return broker.Middleware[T]{
+		Publish: func(next broker.PublishFunc[T]) broker.PublishFunc[T] {
+			return func(ctx context.Context, topic string, m *T, popts ...broker.PublishOption) error {
+				var op broker.PublishOptions
+				op.Apply(popts...)
+				op.Stamp()
+				if !oteltrace.SpanContextFromContext(ctx).IsValid() {
+					ctx = o.propagator.Extract(ctx, propagation.MapCarrier(op.Headers))
+				}
+				ctx, span := tracer.Start(ctx, "publish "+topic,
+					oteltrace.WithSpanKind(oteltrace.SpanKindProducer),
+					oteltrace.WithAttributes(append(o.attributes("send", "publish", topic, op.Headers), o.attrs...)...),
+				)
+				defer span.End()
+
+				o.propagator.Inject(ctx, propagation.MapCarrier(op.Headers))
+				err := next(ctx, topic, m, append(popts[:len(popts):len(popts)], broker.Headers(op.Headers))...)
+				record(span, err)
+				return err
+			}
+		},
+		Handler: func(next broker.Handler[T]) broker.Handler[T] {
+			return func(e broker.Event[T]) error {
+				parent := broker.ContextFrom(e)
+				carrier := propagation.MapCarrier(e.Headers())
+				producer := oteltrace.SpanContextFromContext(o.propagator.Extract(context.Background(), carrier))
+				// Keep the propagated baggage but not the producer span: it
+				// is linked rather than being the parent.
+				ctx := oteltrace.ContextWithSpan(o.propagator.Extract(parent, carrier), oteltrace.SpanFromContext(parent))
+
+				startOpts := []oteltrace.SpanStartOption{
+					oteltrace.WithSpanKind(oteltrace.SpanKindConsumer),
+					oteltrace.WithAttributes(append(o.attributes("process", "process", e.Topic(), e.Headers()), o.attrs...)...),
+				}
+				if producer.IsValid() {
+					startOpts = append(startOpts, oteltrace.WithLinks(oteltrace.Link{SpanContext: producer}))
+				}
+				ctx, span := tracer.Start(ctx, "process "+e.Topic(), startOpts...)
+				defer span.End()
+
+				if err := e.Error(); err != nil {
+					span.RecordError(err)
+				}
+				err := next(broker.WithContext(e, ctx))
+				record(span, err)
+				return err
+			}
+		},
+	}
+}
+
+// Headers returns a PublishOption carrying the span context of ctx in the
+// message headers. Use it where the message is published later from another
+// context, such as when adding it to an outbox, so that the eventual publish
+// continues the trace.
+func Headers(ctx context.Context, opts ...Option) broker.PublishOption {
+	o := newOptions(opts...)
+	headers := propagation.MapCarrier{}
+	o.propagator.Inject(ctx, headers)
+	return broker.Headers(headers)
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		tracerProvider: otel.GetTracerProvider(),
+		propagator:     otel.GetTextMapPropagator(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func (o *options) attributes(operationType, operationName, topic string, headers map[string]string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.operation.type", operationType),
+		attribute.String("messaging.operation.name", operationName),
+		attribute.String("messaging.destination.name", topic),
+	}
+	if o.system != "" {
+		attrs = append(attrs, attribute.String("messaging.system", o.system))
+	}
+	if id := headers[broker.HeaderMessageID]; id != "" {
+		attrs = append(attrs, attribute.String("messaging.message.id", id))
+	}
+	if id := headers[broker.HeaderCorrelationID]; id != "" {
+		attrs = append(attrs, attribute.String("messaging.message.conversation_id", id))
+	}
+	return attrs
+}
+
+func record(span oteltrace.Span, err error) {
+	if err == nil {
+		span.SetStatus(codes.Ok, "")
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,249 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/memory"
+	"github.com/pthethanh/nano/broker/middleware/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+)
+
+type event struct {
+	broker.Event[string]
+	headers map[string]string
+}
+
+func (e *event) Topic() string              { return "orders" }
+func (e *event) Headers() map[string]string { return e.headers }
+func (e *event) Error() error               { return nil }
+
+var parent = oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+	TraceID:    oteltrace.TraceID{1},
+	SpanID:     oteltrace.SpanID{1},
+	TraceFlags: oteltrace.FlagsSampled,
+})
+
+func TestMiddlewareLinksConsumerToProducerAcrossMemoryBroker(t *testing.T) {
+	tp := &tracerProvider{}
+	br := memory.New[string]()
+	if err := br.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b := broker.Wrap[string](br, tracing.Middleware[string](
+		tracing.WithTracerProvider(tp),
+		tracing.WithPropagator(propagation.TraceContext{}),
+		tracing.WithSystem("memory"),
+	))
+
+	type handled struct {
+		id   string
+		span oteltrace.SpanContext
+	}
+	done := make(chan handled, 1)
+	if _, err := b.Subscribe(context.Background(), "orders", func(e broker.Event[string]) error {
+		done <- handled{id: e.ID(), span: oteltrace.SpanContextFromContext(broker.ContextFrom(e))}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	msg := "hello"
+	if err := b.Publish(oteltrace.ContextWithSpanContext(context.Background(), parent), "orders", &msg); err != nil {
+		t.Fatal(err)
+	}
+	var got handled
+	select {
+	case got = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+	// Wait for the handler middleware to finish with the consumer span.
+	if err := br.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	producer, consumer := tp.span("publish orders"), tp.span("process orders")
+	if producer == nil || consumer == nil {
+		t.Fatalf("got spans %v, want a publish and a process span", tp.names())
+	}
+	if producer.kind != oteltrace.SpanKindProducer || producer.parent.SpanID() != parent.SpanID() {
+		t.Fatalf("got producer kind %v with parent %v, want a producer span child of the caller", producer.kind, producer.parent.SpanID())
+	}
+	if consumer.kind != oteltrace.SpanKindConsumer || consumer.parent.IsValid() {
+		t.Fatalf("got consumer kind %v with parent %v, want a root consumer span", consumer.kind, consumer.parent)
+	}
+	if len(consumer.links) != 1 || consumer.links[0].SpanContext.SpanID() != producer.spanContext.SpanID() {
+		t.Fatalf("got consumer links %v, want a link to the producer span %v", consumer.links, producer.spanContext)
+	}
+	if got.span.SpanID() != consumer.spanContext.SpanID() {
+		t.Fatalf("handler saw span %v, want the consumer span %v", got.span.SpanID(), consumer.spanContext.SpanID())
+	}
+	for _, s := range []*recordingSpan{producer, consumer} {
+		want := map[attribute.Key]string{
+			"messaging.system":           "memory",
+			"messaging.destination.name": "orders",
+			"messaging.message.id":       got.id,
+		}
+		for k, v := range want {
+			if s.attr(k) != v {
+				t.Fatalf("%s: got %s=%q, want %q", s.name, k, s.attr(k), v)
+			}
+		}
+	}
+}
+
+func TestMiddlewareContinuesTraceFromHeaders(t *testing.T) {
+	tp := &tracerProvider{}
+	mw := tracing.Middleware[string](
+		tracing.WithTracerProvider(tp),
+		tracing.WithPropagator(propagation.TraceContext{}),
+	)
+	publish := mw.Publish(func(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+		return errors.New("unavailable")
+	})
+
+	// The span context travelled with the message, e.g. through an outbox,
+	// while the relay publishing it has none.
+	headers := tracing.Headers(oteltrace.ContextWithSpanContext(context.Background(), parent), tracing.WithPropagator(propagation.TraceContext{}))
+	msg := "hello"
+	if err := publish(context.Background(), "orders", &msg, headers); err == nil {
+		t.Fatal("Publish() did not return the broker error")
+	}
+
+	producer := tp.span("publish orders")
+	if producer == nil || producer.parent.SpanID() != parent.SpanID() {
+		t.Fatalf("got producer %+v, want a span child of the span in the headers", producer)
+	}
+	if producer.statusCode != codes.Error {
+		t.Fatalf("got status %v, want error", producer.statusCode)
+	}
+}
+
+func TestMiddlewareLeavesTheCallersOptionsUntouched(t *testing.T) {
+	publish := tracing.Middleware[string](tracing.WithTracerProvider(&tracerProvider{})).Publish(func(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+		return nil
+	})
+	// Spare capacity lets an unclipped append write into the caller's array.
+	opts := make([]broker.PublishOption, 1, 2)
+	opts[0] = broker.Key("k")
+	spare := opts[:2]
+	msg := "hello"
+	if err := publish(context.Background(), "orders", &msg, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if spare[1] != nil {
+		t.Error("Publish() appended to the caller's option slice")
+	}
+}
+
+func TestMiddlewareWithoutProducerSpan(t *testing.T) {
+	tp := &tracerProvider{}
+	h := tracing.Middleware[string](tracing.WithTracerProvider(tp)).Handler(func(broker.Event[string]) error {
+		return nil
+	})
+	if err := h(&event{}); err != nil {
+		t.Fatal(err)
+	}
+	consumer := tp.span("process orders")
+	if consumer == nil || len(consumer.links) != 0 {
+		t.Fatalf("got consumer %+v, want a span without links", consumer)
+	}
+}
+
+type tracerProvider struct {
+	embedded.TracerProvider
+	mu     sync.Mutex
+	spans  []*recordingSpan
+	nextID byte
+}
+
+func (p *tracerProvider) Tracer(string, ...oteltrace.TracerOption) oteltrace.Tracer {
+	return tracer{provider: p}
+}
+
+func (p *tracerProvider) span(name string) *recordingSpan {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (p *tracerProvider) names() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var names []string
+	for _, s := range p.spans {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+type tracer struct {
+	embedded.Tracer
+	provider *tracerProvider
+}
+
+func (t tracer) Start(ctx context.Context, name string, opts ...oteltrace.SpanStartOption) (context.Context, oteltrace.Span) {
+	cfg := oteltrace.NewSpanStartConfig(opts...)
+	parent := oteltrace.SpanContextFromContext(ctx)
+
+	t.provider.mu.Lock()
+	defer t.provider.mu.Unlock()
+	t.provider.nextID++
+	traceID := parent.TraceID()
+	if !traceID.IsValid() {
+		traceID = oteltrace.TraceID{0xff, t.provider.nextID}
+	}
+	span := &recordingSpan{
+		name: name,
+		kind: cfg.SpanKind(),
+		spanContext: oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     oteltrace.SpanID{0xff, t.provider.nextID},
+			TraceFlags: oteltrace.FlagsSampled,
+		}),
+		parent: parent,
+		links:  cfg.Links(),
+		attrs:  append([]attribute.KeyValue(nil), cfg.Attributes()...),
+	}
+	t.provider.spans = append(t.provider.spans, span)
+	return oteltrace.ContextWithSpan(ctx, span), span
+}
+
+type recordingSpan struct {
+	oteltrace.Span
+	name        string
+	kind        oteltrace.SpanKind
+	spanContext oteltrace.SpanContext
+	parent      oteltrace.SpanContext
+	links       []oteltrace.Link
+	attrs       []attribute.KeyValue
+	statusCode  codes.Code
+}
+
+func (s *recordingSpan) End(...oteltrace.SpanEndOption)              {}
+func (s *recordingSpan) RecordError(error, ...oteltrace.EventOption) {}
+func (s *recordingSpan) SpanContext() oteltrace.SpanContext          { return s.spanContext }
+func (s *recordingSpan) SetStatus(code codes.Code, _ string)         { s.statusCode = code }
+func (s *recordingSpan) SetAttributes(kv ...attribute.KeyValue)      { s.attrs = append(s.attrs, kv...) }
+
+func (s *recordingSpan) attr(key attribute.Key) string {
+	for _, kv := range s.attrs {
+		if kv.Key == key {
+			return kv.Value.AsString()
+		}
+	}
+	return ""
+}
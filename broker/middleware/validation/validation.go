@@ -0,0 +1,54 @@
+// Package validation provides a broker middleware that validates protobuf
+// messages against the buf.validate rules declared in their schemas, with
+// the validators of the validator package.
+package validation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/validator"
+	"google.golang.org/protobuf/proto"
+)
+
+// Middleware returns a middleware that rejects invalid messages: Publish
+// returns the validation error without publishing, and handlers are not
+// called for invalid events, whose validation error is returned instead so
+// they follow the subscription's retry and dead-letter policy. *T must be a
+// proto.Message. A nil Validator uses validator.Default.
+func Middleware[T any](v validator.Validator) broker.Middleware[T] {
+	if v == nil {
+		v = validator.Default()
+	}
+	return broker.Middleware[T]{
+		Publish: func(next broker.PublishFunc[T]) broker.PublishFunc[T] {
+			return func(ctx context.Context, topic string, m *T, opts ...broker.PublishOption) error {
+				if err := validate(v, m); err != nil {
+					return err
+				}
+				return next(ctx, topic, m, opts...)
+			}
+		},
+		Handler: func(next broker.Handler[T]) broker.Handler[T] {
+			return func(e broker.Event[T]) error {
+				// Events that failed to decode carry no message to validate.
+				if e.Error() != nil {
+					return next(e)
+				}
+				if err := validate(v, e.Message()); err != nil {
+					return err
+				}
+				return next(e)
+			}
+		},
+	}
+}
+
+func validate[T any](v validator.Validator, m *T) error {
+	msg, ok := any(m).(proto.Message)
+	if !ok {
+		return fmt.Errorf("validation: unsupported message type %T", m)
+	}
+	return v.Validate(msg)
+}
@@ -0,0 +1,117 @@
+package validation_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"buf.build/go/protovalidate"
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/middleware/validation"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type event struct {
+	broker.Event[dynamicpb.Message]
+	msg *dynamicpb.Message
+	err error
+}
+
+func (e *event) Message() *dynamicpb.Message { return e.msg }
+func (e *event) Error() error                { return e.err }
+
+func TestMiddlewareRejectsInvalidPublish(t *testing.T) {
+	messageType := requiredNameMessageType(t)
+	published := 0
+	publish := validation.Middleware[dynamicpb.Message](nil).Publish(func(ctx context.Context, topic string, m *dynamicpb.Message, opts ...broker.PublishOption) error {
+		published++
+		return nil
+	})
+
+	err := publish(context.Background(), "topic", messageType.New().Interface().(*dynamicpb.Message))
+	var validationErr *protovalidate.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got err=%v, want *protovalidate.ValidationError", err)
+	}
+
+	valid := messageType.New()
+	valid.Set(valid.Descriptor().Fields().ByName("name"), protoreflect.ValueOfString("nano"))
+	if err := publish(context.Background(), "topic", valid.Interface().(*dynamicpb.Message)); err != nil {
+		t.Fatal(err)
+	}
+	if published != 1 {
+		t.Fatalf("published %d messages, want only the valid one", published)
+	}
+}
+
+func TestMiddlewareSkipsHandlerForInvalidEvent(t *testing.T) {
+	messageType := requiredNameMessageType(t)
+	handled := 0
+	h := validation.Middleware[dynamicpb.Message](nil).Handler(func(broker.Event[dynamicpb.Message]) error {
+		handled++
+		return nil
+	})
+
+	if err := h(&event{msg: messageType.New().Interface().(*dynamicpb.Message)}); err == nil {
+		t.Fatal("handler accepted a message that violates min_len=1")
+	}
+	// Decode failures reach the handler untouched.
+	if err := h(&event{err: errors.New("bad payload")}); err != nil {
+		t.Fatal(err)
+	}
+	if handled != 1 {
+		t.Fatalf("handled %d events, want only the decode failure", handled)
+	}
+}
+
+func TestMiddlewareRejectsNonProtoMessages(t *testing.T) {
+	publish := validation.Middleware[string](nil).Publish(func(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+		return nil
+	})
+	msg := "hello"
+	if err := publish(context.Background(), "topic", &msg); err == nil {
+		t.Fatal("Publish() accepted a message that is not a proto.Message")
+	}
+}
+
+func requiredNameMessageType(t *testing.T) protoreflect.MessageType {
+	t.Helper()
+
+	fieldOptions := &descriptorpb.FieldOptions{}
+	proto.SetExtension(fieldOptions, validate.E_Field, validate.FieldRules_builder{
+		String: validate.StringRules_builder{MinLen: proto.Uint64(1)}.Build(),
+	}.Build())
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("nano.broker.validation.proto"),
+		Package:    proto.String("nano.broker.validation.test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"buf/validate/validate.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Event"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:    proto.String("name"),
+				Number:  proto.Int32(1),
+				Type:    descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label:   descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Options: fieldOptions,
+			}},
+		}},
+	}
+
+	files := new(protoregistry.Files)
+	if err := files.RegisterFile(validate.File_buf_validate_validate_proto); err != nil {
+		t.Fatalf("register validate descriptor: %v", err)
+	}
+	descriptor, err := protodesc.FileOptions{}.New(file, files)
+	if err != nil {
+		t.Fatalf("build event descriptor: %v", err)
+	}
+	return dynamicpb.NewMessageType(descriptor.Messages().ByName("Event"))
+}
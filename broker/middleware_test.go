@@ -0,0 +1,97 @@
+package broker_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+type ctxKey struct{}
+
+// stubBroker publishes through capture and keeps the handler of the last
+// subscription so tests can deliver events to it.
+type stubBroker struct {
+	broker.Broker[string]
+	published []published
+	handler   func(broker.Event[string]) error
+}
+
+func (b *stubBroker) Publish(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+	return capture(&b.published)(ctx, topic, m, opts...)
+}
+
+func (b *stubBroker) Subscribe(ctx context.Context, topic string, h func(broker.Event[string]) error, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	b.handler = h
+	return nil, nil
+}
+
+func recordingMiddleware(name string, calls *[]string) broker.Middleware[string] {
+	return broker.Middleware[string]{
+		Publish: func(next broker.PublishFunc[string]) broker.PublishFunc[string] {
+			return func(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+				*calls = append(*calls, "publish "+name)
+				return next(ctx, topic, m, append(opts, broker.Header(name, "1"))...)
+			}
+		},
+		Handler: func(next broker.Handler[string]) broker.Handler[string] {
+			return func(e broker.Event[string]) error {
+				*calls = append(*calls, "handle "+name)
+				return next(e)
+			}
+		},
+	}
+}
+
+func TestWrap_AppliesMiddlewaresInOrder(t *testing.T) {
+	var calls []string
+	b := &stubBroker{}
+	w := broker.Wrap[string](b,
+		recordingMiddleware("outer", &calls),
+		broker.Middleware[string]{}, // both sides nil
+		recordingMiddleware("inner", &calls),
+	)
+
+	msg := "hello"
+	if err := w.Publish(context.Background(), "topic", &msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.published) != 1 || b.published[0].headers["outer"] != "1" || b.published[0].headers["inner"] != "1" {
+		t.Fatalf("published %+v, want both middleware headers", b.published)
+	}
+
+	if _, err := w.Subscribe(context.Background(), "topic", func(broker.Event[string]) error {
+		calls = append(calls, "handler")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.handler(&testEvent{topic: "topic", msg: &msg}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"publish outer", "publish inner", "handle outer", "handle inner", "handler"}
+	if !slices.Equal(calls, want) {
+		t.Fatalf("got calls %v, want %v", calls, want)
+	}
+	if got := w.(interface{ Unwrap() broker.Broker[string] }).Unwrap(); got != b {
+		t.Fatalf("Unwrap() = %v, want the wrapped broker", got)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	msg := "hello"
+	e := &testEvent{topic: "topic", msg: &msg}
+	if got := broker.ContextFrom[string](e); got != context.Background() {
+		t.Fatalf("ContextFrom() = %v, want context.Background()", got)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "v")
+	ce := broker.WithContext[string](e, ctx)
+	if got := broker.ContextFrom(ce).Value(ctxKey{}); got != "v" {
+		t.Fatalf("ContextFrom() value = %v, want v", got)
+	}
+	if ce.Message() != &msg || ce.Topic() != "topic" {
+		t.Fatal("WithContext() did not keep the event")
+	}
+}
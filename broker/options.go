@@ -1,23 +1,42 @@
 package broker
 
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
 type (
 	// PublishOptions holds configuration for publishing messages.
 	PublishOptions struct {
 		// Headers are transport-level key/value pairs attached to the
 		// message, e.g. Kafka record headers, NATS message headers, or
-		// Watermill message metadata. Support is transport-dependent; a
-		// Broker implementation with no wire-level header concept (such as
-		// the in-memory broker) ignores this field.
+		// Watermill message metadata. Subscribers read them back through
+		// Event.Headers.
 		Headers map[string]string
+		// Key is the ordering key of the message, see Key.
+		Key string
+		// DeliverAt is the time the message is delivered at, see DeliverAt;
+		// the zero time means right away.
+		DeliverAt time.Time
 	}
 
 	// SubscribeOptions holds configuration for subscribing to messages.
 	// Fields:
 	//   AutoAck: If true (default), messages are automatically acknowledged when the handler returns nil error.
+	//   AckWait: With AutoAck disabled, how long a message may stay unacknowledged before it is redelivered.
 	//   Queue: Subscribers with the same queue name will share the subscription and receive a subset of messages.
+	//   MaxAttempts, Backoff, DeadLetter: redelivery policy for failed handlers, see Handle.
+	//   BatchSize, BatchLinger: batching limits of subscriptions made with SubscribeBatch.
 	SubscribeOptions struct {
-		AutoAck bool   // If true, automatically ack messages on successful handler execution.
-		Queue   string // Name of the queue for shared subscriptions.
+		AutoAck     bool                            // If true, automatically ack messages on successful handler execution.
+		AckWait     time.Duration                   // Visibility timeout of unacknowledged messages; zero means the broker default.
+		Queue       string                          // Name of the queue for shared subscriptions.
+		MaxAttempts int                             // Number of handler attempts per message; values below 1 mean a single attempt.
+		Backoff     func(attempt int) time.Duration // Delay before the next attempt after the given failed attempt.
+		DeadLetter  string                          // Topic that messages are republished to once all attempts fail.
+		BatchSize   int                             // Maximum number of events per batch; zero means DefaultBatchSize.
+		BatchLinger time.Duration                   // Maximum wait for a batch to fill up; zero means DefaultBatchLinger.
 	}
 
 	// PublishOption defines a function that configures PublishOptions.
@@ -36,13 +55,77 @@ func Queue(name string) SubscribeOption {
 }
 
 // DisableAutoAck disables automatic acknowledgment of messages
-// after they have been handled by the subscriber.
+// after they have been handled by the subscriber. The handler then settles
+// each event with Event.Ack or Event.Nack.
 func DisableAutoAck() SubscribeOption {
 	return func(o *SubscribeOptions) {
 		o.AutoAck = false
 	}
 }
 
+// AckWait sets how long a message delivered to a subscriber with auto-ack
+// disabled may stay unacknowledged before it is redelivered. Support and
+// defaults are broker-specific: Kafka has no per-message ack deadline and
+// ignores it, and the file broker rejects it.
+func AckWait(d time.Duration) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		if d > 0 {
+			o.AckWait = d
+		}
+	}
+}
+
+// MaxAttempts sets the total number of times the handler is invoked for a
+// message, including the first delivery, before the message is given up on
+// (and dead-lettered, if DeadLetter is set). Values below 1 are ignored.
+func MaxAttempts(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		if n > 0 {
+			o.MaxAttempts = n
+		}
+	}
+}
+
+// Backoff sets the delay between handler attempts. Attempt numbering starts
+// at 1 for the first delivery, so backoff(1) is the delay before the second
+// attempt.
+func Backoff(backoff func(attempt int) time.Duration) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Backoff = backoff
+	}
+}
+
+// DeadLetter sets the topic that a message is republished to once every
+// handler attempt has failed. The republished message keeps its original
+// headers and gains HeaderDeadLetterTopic, HeaderDeadLetterReason and
+// HeaderDeadLetterAttempts.
+func DeadLetter(topic string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.DeadLetter = topic
+	}
+}
+
+// BatchSize sets the maximum number of events handed to a batch handler at
+// once. Values below 1 are ignored.
+func BatchSize(n int) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		if n > 0 {
+			o.BatchSize = n
+		}
+	}
+}
+
+// BatchLinger sets how long a batch subscription waits, after the first event
+// of a batch arrives, for the batch to fill up before handing it over
+// anyway. Values of zero or below are ignored.
+func BatchLinger(d time.Duration) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		if d > 0 {
+			o.BatchLinger = d
+		}
+	}
+}
+
 // Apply applies a list of SubscribeOption functions to the SubscribeOptions receiver.
 func (op *SubscribeOptions) Apply(opts ...SubscribeOption) {
 	for _, f := range opts {
@@ -75,6 +158,80 @@ func Headers(headers map[string]string) PublishOption {
 	}
 }
 
+// MessageID sets the HeaderMessageID header, overriding the random ID Publish
+// would otherwise assign.
+func MessageID(id string) PublishOption {
+	return Header(HeaderMessageID, id)
+}
+
+// Key sets the ordering key of the message. Messages published with the
+// same key to the same topic are delivered in publish order, to a single
+// member of a queue group: Kafka uses it as the record key, NATS carries it
+// in a header and can append it to the subject as a token, and the memory
+// broker handles every message of a key on the same worker goroutine. Brokers without a notion of keys ignore
+// it. Subscribers read it back with KeyFrom.
+func Key(key string) PublishOption {
+	return func(o *PublishOptions) {
+		o.Key = key
+	}
+}
+
+// DeliverAt delays the delivery of the message until t. Brokers deliver it
+// right away if t is not in the future, and return ErrDelayUnsupported if
+// they cannot delay messages. Use a MessageID to cancel it with Cancel until
+// then.
+func DeliverAt(t time.Time) PublishOption {
+	return func(o *PublishOptions) {
+		o.DeliverAt = t
+	}
+}
+
+// Delay delays the delivery of the message by d from the time of the
+// publish, see DeliverAt.
+func Delay(d time.Duration) PublishOption {
+	return func(o *PublishOptions) {
+		o.DeliverAt = time.Now().Add(d)
+	}
+}
+
+// KeyFrom returns the ordering key e was published with, or "" if it has none
+// or its broker does not carry keys. It looks through events wrapped by
+// Handle and WithContext.
+func KeyFrom[T any](e Event[T]) string {
+	for {
+		switch v := e.(type) {
+		case interface{ Key() string }:
+			return v.Key()
+		case interface{ Unwrap() Event[T] }:
+			e = v.Unwrap()
+		default:
+			return ""
+		}
+	}
+}
+
+// Stamp sets the HeaderMessageID header to a random ID and the
+// HeaderTimestamp header to the current time, keeping any value already set.
+// Broker implementations call it from Publish so every message carries an ID
+// and a publish time across the hop.
+func (op *PublishOptions) Stamp() {
+	if op.Headers == nil {
+		op.Headers = make(map[string]string, 2)
+	}
+	if op.Headers[HeaderMessageID] == "" {
+		op.Headers[HeaderMessageID] = uuid.New().String()
+	}
+	if op.Headers[HeaderTimestamp] == "" {
+		op.Headers[HeaderTimestamp] = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// Delayed reports whether the message is to be delivered later, see
+// DeliverAt.
+func (op *PublishOptions) Delayed() bool {
+	return op.DeliverAt.After(time.Now())
+}
+
 // Apply applies a list of PublishOption functions to the PublishOptions receiver.
 func (op *PublishOptions) Apply(opts ...PublishOption) {
 	for _, f := range opts {
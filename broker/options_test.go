@@ -28,3 +28,22 @@ func TestPublishOptions_HeaderOnEmptyOptions(t *testing.T) {
 		t.Errorf("Headers[%q] = %q, want %q", "k", opts.Headers["k"], "v")
 	}
 }
+
+func TestPublishOptions_StampKeepsExistingValues(t *testing.T) {
+	opts := &broker.PublishOptions{}
+	opts.Apply(broker.MessageID("m-1"))
+	opts.Stamp()
+
+	if opts.Headers[broker.HeaderMessageID] != "m-1" {
+		t.Errorf("Headers[%s] = %q, want %q", broker.HeaderMessageID, opts.Headers[broker.HeaderMessageID], "m-1")
+	}
+	if broker.TimestampFrom(opts.Headers).IsZero() {
+		t.Errorf("Headers[%s] = %q, want the current time", broker.HeaderTimestamp, opts.Headers[broker.HeaderTimestamp])
+	}
+
+	stamped := &broker.PublishOptions{}
+	stamped.Stamp()
+	if stamped.Headers[broker.HeaderMessageID] == "" {
+		t.Errorf("Headers[%s] is empty, want a generated ID", broker.HeaderMessageID)
+	}
+}
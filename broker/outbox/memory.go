@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory Store, for tests and for services that only
+// need the retry behavior of a Relay without durability.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records []*memoryRecord
+}
+
+type memoryRecord struct {
+	Record
+	next time.Time
+	dead bool
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Add implements Store.
+func (s *MemoryStore) Add(ctx context.Context, records ...*Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range records {
+		s.records = append(s.records, &memoryRecord{Record: *r})
+	}
+	return nil
+}
+
+// Pending implements Store.
+func (s *MemoryStore) Pending(ctx context.Context, now time.Time, limit int) ([]*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*Record
+	for _, r := range s.records {
+		if len(out) == limit {
+			break
+		}
+		if r.dead || r.next.After(now) {
+			continue
+		}
+		rec := r.Record
+		out = append(out, &rec)
+	}
+	return out, nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = slices.DeleteFunc(s.records, func(r *memoryRecord) bool {
+		return slices.Contains(ids, r.ID)
+	})
+	return nil
+}
+
+// Retry implements Store.
+func (s *MemoryStore) Retry(ctx context.Context, id string, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.records {
+		if r.ID == id {
+			r.Attempts++
+			r.next = next
+		}
+	}
+	return nil
+}
+
+// Bury implements Store.
+func (s *MemoryStore) Bury(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.records {
+		if r.ID == id {
+			r.Attempts++
+			r.dead = true
+		}
+	}
+	return nil
+}
+
+// Dead returns the records buried by Bury.
+func (s *MemoryStore) Dead() []*Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*Record
+	for _, r := range s.records {
+		if r.dead {
+			rec := r.Record
+			out = append(out, &rec)
+		}
+	}
+	return out
+}
+
+// Len returns the number of records in the store, including dead ones.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
@@ -0,0 +1,100 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
+)
+
+type (
+	options[T any] struct {
+		codec       broker.Codec[T]
+		interval    time.Duration
+		batchSize   int
+		maxAttempts int
+		backoff     func(attempt int) time.Duration
+		log         logger
+	}
+
+	// Option is an optional configuration of an Outbox or a Relay.
+	Option[T any] func(*options[T])
+
+	logger interface {
+		Log(ctx context.Context, level slog.Level, msg string, args ...any)
+	}
+)
+
+func newOptions[T any](opts ...Option[T]) *options[T] {
+	o := &options[T]{
+		codec:       codec.JSON[T]{},
+		interval:    time.Second,
+		batchSize:   100,
+		maxAttempts: 20,
+		backoff: func(attempt int) time.Duration {
+			return min(time.Duration(attempt)*time.Second, time.Minute)
+		},
+		log: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Codec sets the codec used to encode messages into records and decode them
+// back before publishing. Outbox and Relay must use the same codec.
+func Codec[T any](c broker.Codec[T]) Option[T] {
+	return func(o *options[T]) {
+		o.codec = c
+	}
+}
+
+// Interval sets how often a Relay polls the store for pending records.
+// Default is 1 second.
+func Interval[T any](d time.Duration) Option[T] {
+	return func(o *options[T]) {
+		if d > 0 {
+			o.interval = d
+		}
+	}
+}
+
+// BatchSize sets the maximum number of records a Relay reads from the store
+// at once. Default is 100.
+func BatchSize[T any](n int) Option[T] {
+	return func(o *options[T]) {
+		if n > 0 {
+			o.batchSize = n
+		}
+	}
+}
+
+// Backoff sets how long a Relay waits before retrying a record after its
+// given failed attempt. Default is one second per attempt, capped at one
+// minute.
+func Backoff[T any](backoff func(attempt int) time.Duration) Option[T] {
+	return func(o *options[T]) {
+		if backoff != nil {
+			o.backoff = backoff
+		}
+	}
+}
+
+// MaxAttempts sets how many times a Relay tries to publish a record before
+// burying it in the store, see Store.Bury. Zero or less retries records
+// forever. Default is 20.
+func MaxAttempts[T any](n int) Option[T] {
+	return func(o *options[T]) {
+		o.maxAttempts = n
+	}
+}
+
+// Logger sets the logger a Relay reports publish failures to.
+func Logger[T any](l logger) Option[T] {
+	return func(o *options[T]) {
+		o.log = l
+	}
+}
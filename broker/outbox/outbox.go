@@ -0,0 +1,114 @@
+// Package outbox implements the transactional outbox pattern for brokers.
+//
+// Instead of publishing directly, a handler stores the message as a Record in
+// the same database transaction as its own state changes, and a Relay
+// publishes stored records to a broker.Broker afterwards, deleting them once
+// the broker accepted them. A crash between the two steps leads to the record
+// being published again rather than lost, so every published message carries
+// its record ID in the broker.HeaderMessageID header for consumers to
+// discard duplicates.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
+)
+
+// errNoMessage reports a record returned without its message, see
+// Store.Pending.
+var errNoMessage = errors.New("outbox: record has no decodable message")
+
+type (
+	// Record is a message waiting in the outbox to be published.
+	Record struct {
+		// ID uniquely identifies the record and is published as the
+		// broker.HeaderMessageID header.
+		ID string
+		// Topic is the topic the message is published to.
+		Topic string
+		// Key is the ordering key the message is published with, see
+		// broker.Key.
+		Key string
+		// Message is the envelope holding the headers and encoded body. It
+		// is nil if the store could not decode the stored envelope.
+		Message *broker.Message
+		// Attempts is the number of failed publish attempts so far.
+		Attempts int
+		// CreatedAt is the time the record was created.
+		CreatedAt time.Time
+	}
+
+	// Store persists outbox records. Implementations must be safe for
+	// concurrent use.
+	Store interface {
+		// Add stores records to be published.
+		Add(ctx context.Context, records ...*Record) error
+		// Pending returns up to limit records that are due at now, oldest
+		// first. A record whose stored envelope cannot be decoded is
+		// returned with a nil Message, for the relay to bury, rather than
+		// failing the whole batch.
+		Pending(ctx context.Context, now time.Time, limit int) ([]*Record, error)
+		// Delete removes published records.
+		Delete(ctx context.Context, ids ...string) error
+		// Retry records a failed publish attempt of the record, deferring it
+		// until next.
+		Retry(ctx context.Context, id string, next time.Time) error
+		// Bury records the final failed attempt of the record and marks it
+		// dead: Pending no longer returns it, but it is kept for
+		// inspection.
+		Bury(ctx context.Context, id string) error
+	}
+
+	// Outbox encodes messages of type T into Records and adds them to a
+	// Store.
+	Outbox[T any] struct {
+		store Store
+		codec broker.Codec[T]
+	}
+)
+
+// New returns an Outbox that adds records to store.
+func New[T any](store Store, opts ...Option[T]) *Outbox[T] {
+	o := newOptions(opts...)
+	return &Outbox[T]{
+		store: store,
+		codec: o.codec,
+	}
+}
+
+// Record encodes m into a new Record for topic without storing it. Use it to
+// add the record through a store bound to a transaction, such as
+// SQLStore.AddTx. The message ID and timestamp headers are assigned here, so
+// consumers see when the record was created rather than relayed, and the
+// ordering key set with broker.Key is kept for the relay.
+func (o *Outbox[T]) Record(topic string, m *T, opts ...broker.PublishOption) (*Record, error) {
+	var popts broker.PublishOptions
+	popts.Apply(opts...)
+	popts.Stamp()
+	body, err := codec.Marshal(o.codec, m, popts.Headers)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		ID:        popts.Headers[broker.HeaderMessageID],
+		Topic:     topic,
+		Key:       popts.Key,
+		Message:   &broker.Message{Header: popts.Headers, Body: body},
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// Publish encodes m and adds it to the store. It has the signature of
+// broker.Broker.Publish so an Outbox can stand in for a broker in code that
+// only publishes.
+func (o *Outbox[T]) Publish(ctx context.Context, topic string, m *T, opts ...broker.PublishOption) error {
+	r, err := o.Record(topic, m, opts...)
+	if err != nil {
+		return err
+	}
+	return o.store.Add(ctx, r)
+}
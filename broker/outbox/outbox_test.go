@@ -0,0 +1,208 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/memory"
+	"github.com/pthethanh/nano/broker/outbox"
+)
+
+type order struct {
+	ID string
+}
+
+type publishedMessage struct {
+	topic   string
+	msg     *order
+	headers map[string]string
+}
+
+// fakeBroker records published messages and fails the first fail publishes.
+type fakeBroker struct {
+	broker.Broker[order]
+	mu        sync.Mutex
+	fail      int
+	published []publishedMessage
+}
+
+func (b *fakeBroker) Publish(ctx context.Context, topic string, m *order, opts ...broker.PublishOption) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail > 0 {
+		b.fail--
+		return errors.New("broker unavailable")
+	}
+	var popts broker.PublishOptions
+	popts.Apply(opts...)
+	b.published = append(b.published, publishedMessage{topic: topic, msg: m, headers: popts.Headers})
+	return nil
+}
+
+func TestRelay_PublishesAndDeletesRecords(t *testing.T) {
+	store := outbox.NewMemoryStore()
+	ob := outbox.New[order](store)
+	if err := ob.Publish(context.Background(), "orders", &order{ID: "1"}, broker.Header("trace-id", "abc")); err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("got %d stored records, want 1", store.Len())
+	}
+
+	br := &fakeBroker{}
+	n, err := outbox.NewRelay[order](store, br).Flush(context.Background())
+	if err != nil || n != 1 {
+		t.Fatalf("Flush() = %d, %v, want 1, nil", n, err)
+	}
+	if store.Len() != 0 {
+		t.Errorf("got %d stored records after flush, want 0", store.Len())
+	}
+	if len(br.published) != 1 {
+		t.Fatalf("got %d published messages, want 1", len(br.published))
+	}
+	got := br.published[0]
+	if got.topic != "orders" || got.msg.ID != "1" {
+		t.Errorf("published %v to %q, want order 1 to orders", got.msg, got.topic)
+	}
+	if got.headers["trace-id"] != "abc" || got.headers[broker.HeaderMessageID] == "" {
+		t.Errorf("published headers = %v, want the original headers plus %s", got.headers, broker.HeaderMessageID)
+	}
+	// The broker's codec encodes the message again and sets its own type.
+	if ct, ok := got.headers[broker.HeaderContentType]; ok {
+		t.Errorf("published with %s %q, want it left to the broker", broker.HeaderContentType, ct)
+	}
+}
+
+func TestRelay_PublishesWithTheRecordedKey(t *testing.T) {
+	br := memory.New[order]()
+	if err := br.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer br.Close(context.Background())
+	keys := make(chan string, 1)
+	if _, err := br.Subscribe(context.Background(), "orders", func(e broker.Event[order]) error {
+		keys <- broker.KeyFrom(e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store := outbox.NewMemoryStore()
+	if err := outbox.New[order](store).Publish(context.Background(), "orders", &order{ID: "1"}, broker.Key("customer-7")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := outbox.NewRelay[order](store, br).Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case key := <-keys:
+		if key != "customer-7" {
+			t.Errorf("Key() = %q, want customer-7", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+}
+
+func TestRelay_RetriesFailedRecordsAfterBackoff(t *testing.T) {
+	store := outbox.NewMemoryStore()
+	ob := outbox.New[order](store)
+	rec, err := ob.Record("orders", &order{ID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(context.Background(), rec); err != nil {
+		t.Fatal(err)
+	}
+
+	br := &fakeBroker{fail: 1}
+	relay := outbox.NewRelay[order](store, br, outbox.Backoff[order](func(int) time.Duration { return time.Hour }))
+	if _, err := relay.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(br.published) != 0 || store.Len() != 1 {
+		t.Fatalf("got %d published and %d stored, want the failed record kept", len(br.published), store.Len())
+	}
+	if n, _ := relay.Flush(context.Background()); n != 0 {
+		t.Errorf("got %d records on the next flush, want the failed record deferred by the backoff", n)
+	}
+	pending, _ := store.Pending(context.Background(), time.Now().Add(2*time.Hour), 10)
+	if len(pending) != 1 || pending[0].Attempts != 1 {
+		t.Fatalf("got pending=%v, want the record due after the backoff with 1 attempt", pending)
+	}
+}
+
+func TestRelay_RunStopsWithContext(t *testing.T) {
+	store := outbox.NewMemoryStore()
+	ob := outbox.New[order](store)
+	br := &fakeBroker{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- outbox.NewRelay[order](store, br, outbox.Interval[order](time.Millisecond)).Run(ctx)
+	}()
+	if err := ob.Publish(context.Background(), "orders", &order{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for store.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("relay did not publish the record")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run() error = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not return after the context was cancelled")
+	}
+}
+
+func TestRelay_BuriesRecordsAfterMaxAttempts(t *testing.T) {
+	store := outbox.NewMemoryStore()
+	if err := outbox.New[order](store).Publish(context.Background(), "orders", &order{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	br := &fakeBroker{fail: 10}
+	relay := outbox.NewRelay[order](store, br,
+		outbox.MaxAttempts[order](2),
+		outbox.Backoff[order](func(int) time.Duration { return 0 }),
+	)
+	for range 3 {
+		if _, err := relay.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if br.fail != 8 {
+		t.Errorf("got %d publish attempts, want 2", 10-br.fail)
+	}
+	dead := store.Dead()
+	if len(dead) != 1 || dead[0].Attempts != 2 {
+		t.Fatalf("got dead=%v, want the record buried after 2 attempts", dead)
+	}
+	if pending, _ := store.Pending(context.Background(), time.Now().Add(time.Hour), 10); len(pending) != 0 {
+		t.Errorf("got pending=%v, want the buried record left out", pending)
+	}
+}
+
+func TestRelay_BuriesRecordsThatCannotBeDecoded(t *testing.T) {
+	store := outbox.NewMemoryStore()
+	rec := &outbox.Record{ID: "1", Topic: "orders", Message: &broker.Message{Body: []byte("not json")}, CreatedAt: time.Now()}
+	if err := store.Add(context.Background(), rec); err != nil {
+		t.Fatal(err)
+	}
+	br := &fakeBroker{}
+	if _, err := outbox.NewRelay[order](store, br).Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(br.published) != 0 || len(store.Dead()) != 1 {
+		t.Errorf("got %d published and %d dead, want the record buried without publishing", len(br.published), len(store.Dead()))
+	}
+}
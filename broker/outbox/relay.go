@@ -0,0 +1,124 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
+)
+
+// Relay publishes pending outbox records to a broker.
+//
+// Several relays may drain the same store, for example one per replica; a
+// record may then be published more than once, which consumers already
+// tolerate through broker.HeaderMessageID.
+type Relay[T any] struct {
+	store Store
+	br    broker.Broker[T]
+	opts  *options[T]
+}
+
+// NewRelay returns a Relay that publishes the records of store through b.
+func NewRelay[T any](store Store, b broker.Broker[T], opts ...Option[T]) *Relay[T] {
+	return &Relay[T]{
+		store: store,
+		br:    b,
+		opts:  newOptions(opts...),
+	}
+}
+
+// Run publishes pending records every polling interval until ctx is done.
+// It returns nil when ctx is cancelled.
+func (r *Relay[T]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.opts.interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := r.Flush(ctx)
+			if err != nil {
+				r.opts.log.Log(ctx, slog.LevelError, "outbox: flush failed", "error", err)
+			}
+			// A full batch likely means more records are waiting.
+			if err != nil || n < r.opts.batchSize || ctx.Err() != nil {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush makes a single pass over the records due now, publishing each one
+// and deleting it from the store once the broker accepted it. A record that
+// fails to publish is retried after the configured backoff, until its
+// attempts reach the configured maximum; it is then buried in the store. A
+// record that cannot be decoded is buried at once, as no retry can succeed.
+// Flush returns the number of records read from the store.
+func (r *Relay[T]) Flush(ctx context.Context) (int, error) {
+	records, err := r.store.Pending(ctx, time.Now(), r.opts.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, rec := range records {
+		if ctx.Err() != nil {
+			return len(records), nil
+		}
+		m, err := r.decode(rec)
+		if err != nil {
+			r.opts.log.Log(ctx, slog.LevelError, "outbox: decode failed, burying record", "id", rec.ID, "topic", rec.Topic, "error", err)
+			if err := r.store.Bury(ctx, rec.ID); err != nil {
+				return len(records), err
+			}
+			continue
+		}
+		if err := r.publish(ctx, rec, m); err != nil {
+			attempts := rec.Attempts + 1
+			if r.opts.maxAttempts > 0 && attempts >= r.opts.maxAttempts {
+				r.opts.log.Log(ctx, slog.LevelError, "outbox: publish failed, burying record", "id", rec.ID, "topic", rec.Topic, "attempts", attempts, "error", err)
+				if err := r.store.Bury(ctx, rec.ID); err != nil {
+					return len(records), err
+				}
+				continue
+			}
+			r.opts.log.Log(ctx, slog.LevelError, "outbox: publish failed", "id", rec.ID, "topic", rec.Topic, "attempts", attempts, "error", err)
+			next := time.Now().Add(r.opts.backoff(attempts))
+			if err := r.store.Retry(ctx, rec.ID, next); err != nil {
+				return len(records), err
+			}
+			continue
+		}
+		if err := r.store.Delete(ctx, rec.ID); err != nil {
+			return len(records), err
+		}
+	}
+	return len(records), nil
+}
+
+func (r *Relay[T]) decode(rec *Record) (*T, error) {
+	if rec.Message == nil {
+		return nil, errNoMessage
+	}
+	var m T
+	if err := codec.Unmarshal(r.opts.codec, rec.Message.GetHeader(), rec.Message.GetBody(), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *Relay[T]) publish(ctx context.Context, rec *Record, m *T) error {
+	// The broker encodes the message again with its own codec, which sets
+	// the content type.
+	headers := maps.Clone(rec.Message.GetHeader())
+	delete(headers, broker.HeaderContentType)
+	return r.br.Publish(ctx, rec.Topic, m,
+		broker.Headers(headers),
+		broker.Header(broker.HeaderMessageID, rec.ID),
+		broker.Key(rec.Key),
+	)
+}
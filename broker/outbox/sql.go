@@ -0,0 +1,197 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"google.golang.org/protobuf/proto"
+)
+
+type (
+	// SQLStore is a Store on top of database/sql. It uses only portable SQL
+	// and expects a table with the following columns, shown here for
+	// PostgreSQL (use BLOB or VARBINARY instead of BYTEA elsewhere):
+	//
+	//	CREATE TABLE outbox (
+	//		id           VARCHAR(64) PRIMARY KEY,
+	//		topic        VARCHAR(255) NOT NULL,
+	//		message      BYTEA NOT NULL,
+	//		attempts     INTEGER NOT NULL,
+	//		created_at   BIGINT NOT NULL,
+	//		available_at BIGINT NOT NULL,
+	//		dead         INTEGER NOT NULL DEFAULT 0
+	//	);
+	//	CREATE INDEX outbox_available_at ON outbox (dead, available_at);
+	//
+	// message holds the protobuf encoding of broker.Message, carrying the
+	// record's Key in the "outbox-key" header; created_at and available_at
+	// hold Unix nanoseconds; dead is 1 for buried records.
+	SQLStore struct {
+		db          *sql.DB
+		table       string
+		placeholder func(n int) string
+	}
+
+	// SQLOption is an optional configuration of a SQLStore.
+	SQLOption func(*SQLStore)
+
+	// Execer executes a statement. *sql.DB, *sql.Tx and *sql.Conn satisfy it.
+	Execer interface {
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	}
+)
+
+// keyHeader is the header SQLStore keeps Record.Key in, so that the key
+// needs no column of its own.
+const keyHeader = "outbox-key"
+
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore returns a SQLStore using db.
+func NewSQLStore(db *sql.DB, opts ...SQLOption) *SQLStore {
+	s := &SQLStore{
+		db:          db,
+		table:       "outbox",
+		placeholder: QuestionPlaceholder,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Table sets the name of the outbox table. Default is "outbox".
+func Table(name string) SQLOption {
+	return func(s *SQLStore) {
+		s.table = name
+	}
+}
+
+// Placeholder sets how the n-th (1-based) query parameter is written.
+// Default is QuestionPlaceholder; use DollarPlaceholder for PostgreSQL.
+func Placeholder(f func(n int) string) SQLOption {
+	return func(s *SQLStore) {
+		s.placeholder = f
+	}
+}
+
+// QuestionPlaceholder writes parameters as ?, as MySQL and SQLite expect.
+func QuestionPlaceholder(int) string {
+	return "?"
+}
+
+// DollarPlaceholder writes parameters as $1, $2, ..., as PostgreSQL expects.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Add implements Store.
+func (s *SQLStore) Add(ctx context.Context, records ...*Record) error {
+	return s.AddTx(ctx, s.db, records...)
+}
+
+// AddTx adds records through exec, typically the *sql.Tx that also carries
+// the caller's own writes, so the records are committed atomically with
+// them.
+func (s *SQLStore) AddTx(ctx context.Context, exec Execer, records ...*Record) error {
+	query := fmt.Sprintf("INSERT INTO %s (id, topic, message, attempts, created_at, available_at) VALUES (%s)", s.table, s.params(1, 6))
+	for _, r := range records {
+		msg, err := proto.Marshal(withKey(r.Message, r.Key))
+		if err != nil {
+			return err
+		}
+		createdAt := r.CreatedAt.UnixNano()
+		if _, err := exec.ExecContext(ctx, query, r.ID, r.Topic, msg, r.Attempts, createdAt, createdAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending implements Store.
+func (s *SQLStore) Pending(ctx context.Context, now time.Time, limit int) ([]*Record, error) {
+	query := fmt.Sprintf("SELECT id, topic, message, attempts, created_at FROM %s WHERE dead = 0 AND available_at <= %s ORDER BY created_at, id LIMIT %d", s.table, s.placeholder(1), limit)
+	rows, err := s.db.QueryContext(ctx, query, now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Record
+	for rows.Next() {
+		var (
+			r         Record
+			msg       []byte
+			createdAt int64
+		)
+		if err := rows.Scan(&r.ID, &r.Topic, &msg, &r.Attempts, &createdAt); err != nil {
+			return nil, err
+		}
+		r.Message = &broker.Message{}
+		if err := proto.Unmarshal(msg, r.Message); err != nil {
+			// Left to the relay to bury, see Store.Pending.
+			r.Message = nil
+		}
+		r.Key = r.Message.GetHeader()[keyHeader]
+		delete(r.Message.GetHeader(), keyHeader)
+		r.CreatedAt = time.Unix(0, createdAt)
+		out = append(out, &r)
+	}
+	return out, rows.Err()
+}
+
+// Delete implements Store.
+func (s *SQLStore) Delete(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", s.table, s.params(1, len(ids)))
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Retry implements Store.
+func (s *SQLStore) Retry(ctx context.Context, id string, next time.Time) error {
+	query := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, available_at = %s WHERE id = %s", s.table, s.placeholder(1), s.placeholder(2))
+	_, err := s.db.ExecContext(ctx, query, next.UnixNano(), id)
+	return err
+}
+
+// Bury implements Store.
+func (s *SQLStore) Bury(ctx context.Context, id string) error {
+	query := fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, dead = 1 WHERE id = %s", s.table, s.placeholder(1))
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// withKey returns msg with key in its keyHeader header, leaving msg itself
+// untouched.
+func withKey(msg *broker.Message, key string) *broker.Message {
+	if key == "" {
+		return msg
+	}
+	header := maps.Clone(msg.GetHeader())
+	if header == nil {
+		header = make(map[string]string, 1)
+	}
+	header[keyHeader] = key
+	return &broker.Message{Header: header, Body: msg.GetBody()}
+}
+
+// params returns n comma-separated placeholders numbered from first.
+func (s *SQLStore) params(first, n int) string {
+	p := make([]string, n)
+	for i := range p {
+		p[i] = s.placeholder(first + i)
+	}
+	return strings.Join(p, ", ")
+}
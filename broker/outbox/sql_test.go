@@ -0,0 +1,165 @@
+package outbox_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/outbox"
+)
+
+// recordingDriver records the statements it executes and answers every query
+// with rows.
+type recordingDriver struct {
+	mu    sync.Mutex
+	execs []string
+	args  [][]driver.Value
+	rows  [][]driver.Value
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) { return &recordingConn{d: d}, nil }
+func (d *recordingDriver) Connect(context.Context) (driver.Conn, error) {
+	return &recordingConn{d: d}, nil
+}
+func (d *recordingDriver) Driver() driver.Driver { return d }
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{d: c.d, query: query}, nil
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *recordingConn) Commit() error             { return nil }
+func (c *recordingConn) Rollback() error           { return nil }
+
+type recordingStmt struct {
+	d     *recordingDriver
+	query string
+}
+
+func (s *recordingStmt) Close() error  { return nil }
+func (s *recordingStmt) NumInput() int { return -1 }
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.execs = append(s.d.execs, s.query)
+	s.d.args = append(s.d.args, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.execs = append(s.d.execs, s.query)
+	s.d.args = append(s.d.args, args)
+	return &recordingRows{rows: s.d.rows}, nil
+}
+
+type recordingRows struct{ rows [][]driver.Value }
+
+func (r *recordingRows) Columns() []string {
+	return []string{"id", "topic", "message", "attempts", "created_at"}
+}
+func (r *recordingRows) Close() error { return nil }
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLStore(t *testing.T) {
+	d := &recordingDriver{}
+	db := sql.OpenDB(d)
+	defer db.Close()
+	store := outbox.NewSQLStore(db, outbox.Table("events_outbox"), outbox.Placeholder(outbox.DollarPlaceholder))
+	ctx := context.Background()
+
+	// Records added in a transaction round-trip through Pending.
+	rec, err := outbox.New[order](store).Record("orders", &order{ID: "1"}, broker.Key("customer-7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddTx(ctx, tx, rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	d.rows = [][]driver.Value{d.args[0][:5]}
+	pending, err := store.Pending(ctx, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != rec.ID || pending[0].Topic != "orders" || string(pending[0].Message.GetBody()) != string(rec.Message.GetBody()) || !pending[0].CreatedAt.Equal(rec.CreatedAt) {
+		t.Fatalf("Pending() = %v, want the added record", pending)
+	}
+	if pending[0].Key != "customer-7" || len(pending[0].Message.GetHeader()) != len(rec.Message.GetHeader()) {
+		t.Errorf("Pending() = key %q with headers %v, want key customer-7 with headers %v", pending[0].Key, pending[0].Message.GetHeader(), rec.Message.GetHeader())
+	}
+	if err := store.Retry(ctx, rec.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Bury(ctx, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"INSERT INTO events_outbox (id, topic, message, attempts, created_at, available_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		"SELECT id, topic, message, attempts, created_at FROM events_outbox WHERE dead = 0 AND available_at <= $1 ORDER BY created_at, id LIMIT 10",
+		"UPDATE events_outbox SET attempts = attempts + 1, available_at = $1 WHERE id = $2",
+		"UPDATE events_outbox SET attempts = attempts + 1, dead = 1 WHERE id = $1",
+		"DELETE FROM events_outbox WHERE id IN ($1, $2)",
+	}
+	if got := strings.Join(d.execs, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("got statements:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestSQLStore_PendingLeavesUndecodableRowsToTheRelay(t *testing.T) {
+	d := &recordingDriver{}
+	db := sql.OpenDB(d)
+	defer db.Close()
+	store := outbox.NewSQLStore(db)
+	ctx := context.Background()
+
+	rec, err := outbox.New[order](store).Record("orders", &order{ID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	d.rows = [][]driver.Value{
+		{"bad", "orders", []byte{0xff}, int64(0), int64(0)},
+		d.args[0][:5],
+	}
+	d.execs, d.args = nil, nil
+	br := &fakeBroker{}
+	if n, err := outbox.NewRelay[order](store, br).Flush(ctx); err != nil || n != 2 {
+		t.Fatalf("Flush() = %d, %v, want 2, nil", n, err)
+	}
+	if len(br.published) != 1 || br.published[0].msg.ID != "1" {
+		t.Errorf("published %v, want order 1 only", br.published)
+	}
+	if len(d.args) != 3 || d.args[1][0] != "bad" || d.args[2][0] != rec.ID {
+		t.Errorf("got statements %q with args %v, want the undecodable row buried and the other deleted", d.execs, d.args)
+	}
+}
@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Headers added to a message that is republished to a dead-letter topic.
+const (
+	// HeaderDeadLetterTopic is the topic the message was originally consumed from.
+	HeaderDeadLetterTopic = "x-dead-letter-topic"
+	// HeaderDeadLetterReason is the error returned by the last handler attempt.
+	HeaderDeadLetterReason = "x-dead-letter-reason"
+	// HeaderDeadLetterAttempts is the number of handler attempts made.
+	HeaderDeadLetterAttempts = "x-dead-letter-attempts"
+)
+
+// ErrDeadLettered is returned by Handle and HandleBatch once the message was
+// republished to the dead-letter topic: it is handled for good, and must be
+// acknowledged even with auto-ack disabled, or its redelivery would start
+// the attempts over and dead-letter it again.
+var ErrDeadLettered = errors.New("broker: message dead-lettered")
+
+// Handle delivers e to h honoring the redelivery policy in opts, and is
+// meant to be called by Broker implementations for every received message so
+// that all of them retry and dead-letter the same way.
+//
+// h is invoked up to opts.MaxAttempts times, waiting opts.Backoff(attempt)
+// between failed attempts. If every attempt fails and opts.DeadLetter is set,
+// the message is republished to that topic through publish with its headers
+// plus the HeaderDeadLetter* headers, less HeaderContentType, and Handle
+// returns ErrDeadLettered, or the error of that publish.
+// Otherwise Handle returns the last handler error.
+//
+// Retries are handed to h wrapped so that Event.Attempt reports the attempt.
+//
+// Events carrying a decoding error (e.Error() != nil) are handed to h exactly
+// once and never dead-lettered, since there is no message to republish.
+//
+// ctx bounds the waits between attempts; if it is done, Handle stops retrying
+// and returns the last handler error without dead-lettering, leaving the
+// message to the broker's own redelivery, if any.
+func Handle[T any](ctx context.Context, e Event[T], h func(Event[T]) error, opts *SubscribeOptions, publish PublishFunc[T]) error {
+	if e.Error() != nil {
+		return h(e)
+	}
+	attempts := max(opts.MaxAttempts, 1)
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		ev := e
+		if attempt > 1 {
+			ev = &retryEvent[T]{Event: e, attempt: e.Attempt() + attempt - 1}
+		}
+		if err = h(ev); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+		if opts.Backoff != nil {
+			if sleep(ctx, opts.Backoff(attempt)) != nil {
+				return err
+			}
+		}
+	}
+	if opts.DeadLetter == "" || publish == nil {
+		return err
+	}
+	if err := deadLetter(ctx, e, err, attempt, opts.DeadLetter, publish); err != nil {
+		return err
+	}
+	return ErrDeadLettered
+}
+
+// deadLetter republishes the message of e to topic with the HeaderDeadLetter*
+// headers describing its failure.
+func deadLetter[T any](ctx context.Context, e Event[T], err error, attempts int, topic string, publish PublishFunc[T]) error {
+	headers := e.Headers()
+	dlq := make(map[string]string, len(headers)+3)
+	for k, v := range headers {
+		dlq[k] = v
+	}
+	dlq[HeaderDeadLetterTopic] = e.Topic()
+	dlq[HeaderDeadLetterReason] = err.Error()
+	dlq[HeaderDeadLetterAttempts] = strconv.Itoa(attempts)
+	// publish encodes the message again and sets its own content type.
+	delete(dlq, HeaderContentType)
+	return publish(ctx, topic, e.Message(), Headers(dlq))
+}
+
+// retryEvent is an Event redelivered in-process by Handle.
+type retryEvent[T any] struct {
+	Event[T]
+	attempt int
+}
+
+func (e *retryEvent[T]) Attempt() int {
+	return e.attempt
+}
+
+func (e *retryEvent[T]) Unwrap() Event[T] {
+	return e.Event
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+type testEvent struct {
+	topic   string
+	msg     *string
+	headers map[string]string
+	err     error
+}
+
+func (e *testEvent) Topic() string              { return e.topic }
+func (e *testEvent) Message() *string           { return e.msg }
+func (e *testEvent) Headers() map[string]string { return e.headers }
+func (e *testEvent) ID() string                 { return e.headers[broker.HeaderMessageID] }
+func (e *testEvent) Timestamp() time.Time       { return time.Time{} }
+func (e *testEvent) Attempt() int               { return 1 }
+func (e *testEvent) Nack(time.Duration) error   { return nil }
+func (e *testEvent) Ack() error                 { return nil }
+func (e *testEvent) Error() error               { return e.err }
+func (e *testEvent) Reason() broker.Reason      { return broker.ReasonUnmarshalFailure }
+
+type published struct {
+	topic   string
+	msg     *string
+	headers map[string]string
+}
+
+func capture(out *[]published) broker.PublishFunc[string] {
+	return func(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+		var popts broker.PublishOptions
+		popts.Apply(opts...)
+		*out = append(*out, published{topic: topic, msg: m, headers: popts.Headers})
+		return nil
+	}
+}
+
+func TestHandle_RetriesThenDeadLetters(t *testing.T) {
+	msg := "poison"
+	var calls int
+	var dlq []published
+	opts := &broker.SubscribeOptions{}
+	opts.Apply(broker.MaxAttempts(3), broker.DeadLetter("orders.dlq"))
+
+	err := broker.Handle(context.Background(), &testEvent{topic: "orders", msg: &msg, headers: map[string]string{"trace-id": "abc"}}, func(e broker.Event[string]) error {
+		calls++
+		if e.Attempt() != calls {
+			t.Errorf("got attempt %d on call %d", e.Attempt(), calls)
+		}
+		return errors.New("boom")
+	}, opts, capture(&dlq))
+	if !errors.Is(err, broker.ErrDeadLettered) {
+		t.Fatalf("Handle() error = %v, want %v", err, broker.ErrDeadLettered)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+	if len(dlq) != 1 {
+		t.Fatalf("got %d dead-lettered messages, want 1", len(dlq))
+	}
+	got := dlq[0]
+	if got.topic != "orders.dlq" || got.msg != &msg {
+		t.Errorf("dead-lettered to %q with %v, want orders.dlq with the original message", got.topic, got.msg)
+	}
+	want := map[string]string{
+		"trace-id":                      "abc",
+		broker.HeaderDeadLetterTopic:    "orders",
+		broker.HeaderDeadLetterReason:   "boom",
+		broker.HeaderDeadLetterAttempts: "3",
+	}
+	for k, v := range want {
+		if got.headers[k] != v {
+			t.Errorf("header %s = %q, want %q", k, got.headers[k], v)
+		}
+	}
+}
+
+func TestHandle_DeadLetterLeavesContentTypeToPublish(t *testing.T) {
+	msg := "poison"
+	var dlq []published
+	opts := &broker.SubscribeOptions{}
+	opts.Apply(broker.DeadLetter("orders.dlq"))
+
+	headers := map[string]string{broker.HeaderContentType: "application/json"}
+	if err := broker.Handle(context.Background(), &testEvent{topic: "orders", msg: &msg, headers: headers}, func(e broker.Event[string]) error {
+		return errors.New("boom")
+	}, opts, capture(&dlq)); !errors.Is(err, broker.ErrDeadLettered) {
+		t.Fatalf("Handle() error = %v, want %v", err, broker.ErrDeadLettered)
+	}
+	if _, ok := dlq[0].headers[broker.HeaderContentType]; ok {
+		t.Errorf("dead-lettered with header %s, want it left to the publishing codec", broker.HeaderContentType)
+	}
+}
+
+func TestHandle_StopsRetryingOnSuccess(t *testing.T) {
+	msg := "ok"
+	var calls int
+	var backoffs []int
+	var dlq []published
+	opts := &broker.SubscribeOptions{}
+	opts.Apply(
+		broker.MaxAttempts(5),
+		broker.Backoff(func(attempt int) time.Duration {
+			backoffs = append(backoffs, attempt)
+			return time.Millisecond
+		}),
+		broker.DeadLetter("dlq"),
+	)
+
+	err := broker.Handle(context.Background(), &testEvent{topic: "t", msg: &msg}, func(broker.Event[string]) error {
+		calls++
+		if calls < 2 {
+			return errors.New("transient")
+		}
+		return nil
+	}, opts, capture(&dlq))
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if calls != 2 || len(dlq) != 0 {
+		t.Errorf("got %d calls and %d dead-lettered, want 2 and 0", calls, len(dlq))
+	}
+	if len(backoffs) != 1 || backoffs[0] != 1 {
+		t.Errorf("backoff called with %v, want [1]", backoffs)
+	}
+}
+
+func TestHandle_ReturnsErrorWithoutDeadLetter(t *testing.T) {
+	msg := "m"
+	wantErr := errors.New("boom")
+	opts := &broker.SubscribeOptions{}
+
+	err := broker.Handle(context.Background(), &testEvent{topic: "t", msg: &msg}, func(broker.Event[string]) error {
+		return wantErr
+	}, opts, nil)
+	if err != wantErr {
+		t.Errorf("Handle() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestHandle_DecodeFailureIsDeliveredOnceAndNotDeadLettered(t *testing.T) {
+	var calls int
+	var dlq []published
+	opts := &broker.SubscribeOptions{}
+	opts.Apply(broker.MaxAttempts(3), broker.DeadLetter("dlq"))
+
+	_ = broker.Handle(context.Background(), &testEvent{topic: "t", err: errors.New("bad payload")}, func(broker.Event[string]) error {
+		calls++
+		return errors.New("cannot handle")
+	}, opts, capture(&dlq))
+	if calls != 1 || len(dlq) != 0 {
+		t.Errorf("got %d calls and %d dead-lettered, want 1 and 0", calls, len(dlq))
+	}
+}
+
+func TestHandle_CancelledContextStopsRetrying(t *testing.T) {
+	msg := "m"
+	var calls int
+	var dlq []published
+	opts := &broker.SubscribeOptions{}
+	opts.Apply(
+		broker.MaxAttempts(3),
+		broker.Backoff(func(int) time.Duration { return time.Hour }),
+		broker.DeadLetter("dlq"),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := broker.Handle(ctx, &testEvent{topic: "t", msg: &msg}, func(broker.Event[string]) error {
+		calls++
+		return errors.New("boom")
+	}, opts, capture(&dlq))
+	if err == nil || calls != 1 || len(dlq) != 0 {
+		t.Errorf("got err=%v, %d calls, %d dead-lettered; want an error, 1 call, 0 dead-lettered", err, calls, len(dlq))
+	}
+}
@@ -0,0 +1,50 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type (
+	clientOptions struct {
+		replyTopic    string
+		replyTopicSet bool
+		timeout       time.Duration
+	}
+
+	// ClientOption is an optional configuration of a Client.
+	ClientOption func(*clientOptions)
+)
+
+func newClientOptions(opts ...ClientOption) *clientOptions {
+	o := &clientOptions{
+		replyTopic: "_reply." + uuid.New().String(),
+		timeout:    5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// ReplyTopic sets the topic the client receives replies on. It must be unique
+// to the client. Default is a random topic, which suits brokers that create
+// topics on demand; set it for brokers, such as Kafka, where topics are
+// provisioned up front. Setting it also disables native request/reply.
+func ReplyTopic(topic string) ClientOption {
+	return func(o *clientOptions) {
+		o.replyTopic = topic
+		o.replyTopicSet = true
+	}
+}
+
+// Timeout sets how long Request waits for a reply when its context has no
+// deadline. Default is 5 seconds.
+func Timeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		if d > 0 {
+			o.timeout = d
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Package rpc implements request/reply on top of broker.Broker.
+//
+// A Client publishes a request with the broker.HeaderCorrelationID and
+// broker.HeaderReplyTo headers and waits for the matching reply on its own
+// reply topic; Reply subscribes a handler to a topic and publishes its
+// result to the reply topic of each request. Brokers that offer native
+// request/reply, such as NATS, are used directly when requests and replies
+// share the message type.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pthethanh/nano/broker"
+)
+
+type (
+	// Client sends requests of type Req and waits for replies of type Resp.
+	Client[Req, Resp any] struct {
+		req        broker.Broker[Req]
+		native     requester[Req, Resp]
+		sub        broker.Subscriber
+		opts       *clientOptions
+		replyTopic string
+
+		mu      sync.Mutex
+		pending map[string]chan reply[Resp]
+	}
+
+	// RemoteError is returned by Client.Request when the handler serving the
+	// request failed. Message is the handler's error message.
+	RemoteError struct {
+		Message string
+	}
+
+	// requester is implemented by brokers with native request/reply.
+	requester[Req, Resp any] interface {
+		Request(ctx context.Context, topic string, m *Req, opts ...broker.PublishOption) (*Resp, map[string]string, error)
+	}
+
+	reply[Resp any] struct {
+		m       *Resp
+		headers map[string]string
+		err     error
+	}
+)
+
+// HeaderError carries the error message of a failed handler on a reply.
+const HeaderError = "rpc-error"
+
+var (
+	// ErrNoReplyTo is returned by a Reply handler for a request without a
+	// reply topic, making it subject to the subscription's redelivery
+	// policy.
+	ErrNoReplyTo = errors.New("rpc: request has no reply topic")
+)
+
+// NewClient returns a Client that publishes requests through req and, unless
+// req offers native request/reply, subscribes to its reply topic on resp. The
+// subscription lasts until Close.
+func NewClient[Req, Resp any](ctx context.Context, req broker.Broker[Req], resp broker.Broker[Resp], opts ...ClientOption) (*Client[Req, Resp], error) {
+	c := &Client[Req, Resp]{
+		req:     req,
+		opts:    newClientOptions(opts...),
+		pending: make(map[string]chan reply[Resp]),
+	}
+	if native, ok := any(req).(requester[Req, Resp]); ok && !c.opts.replyTopicSet {
+		c.native = native
+		return c, nil
+	}
+	c.replyTopic = c.opts.replyTopic
+	sub, err := resp.Subscribe(ctx, c.replyTopic, c.receive)
+	if err != nil {
+		return nil, err
+	}
+	c.sub = sub
+	return c, nil
+}
+
+// Request publishes m to topic and waits for its reply until ctx is done.
+// Without a deadline on ctx, the client's Timeout applies. A failure of the
+// remote handler is returned as a *RemoteError.
+func (c *Client[Req, Resp]) Request(ctx context.Context, topic string, m *Req, opts ...broker.PublishOption) (*Resp, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
+		defer cancel()
+	}
+	if c.native != nil {
+		resp, headers, err := c.native.Request(ctx, topic, m, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return replyFrom(resp, headers)
+	}
+
+	id := uuid.New().String()
+	ch := make(chan reply[Resp], 1)
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	opts = append(opts[:len(opts):len(opts)],
+		broker.Header(broker.HeaderCorrelationID, id),
+		broker.Header(broker.HeaderReplyTo, c.replyTopic),
+	)
+	if err := c.req.Publish(ctx, topic, m, opts...); err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return replyFrom(r.m, r.headers)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close unsubscribes from the reply topic. Pending requests wait until their
+// context is done.
+func (c *Client[Req, Resp]) Close() error {
+	if c.sub == nil {
+		return nil
+	}
+	return c.sub.Unsubscribe()
+}
+
+// receive routes a reply to the request waiting for it. Replies to requests
+// that already gave up are dropped.
+func (c *Client[Req, Resp]) receive(e broker.Event[Resp]) error {
+	headers := e.Headers()
+	c.mu.Lock()
+	ch, ok := c.pending[headers[broker.HeaderCorrelationID]]
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	select {
+	case ch <- reply[Resp]{m: e.Message(), headers: headers, err: e.Error()}:
+	default:
+	}
+	return nil
+}
+
+// Reply subscribes h to requests published to topic on req and publishes
+// each result to the request's reply topic on resp. An error returned by h
+// is sent back to the requester instead of a reply; it does not trigger the
+// redelivery policy set by opts.
+func Reply[Req, Resp any](ctx context.Context, req broker.Broker[Req], resp broker.Broker[Resp], topic string, h func(context.Context, *Req) (*Resp, error), opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	// The subscription may outlive ctx, which is often request-scoped.
+	hctx := context.WithoutCancel(ctx)
+	return req.Subscribe(ctx, topic, func(e broker.Event[Req]) error {
+		headers := e.Headers()
+		replyTo := headers[broker.HeaderReplyTo]
+		if replyTo == "" {
+			return ErrNoReplyTo
+		}
+		var popts []broker.PublishOption
+		if id := headers[broker.HeaderCorrelationID]; id != "" {
+			popts = append(popts, broker.Header(broker.HeaderCorrelationID, id))
+		}
+		var m *Resp
+		err := e.Error()
+		if err == nil {
+			m, err = h(hctx, e.Message())
+		}
+		if err != nil {
+			m = nil
+			popts = append(popts, broker.Header(HeaderError, err.Error()))
+		}
+		if m == nil {
+			m = new(Resp)
+		}
+		return resp.Publish(hctx, replyTo, m, popts...)
+	}, opts...)
+}
+
+func (e *RemoteError) Error() string {
+	return "rpc: remote error: " + e.Message
+}
+
+func replyFrom[Resp any](m *Resp, headers map[string]string) (*Resp, error) {
+	if msg, ok := headers[HeaderError]; ok {
+		return nil, &RemoteError{Message: msg}
+	}
+	return m, nil
+}
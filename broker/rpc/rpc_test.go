@@ -0,0 +1,168 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/memory"
+	"github.com/pthethanh/nano/broker/rpc"
+)
+
+type (
+	sumRequest struct {
+		A, B int
+	}
+
+	sumResponse struct {
+		Sum int
+	}
+)
+
+func open[T any](t *testing.T) *memory.Broker[T] {
+	t.Helper()
+	b := memory.New[T]()
+	if err := b.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close(context.Background()) })
+	return b
+}
+
+func sum(_ context.Context, req *sumRequest) (*sumResponse, error) {
+	if req.A < 0 {
+		return nil, errors.New("negative input")
+	}
+	return &sumResponse{Sum: req.A + req.B}, nil
+}
+
+func TestRequestReply(t *testing.T) {
+	reqs, resps := open[sumRequest](t), open[sumResponse](t)
+	if _, err := rpc.Reply(context.Background(), reqs, resps, "sum", sum); err != nil {
+		t.Fatal(err)
+	}
+	client, err := rpc.NewClient(context.Background(), reqs, resps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			resp, err := client.Request(context.Background(), "sum", &sumRequest{A: i, B: i})
+			if err != nil {
+				t.Errorf("Request(%d) error = %v", i, err)
+				return
+			}
+			if resp.Sum != 2*i {
+				t.Errorf("Request(%d) = %d, want %d", i, resp.Sum, 2*i)
+			}
+		})
+	}
+	wg.Wait()
+
+	_, err = client.Request(context.Background(), "sum", &sumRequest{A: -1})
+	var remote *rpc.RemoteError
+	if !errors.As(err, &remote) || remote.Message != "negative input" {
+		t.Errorf("Request() error = %v, want a RemoteError with the handler message", err)
+	}
+}
+
+func TestRequest_Timeout(t *testing.T) {
+	reqs, resps := open[sumRequest](t), open[sumResponse](t)
+	client, err := rpc.NewClient(context.Background(), reqs, resps, rpc.Timeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Request(context.Background(), "nobody", &sumRequest{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Request() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestReply_WithoutReplyTopic(t *testing.T) {
+	reqs, resps := open[sumRequest](t), open[sumResponse](t)
+	dead := make(chan string, 1)
+	if _, err := rpc.Reply(context.Background(), reqs, resps, "sum", sum, broker.DeadLetter("sum.dlq")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reqs.Subscribe(context.Background(), "sum.dlq", func(e broker.Event[sumRequest]) error {
+		dead <- fmt.Sprint(e.Message().A)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reqs.Publish(context.Background(), "sum", &sumRequest{A: 1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-dead:
+		if got != "1" {
+			t.Errorf("dead-lettered %s, want 1", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request without a reply topic was not dead-lettered")
+	}
+}
+
+// nativeBroker offers native request/reply by calling a handler directly.
+type nativeBroker struct {
+	broker.Broker[string]
+	calls int
+}
+
+func (b *nativeBroker) Request(_ context.Context, topic string, m *string, _ ...broker.PublishOption) (*string, map[string]string, error) {
+	b.calls++
+	if *m == "fail" {
+		return nil, map[string]string{rpc.HeaderError: "failed"}, nil
+	}
+	r := topic + ":" + *m
+	return &r, nil, nil
+}
+
+func TestRequest_NativeFastPath(t *testing.T) {
+	b := &nativeBroker{Broker: open[string](t)}
+	client, err := rpc.NewClient[string, string](context.Background(), b, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	m := "ping"
+	got, err := client.Request(context.Background(), "echo", &m)
+	if err != nil || *got != "echo:ping" {
+		t.Fatalf("Request() = %v, %v, want echo:ping", got, err)
+	}
+	fail := "fail"
+	var remote *rpc.RemoteError
+	if _, err := client.Request(context.Background(), "echo", &fail); !errors.As(err, &remote) {
+		t.Errorf("Request() error = %v, want a RemoteError", err)
+	}
+	if b.calls != 2 {
+		t.Errorf("native Request called %d times, want 2", b.calls)
+	}
+
+	// An explicit reply topic opts out of the native path.
+	if _, err := rpc.Reply(context.Background(), b.Broker, b.Broker, "echo", func(_ context.Context, m *string) (*string, error) {
+		r := strconv.Quote(*m)
+		return &r, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	client, err = rpc.NewClient[string, string](context.Background(), b, b, rpc.ReplyTopic("echo.replies"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if got, err := client.Request(context.Background(), "echo", &m); err != nil || *got != `"ping"` {
+		t.Errorf("Request() = %v, %v, want the reply of the subscribed handler", got, err)
+	}
+	if b.calls != 2 {
+		t.Errorf("native Request called %d times, want 2", b.calls)
+	}
+}
@@ -0,0 +1,243 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultScheduleTick is the resolution of a Scheduler created with a tick of
+// zero or below.
+const DefaultScheduleTick = 10 * time.Millisecond
+
+// wheelSlots is the number of slots of the timer wheel of a Scheduler.
+const wheelSlots = 512
+
+// duePublishTimeout bounds the publish of a due message by a Scheduler.
+const duePublishTimeout = 10 * time.Second
+
+var (
+	// ErrDelayUnsupported is returned by Publish of brokers that cannot delay
+	// the delivery of messages, see DeliverAt.
+	ErrDelayUnsupported = fmt.Errorf("broker: delayed delivery: %w", errors.ErrUnsupported)
+
+	// ErrNotScheduled is returned by Cancel for an ID that matches no message
+	// waiting for its delivery time.
+	ErrNotScheduled = errors.New("broker: message is not scheduled")
+
+	// ErrSchedulerClosed is returned when scheduling a message after the
+	// Scheduler is closed.
+	ErrSchedulerClosed = errors.New("broker: scheduler closed")
+)
+
+type (
+	// Canceler is implemented by brokers that can cancel the delivery of a
+	// delayed message. Use Cancel to cancel through any Broker.
+	Canceler interface {
+		// Cancel cancels the delivery of the delayed message with the given
+		// HeaderMessageID, returning ErrNotScheduled if no such message waits
+		// for its delivery time.
+		Cancel(ctx context.Context, id string) error
+	}
+
+	// Scheduler holds delayed messages in process and publishes them at their
+	// DeliverAt time, with the resolution of its tick. Messages are kept in a
+	// hashed timer wheel that is only turned while some are pending. It helps
+	// Broker implementations without native delayed delivery support
+	// DeliverAt and implement Canceler; pending messages do not survive a
+	// restart. Due messages are published in due order by a goroutine of
+	// their own, each within 10 seconds, so a slow publish delays the
+	// following messages but not the wheel.
+	Scheduler[T any] struct {
+		publish PublishFunc[T]
+		tick    time.Duration
+		ctx     context.Context
+		cancel  context.CancelFunc
+
+		mu         sync.Mutex
+		slots      [wheelSlots][]*scheduled[T]
+		pos        int
+		last       time.Time // time of the last tick
+		pending    map[string]*scheduled[T]
+		due        []*scheduled[T] // waiting to be published
+		running    bool
+		publishing bool
+		closed     bool
+	}
+
+	scheduled[T any] struct {
+		id       string
+		topic    string
+		m        *T
+		opts     []PublishOption
+		rounds   int
+		canceled bool
+	}
+)
+
+// Cancel cancels the delayed message with the given id through b if it
+// implements Canceler, and returns errors.ErrUnsupported otherwise.
+func Cancel[T any](ctx context.Context, b Broker[T], id string) error {
+	if c, ok := b.(Canceler); ok {
+		return c.Cancel(ctx, id)
+	}
+	return errors.ErrUnsupported
+}
+
+// NewScheduler returns a Scheduler publishing the messages through publish
+// when they are due, with a resolution of tick.
+func NewScheduler[T any](publish PublishFunc[T], tick time.Duration) *Scheduler[T] {
+	if tick <= 0 {
+		tick = DefaultScheduleTick
+	}
+	s := &Scheduler[T]{
+		publish: publish,
+		tick:    tick,
+		pending: make(map[string]*scheduled[T]),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Publish publishes m to topic through the publish function of the
+// Scheduler, right away unless the options delay it. A delayed message
+// replaces the pending one with the same HeaderMessageID, if any; errors of
+// the publish function when it is due are dropped.
+func (s *Scheduler[T]) Publish(ctx context.Context, topic string, m *T, opts ...PublishOption) error {
+	var popts PublishOptions
+	popts.Apply(opts...)
+	if !popts.Delayed() {
+		return s.publish(ctx, topic, m, opts...)
+	}
+	popts.Stamp()
+	id := popts.Headers[HeaderMessageID]
+	e := &scheduled[T]{
+		id:    id,
+		topic: topic,
+		m:     m,
+		// Keep the ID for consumers and do not delay the message again.
+		opts: append(opts[:len(opts):len(opts)], MessageID(id), DeliverAt(time.Time{})),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSchedulerClosed
+	}
+	if !s.running {
+		s.running = true
+		s.last = time.Now()
+		go s.run()
+	}
+	ticks := max(int((popts.DeliverAt.Sub(s.last)+s.tick-1)/s.tick), 1)
+	e.rounds = (ticks - 1) / wheelSlots
+	slot := (s.pos + ticks) % wheelSlots
+	s.slots[slot] = append(s.slots[slot], e)
+	if old := s.pending[id]; old != nil {
+		old.canceled = true
+	}
+	s.pending[id] = e
+	return nil
+}
+
+// Cancel implements Canceler.
+func (s *Scheduler[T]) Cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.pending[id]
+	if e == nil {
+		return ErrNotScheduled
+	}
+	e.canceled = true
+	delete(s.pending, id)
+	return nil
+}
+
+// Len returns the number of messages waiting for their delivery time.
+func (s *Scheduler[T]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// Close drops the pending messages and stops the Scheduler.
+func (s *Scheduler[T]) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.slots = [wheelSlots][]*scheduled[T]{}
+	clear(s.pending)
+	s.due = nil
+	s.cancel()
+}
+
+func (s *Scheduler[T]) run() {
+	t := time.NewTicker(s.tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if !s.advance() {
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// advance turns the wheel by the ticks elapsed since the last one, which
+// may be more than one when the ticker fell behind, and hands the messages
+// that are due to the publishing goroutine. It reports whether other
+// messages are pending.
+func (s *Scheduler[T]) advance() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range int(time.Since(s.last) / s.tick) {
+		s.pos = (s.pos + 1) % wheelSlots
+		s.last = s.last.Add(s.tick)
+		slot := s.slots[s.pos][:0]
+		for _, e := range s.slots[s.pos] {
+			switch {
+			case e.canceled:
+			case e.rounds > 0:
+				e.rounds--
+				slot = append(slot, e)
+			default:
+				s.due = append(s.due, e)
+				delete(s.pending, e.id)
+			}
+		}
+		s.slots[s.pos] = slot
+	}
+	if len(s.due) > 0 && !s.publishing {
+		s.publishing = true
+		go s.publishDue()
+	}
+	if len(s.pending) == 0 {
+		s.running = false
+		return false
+	}
+	return true
+}
+
+// publishDue publishes the due messages in order until none is left.
+func (s *Scheduler[T]) publishDue() {
+	for {
+		s.mu.Lock()
+		if len(s.due) == 0 || s.ctx.Err() != nil {
+			s.publishing = false
+			s.mu.Unlock()
+			return
+		}
+		e := s.due[0]
+		s.due[0] = nil
+		s.due = s.due[1:]
+		s.mu.Unlock()
+		ctx, cancel := context.WithTimeout(s.ctx, duePublishTimeout)
+		_ = s.publish(ctx, e.topic, e.m, e.opts...)
+		cancel()
+	}
+}
@@ -0,0 +1,121 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+func TestScheduler(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var out []published
+		s := broker.NewScheduler(capture(&out), time.Second)
+		defer s.Close()
+		now, soon, later, replaced := "now", "soon", "later", "replaced"
+
+		if err := s.Publish(context.Background(), "orders", &now); err != nil || len(out) != 1 {
+			t.Fatalf("Publish() error = %v, published %d, want an undelayed message published right away", err, len(out))
+		}
+		// Past a full turn of the wheel.
+		if err := s.Publish(context.Background(), "orders", &later, broker.Delay(time.Hour), broker.MessageID("later")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Publish(context.Background(), "orders", &replaced, broker.Delay(2*time.Second), broker.MessageID("soon")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Publish(context.Background(), "orders", &soon, broker.DeliverAt(time.Now().Add(3*time.Second)), broker.MessageID("soon")); err != nil {
+			t.Fatal(err)
+		}
+		if s.Len() != 2 {
+			t.Fatalf("got %d pending messages, want 2 once replaced", s.Len())
+		}
+
+		time.Sleep(3 * time.Second)
+		synctest.Wait()
+		if len(out) != 2 || out[1].msg != &soon || out[1].headers[broker.HeaderMessageID] != "soon" {
+			t.Fatalf("published %+v after 3s, want the replacing message with its ID", out)
+		}
+		if err := s.Cancel("later"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Cancel("later"); !errors.Is(err, broker.ErrNotScheduled) {
+			t.Fatalf("Cancel() error = %v, want ErrNotScheduled", err)
+		}
+		time.Sleep(time.Hour)
+		synctest.Wait()
+		if len(out) != 2 {
+			t.Fatalf("published %+v, want the cancelled message dropped", out)
+		}
+
+		s.Close()
+		if err := s.Publish(context.Background(), "orders", &later, broker.Delay(time.Second)); !errors.Is(err, broker.ErrSchedulerClosed) {
+			t.Fatalf("Publish() after Close error = %v, want ErrSchedulerClosed", err)
+		}
+	})
+}
+
+func TestScheduler_SlowPublishDoesNotDelayTheWheel(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		type delivery struct {
+			msg         string
+			at          time.Duration
+			hasDeadline bool
+		}
+		delivered := make(chan delivery, 2)
+		start := time.Now()
+		s := broker.NewScheduler(func(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+			_, ok := ctx.Deadline()
+			delivered <- delivery{msg: *m, at: time.Since(start), hasDeadline: ok}
+			if *m == "stuck" {
+				// A publish that hangs until its context gives up.
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}, time.Second)
+		defer s.Close()
+		stuck, next := "stuck", "next"
+		if err := s.Publish(context.Background(), "orders", &stuck, broker.Delay(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Publish(context.Background(), "orders", &next, broker.Delay(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-delivered; got.msg != "stuck" || !got.hasDeadline {
+			t.Fatalf("got %+v, want the stuck message published with a deadline", got)
+		}
+		if got := <-delivered; got.msg != "next" || got.at != time.Minute {
+			t.Fatalf("got %+v, want the next message after 1m", got)
+		}
+	})
+}
+
+func TestScheduler_DeliversOnTime(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		delivered := make(chan time.Duration, 1)
+		start := time.Now()
+		s := broker.NewScheduler(func(ctx context.Context, topic string, m *string, opts ...broker.PublishOption) error {
+			delivered <- time.Since(start)
+			return nil
+		}, 0)
+		defer s.Close()
+		m := "m"
+		if err := s.Publish(context.Background(), "orders", &m, broker.Delay(25*time.Second+5*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		// Rounded up to the next tick.
+		if got, want := <-delivered, 25*time.Second+broker.DefaultScheduleTick; got != want {
+			t.Fatalf("delivered after %v, want %v", got, want)
+		}
+	})
+}
+
+func TestCancel_UnsupportedBroker(t *testing.T) {
+	if err := broker.Cancel[string](context.Background(), &stubBroker{}, "id"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("Cancel() error = %v, want errors.ErrUnsupported", err)
+	}
+}
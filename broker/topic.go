@@ -0,0 +1,70 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Topic patterns subscribe to every topic they match. Topics are made of
+// tokens separated by dots, as NATS subjects are: in a pattern, a "*" token
+// matches exactly one token and a ">" token, which must come last, matches
+// one or more tokens. "orders.*" matches "orders.created" but not
+// "orders.eu.created", which "orders.>" matches.
+const (
+	// TokenWildcard matches exactly one token of a topic.
+	TokenWildcard = "*"
+	// TailWildcard matches the remaining tokens of a topic, at least one.
+	TailWildcard = ">"
+)
+
+var (
+	// ErrInvalidPattern is returned by Subscribe for a topic pattern with a
+	// TailWildcard before its last token.
+	ErrInvalidPattern = errors.New("broker: invalid topic pattern")
+
+	// ErrPatternUnsupported is returned by Subscribe of brokers that cannot
+	// subscribe to topic patterns.
+	ErrPatternUnsupported = fmt.Errorf("broker: topic patterns: %w", errors.ErrUnsupported)
+)
+
+// IsPattern reports whether topic holds a wildcard token, see TokenWildcard
+// and TailWildcard.
+func IsPattern(topic string) bool {
+	for token := range strings.SplitSeq(topic, ".") {
+		if token == TokenWildcard || token == TailWildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePattern returns ErrInvalidPattern if pattern has a TailWildcard
+// before its last token.
+func ValidatePattern(pattern string) error {
+	tokens := strings.Split(pattern, ".")
+	for i, token := range tokens {
+		if token == TailWildcard && i != len(tokens)-1 {
+			return fmt.Errorf("%w: %q", ErrInvalidPattern, pattern)
+		}
+	}
+	return nil
+}
+
+// MatchTopic reports whether topic matches pattern. A pattern without
+// wildcards only matches itself.
+func MatchTopic(pattern, topic string) bool {
+	for {
+		p, prest, pmore := strings.Cut(pattern, ".")
+		t, trest, tmore := strings.Cut(topic, ".")
+		switch {
+		case p == TailWildcard && !pmore:
+			return t != "" || tmore
+		case p != TokenWildcard && p != t:
+			return false
+		case !pmore || !tmore:
+			return pmore == tmore
+		}
+		pattern, topic = prest, trest
+	}
+}
@@ -0,0 +1,164 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: broker/topic.proto
+
+package broker
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	descriptorpb "google.golang.org/protobuf/types/descriptorpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// TopicOptions declares the broker topic a message is published to.
+// protoc-gen-nano generates typed Publish and Subscribe helpers for the
+// messages carrying it, and their AsyncAPI document.
+type TopicOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Name of the topic.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Description of the topic in the AsyncAPI document. Defaults to the
+	// comment of the message.
+	Description   string `protobuf:"bytes,2,opt,name=description,proto3" json:"description,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TopicOptions) Reset() {
+	*x = TopicOptions{}
+	mi := &file_broker_topic_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TopicOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TopicOptions) ProtoMessage() {}
+
+func (x *TopicOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_topic_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TopicOptions.ProtoReflect.Descriptor instead.
+func (*TopicOptions) Descriptor() ([]byte, []int) {
+	return file_broker_topic_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TopicOptions) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TopicOptions) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+var file_broker_topic_proto_extTypes = []protoimpl.ExtensionInfo{
+	{
+		ExtendedType:  (*descriptorpb.MessageOptions)(nil),
+		ExtensionType: (*TopicOptions)(nil),
+		Field:         50710,
+		Name:          "broker.topic",
+		Tag:           "bytes,50710,opt,name=topic",
+		Filename:      "broker/topic.proto",
+	},
+}
+
+// Extension fields to descriptorpb.MessageOptions.
+var (
+	// Topic of the message, e.g.
+	//
+	//	option (broker.topic) = { name: "orders.created" };
+	//
+	// optional broker.TopicOptions topic = 50710;
+	E_Topic = &file_broker_topic_proto_extTypes[0]
+)
+
+var File_broker_topic_proto protoreflect.FileDescriptor
+
+const file_broker_topic_proto_rawDesc = "" +
+	"\n" +
+	"\x12broker/topic.proto\x12\x06broker\x1a google/protobuf/descriptor.proto\"D\n" +
+	"\fTopicOptions\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12 \n" +
+	"\vdescription\x18\x02 \x01(\tR\vdescription:M\n" +
+	"\x05topic\x12\x1f.google.protobuf.MessageOptions\x18\x96\x8c\x03 \x01(\v2\x14.broker.TopicOptionsR\x05topicB)Z'github.com/pthethanh/nano/broker;brokerb\x06proto3"
+
+var (
+	file_broker_topic_proto_rawDescOnce sync.Once
+	file_broker_topic_proto_rawDescData []byte
+)
+
+func file_broker_topic_proto_rawDescGZIP() []byte {
+	file_broker_topic_proto_rawDescOnce.Do(func() {
+		file_broker_topic_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_broker_topic_proto_rawDesc), len(file_broker_topic_proto_rawDesc)))
+	})
+	return file_broker_topic_proto_rawDescData
+}
+
+var file_broker_topic_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_broker_topic_proto_goTypes = []any{
+	(*TopicOptions)(nil),                // 0: broker.TopicOptions
+	(*descriptorpb.MessageOptions)(nil), // 1: google.protobuf.MessageOptions
+}
+var file_broker_topic_proto_depIdxs = []int32{
+	1, // 0: broker.topic:extendee -> google.protobuf.MessageOptions
+	0, // 1: broker.topic:type_name -> broker.TopicOptions
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	1, // [1:2] is the sub-list for extension type_name
+	0, // [0:1] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_broker_topic_proto_init() }
+func file_broker_topic_proto_init() {
+	if File_broker_topic_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_broker_topic_proto_rawDesc), len(file_broker_topic_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 1,
+			NumServices:   0,
+		},
+		GoTypes:           file_broker_topic_proto_goTypes,
+		DependencyIndexes: file_broker_topic_proto_depIdxs,
+		MessageInfos:      file_broker_topic_proto_msgTypes,
+		ExtensionInfos:    file_broker_topic_proto_extTypes,
+	}.Build()
+	File_broker_topic_proto = out.File
+	file_broker_topic_proto_goTypes = nil
+	file_broker_topic_proto_depIdxs = nil
+}
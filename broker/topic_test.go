@@ -0,0 +1,46 @@
+package broker_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "orders.created", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.*.created", "orders.eu.deleted", false},
+		{"orders.>", "orders.created", true},
+		{"orders.>", "orders.eu.created", true},
+		{"orders.>", "orders", false},
+		{">", "orders", true},
+		{"*", "orders.created", false},
+		{"orders.a*", "orders.ab", false},
+	}
+	for _, tt := range tests {
+		if got := broker.MatchTopic(tt.pattern, tt.topic); got != tt.want {
+			t.Errorf("MatchTopic(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestIsPatternAndValidatePattern(t *testing.T) {
+	if broker.IsPattern("orders.a*") || !broker.IsPattern("orders.*.created") || !broker.IsPattern("orders.>") {
+		t.Error("IsPattern() reports partial-token wildcards or misses whole-token ones")
+	}
+	if err := broker.ValidatePattern("orders.>.created"); !errors.Is(err, broker.ErrInvalidPattern) {
+		t.Errorf("ValidatePattern() error = %v, want ErrInvalidPattern", err)
+	}
+	if err := broker.ValidatePattern("orders.*.>"); err != nil {
+		t.Errorf("ValidatePattern() error = %v, want nil", err)
+	}
+}
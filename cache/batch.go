@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"errors"
+)
+
+type (
+	// Batcher is implemented by caches that get, set and delete several keys
+	// in one round trip. Use Batch to get one for any Cacher.
+	Batcher[K comparable, V any] interface {
+		// GetMany retrieves the values of keys. Missing keys are absent from
+		// the result, they are not an error.
+		GetMany(ctx context.Context, keys []K) (map[K]V, error)
+		// SetMany stores the values of items, with the same settings.
+		SetMany(ctx context.Context, items map[K]V, opts ...SetOption) error
+		// DeleteMany removes the values of keys.
+		DeleteMany(ctx context.Context, keys []K) error
+	}
+
+	// batch implements Batcher over a Cacher one key at a time.
+	batch[K comparable, V any] struct {
+		c Cacher[K, V]
+	}
+)
+
+// Batch returns c as a Batcher: c itself if it implements Batcher, or an
+// adapter calling Get, Set and Delete for each key otherwise.
+func Batch[K comparable, V any](c Cacher[K, V]) Batcher[K, V] {
+	if b, ok := c.(Batcher[K, V]); ok {
+		return b
+	}
+	return batch[K, V]{c: c}
+}
+
+func (b batch[K, V]) GetMany(ctx context.Context, keys []K) (map[K]V, error) {
+	rs := make(map[K]V, len(keys))
+	for _, k := range keys {
+		v, err := b.c.Get(ctx, k)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rs[k] = v
+	}
+	return rs, nil
+}
+
+func (b batch[K, V]) SetMany(ctx context.Context, items map[K]V, opts ...SetOption) error {
+	for k, v := range items {
+		if err := b.c.Set(ctx, k, v, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b batch[K, V]) DeleteMany(ctx context.Context, keys []K) error {
+	for _, k := range keys {
+		if err := b.c.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pthethanh/nano/cache"
+	"github.com/pthethanh/nano/cache/cachetest"
+	"github.com/pthethanh/nano/cache/memory"
+)
+
+// singleKey hides the batch operations of the memory cache.
+type singleKey struct {
+	cache.Cacher[string, []byte]
+}
+
+func TestBatch_ReturnsNativeBatcher(t *testing.T) {
+	c := memory.New[string, []byte]()
+	if b := cache.Batch[string, []byte](c); b != cache.Batcher[string, []byte](c) {
+		t.Fatalf("Batch() = %T, want the cache itself", b)
+	}
+}
+
+func TestBatch_FallbackConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cacher[string, []byte] {
+		c := singleKey{memory.New[string, []byte]()}
+		if _, ok := cache.Batch[string, []byte](c).(*memory.Cacher[string, []byte]); ok {
+			t.Fatal("Batch() returned the wrapped cache, want the fallback adapter")
+		}
+		return c
+	}, cachetest.Synctest())
+}
+
+func TestBatch_FallbackSkipsMissingKeys(t *testing.T) {
+	c := singleKey{memory.New[string, []byte]()}
+	b := cache.Batch[string, []byte](c)
+	if err := b.SetMany(context.Background(), map[string][]byte{"k": []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.GetMany(context.Background(), []string{"k", "missing"})
+	if err != nil || len(got) != 1 || string(got["k"]) != "v" {
+		t.Fatalf("GetMany() = %q, %v, want only k", got, err)
+	}
+}
@@ -0,0 +1,320 @@
+// Package cachetest provides a conformance suite for implementations of
+// cache.Cacher, so that every implementation agrees on the semantics the
+// cache package documents:
+//
+//   - Get returns cache.ErrNotFound for missing, deleted and expired keys,
+//     and Delete of a missing key succeeds;
+//   - Set overwrites the value and the TTL of a key, and a zero TTL keeps the
+//     key until it is deleted;
+//   - the batch operations of cache.Batch behave like their single-key
+//     counterparts;
+//   - the cache is safe for concurrent use;
+//   - after Close, Get, Set and Delete return cache.ErrInValidConnState, and
+//     Close succeeds whether the cache is open, closed or never opened.
+//
+// Implementations run it from their tests, with a Clock driving the expiry
+// of the keys when the backend can fake time:
+//
+//	func TestConformance(t *testing.T) {
+//		cachetest.Run(t, func(t *testing.T) cache.Cacher[string, []byte] {
+//			return memory.New[string, []byte]()
+//		}, cachetest.Synctest())
+//	}
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/pthethanh/nano/cache"
+)
+
+type (
+	// Factory returns a new cache, not opened yet. The suite opens it and
+	// closes it at the end of the test. Caches that share state across
+	// instances, such as a server, must not share keys across tests.
+	Factory func(t *testing.T) cache.Cacher[string, []byte]
+
+	// Clock advances the time the cache under test sees by d.
+	Clock func(t *testing.T, d time.Duration)
+
+	// Option is an optional configuration of the suite.
+	Option func(*suite)
+
+	suite struct {
+		factory  Factory
+		clock    Clock
+		synctest bool
+	}
+)
+
+// WithClock sets the clock driving the TTL tests, such as the fast forward
+// of a fake server. Default sleeps for d.
+func WithClock(clock Clock) Option {
+	return func(s *suite) {
+		s.clock = clock
+	}
+}
+
+// Synctest runs each test, the factory included, in a testing/synctest
+// bubble, so that the default clock advances the fake time of the bubble
+// instead of sleeping. Use it for in-process caches relying on the time
+// package.
+func Synctest() Option {
+	return func(s *suite) {
+		s.synctest = true
+	}
+}
+
+// Run runs the conformance suite against the caches returned by factory, each
+// test in its own subtest.
+func Run(t *testing.T, factory Factory, opts ...Option) {
+	s := &suite{
+		factory: factory,
+		clock: func(t *testing.T, d time.Duration) {
+			time.Sleep(d)
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for _, tc := range []struct {
+		name string
+		test func(*testing.T, cache.Cacher[string, []byte])
+	}{
+		{"SetGet", s.testSetGet},
+		{"NotFound", s.testNotFound},
+		{"Delete", s.testDelete},
+		{"Overwrite", s.testOverwrite},
+		{"TTL", s.testTTL},
+		{"ZeroTTL", s.testZeroTTL},
+		{"OverwriteTTL", s.testOverwriteTTL},
+		{"Batch", s.testBatch},
+		{"Concurrent", s.testConcurrent},
+		{"Closed", s.testClosed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if !s.synctest {
+				tc.test(t, s.open(t))
+				return
+			}
+			synctest.Test(t, func(t *testing.T) {
+				tc.test(t, s.open(t))
+			})
+		})
+	}
+	t.Run("CloseWithoutOpen", s.testCloseWithoutOpen)
+}
+
+func (s *suite) testSetGet(t *testing.T, c cache.Cacher[string, []byte]) {
+	ctx := context.Background()
+	set(t, c, "k", "v")
+	expect(t, c, "k", "v")
+	if err := c.Set(ctx, "empty", []byte{}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if v, err := c.Get(ctx, "empty"); err != nil || len(v) != 0 {
+		t.Errorf("Get() = %q, %v, want an empty value", v, err)
+	}
+}
+
+func (s *suite) testNotFound(t *testing.T, c cache.Cacher[string, []byte]) {
+	expectNotFound(t, c, "missing")
+	if err := c.Delete(context.Background(), "missing"); err != nil {
+		t.Errorf("Delete() error = %v for a missing key, want none", err)
+	}
+}
+
+func (s *suite) testDelete(t *testing.T, c cache.Cacher[string, []byte]) {
+	set(t, c, "k", "v")
+	set(t, c, "other", "v")
+	if err := c.Delete(context.Background(), "k"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	expectNotFound(t, c, "k")
+	expect(t, c, "other", "v")
+}
+
+func (s *suite) testOverwrite(t *testing.T, c cache.Cacher[string, []byte]) {
+	set(t, c, "k", "v1")
+	set(t, c, "k", "v2")
+	expect(t, c, "k", "v2")
+}
+
+func (s *suite) testTTL(t *testing.T, c cache.Cacher[string, []byte]) {
+	set(t, c, "short", "v", cache.TTL(time.Second))
+	set(t, c, "long", "v", cache.TTL(time.Minute))
+	s.clock(t, 500*time.Millisecond)
+	expect(t, c, "short", "v")
+	s.clock(t, time.Second)
+	expectNotFound(t, c, "short")
+	expect(t, c, "long", "v")
+}
+
+func (s *suite) testZeroTTL(t *testing.T, c cache.Cacher[string, []byte]) {
+	set(t, c, "k", "v", cache.TTL(0))
+	s.clock(t, time.Hour)
+	expect(t, c, "k", "v")
+}
+
+func (s *suite) testOverwriteTTL(t *testing.T, c cache.Cacher[string, []byte]) {
+	// Set replaces the TTL of the key, including with no TTL.
+	set(t, c, "extended", "v", cache.TTL(time.Second))
+	set(t, c, "extended", "v", cache.TTL(time.Minute))
+	set(t, c, "kept", "v", cache.TTL(time.Second))
+	set(t, c, "kept", "v")
+	s.clock(t, 2*time.Second)
+	expect(t, c, "extended", "v")
+	expect(t, c, "kept", "v")
+}
+
+func (s *suite) testBatch(t *testing.T, c cache.Cacher[string, []byte]) {
+	ctx := context.Background()
+	b := cache.Batch(c)
+	if err := b.SetMany(ctx, map[string][]byte{"a": []byte("1"), "b": []byte("2"), "short": []byte("3")}); err != nil {
+		t.Fatalf("SetMany() error = %v", err)
+	}
+	if err := b.SetMany(ctx, map[string][]byte{"short": []byte("4")}, cache.TTL(time.Second)); err != nil {
+		t.Fatalf("SetMany() error = %v", err)
+	}
+	expect(t, c, "a", "1")
+	got, err := b.GetMany(ctx, []string{"a", "missing", "b", "short"})
+	if err != nil {
+		t.Fatalf("GetMany() error = %v", err)
+	}
+	if len(got) != 3 || string(got["a"]) != "1" || string(got["b"]) != "2" || string(got["short"]) != "4" {
+		t.Errorf("GetMany() = %q, want a, b and short without the missing key", got)
+	}
+	if got, err := b.GetMany(ctx, nil); err != nil || len(got) != 0 {
+		t.Errorf("GetMany(nil) = %q, %v, want no values", got, err)
+	}
+	s.clock(t, 2*time.Second)
+	expectNotFound(t, c, "short")
+	if err := b.DeleteMany(ctx, []string{"a", "missing"}); err != nil {
+		t.Fatalf("DeleteMany() error = %v", err)
+	}
+	expectNotFound(t, c, "a")
+	expect(t, c, "b", "2")
+}
+
+func (s *suite) testConcurrent(t *testing.T, c cache.Cacher[string, []byte]) {
+	ctx := context.Background()
+	const workers, n = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := range workers {
+		wg.Go(func() {
+			for i := range n {
+				own := fmt.Sprintf("w%d-%d", w, i)
+				if err := c.Set(ctx, own, []byte(own)); err != nil {
+					errs <- err
+					return
+				}
+				if err := c.Set(ctx, "shared", []byte(own)); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := c.Get(ctx, "shared"); err != nil {
+					errs <- err
+					return
+				}
+				if i%2 == 1 {
+					if err := c.Delete(ctx, own); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent access error = %v", err)
+	}
+	for w := range workers {
+		for i := range n {
+			own := fmt.Sprintf("w%d-%d", w, i)
+			if i%2 == 1 {
+				expectNotFound(t, c, own)
+			} else {
+				expect(t, c, own, own)
+			}
+		}
+	}
+}
+
+func (s *suite) testClosed(t *testing.T, c cache.Cacher[string, []byte]) {
+	ctx := context.Background()
+	set(t, c, "k", "v")
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, cache.ErrInValidConnState) {
+		t.Errorf("Get() error = %v after Close, want %v", err, cache.ErrInValidConnState)
+	}
+	if err := c.Set(ctx, "k", []byte("v")); !errors.Is(err, cache.ErrInValidConnState) {
+		t.Errorf("Set() error = %v after Close, want %v", err, cache.ErrInValidConnState)
+	}
+	if err := c.Delete(ctx, "k"); !errors.Is(err, cache.ErrInValidConnState) {
+		t.Errorf("Delete() error = %v after Close, want %v", err, cache.ErrInValidConnState)
+	}
+	if _, err := cache.Batch(c).GetMany(ctx, []string{"k"}); !errors.Is(err, cache.ErrInValidConnState) {
+		t.Errorf("GetMany() error = %v after Close, want %v", err, cache.ErrInValidConnState)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Errorf("second Close() error = %v, want none", err)
+	}
+}
+
+func (s *suite) testCloseWithoutOpen(t *testing.T) {
+	run := func(t *testing.T) {
+		if err := s.factory(t).Close(context.Background()); err != nil {
+			t.Fatalf("Close() error = %v before Open", err)
+		}
+	}
+	if s.synctest {
+		synctest.Test(t, run)
+		return
+	}
+	run(t)
+}
+
+// open returns an open cache, closed at the end of the test.
+func (s *suite) open(t *testing.T) cache.Cacher[string, []byte] {
+	t.Helper()
+	c := s.factory(t)
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close(context.Background())
+	})
+	return c
+}
+
+func set(t *testing.T, c cache.Cacher[string, []byte], k, v string, opts ...cache.SetOption) {
+	t.Helper()
+	if err := c.Set(context.Background(), k, []byte(v), opts...); err != nil {
+		t.Fatalf("Set(%q) error = %v", k, err)
+	}
+}
+
+func expect(t *testing.T, c cache.Cacher[string, []byte], k, want string) {
+	t.Helper()
+	if v, err := c.Get(context.Background(), k); err != nil || string(v) != want {
+		t.Errorf("Get(%q) = %q, %v, want %q", k, v, err, want)
+	}
+}
+
+func expectNotFound(t *testing.T, c cache.Cacher[string, []byte], k string) {
+	t.Helper()
+	if v, err := c.Get(context.Background(), k); !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("Get(%q) = %q, %v, want %v", k, v, err, cache.ErrNotFound)
+	}
+}
@@ -1,3 +1,5 @@
 // Package cache defines a generic cache interface, common options, and shared
-// errors for cache implementations in this repository.
+// errors for cache implementations in this repository, and a read-through
+// Loader working over any of them. A Loader of V values caches Entry[V]
+// values: it is built over a Cacher[K, Entry[V]], see NewLoader.
 package cache
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	// Entry is a value cached by a Loader: the loaded value, or the fact that
+	// the source does not have it, and until when it is fresh.
+	Entry[V any] struct {
+		Value      V         `json:"value"`
+		NotFound   bool      `json:"not_found,omitempty"`
+		FreshUntil time.Time `json:"fresh_until,omitzero"` // Zero for no expiry.
+	}
+
+	// LoadFunc loads the value of a key from the source of the cache. It
+	// returns ErrNotFound when the source does not have the key.
+	LoadFunc[K comparable, V any] func(ctx context.Context, k K) (V, error)
+
+	// LoadOptions configures how a Loader caches the values it loads.
+	LoadOptions struct {
+		TTL         time.Duration // How long a loaded value is fresh, zero for no expiry.
+		Stale       time.Duration // How long a value is served after its TTL while it is reloaded.
+		NegativeTTL time.Duration // How long a not found result is cached, zero to not cache it.
+	}
+
+	// LoadOption modifies LoadOptions.
+	LoadOption func(*LoadOptions)
+
+	// Loader is a read-through cache over a Cacher: GetOrLoad returns the
+	// cached value of a key, loading and caching it on a miss. The Cacher
+	// stores Entry[V] values, not V: see NewLoader.
+	Loader[K comparable, V any] struct {
+		cacher Cacher[K, Entry[V]]
+		opts   LoadOptions
+
+		mu    sync.Mutex
+		calls map[K]*loadCall[V]
+	}
+
+	loadCall[V any] struct {
+		done     chan struct{}
+		v        V
+		err      error
+		panicked any // Value the load function panicked with, re-raised in the waiting callers.
+	}
+)
+
+// LoadTTL sets how long a loaded value is fresh.
+func LoadTTL(ttl time.Duration) LoadOption {
+	return func(opts *LoadOptions) {
+		opts.TTL = ttl
+	}
+}
+
+// StaleWhileRevalidate keeps serving a value for d after its TTL, reloading
+// it in the background, so callers do not wait for the source once a value
+// was loaded. It has no effect without a TTL.
+func StaleWhileRevalidate(d time.Duration) LoadOption {
+	return func(opts *LoadOptions) {
+		opts.Stale = d
+	}
+}
+
+// NegativeTTL caches the keys the source does not have for ttl, so that
+// GetOrLoad returns ErrNotFound for them without calling the source again.
+func NegativeTTL(ttl time.Duration) LoadOption {
+	return func(opts *LoadOptions) {
+		opts.NegativeTTL = ttl
+	}
+}
+
+// Apply applies LoadOption functions to LoadOptions.
+func (opt *LoadOptions) Apply(opts ...LoadOption) {
+	for _, op := range opts {
+		op(opt)
+	}
+}
+
+// NewLoader returns a Loader caching the values it loads in c, with the
+// given default options. Any Cacher works, from cache/memory within a
+// process to the Redis cache plugin to share the values across processes.
+//
+// The Cacher must store Entry[V] values, which carry the freshness and
+// not-found metadata of the loaded values: a Loader of V values needs a
+// Cacher[K, Entry[V]], such as memory.New[K, cache.Entry[V]](), and a
+// Cacher[K, V] holding plain values cannot be used, nor shared with it.
+func NewLoader[K comparable, V any](c Cacher[K, Entry[V]], opts ...LoadOption) *Loader[K, V] {
+	l := &Loader[K, V]{
+		cacher: c,
+		calls:  make(map[K]*loadCall[V]),
+	}
+	l.opts.Apply(opts...)
+	return l
+}
+
+// GetOrLoad returns the cached value of k. On a miss, it loads the value with
+// load and caches it, using the options of the Loader overridden by opts.
+// Concurrent misses of a key in the Loader share a single call to load, so
+// the source sees one call per key however many callers miss it; the call
+// is not canceled when ctx is, and only stops the callers waiting for it.
+// If load panics, the callers waiting for it panic with the same value, and
+// the next miss calls load again.
+//
+// A stale value is returned right away while it is reloaded in the
+// background, see StaleWhileRevalidate. It returns ErrNotFound when load
+// does, caching the result under NegativeTTL, and the errors of load
+// otherwise, which are not cached. The cache failing only makes GetOrLoad
+// go to the source.
+func (l *Loader[K, V]) GetOrLoad(ctx context.Context, k K, load LoadFunc[K, V], opts ...LoadOption) (V, error) {
+	o := l.opts
+	o.Apply(opts...)
+	if e, err := l.cacher.Get(ctx, k); err == nil {
+		if !e.FreshUntil.IsZero() && !time.Now().Before(e.FreshUntil) {
+			// Stale: the reload runs on its own.
+			l.do(ctx, k, load, o)
+		}
+		if e.NotFound {
+			return e.Value, ErrNotFound
+		}
+		return e.Value, nil
+	}
+	call := l.do(ctx, k, load, o)
+	select {
+	case <-call.done:
+		if call.panicked != nil {
+			panic(call.panicked)
+		}
+		return call.v, call.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// do returns the call loading k, starting it if no call of k is in flight.
+func (l *Loader[K, V]) do(ctx context.Context, k K, load LoadFunc[K, V], o LoadOptions) *loadCall[V] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if call, ok := l.calls[k]; ok {
+		return call
+	}
+	call := &loadCall[V]{done: make(chan struct{})}
+	l.calls[k] = call
+	go func() {
+		defer func() {
+			// A panic of load is handed to the waiting callers rather than
+			// crashing the process from this goroutine; a stale reload has
+			// none.
+			call.panicked = recover()
+			l.mu.Lock()
+			delete(l.calls, k)
+			l.mu.Unlock()
+			close(call.done)
+		}()
+		call.v, call.err = l.load(context.WithoutCancel(ctx), k, load, o)
+	}()
+	return call
+}
+
+// load loads k from the source and caches the result.
+func (l *Loader[K, V]) load(ctx context.Context, k K, load LoadFunc[K, V], o LoadOptions) (V, error) {
+	v, err := load(ctx, k)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if o.NegativeTTL > 0 {
+			// Failing to cache it only means the source is asked again.
+			_ = l.cacher.Set(ctx, k, Entry[V]{NotFound: true, FreshUntil: time.Now().Add(o.NegativeTTL)}, TTL(o.NegativeTTL))
+		}
+		return v, ErrNotFound
+	case err != nil:
+		return v, err
+	}
+	e := Entry[V]{Value: v}
+	var ttl time.Duration
+	if o.TTL > 0 {
+		e.FreshUntil = time.Now().Add(o.TTL)
+		ttl = o.TTL + o.Stale
+	}
+	_ = l.cacher.Set(ctx, k, e, TTL(ttl))
+	return v, nil
+}
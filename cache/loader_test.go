@@ -0,0 +1,174 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/pthethanh/nano/cache"
+	"github.com/pthethanh/nano/cache/memory"
+)
+
+func newLoader(t *testing.T, opts ...cache.LoadOption) *cache.Loader[string, string] {
+	c := memory.New[string, cache.Entry[string]]()
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close(context.Background()) })
+	return cache.NewLoader(c, opts...)
+}
+
+func TestGetOrLoad_CoalescesConcurrentMisses(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		l := newLoader(t)
+		var calls atomic.Int32
+		load := func(ctx context.Context, k string) (string, error) {
+			calls.Add(1)
+			time.Sleep(time.Second)
+			return "value of " + k, nil
+		}
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Go(func() {
+				if v, err := l.GetOrLoad(context.Background(), "k", load); err != nil || v != "value of k" {
+					t.Errorf("GetOrLoad() = %q, %v, want the loaded value", v, err)
+				}
+			})
+		}
+		wg.Wait()
+		if v, err := l.GetOrLoad(context.Background(), "k", load); err != nil || v != "value of k" {
+			t.Errorf("GetOrLoad() = %q, %v, want the cached value", v, err)
+		}
+		if n := calls.Load(); n != 1 {
+			t.Errorf("load called %d times, want once", n)
+		}
+	})
+}
+
+func TestGetOrLoad_ServesStaleWhileRevalidating(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		l := newLoader(t, cache.LoadTTL(time.Minute), cache.StaleWhileRevalidate(time.Hour))
+		var version atomic.Int32
+		load := func(ctx context.Context, k string) (string, error) {
+			time.Sleep(time.Second)
+			if version.Add(1) == 1 {
+				return "v1", nil
+			}
+			return "v2", nil
+		}
+		if v, _ := l.GetOrLoad(context.Background(), "k", load); v != "v1" {
+			t.Fatalf("GetOrLoad() = %q, want v1", v)
+		}
+		time.Sleep(2 * time.Minute)
+		start := time.Now()
+		if v, _ := l.GetOrLoad(context.Background(), "k", load); v != "v1" || time.Since(start) != 0 {
+			t.Fatalf("GetOrLoad() = %q after %v, want the stale v1 right away", v, time.Since(start))
+		}
+		time.Sleep(time.Second)
+		synctest.Wait()
+		if v, _ := l.GetOrLoad(context.Background(), "k", load); v != "v2" {
+			t.Fatalf("GetOrLoad() = %q, want the revalidated v2", v)
+		}
+
+		// Past the stale window, the value is loaded again.
+		time.Sleep(2 * time.Hour)
+		if v, _ := l.GetOrLoad(context.Background(), "k", load); v != "v2" || version.Load() != 3 {
+			t.Fatalf("GetOrLoad() = %q after %d loads, want v2 loaded a third time", v, version.Load())
+		}
+	})
+}
+
+func TestGetOrLoad_CachesNotFound(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		l := newLoader(t, cache.NegativeTTL(time.Minute))
+		var calls atomic.Int32
+		load := func(ctx context.Context, k string) (string, error) {
+			calls.Add(1)
+			return "", cache.ErrNotFound
+		}
+		for range 3 {
+			if _, err := l.GetOrLoad(context.Background(), "k", load); !errors.Is(err, cache.ErrNotFound) {
+				t.Fatalf("GetOrLoad() error = %v, want %v", err, cache.ErrNotFound)
+			}
+		}
+		if n := calls.Load(); n != 1 {
+			t.Fatalf("load called %d times within the negative TTL, want once", n)
+		}
+		time.Sleep(2 * time.Minute)
+		if _, err := l.GetOrLoad(context.Background(), "k", load, cache.NegativeTTL(0)); !errors.Is(err, cache.ErrNotFound) || calls.Load() != 2 {
+			t.Fatalf("GetOrLoad() error = %v after %d loads, want %v loaded again", err, calls.Load(), cache.ErrNotFound)
+		}
+	})
+}
+
+func TestGetOrLoad_DoesNotCacheErrors(t *testing.T) {
+	l := newLoader(t)
+	fail := errors.New("db down")
+	if _, err := l.GetOrLoad(context.Background(), "k", func(context.Context, string) (string, error) {
+		return "", fail
+	}); !errors.Is(err, fail) {
+		t.Fatalf("GetOrLoad() error = %v, want %v", err, fail)
+	}
+	if v, err := l.GetOrLoad(context.Background(), "k", func(context.Context, string) (string, error) {
+		return "v", nil
+	}); err != nil || v != "v" {
+		t.Fatalf("GetOrLoad() = %q, %v, want the value loaded again", v, err)
+	}
+}
+
+func TestGetOrLoad_CanceledCallerDoesNotCancelTheLoad(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		l := newLoader(t)
+		load := func(ctx context.Context, k string) (string, error) {
+			time.Sleep(time.Second)
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+			return "v", nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if _, err := l.GetOrLoad(ctx, "k", load); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("GetOrLoad() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if v, err := l.GetOrLoad(context.Background(), "k", load); err != nil || v != "v" {
+			t.Fatalf("GetOrLoad() = %q, %v, want the value of the first load", v, err)
+		}
+	})
+}
+
+func TestGetOrLoad_PanicReachesTheWaitingCallers(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		l := newLoader(t)
+		var calls atomic.Int32
+		load := func(ctx context.Context, k string) (string, error) {
+			if calls.Add(1) == 1 {
+				time.Sleep(time.Second)
+				panic("boom")
+			}
+			return "v", nil
+		}
+		var wg sync.WaitGroup
+		for range 3 {
+			wg.Go(func() {
+				defer func() {
+					if r := recover(); r != "boom" {
+						t.Errorf("GetOrLoad() panicked with %v, want boom", r)
+					}
+				}()
+				l.GetOrLoad(context.Background(), "k", load)
+			})
+		}
+		wg.Wait()
+		if v, err := l.GetOrLoad(context.Background(), "k", load); err != nil || v != "v" {
+			t.Errorf("GetOrLoad() = %q, %v after the panic, want a new load", v, err)
+		}
+		if n := calls.Load(); n != 2 {
+			t.Errorf("load called %d times, want twice", n)
+		}
+	})
+}
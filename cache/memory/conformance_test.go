@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/pthethanh/nano/cache"
+	"github.com/pthethanh/nano/cache/cachetest"
+	"github.com/pthethanh/nano/cache/memory"
+)
+
+func TestConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cacher[string, []byte] {
+		return memory.New[string, []byte]()
+	}, cachetest.Synctest())
+}
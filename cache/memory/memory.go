@@ -4,6 +4,9 @@ package memory
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jellydator/ttlcache/v3"
 	"github.com/pthethanh/nano/cache"
@@ -12,32 +15,74 @@ import (
 type (
 	// Cacher is an in-memory cache implementation.
 	Cacher[K comparable, V any] struct {
-		cache *ttlcache.Cache[K, V]
+		cache  *ttlcache.Cache[K, V]
+		closed atomic.Bool
+
+		mu    sync.Mutex
+		stop  chan struct{} // closed by Close to stop the sweep goroutine
+		sweep chan struct{} // closed when the sweep goroutine returns
 	}
 )
 
+// sweepInterval is how often the sweep goroutine evicts the expired entries.
+const sweepInterval = time.Second
+
 var (
 	// Cacher should implements cache.Cacher
-	_ cache.Cacher[string, []byte] = &Cacher[string, []byte]{}
+	_ cache.Cacher[string, []byte]  = &Cacher[string, []byte]{}
+	_ cache.Batcher[string, []byte] = &Cacher[string, []byte]{}
+	_ cache.TTLReader[string]       = &Cacher[string, []byte]{}
 )
 
+// New returns an in-memory Cacher configured by the ttlcache options. Keys
+// expire their TTL after they are set, like in other cache.Cacher
+// implementations: Get does not extend it. This is a breaking change from
+// the former behavior of ttlcache, which extended the TTL of a key on every
+// hit; ttlcache has no option to turn it back on.
 func New[K comparable, V any](opts ...ttlcache.Option[K, V]) *Cacher[K, V] {
+	opts = append([]ttlcache.Option[K, V]{ttlcache.WithDisableTouchOnHit[K, V]()}, opts...)
 	return &Cacher[K, V]{
 		cache: ttlcache.New[K, V](opts...),
 	}
 }
 
-// Open starts the background sweep goroutine that proactively evicts
-// expired entries. It is not required before calling Get/Set/Delete: the
+// Open starts the background sweep goroutine that evicts expired entries
+// every second. It is not required before calling Get/Set/Delete: the
 // cache is fully usable immediately after New, and Get already treats an
 // expired entry as not found even if it hasn't been swept yet. Calling Open
-// more than once is safe (ttlcache.Start is idempotent while already
-// running).
+// more than once is safe: the sweep goroutine is only started once. Open
+// also reopens a closed Cacher, empty.
 func (c *Cacher[K, V]) Open(ctx context.Context) error {
-	go c.cache.Start()
+	if c.cache == nil {
+		return cache.ErrInValidConnState
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed.Store(false)
+	if c.sweep != nil {
+		return nil
+	}
+	c.stop, c.sweep = make(chan struct{}), make(chan struct{})
+	go c.sweepExpired(c.stop, c.sweep)
 	return nil
 }
 
+// sweepExpired evicts the expired entries every sweepInterval until stop is
+// closed, then closes done.
+func (c *Cacher[K, V]) sweepExpired(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	t := time.NewTicker(sweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			c.cache.DeleteExpired()
+		}
+	}
+}
+
 // Get a value, return ErrNotFound if key not found.
 func (c *Cacher[K, V]) Get(ctx context.Context, k K) (rs V, err error) {
 	if err := c.validate(); err != nil {
@@ -73,7 +118,69 @@ func (c *Cacher[K, V]) Delete(ctx context.Context, k K) error {
 	return nil
 }
 
-// Close stops the background sweep goroutine and clears all entries.
+// GetMany gets the values of keys, see cache.Batcher.
+func (c *Cacher[K, V]) GetMany(ctx context.Context, keys []K) (map[K]V, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	rs := make(map[K]V, len(keys))
+	for _, k := range keys {
+		if item := c.cache.Get(k); item != nil {
+			rs[k] = item.Value()
+		}
+	}
+	return rs, nil
+}
+
+// SetMany sets the values of items, see cache.Batcher.
+func (c *Cacher[K, V]) SetMany(ctx context.Context, items map[K]V, opts ...cache.SetOption) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	setOpts := &cache.SetOptions{}
+	setOpts.Apply(opts...)
+	ttl := ttlcache.NoTTL
+	if setOpts.TTL > 0 {
+		ttl = setOpts.TTL
+	}
+	for k, v := range items {
+		c.cache.Set(k, v, ttl)
+	}
+	return nil
+}
+
+// DeleteMany deletes the values of keys, see cache.Batcher.
+func (c *Cacher[K, V]) DeleteMany(ctx context.Context, keys []K) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		c.cache.Delete(k)
+	}
+	return nil
+}
+
+// TTLs returns the remaining time to live of the values of keys, see
+// cache.TTLReader.
+func (c *Cacher[K, V]) TTLs(ctx context.Context, keys []K) (map[K]time.Duration, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	rs := make(map[K]time.Duration, len(keys))
+	for _, k := range keys {
+		item := c.cache.Get(k)
+		switch {
+		case item == nil:
+			rs[k] = 0
+		case !item.ExpiresAt().IsZero():
+			rs[k] = max(time.Until(item.ExpiresAt()), 0)
+		}
+	}
+	return rs, nil
+}
+
+// Close stops the background sweep goroutine and clears all entries;
+// Get/Set/Delete then return ErrInValidConnState until the next Open.
 // Unlike Get/Set/Delete, Close deliberately ignores validate()'s error and
 // always returns nil: closing an already-invalid or never-opened Cacher is
 // treated as already-closed rather than an error, so callers can safely
@@ -82,13 +189,20 @@ func (c *Cacher[K, V]) Close(ctx context.Context) error {
 	if err := c.validate(); err != nil {
 		return nil
 	}
-	c.cache.Stop()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed.Store(true)
+	if c.sweep != nil {
+		close(c.stop)
+		<-c.sweep
+		c.stop, c.sweep = nil, nil
+	}
 	c.cache.DeleteAll()
 	return nil
 }
 
 func (c *Cacher[K, V]) validate() error {
-	if c.cache == nil {
+	if c.cache == nil || c.closed.Load() {
 		return cache.ErrInValidConnState
 	}
 	return nil
@@ -0,0 +1,56 @@
+package tiered
+
+import (
+	"context"
+
+	"github.com/pthethanh/nano/broker"
+)
+
+type (
+	// Message tells the instances sharing a far cache to evict keys from
+	// their near cache.
+	Message[K comparable] struct {
+		Keys   []K    `json:"keys"`
+		Source string `json:"source"` // Instance that changed the keys, which ignores its own messages.
+	}
+
+	// Channel carries the invalidation messages between the instances
+	// sharing a far cache. Every subscription gets every message: it must
+	// not be load balanced across instances.
+	Channel[K comparable] interface {
+		// Publish sends m to the subscriptions of every instance.
+		Publish(ctx context.Context, m *Message[K]) error
+		// Subscribe calls h with the messages published from now on.
+		Subscribe(ctx context.Context, h func(m *Message[K])) (Subscription, error)
+	}
+
+	// Subscription is a subscription to a Channel.
+	Subscription interface {
+		// Unsubscribe cancels the subscription.
+		Unsubscribe() error
+	}
+
+	brokerChannel[K comparable] struct {
+		b     broker.Broker[Message[K]]
+		topic string
+	}
+)
+
+// Broker returns a Channel publishing the invalidation messages to topic on
+// b. The broker is not opened or closed by the Cacher: its owner does.
+func Broker[K comparable](b broker.Broker[Message[K]], topic string) Channel[K] {
+	return brokerChannel[K]{b: b, topic: topic}
+}
+
+func (c brokerChannel[K]) Publish(ctx context.Context, m *Message[K]) error {
+	return c.b.Publish(ctx, c.topic, m)
+}
+
+func (c brokerChannel[K]) Subscribe(ctx context.Context, h func(m *Message[K])) (Subscription, error) {
+	return c.b.Subscribe(ctx, c.topic, func(e broker.Event[Message[K]]) error {
+		if e.Error() == nil {
+			h(e.Message())
+		}
+		return nil
+	})
+}
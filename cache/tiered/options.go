@@ -0,0 +1,40 @@
+package tiered
+
+import (
+	"time"
+
+	"github.com/pthethanh/nano/cache"
+)
+
+// Option customizes a tiered Cacher.
+type Option[K comparable, V any] func(*Cacher[K, V])
+
+// Near sets the near cache. The default is a cache/memory Cacher.
+func Near[K comparable, V any](near cache.Cacher[K, V]) Option[K, V] {
+	return func(c *Cacher[K, V]) {
+		if near != nil {
+			c.near = near
+		}
+	}
+}
+
+// NearTTL sets how long values are kept in the near cache, bounding how long
+// an instance can serve a value changed elsewhere when an invalidation
+// message is lost. Values set with a shorter TTL keep it. The default is one
+// minute.
+func NearTTL[K comparable, V any](ttl time.Duration) Option[K, V] {
+	return func(c *Cacher[K, V]) {
+		if ttl > 0 {
+			c.nearTTL = ttl
+		}
+	}
+}
+
+// Invalidation sets the channel the instances sharing the far cache evict
+// each other's near copies through. Without it, other instances serve their
+// near copy of a changed key until the near TTL.
+func Invalidation[K comparable, V any](ch Channel[K]) Option[K, V] {
+	return func(c *Cacher[K, V]) {
+		c.channel = ch
+	}
+}
@@ -0,0 +1,223 @@
+// Package tiered provides a two-tier cache: a near cache local to the
+// instance, cache/memory by default, in front of a far cache shared by the
+// instances, such as the Redis cache plugin.
+//
+// Reads are served from the near cache, falling back to the far cache and
+// keeping a copy of the value near for a short TTL, no longer than the value
+// has left to live in the far cache if it is a cache.TTLReader. Writes go through to
+// both, and are announced to the other instances on an invalidation Channel
+// so that they evict their near copies, over a broker.Broker with Broker or
+// over Redis pub/sub with the Redis cache plugin.
+package tiered
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pthethanh/nano/cache"
+	"github.com/pthethanh/nano/cache/memory"
+)
+
+// Cacher is a two-tier cache.Cacher.
+type Cacher[K comparable, V any] struct {
+	near    cache.Cacher[K, V]
+	far     cache.Cacher[K, V]
+	nearTTL time.Duration
+	channel Channel[K]
+	id      string
+	sub     Subscription
+}
+
+var (
+	_ cache.Cacher[string, []byte]  = (*Cacher[string, []byte])(nil)
+	_ cache.Batcher[string, []byte] = (*Cacher[string, []byte])(nil)
+)
+
+// New returns a Cacher in front of far. The near and far caches are opened
+// and closed with the Cacher.
+func New[K comparable, V any](far cache.Cacher[K, V], opts ...Option[K, V]) *Cacher[K, V] {
+	c := &Cacher[K, V]{
+		far:     far,
+		nearTTL: time.Minute,
+		id:      uuid.NewString(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.near == nil {
+		c.near = memory.New[K, V]()
+	}
+	return c
+}
+
+// Open opens the near and far caches, then subscribes to the invalidation
+// channel.
+func (c *Cacher[K, V]) Open(ctx context.Context) error {
+	if err := c.near.Open(ctx); err != nil {
+		return err
+	}
+	if err := c.far.Open(ctx); err != nil {
+		return errors.Join(err, c.near.Close(ctx))
+	}
+	if c.channel == nil || c.sub != nil {
+		return nil
+	}
+	sub, err := c.channel.Subscribe(ctx, c.evict)
+	if err != nil {
+		return errors.Join(err, c.far.Close(ctx), c.near.Close(ctx))
+	}
+	c.sub = sub
+	return nil
+}
+
+// Get returns the near copy of the value of k, or gets it from the far cache
+// and keeps a copy near. The copy is kept for the near TTL, or until the
+// value expires in the far cache if sooner and the far cache is a
+// cache.TTLReader. Otherwise the copy may outlive the value in the far cache.
+func (c *Cacher[K, V]) Get(ctx context.Context, k K) (V, error) {
+	if v, err := c.near.Get(ctx, k); err == nil {
+		return v, nil
+	}
+	v, err := c.far.Get(ctx, k)
+	if err != nil {
+		return v, err
+	}
+	c.keepNear(ctx, map[K]V{k: v})
+	return v, nil
+}
+
+// Set stores the value in the far cache then in the near cache, and tells the
+// other instances to evict their near copies. When that fails, the value is
+// stored but other instances may serve their copy until the near TTL.
+func (c *Cacher[K, V]) Set(ctx context.Context, k K, v V, opts ...cache.SetOption) error {
+	if err := c.far.Set(ctx, k, v, opts...); err != nil {
+		return err
+	}
+	_ = c.near.Set(ctx, k, v, c.nearTTLOf(opts))
+	return c.invalidate(ctx, k)
+}
+
+// Delete removes the value from the far and near caches, and tells the other
+// instances to evict their near copies.
+func (c *Cacher[K, V]) Delete(ctx context.Context, k K) error {
+	if err := c.far.Delete(ctx, k); err != nil {
+		return err
+	}
+	if err := c.near.Delete(ctx, k); err != nil {
+		return err
+	}
+	return c.invalidate(ctx, k)
+}
+
+// GetMany gets the values of keys from the near cache, and the missing ones
+// from the far cache, see Get.
+func (c *Cacher[K, V]) GetMany(ctx context.Context, keys []K) (map[K]V, error) {
+	rs, err := cache.Batch(c.near).GetMany(ctx, keys)
+	if err != nil {
+		rs = make(map[K]V, len(keys))
+	}
+	missing := slices.DeleteFunc(slices.Clone(keys), func(k K) bool {
+		_, ok := rs[k]
+		return ok
+	})
+	if len(missing) == 0 {
+		return rs, nil
+	}
+	far, err := cache.Batch(c.far).GetMany(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	c.keepNear(ctx, far)
+	maps.Copy(rs, far)
+	return rs, nil
+}
+
+// SetMany stores the values of items in the far then near caches, see Set.
+func (c *Cacher[K, V]) SetMany(ctx context.Context, items map[K]V, opts ...cache.SetOption) error {
+	if err := cache.Batch(c.far).SetMany(ctx, items, opts...); err != nil {
+		return err
+	}
+	_ = cache.Batch(c.near).SetMany(ctx, items, c.nearTTLOf(opts))
+	return c.invalidate(ctx, slices.Collect(maps.Keys(items))...)
+}
+
+// DeleteMany removes the values of keys from the far and near caches, see
+// Delete.
+func (c *Cacher[K, V]) DeleteMany(ctx context.Context, keys []K) error {
+	if err := cache.Batch(c.far).DeleteMany(ctx, keys); err != nil {
+		return err
+	}
+	if err := cache.Batch(c.near).DeleteMany(ctx, keys); err != nil {
+		return err
+	}
+	return c.invalidate(ctx, keys...)
+}
+
+// Close unsubscribes from the invalidation channel and closes the near and
+// far caches.
+func (c *Cacher[K, V]) Close(ctx context.Context) error {
+	var err error
+	if c.sub != nil {
+		err = c.sub.Unsubscribe()
+		c.sub = nil
+	}
+	return errors.Join(err, c.near.Close(ctx), c.far.Close(ctx))
+}
+
+// invalidate tells the other instances to evict keys from their near cache.
+func (c *Cacher[K, V]) invalidate(ctx context.Context, keys ...K) error {
+	if c.channel == nil || len(keys) == 0 {
+		return nil
+	}
+	return c.channel.Publish(ctx, &Message[K]{Keys: keys, Source: c.id})
+}
+
+// evict handles the invalidation messages of the other instances.
+func (c *Cacher[K, V]) evict(m *Message[K]) {
+	if m.Source == c.id {
+		return
+	}
+	// A copy that cannot be evicted expires with the near TTL.
+	_ = cache.Batch(c.near).DeleteMany(context.Background(), m.Keys)
+}
+
+// keepNear keeps copies of items, read from the far cache, in the near cache
+// for the near TTL, capped at the time the values have left to live in the
+// far cache if it reports it.
+func (c *Cacher[K, V]) keepNear(ctx context.Context, items map[K]V) {
+	// The near cache only saves round trips: failing to fill it is not an
+	// error.
+	r, ok := c.far.(cache.TTLReader[K])
+	if !ok {
+		_ = cache.Batch(c.near).SetMany(ctx, items, cache.TTL(c.nearTTL))
+		return
+	}
+	ttls, err := r.TTLs(ctx, slices.Collect(maps.Keys(items)))
+	if err != nil {
+		// A copy could outlive the value in the far cache.
+		return
+	}
+	for k, v := range items {
+		ttl, ok := ttls[k]
+		if !ok || ttl > c.nearTTL {
+			ttl = c.nearTTL
+		}
+		if ttl > 0 {
+			_ = c.near.Set(ctx, k, v, cache.TTL(ttl))
+		}
+	}
+}
+
+// nearTTLOf returns the TTL of the near copy of a value set with opts.
+func (c *Cacher[K, V]) nearTTLOf(opts []cache.SetOption) cache.SetOption {
+	o := &cache.SetOptions{}
+	o.Apply(opts...)
+	if o.TTL > 0 && o.TTL < c.nearTTL {
+		return cache.TTL(o.TTL)
+	}
+	return cache.TTL(c.nearTTL)
+}
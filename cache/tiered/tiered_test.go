@@ -0,0 +1,126 @@
+package tiered_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	brokermemory "github.com/pthethanh/nano/broker/memory"
+	"github.com/pthethanh/nano/cache"
+	"github.com/pthethanh/nano/cache/cachetest"
+	"github.com/pthethanh/nano/cache/memory"
+	"github.com/pthethanh/nano/cache/tiered"
+)
+
+func TestConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cacher[string, []byte] {
+		return tiered.New[string, []byte](memory.New[string, []byte](), tiered.NearTTL[string, []byte](10*time.Second))
+	}, cachetest.Synctest())
+}
+
+func open(t *testing.T, c *tiered.Cacher[string, string]) *tiered.Cacher[string, string] {
+	t.Helper()
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close(context.Background()) })
+	return c
+}
+
+func expect(t *testing.T, c cache.Cacher[string, string], k, want string) {
+	t.Helper()
+	if v, err := c.Get(context.Background(), k); err != nil || v != want {
+		t.Errorf("Get(%q) = %q, %v, want %q", k, v, err, want)
+	}
+}
+
+func TestNearCopyExpiresWithTheNearTTL(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx := context.Background()
+		far := memory.New[string, string]()
+		c := open(t, tiered.New[string, string](far, tiered.NearTTL[string, string](time.Minute)))
+		if err := c.Set(ctx, "k", "v1"); err != nil {
+			t.Fatal(err)
+		}
+		// Changed in the far cache only, as by an instance without
+		// invalidation.
+		if err := far.Set(ctx, "k", "v2"); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, c, "k", "v1")
+		time.Sleep(2 * time.Minute)
+		expect(t, c, "k", "v2")
+	})
+}
+
+func TestInvalidationEvictsTheNearCopiesOfOtherInstances(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx := context.Background()
+		b := brokermemory.New[tiered.Message[string]]()
+		if err := b.Open(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close(ctx)
+		far := memory.New[string, string]()
+		newInstance := func() *tiered.Cacher[string, string] {
+			return open(t, tiered.New[string, string](far,
+				tiered.NearTTL[string, string](time.Hour),
+				tiered.Invalidation[string, string](tiered.Broker[string](b, "invalidations")),
+			))
+		}
+		writer, reader := newInstance(), newInstance()
+
+		if err := writer.Set(ctx, "k", "v1"); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, reader, "k", "v1")
+
+		if err := writer.Set(ctx, "k", "v2"); err != nil {
+			t.Fatal(err)
+		}
+		synctest.Wait()
+		expect(t, reader, "k", "v2")
+
+		if err := writer.DeleteMany(ctx, []string{"k"}); err != nil {
+			t.Fatal(err)
+		}
+		synctest.Wait()
+		if _, err := reader.Get(ctx, "k"); !errors.Is(err, cache.ErrNotFound) {
+			t.Errorf("Get() error = %v after Delete on another instance, want %v", err, cache.ErrNotFound)
+		}
+		// The writer ignores its own messages: its near copy is current.
+		if err := writer.Set(ctx, "k", "v3"); err != nil {
+			t.Fatal(err)
+		}
+		synctest.Wait()
+		if err := far.Delete(ctx, "k"); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, writer, "k", "v3")
+	})
+}
+
+func TestNearCopyExpiresWithTheFarValue(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx := context.Background()
+		far := memory.New[string, string]()
+		writer := open(t, tiered.New[string, string](far, tiered.NearTTL[string, string](time.Minute)))
+		reader := open(t, tiered.New[string, string](far, tiered.NearTTL[string, string](time.Minute)))
+		if err := writer.SetMany(ctx, map[string]string{"a": "v", "b": "v"}, cache.TTL(10*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Second)
+		expect(t, reader, "a", "v")
+		if got, err := reader.GetMany(ctx, []string{"b"}); err != nil || got["b"] != "v" {
+			t.Fatalf("GetMany() = %v, %v, want b: v", got, err)
+		}
+		time.Sleep(6 * time.Second)
+		for _, k := range []string{"a", "b"} {
+			if v, err := reader.Get(ctx, k); !errors.Is(err, cache.ErrNotFound) {
+				t.Errorf("Get(%q) = %q, %v after the far value expired, want %v", k, v, err, cache.ErrNotFound)
+			}
+		}
+	})
+}
@@ -0,0 +1,16 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// TTLReader is implemented by caches that report how long their values have
+// left to live, such as cache/memory and the Redis cache plugin. A tiered
+// cache keeps its near copies no longer than that.
+type TTLReader[K comparable] interface {
+	// TTLs returns the remaining time to live of the values of keys.
+	// Values that do not expire are absent from the result, and missing
+	// keys map to zero.
+	TTLs(ctx context.Context, keys []K) (map[K]time.Duration, error)
+}
@@ -138,6 +138,10 @@ type Descriptor struct {
 	group    bool
 }
 
+// Path returns the SourceCodeInfo path of the message as comma-separated
+// integers.
+func (d *Descriptor) Path() string { return d.path }
+
 // TypeName returns the elements of the dotted type name.
 // The package name is not part of this name.
 func (d *Descriptor) TypeName() []string {
@@ -276,6 +280,26 @@ type FileDescriptor struct {
 	proto3 bool // whether to generate proto3 code for this file
 }
 
+// Messages returns all the messages defined in this file, nested ones
+// included.
+func (d *FileDescriptor) Messages() []*Descriptor {
+	return d.desc
+}
+
+// Comments returns the leading comments of the element at path, without the
+// comment markers, or "" if there are none.
+func (d *FileDescriptor) Comments(path string) string {
+	loc, ok := d.comments[path]
+	if !ok {
+		return ""
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(loc.GetLeadingComments(), "\n"), "\n") {
+		lines = append(lines, strings.TrimPrefix(line, " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
 // VarName is the variable name we'll use in the generated code to refer
 // to the compressed bytes of this descriptor. It is not exported, so
 // it is only valid inside the generated package.
@@ -308,11 +332,17 @@ func (d *FileDescriptor) goPackageOption() (impPath GoImportPath, pkg GoPackageN
 
 // goFileName returns the output name for the generated Go file.
 func (d *FileDescriptor) goFileName(pathType pathType, moduleRoot string) string {
+	return d.fileName(defaultGoFileExtension, pathType, moduleRoot)
+}
+
+// fileName returns the output name for a file generated next to the Go
+// file, with the given extension.
+func (d *FileDescriptor) fileName(extension string, pathType pathType, moduleRoot string) string {
 	name := *d.Name
 	if ext := path.Ext(name); ext == ".proto" || ext == ".protodevel" {
 		name = name[:len(name)-len(ext)]
 	}
-	name += defaultGoFileExtension
+	name += extension
 
 	if pathType == pathTypeSourceRelative {
 		return name
@@ -435,8 +465,10 @@ type Generator struct {
 	pathType         pathType // How to generate output filenames.
 	writeOutput      bool
 
-	GenGW    bool
-	GenUtils bool
+	GenGW           bool
+	GenUtils        bool
+	GenAsyncAPI     bool
+	AsyncAPIVersion string // info.version of the AsyncAPI documents.
 }
 
 type pathType int
@@ -515,6 +547,10 @@ func (g *Generator) CommandLineParameters(parameter string) {
 			g.GenGW, _ = strconv.ParseBool(v)
 		case "generate_utils":
 			g.GenUtils, _ = strconv.ParseBool(v)
+		case "generate_asyncapi":
+			g.GenAsyncAPI, _ = strconv.ParseBool(v)
+		case "asyncapi_version":
+			g.AsyncAPIVersion = v
 		default:
 			if len(k) > 0 && k[0] == 'M' {
 				g.ImportMap[k[1:]] = v
@@ -1107,6 +1143,19 @@ func (g *Generator) GenerateAllFiles() {
 	g.Response.SupportedFeatures = proto.Uint64(SupportedFeatures)
 }
 
+// WriteFile adds a file generated next to the Go file of the current file,
+// with the given extension, to the output. It does nothing for the files
+// that are not generated.
+func (g *Generator) WriteFile(extension, content string) {
+	if !g.writeOutput {
+		return
+	}
+	g.Response.File = append(g.Response.File, &plugin.CodeGeneratorResponse_File{
+		Name:    proto.String(g.file.fileName(extension, g.pathType, g.ModuleRoot)),
+		Content: proto.String(content),
+	})
+}
+
 // Run all the plugins associated with the file.
 func (g *Generator) runPlugins(file *FileDescriptor) {
 	for _, p := range plugins {
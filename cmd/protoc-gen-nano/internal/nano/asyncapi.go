@@ -0,0 +1,207 @@
+package nano
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pthethanh/nano/cmd/protoc-gen-nano/internal/generator"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+)
+
+// asyncAPIExtension is the extension of the generated AsyncAPI documents.
+const asyncAPIExtension = ".asyncapi.json"
+
+type (
+	// asyncAPI is an AsyncAPI 3.0 document.
+	asyncAPI struct {
+		AsyncAPI           string               `json:"asyncapi"`
+		Info               asyncAPIInfo         `json:"info"`
+		DefaultContentType string               `json:"defaultContentType"`
+		Channels           map[string]channel   `json:"channels"`
+		Operations         map[string]operation `json:"operations"`
+		Components         components           `json:"components"`
+	}
+
+	asyncAPIInfo struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+
+	channel struct {
+		Address     string         `json:"address"`
+		Description string         `json:"description,omitempty"`
+		Messages    map[string]ref `json:"messages"`
+	}
+
+	operation struct {
+		Action   string `json:"action"`
+		Channel  ref    `json:"channel"`
+		Messages []ref  `json:"messages"`
+	}
+
+	components struct {
+		Messages map[string]message `json:"messages"`
+		Schemas  map[string]schema  `json:"schemas"`
+	}
+
+	message struct {
+		Name    string `json:"name"`
+		Payload ref    `json:"payload"`
+	}
+
+	ref struct {
+		Ref string `json:"$ref"`
+	}
+
+	// schema is a JSON Schema.
+	schema map[string]any
+)
+
+// generateAsyncAPI generates the AsyncAPI document of the topics of file
+// when the generate_asyncapi parameter is set. Payloads are described by the
+// JSON Schema of their protojson mapping.
+func (g *nano) generateAsyncAPI(file *generator.FileDescriptor, topics []*topic) {
+	if !g.gen.GenAsyncAPI || len(topics) == 0 {
+		return
+	}
+	doc := asyncAPI{
+		AsyncAPI: "3.0.0",
+		Info: asyncAPIInfo{
+			Title:   file.GetPackage(),
+			Version: g.gen.AsyncAPIVersion,
+		},
+		DefaultContentType: "application/json",
+		Channels:           make(map[string]channel),
+		Operations:         make(map[string]operation),
+		Components: components{
+			Messages: make(map[string]message),
+			Schemas:  make(map[string]schema),
+		},
+	}
+	if doc.Info.Title == "" {
+		doc.Info.Title = file.GetName()
+	}
+	if doc.Info.Version == "" {
+		doc.Info.Version = "1.0.0"
+	}
+	for _, t := range topics {
+		name := t.goName
+		doc.Channels[name] = channel{
+			Address:     t.name,
+			Description: t.description,
+			Messages:    map[string]ref{name: {Ref: "#/components/messages/" + name}},
+		}
+		msgRef := ref{Ref: "#/channels/" + name + "/messages/" + name}
+		doc.Operations["Publish"+name] = operation{Action: "send", Channel: ref{Ref: "#/channels/" + name}, Messages: []ref{msgRef}}
+		doc.Operations["Subscribe"+name] = operation{Action: "receive", Channel: ref{Ref: "#/channels/" + name}, Messages: []ref{msgRef}}
+		doc.Components.Messages[name] = message{Name: name, Payload: g.messageRef(t.msg, doc.Components.Schemas)}
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		g.gen.Error(err, "marshaling the AsyncAPI document")
+	}
+	g.gen.WriteFile(asyncAPIExtension, string(b)+"\n")
+}
+
+// messageRef returns a reference to the schema of msg, adding it and the
+// schemas it refers to to schemas.
+func (g *nano) messageRef(msg *generator.Descriptor, schemas map[string]schema) ref {
+	name := fullName(msg)
+	r := ref{Ref: "#/components/schemas/" + name}
+	if _, ok := schemas[name]; ok {
+		return r
+	}
+	properties := make(map[string]any)
+	s := schema{"type": "object", "properties": properties}
+	// Set before the fields for recursive messages.
+	schemas[name] = s
+	if description := msg.File().Comments(msg.Path()); description != "" {
+		s["description"] = description
+	}
+	for _, field := range msg.Field {
+		properties[field.GetJsonName()] = g.fieldSchema(field, schemas)
+	}
+	return r
+}
+
+// fieldSchema returns the schema of field.
+func (g *nano) fieldSchema(field *pb.FieldDescriptorProto, schemas map[string]schema) any {
+	var s any
+	switch field.GetType() {
+	case pb.FieldDescriptorProto_TYPE_MESSAGE:
+		msg := g.gen.ObjectNamed(field.GetTypeName()).(*generator.Descriptor)
+		if msg.GetOptions().GetMapEntry() {
+			return schema{"type": "object", "additionalProperties": g.fieldSchema(msg.Field[1], schemas)}
+		}
+		if s = g.wellKnownSchema(msg, schemas); s == nil {
+			s = g.messageRef(msg, schemas)
+		}
+	case pb.FieldDescriptorProto_TYPE_ENUM:
+		enum := g.gen.ObjectNamed(field.GetTypeName()).(*generator.EnumDescriptor)
+		var values []string
+		for _, v := range enum.Value {
+			values = append(values, v.GetName())
+		}
+		s = schema{"type": "string", "enum": values}
+	default:
+		s = scalarSchema(field.GetType())
+	}
+	if field.GetLabel() == pb.FieldDescriptorProto_LABEL_REPEATED {
+		return schema{"type": "array", "items": s}
+	}
+	return s
+}
+
+// wellKnownSchema returns the schema of the protojson mapping of the well
+// known type msg, or nil if msg is not one.
+func (g *nano) wellKnownSchema(msg *generator.Descriptor, schemas map[string]schema) any {
+	switch name := fullName(msg); name {
+	case "google.protobuf.Timestamp":
+		return schema{"type": "string", "format": "date-time"}
+	case "google.protobuf.Duration", "google.protobuf.FieldMask":
+		return schema{"type": "string"}
+	case "google.protobuf.Struct", "google.protobuf.Any", "google.protobuf.Empty":
+		return schema{"type": "object"}
+	case "google.protobuf.ListValue":
+		return schema{"type": "array"}
+	case "google.protobuf.Value":
+		return schema{}
+	default:
+		if strings.HasPrefix(name, "google.protobuf.") && strings.HasSuffix(name, "Value") && len(msg.Field) == 1 {
+			// Wrappers map to their value.
+			return g.fieldSchema(msg.Field[0], schemas)
+		}
+		return nil
+	}
+}
+
+// scalarSchema returns the schema of the protojson mapping of a scalar
+// field type.
+func scalarSchema(typ pb.FieldDescriptorProto_Type) schema {
+	switch typ {
+	case pb.FieldDescriptorProto_TYPE_DOUBLE, pb.FieldDescriptorProto_TYPE_FLOAT:
+		return schema{"type": "number"}
+	case pb.FieldDescriptorProto_TYPE_INT32, pb.FieldDescriptorProto_TYPE_SINT32, pb.FieldDescriptorProto_TYPE_SFIXED32,
+		pb.FieldDescriptorProto_TYPE_UINT32, pb.FieldDescriptorProto_TYPE_FIXED32:
+		return schema{"type": "integer", "format": "int32"}
+	case pb.FieldDescriptorProto_TYPE_INT64, pb.FieldDescriptorProto_TYPE_SINT64, pb.FieldDescriptorProto_TYPE_SFIXED64,
+		pb.FieldDescriptorProto_TYPE_UINT64, pb.FieldDescriptorProto_TYPE_FIXED64:
+		// protojson encodes 64-bit integers as strings.
+		return schema{"type": "string", "format": "int64"}
+	case pb.FieldDescriptorProto_TYPE_BOOL:
+		return schema{"type": "boolean"}
+	case pb.FieldDescriptorProto_TYPE_BYTES:
+		return schema{"type": "string", "contentEncoding": "base64"}
+	default:
+		return schema{"type": "string"}
+	}
+}
+
+// fullName returns the fully qualified proto name of msg.
+func fullName(msg *generator.Descriptor) string {
+	name := strings.Join(msg.TypeName(), ".")
+	if pkg := msg.File().GetPackage(); pkg != "" {
+		name = pkg + "." + name
+	}
+	return name
+}
@@ -30,9 +30,10 @@ func (g *nano) Init(gen *generator.Generator) {
 // P forwards to g.gen.P.
 func (g *nano) P(args ...interface{}) { g.gen.P(args...) }
 
-// Generate generates code for the services in the given file.
+// Generate generates code for the services and the topics in the given file.
 func (g *nano) Generate(file *generator.FileDescriptor) {
-	if len(file.FileDescriptorProto.Service) == 0 {
+	topics := g.topics(file)
+	if len(file.FileDescriptorProto.Service) == 0 && len(topics) == 0 {
 		return
 	}
 	g.generateVars()
@@ -40,6 +41,8 @@ func (g *nano) Generate(file *generator.FileDescriptor) {
 		g.generateService(service)
 		g.generateUtils(service)
 	}
+	g.generateTopics(topics)
+	g.generateAsyncAPI(file, topics)
 }
 
 func (g *nano) generateVars() {
@@ -48,14 +51,17 @@ func (g *nano) generateVars() {
 
 // GenerateImports generates the import declaration for this file.
 func (g *nano) GenerateImports(file *generator.FileDescriptor, imports map[generator.GoImportPath]generator.GoPackageName) {
-	if len(file.FileDescriptorProto.Service) == 0 {
+	hasService := len(file.FileDescriptorProto.Service) > 0
+	if !hasService && len(g.topics(file)) == 0 {
 		return
 	}
 	g.P("import (")
-	g.P("grpc ", `"google.golang.org/grpc"`)
-	g.P(`"github.com/pthethanh/nano/grpc/client"`)
+	if hasService {
+		g.P("grpc ", `"google.golang.org/grpc"`)
+		g.P(`"github.com/pthethanh/nano/grpc/client"`)
+	}
 	g.P(`"context"`)
-	if g.gen.GenGW {
+	if hasService && g.gen.GenGW {
 		g.P(`"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"`)
 	}
 	g.P(")")
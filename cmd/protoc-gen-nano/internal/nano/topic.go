@@ -0,0 +1,125 @@
+package nano
+
+import (
+	"fmt"
+
+	"github.com/pthethanh/nano/cmd/protoc-gen-nano/internal/generator"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// brokerPkg is the import path of the nano broker package.
+	brokerPkg = "github.com/pthethanh/nano/broker"
+
+	// topicField is the field number of the broker.topic message option,
+	// see broker/topic.proto.
+	topicField = 50710
+)
+
+// topic is a message carrying the broker.topic option.
+type topic struct {
+	msg         *generator.Descriptor
+	goName      string
+	name        string
+	description string
+}
+
+// topics returns the messages of file carrying the broker.topic option.
+func (g *nano) topics(file *generator.FileDescriptor) []*topic {
+	var topics []*topic
+	for _, msg := range file.Messages() {
+		opts := msg.GetOptions()
+		if opts == nil || opts.GetMapEntry() {
+			continue
+		}
+		name, description, ok := topicOption(opts.ProtoReflect().GetUnknown())
+		if !ok {
+			continue
+		}
+		goName := generator.CamelCaseSlice(msg.TypeName())
+		if name == "" {
+			g.gen.Fail(fmt.Sprintf("message %s: the broker.topic option has no name", goName))
+		}
+		if description == "" {
+			description = file.Comments(msg.Path())
+		}
+		topics = append(topics, &topic{msg: msg, goName: goName, name: name, description: description})
+	}
+	return topics
+}
+
+// topicOption parses the broker.topic option out of the unknown fields of
+// the options of a message: the plugin does not link the option's Go type.
+func topicOption(b []byte) (name, description string, ok bool) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", false
+		}
+		b = b[n:]
+		if num != topicField || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return "", "", false
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", "", false
+		}
+		b = b[n:]
+		// Repeated occurrences of a message field merge.
+		ok = true
+		for len(v) > 0 {
+			num, typ, n := protowire.ConsumeTag(v)
+			if n < 0 {
+				return "", "", false
+			}
+			v = v[n:]
+			if typ != protowire.BytesType || (num != 1 && num != 2) {
+				if n = protowire.ConsumeFieldValue(num, typ, v); n < 0 {
+					return "", "", false
+				}
+				v = v[n:]
+				continue
+			}
+			s, n := protowire.ConsumeString(v)
+			if n < 0 {
+				return "", "", false
+			}
+			v = v[n:]
+			if num == 1 {
+				name = s
+			} else {
+				description = s
+			}
+		}
+	}
+	return name, description, ok
+}
+
+// generateTopics generates the topic constant and the typed Publish and
+// Subscribe helpers of every topic.
+func (g *nano) generateTopics(topics []*topic) {
+	if len(topics) == 0 {
+		return
+	}
+	broker := string(g.gen.AddImport(brokerPkg))
+	for _, t := range topics {
+		typ := t.goName
+		g.P()
+		g.P("// ", typ, "Topic is the broker topic of ", typ, " messages.")
+		g.P("const ", typ, "Topic = ", fmt.Sprintf("%q", t.name))
+		g.P()
+		g.P("// Publish", typ, " publishes m to ", typ, "Topic through b.")
+		g.P("func Publish", typ, "(ctx context.Context, b ", broker, ".Broker[", typ, "], m *", typ, ", opts ...", broker, ".PublishOption) error {")
+		g.P("return b.Publish(ctx, ", typ, "Topic, m, opts...)")
+		g.P("}")
+		g.P()
+		g.P("// Subscribe", typ, " registers h to consume the ", typ, " messages of ", typ, "Topic through b.")
+		g.P("func Subscribe", typ, "(ctx context.Context, b ", broker, ".Broker[", typ, "], h func(", broker, ".Event[", typ, "]) error, opts ...", broker, ".SubscribeOption) (", broker, ".Subscriber, error) {")
+		g.P("return b.Subscribe(ctx, ", typ, "Topic, h, opts...)")
+		g.P("}")
+	}
+}
@@ -0,0 +1,169 @@
+package nano
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pthethanh/nano/cmd/protoc-gen-nano/internal/generator"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// topicProto is the descriptor of broker/topic.proto.
+var topicProto = &pb.FileDescriptorProto{
+	Name:       proto.String("broker/topic.proto"),
+	Package:    proto.String("broker"),
+	Dependency: []string{"google/protobuf/descriptor.proto"},
+	Syntax:     proto.String("proto3"),
+	Options:    &pb.FileOptions{GoPackage: proto.String("github.com/pthethanh/nano/broker;broker")},
+	MessageType: []*pb.DescriptorProto{{
+		Name: proto.String("TopicOptions"),
+		Field: []*pb.FieldDescriptorProto{
+			stringField("name", 1),
+			stringField("description", 2),
+		},
+	}},
+}
+
+func stringField(name string, number int32) *pb.FieldDescriptorProto {
+	return &pb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    pb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     pb.FieldDescriptorProto_TYPE_STRING.Enum(),
+	}
+}
+
+// topicOptions returns message options carrying the broker.topic option.
+func topicOptions(name string) *pb.MessageOptions {
+	var v []byte
+	v = protowire.AppendTag(v, 1, protowire.BytesType)
+	v = protowire.AppendString(v, name)
+	var b []byte
+	b = protowire.AppendTag(b, topicField, protowire.BytesType)
+	b = protowire.AppendBytes(b, v)
+	opts := &pb.MessageOptions{}
+	opts.ProtoReflect().SetUnknown(b)
+	return opts
+}
+
+func TestGenerate_TypedTopicHelpersAndAsyncAPI(t *testing.T) {
+	orders := &pb.FileDescriptorProto{
+		Name:       proto.String("orders.proto"),
+		Package:    proto.String("shop"),
+		Dependency: []string{"broker/topic.proto"},
+		Syntax:     proto.String("proto3"),
+		Options:    &pb.FileOptions{GoPackage: proto.String("example.com/shop;shop")},
+		MessageType: []*pb.DescriptorProto{
+			{
+				Name:    proto.String("OrderCreated"),
+				Options: topicOptions("orders.created"),
+				Field: []*pb.FieldDescriptorProto{
+					stringField("id", 1),
+					{
+						Name:     proto.String("items"),
+						JsonName: proto.String("items"),
+						Number:   proto.Int32(2),
+						Label:    pb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+						Type:     pb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+						TypeName: proto.String(".shop.Item"),
+					},
+				},
+			},
+			{
+				Name: proto.String("Item"),
+				Field: []*pb.FieldDescriptorProto{{
+					Name:     proto.String("quantity"),
+					JsonName: proto.String("quantity"),
+					Number:   proto.Int32(1),
+					Label:    pb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     pb.FieldDescriptorProto_TYPE_INT64.Enum(),
+				}},
+			},
+		},
+		SourceCodeInfo: &pb.SourceCodeInfo{Location: []*pb.SourceCodeInfo_Location{{
+			Path:            []int32{4, 0},
+			Span:            []int32{0, 0, 0},
+			LeadingComments: proto.String(" OrderCreated is published once an order is placed.\n"),
+		}}},
+	}
+	g := generator.New()
+	g.Request = &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"orders.proto"},
+		Parameter:      proto.String("paths=source_relative,generate_asyncapi=true"),
+		ProtoFile: []*pb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(pb.File_google_protobuf_descriptor_proto),
+			topicProto,
+			orders,
+		},
+	}
+	g.CommandLineParameters(g.Request.GetParameter())
+	g.WrapTypes()
+	g.SetPackageNames()
+	g.BuildTypeNameMap()
+	g.GenerateAllFiles()
+
+	files := make(map[string]string)
+	for _, f := range g.Response.File {
+		files[f.GetName()] = f.GetContent()
+	}
+	code := files["orders.pb.nano.go"]
+	for _, want := range []string{
+		`const OrderCreatedTopic = "orders.created"`,
+		"func PublishOrderCreated(ctx context.Context, b broker.Broker[OrderCreated], m *OrderCreated, opts ...broker.PublishOption) error {",
+		"func SubscribeOrderCreated(ctx context.Context, b broker.Broker[OrderCreated], h func(broker.Event[OrderCreated]) error, opts ...broker.SubscribeOption) (broker.Subscriber, error) {",
+		`broker "github.com/pthethanh/nano/broker"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("generated code lacks %s, got:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "ItemTopic") || strings.Contains(code, "google.golang.org/grpc") {
+		t.Errorf("generated code has helpers for Item or service imports, got:\n%s", code)
+	}
+
+	var doc struct {
+		AsyncAPI string `json:"asyncapi"`
+		Channels map[string]struct {
+			Address     string `json:"address"`
+			Description string `json:"description"`
+		} `json:"channels"`
+		Operations map[string]struct {
+			Action string `json:"action"`
+		} `json:"operations"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal([]byte(files["orders.asyncapi.json"]), &doc); err != nil {
+		t.Fatalf("AsyncAPI document: %v, got files %v", err, files)
+	}
+	ch := doc.Channels["OrderCreated"]
+	if doc.AsyncAPI != "3.0.0" || ch.Address != "orders.created" || ch.Description != "OrderCreated is published once an order is placed." {
+		t.Errorf("got version %s and channel %+v, want 3.0.0 and orders.created with the message comment", doc.AsyncAPI, ch)
+	}
+	if doc.Operations["PublishOrderCreated"].Action != "send" || doc.Operations["SubscribeOrderCreated"].Action != "receive" {
+		t.Errorf("got operations %+v, want send and receive", doc.Operations)
+	}
+	if got := string(doc.Components.Schemas["shop.Item"]); !strings.Contains(got, `"int64"`) {
+		t.Errorf("got Item schema %s, want the int64 quantity as a string", got)
+	}
+}
+
+func TestTopicOption_SkipsOtherOptions(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 3, protowire.VarintType) // deprecated = true
+	b = protowire.AppendVarint(b, 1)
+	if _, _, ok := topicOption(b); ok {
+		t.Fatal("topicOption() ok = true without the option")
+	}
+	b = append(b, topicOptions("orders.created").ProtoReflect().GetUnknown()...)
+	if name, _, ok := topicOption(b); !ok || name != "orders.created" {
+		t.Fatalf("topicOption() = %q, %v, want orders.created", name, ok)
+	}
+}
@@ -38,7 +38,7 @@ require (
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
-replace github.com/pthethanh/nano v0.0.1 => ../../
+//replace github.com/pthethanh/nano v0.0.1 => ../../
 
 //replace github.com/pthethanh/nano/cmd/protoc-gen-nano v0.0.1 => ../../cmd/protoc-gen-nano/
 
@@ -51,4 +51,4 @@ require (
 
 replace github.com/pthethanh/nano/plugins/broker/kafka v0.0.1 => ../../plugins/broker/kafka
 
-replace github.com/pthethanh/nano v0.0.1 => ../../
+//replace github.com/pthethanh/nano v0.0.1 => ../../
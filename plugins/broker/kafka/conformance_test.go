@@ -0,0 +1,20 @@
+package kafka_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/brokertest"
+	"github.com/pthethanh/nano/plugins/broker/kafka"
+)
+
+func TestConformance(t *testing.T) {
+	if os.Getenv("KAFKA_TEST") == "" {
+		t.Skip("Set KAFKA_TEST=1 to run this test (requires local Kafka on :9092)")
+	}
+	brokertest.Run(t, func(t *testing.T) broker.Broker[brokertest.Message] {
+		return kafka.New(kafka.Address[brokertest.Message]("localhost:9092"))
+	}, brokertest.Settle(3*time.Second), brokertest.Timeout(15*time.Second), brokertest.Quiet(time.Second))
+}
@@ -1,38 +1,249 @@
 package kafka
 
 import (
+	"cmp"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
 	"github.com/IBM/sarama"
 	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
 )
 
 // consumerGroupHandler is the implementation of sarama.ConsumerGroupHandler.
 type consumerGroupHandler[T any] struct {
+	*group
 	handler  func(broker.Event[T]) error
 	opts     broker.SubscribeOptions
 	codec    broker.Codec[T]
 	log      logger
 	consumer sarama.ConsumerGroup
+	publish  broker.PublishFunc[T]
+	gate     *broker.Gate
+	metrics  *broker.Metrics
+	// concurrency is the number of records of a partition handled at once,
+	// see PartitionConcurrency.
+	concurrency int
 }
 
-func (*consumerGroupHandler[T]) Setup(_ sarama.ConsumerGroupSession) error   { return nil }
-func (*consumerGroupHandler[T]) Cleanup(_ sarama.ConsumerGroupSession) error { return nil }
 func (h *consumerGroupHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	lag := h.metrics.Lag(claim.Topic())
+	defer lag.Reset()
+	if h.concurrency > 1 {
+		h.consumeConcurrently(session, claim, lag)
+		return nil
+	}
 	for msg := range claim.Messages() {
-		var m T
+		lag.Set(claimLag(claim, msg))
+		if !h.deliver(session, msg, nil) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// consumeConcurrently hands the records of claim to concurrency workers.
+// Records of the same key go to the same worker, so they are still handled
+// in order, and offsets only move past records once they and the earlier
+// ones were acknowledged, see claimOffsets. Nacked records hold back their
+// worker only.
+func (h *consumerGroupHandler[T]) consumeConcurrently(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim, lag *broker.Lag) {
+	offsets := newClaimOffsets()
+	stopped := make(chan struct{})
+	var (
+		once sync.Once
+		wg   sync.WaitGroup
+	)
+	workers := make([]chan *sarama.ConsumerMessage, h.concurrency)
+	for i := range workers {
+		workers[i] = make(chan *sarama.ConsumerMessage)
+		wg.Add(1)
+		go func(msgs <-chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for msg := range msgs {
+				if !h.deliver(session, msg, offsets) {
+					once.Do(func() { close(stopped) })
+					return
+				}
+			}
+		}(workers[i])
+	}
+	defer func() {
+		for _, w := range workers {
+			close(w)
+		}
+		wg.Wait()
+	}()
+	seq := 0
+	for msg := range claim.Messages() {
+		lag.Set(claimLag(claim, msg))
+		offsets.dispatch(msg.Offset)
+		select {
+		case workers[worker(msg, h.concurrency, seq)] <- msg:
+			seq++
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// deliver hands msg to the handler. A record nacked while its handler runs
+// is redelivered after the requested delay before the claim moves on,
+// keeping partition order. Under auto-ack, the record is acknowledged once
+// the handler returns, even when it failed, so it never holds back the
+// offset. Acknowledged records are marked through offsets when the claim is
+// handled concurrently. It reports false if the session ended or the
+// subscription was drained meanwhile.
+func (h *consumerGroupHandler[T]) deliver(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, offsets *claimOffsets) bool {
+	var m T
+	headers := headersFrom(msg.Headers)
+	err := codec.Unmarshal(h.codec, headers, msg.Value, &m)
+	for attempt := 1; ; attempt++ {
+		nacks := make(chan time.Duration, 1)
 		e := &event[T]{
 			m:        &m,
 			topic:    msg.Topic,
 			msg:      msg,
+			headers:  headers,
 			consumer: h.consumer,
 			session:  session,
+			group:    h.group,
+			offsets:  offsets,
+			attempt:  attempt,
+			delivery: broker.NewDelivery(0, func(delay time.Duration) { nacks <- delay }),
 		}
-		if err := h.codec.Unmarshal(msg.Value, &m); err != nil {
+		if err != nil {
 			e.err = err
 			e.reason = broker.ReasonUnmarshalFailure
 		}
-		if err := h.handler(e); err == nil && h.opts.AutoAck {
-			session.MarkMessage(msg, "")
+		if !h.gate.Enter(session.Context()) {
+			return false
+		}
+		if err := broker.Handle(session.Context(), e, h.handler, &h.opts, h.publish); h.opts.AutoAck || errors.Is(err, broker.ErrDeadLettered) {
+			_ = e.Ack()
+		}
+		h.gate.Leave()
+		select {
+		case delay := <-nacks:
+			if sleep(session.Context(), delay) != nil {
+				return false
+			}
+		default:
+			return true
 		}
 	}
-	return nil
+}
+
+// batchConsumerGroupHandler is the sarama.ConsumerGroupHandler of batch
+// subscriptions.
+type batchConsumerGroupHandler[T any] struct {
+	*group
+	handler  broker.BatchHandler[T]
+	opts     broker.SubscribeOptions
+	codec    broker.Codec[T]
+	consumer sarama.ConsumerGroup
+	publish  broker.PublishFunc[T]
+	gate     *broker.Gate
+	metrics  *broker.Metrics
+}
+
+// ConsumeClaim hands the records of claim to the handler in batches of up to
+// BatchSize records, flushing a batch BatchLinger after its first record at
+// the latest. The pending batch is flushed when the claim ends, unless the
+// subscription was drained.
+func (h *batchConsumerGroupHandler[T]) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	size := cmp.Or(h.opts.BatchSize, broker.DefaultBatchSize)
+	var (
+		batch  []broker.Event[T]
+		linger <-chan time.Time
+	)
+	lag := h.metrics.Lag(claim.Topic())
+	defer lag.Reset()
+	flush := func() bool {
+		ok := len(batch) == 0 || h.flush(session, batch)
+		batch, linger = nil, nil
+		return ok
+	}
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				flush()
+				return nil
+			}
+			lag.Set(claimLag(claim, msg))
+			batch = append(batch, h.event(session, msg))
+			if len(batch) == 1 {
+				linger = time.After(cmp.Or(h.opts.BatchLinger, broker.DefaultBatchLinger))
+			}
+			if len(batch) >= size && !flush() {
+				return nil
+			}
+		case <-linger:
+			if !flush() {
+				return nil
+			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// flush hands batch to the handler, marking every record of the batch as
+// consumed under auto-ack once it succeeds. It reports false, leaving the
+// batch unhandled, if the session ended or the subscription was drained.
+func (h *batchConsumerGroupHandler[T]) flush(session sarama.ConsumerGroupSession, batch []broker.Event[T]) bool {
+	if !h.gate.Enter(session.Context()) {
+		return false
+	}
+	defer h.gate.Leave()
+	if err := broker.HandleBatch(session.Context(), batch, h.handler, &h.opts, h.publish); (err == nil && h.opts.AutoAck) || errors.Is(err, broker.ErrDeadLettered) {
+		for _, e := range batch {
+			_ = e.Ack()
+		}
+	}
+	return true
+}
+
+func (h *batchConsumerGroupHandler[T]) event(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) *event[T] {
+	var m T
+	headers := headersFrom(msg.Headers)
+	e := &event[T]{
+		m:        &m,
+		topic:    msg.Topic,
+		msg:      msg,
+		headers:  headers,
+		consumer: h.consumer,
+		session:  session,
+		group:    h.group,
+		attempt:  1,
+		// Records of a batch are not redelivered in-process.
+		delivery: broker.NewDelivery(0, func(time.Duration) {}),
+	}
+	if err := codec.Unmarshal(h.codec, headers, msg.Value, &m); err != nil {
+		e.err = err
+		e.reason = broker.ReasonUnmarshalFailure
+	}
+	return e
+}
+
+// claimLag returns the number of records of claim after msg.
+func claimLag(claim sarama.ConsumerGroupClaim, msg *sarama.ConsumerMessage) int64 {
+	return max(claim.HighWaterMarkOffset()-msg.Offset-1, 0)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
 }
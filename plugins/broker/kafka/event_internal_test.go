@@ -0,0 +1,190 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/pthethanh/nano/broker"
+)
+
+func TestEvent_RecordMetadata(t *testing.T) {
+	ts := time.Unix(100, 0)
+	msg := &sarama.ConsumerMessage{
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("order-1"),
+		Timestamp: ts,
+		Headers:   []*sarama.RecordHeader{{Key: []byte(broker.HeaderMessageID), Value: []byte("m-1")}},
+	}
+	e := &event[string]{msg: msg, headers: headersFrom(msg.Headers)}
+	if e.Partition() != 3 || e.Offset() != 42 || broker.KeyFrom[string](e) != "order-1" {
+		t.Errorf("got partition=%d offset=%d key=%q, want 3, 42 and order-1", e.Partition(), e.Offset(), e.Key())
+	}
+	if e.ID() != "m-1" || !e.Timestamp().Equal(ts) || e.Attempt() != 1 {
+		t.Errorf("got ID=%q timestamp=%v attempt=%d, want m-1, %v, 1", e.ID(), e.Timestamp(), e.Attempt(), ts)
+	}
+}
+
+func TestEvent_TimestampFallsBackToHeader(t *testing.T) {
+	ts := time.Unix(100, 0).UTC()
+	e := &event[string]{
+		msg:     &sarama.ConsumerMessage{},
+		headers: map[string]string{broker.HeaderTimestamp: ts.Format(time.RFC3339Nano)},
+	}
+	if !e.Timestamp().Equal(ts) {
+		t.Errorf("Timestamp() = %v, want %v", e.Timestamp(), ts)
+	}
+}
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	mu      sync.Mutex
+	claims  map[string][]int32
+	marked  []int64
+	next    []string
+	commits int
+}
+
+func (s *fakeSession) Context() context.Context   { return context.Background() }
+func (s *fakeSession) Claims() map[string][]int32 { return s.claims }
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = append(s.next, fmt.Sprintf("%s/%d@%d", topic, partition, offset))
+}
+func (s *fakeSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commits++
+}
+
+func TestDeliver_RedeliversNackedRecordBeforeMovingOn(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		session := &fakeSession{}
+		var attempts []int
+		h := &consumerGroupHandler[string]{
+			handler: func(e broker.Event[string]) error {
+				attempts = append(attempts, e.Attempt())
+				if e.Attempt() == 1 {
+					return e.Nack(time.Second)
+				}
+				return nil
+			},
+			opts:  broker.SubscribeOptions{AutoAck: true},
+			codec: JSONCodec[string]{},
+			gate:  &broker.Gate{},
+		}
+		start := time.Now()
+		if !h.deliver(session, &sarama.ConsumerMessage{Offset: 7, Value: []byte(`"m"`)}, nil) {
+			t.Fatal("deliver() = false, want true")
+		}
+		if fmt.Sprint(attempts) != "[1 2]" || time.Since(start) != time.Second {
+			t.Errorf("got attempts %v after %v, want [1 2] after the 1s nack delay", attempts, time.Since(start))
+		}
+		if fmt.Sprint(session.marked) != "[7]" {
+			t.Errorf("marked offsets %v, want [7] once, after the redelivery succeeded", session.marked)
+		}
+	})
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+func (c *fakeClaim) Topic() string                            { return "orders" }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return 0 }
+
+func TestBatchConsumeClaim_FlushesOnSizeLingerAndClaimEnd(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		session := &fakeSession{}
+		claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage)}
+		var batches []string
+		h := &batchConsumerGroupHandler[string]{
+			handler: func(events []broker.Event[string]) error {
+				var msgs []string
+				for _, e := range events {
+					msgs = append(msgs, *e.Message())
+				}
+				batches = append(batches, fmt.Sprint(msgs))
+				return nil
+			},
+			opts:  broker.SubscribeOptions{AutoAck: true, BatchSize: 2, BatchLinger: time.Second},
+			codec: JSONCodec[string]{},
+			gate:  &broker.Gate{},
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			h.ConsumeClaim(session, claim)
+		}()
+		for i, v := range []string{"a", "b", "c"} {
+			claim.messages <- &sarama.ConsumerMessage{Offset: int64(i), Value: []byte(fmt.Sprintf("%q", v))}
+		}
+		synctest.Wait()
+		if fmt.Sprint(batches) != "[[a b]]" {
+			t.Fatalf("got batches %v, want a full batch [a b]", batches)
+		}
+		time.Sleep(time.Second)
+		synctest.Wait()
+		if fmt.Sprint(batches) != "[[a b] [c]]" {
+			t.Fatalf("got batches %v, want [c] after the 1s linger", batches)
+		}
+		claim.messages <- &sarama.ConsumerMessage{Offset: 3, Value: []byte(`"d"`)}
+		close(claim.messages)
+		<-done
+		if fmt.Sprint(batches) != "[[a b] [c] [d]]" {
+			t.Fatalf("got batches %v, want [d] flushed when the claim ends", batches)
+		}
+		if fmt.Sprint(session.marked) != "[0 1 2 3]" {
+			t.Errorf("marked offsets %v, want every record once its batch succeeded", session.marked)
+		}
+	})
+}
+
+func TestDeliver_HeldBackByPauseAndStoppedByDrain(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		session := &fakeSession{}
+		gate := &broker.Gate{}
+		var handled []int64
+		h := &consumerGroupHandler[string]{
+			handler: func(e broker.Event[string]) error {
+				handled = append(handled, e.(*event[string]).Offset())
+				return nil
+			},
+			opts:  broker.SubscribeOptions{AutoAck: true},
+			codec: JSONCodec[string]{},
+			gate:  gate,
+		}
+		gate.Pause()
+		done := make(chan bool)
+		go func() { done <- h.deliver(session, &sarama.ConsumerMessage{Offset: 1, Value: []byte(`"m"`)}, nil) }()
+		synctest.Wait()
+		if len(handled) != 0 {
+			t.Fatalf("handled %v while paused, want none", handled)
+		}
+		gate.Resume()
+		if !<-done || fmt.Sprint(handled) != "[1]" {
+			t.Fatalf("handled %v after Resume, want [1]", handled)
+		}
+
+		gate.Close()
+		if h.deliver(session, &sarama.ConsumerMessage{Offset: 2, Value: []byte(`"m"`)}, nil) {
+			t.Fatal("deliver() = true after Drain, want false")
+		}
+		if fmt.Sprint(session.marked) != "[1]" {
+			t.Errorf("marked offsets %v, want [1] only, leaving the undelivered record", session.marked)
+		}
+	})
+}
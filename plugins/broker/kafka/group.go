@@ -0,0 +1,165 @@
+package kafka
+
+import (
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+type (
+	// RebalanceHook is called with the session of the consumer group when
+	// partitions are assigned to or revoked from the subscription, see
+	// OnPartitionsAssigned and OnPartitionsRevoked. session.Claims lists the
+	// partitions.
+	RebalanceHook func(session sarama.ConsumerGroupSession) error
+
+	// group implements the Setup and Cleanup of the consumer group handlers
+	// of single and batch subscriptions, and marks their records as
+	// consumed. A nil *group marks records without committing them.
+	group struct {
+		id         string
+		client     sarama.Client
+		startAt    time.Time
+		commit     bool
+		onAssigned RebalanceHook
+		onRevoked  RebalanceHook
+	}
+
+	// claimOffsets tracks the records of a claim handled concurrently, so
+	// that the offset of the group only moves past a record once it and
+	// every earlier record were acknowledged, as if they were acknowledged
+	// in order. A record left unacknowledged holds the offset back, until the
+	// end of the claim if it never is, so that it is redelivered after a
+	// rebalance or restart.
+	claimOffsets struct {
+		mu      sync.Mutex
+		pending []int64 // dispatched and not acknowledged yet, in order
+		acked   map[int64]bool
+	}
+)
+
+// Setup moves the partitions without committed offset to the start time of
+// the OffsetAt option, then calls the OnPartitionsAssigned hook.
+func (g *group) Setup(session sarama.ConsumerGroupSession) error {
+	if g == nil {
+		return nil
+	}
+	if !g.startAt.IsZero() {
+		if err := g.seek(session); err != nil {
+			return err
+		}
+	}
+	if g.onAssigned != nil {
+		return g.onAssigned(session)
+	}
+	return nil
+}
+
+// Cleanup calls the OnPartitionsRevoked hook once the claims of the session
+// stopped, then commits the offsets under CommitOnAck.
+func (g *group) Cleanup(session sarama.ConsumerGroupSession) error {
+	if g == nil {
+		return nil
+	}
+	var err error
+	if g.onRevoked != nil {
+		err = g.onRevoked(session)
+	}
+	if g.commit {
+		session.Commit()
+	}
+	return err
+}
+
+// seek marks the claimed partitions the group has no committed offset for
+// at the first record published at or after the start time.
+func (g *group) seek(session sarama.ConsumerGroupSession) error {
+	coordinator, err := g.client.Coordinator(g.id)
+	if err != nil {
+		return err
+	}
+	claims := session.Claims()
+	committed, err := coordinator.FetchOffset(sarama.NewOffsetFetchRequest(g.client.Config().Version, g.id, claims))
+	if err != nil {
+		return err
+	}
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			if block := committed.GetBlock(topic, partition); block != nil && block.Offset >= 0 {
+				continue
+			}
+			offset, err := g.client.GetOffset(topic, partition, g.startAt.UnixMilli())
+			if err == nil && offset < 0 {
+				// Nothing was published since.
+				offset, err = g.client.GetOffset(topic, partition, sarama.OffsetNewest)
+			}
+			if err != nil {
+				return err
+			}
+			// Without committed offset, marking moves the start position.
+			session.MarkOffset(topic, partition, offset, "")
+		}
+	}
+	return nil
+}
+
+// mark marks msg as consumed, committing it right away under CommitOnAck.
+func (g *group) mark(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) {
+	session.MarkMessage(msg, "")
+	if g != nil && g.commit {
+		session.Commit()
+	}
+}
+
+// markOffset marks the records before offset as consumed, see mark.
+func (g *group) markOffset(session sarama.ConsumerGroupSession, topic string, partition int32, offset int64) {
+	session.MarkOffset(topic, partition, offset, "")
+	if g != nil && g.commit {
+		session.Commit()
+	}
+}
+
+func newClaimOffsets() *claimOffsets {
+	return &claimOffsets{acked: make(map[int64]bool)}
+}
+
+// dispatch records that the record at offset is handed to a worker.
+func (o *claimOffsets) dispatch(offset int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending = append(o.pending, offset)
+}
+
+// ack records that the record at offset was acknowledged, while its handler
+// runs or after it returned. It returns the offset the group can move to, if
+// any.
+func (o *claimOffsets) ack(offset int64) (next int64, ok bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, found := slices.BinarySearch(o.pending, offset); !found {
+		return 0, false
+	}
+	o.acked[offset] = true
+	// Drop the acknowledged records at the head of pending.
+	for len(o.pending) > 0 && o.acked[o.pending[0]] {
+		next, ok = o.pending[0]+1, true
+		delete(o.acked, o.pending[0])
+		o.pending = o.pending[1:]
+	}
+	return next, ok
+}
+
+// worker returns the worker of n that handles msg: records of the same key
+// go to the same worker, keeping their order, and the others are spread
+// round-robin from seq.
+func worker(msg *sarama.ConsumerMessage, n int, seq int) int {
+	if len(msg.Key) == 0 {
+		return seq % n
+	}
+	h := fnv.New32a()
+	h.Write(msg.Key)
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,171 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/pthethanh/nano/broker"
+)
+
+func TestClaimOffsets_MovesPastAcknowledgedRecordsInOrder(t *testing.T) {
+	o := newClaimOffsets()
+	for offset := range int64(4) {
+		o.dispatch(offset)
+	}
+	if next, ok := o.ack(1); ok {
+		t.Fatalf("ack(1) = %d, want no move while 0 is unacknowledged", next)
+	}
+	if next, ok := o.ack(0); !ok || next != 2 {
+		t.Fatalf("ack(0) = %d, %v, want 2, true", next, ok)
+	}
+	// 2 is left unacknowledged, as a failed handler leaves it: it holds the
+	// offset back whatever comes after it.
+	if next, ok := o.ack(3); ok {
+		t.Fatalf("ack(3) = %d, want no move while 2 is unacknowledged", next)
+	}
+	o.dispatch(4)
+	if next, ok := o.ack(4); ok {
+		t.Fatalf("ack(4) = %d, want no move while 2 is unacknowledged", next)
+	}
+	if next, ok := o.ack(2); !ok || next != 5 {
+		t.Fatalf("late ack(2) = %d, %v, want 5, true", next, ok)
+	}
+	if next, ok := o.ack(2); ok {
+		t.Fatalf("second ack(2) = %d, want no move", next)
+	}
+}
+
+func TestConsumeClaim_PartitionConcurrency(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		msgs := []*sarama.ConsumerMessage{
+			{Topic: "orders", Offset: 0, Key: []byte("a"), Value: []byte(`"slow"`)},
+			{Topic: "orders", Offset: 1, Key: []byte("b"), Value: []byte(`"fast"`)},
+		}
+		if worker(msgs[0], 2, 0) == worker(msgs[1], 2, 0) {
+			t.Fatal("the keys of the test share a worker")
+		}
+		session := &fakeSession{}
+		claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, len(msgs))}
+		release := make(chan struct{})
+		h := &consumerGroupHandler[string]{
+			group: &group{commit: true},
+			handler: func(e broker.Event[string]) error {
+				if *e.Message() == "slow" {
+					<-release
+				}
+				return nil
+			},
+			opts:        broker.SubscribeOptions{AutoAck: true},
+			codec:       JSONCodec[string]{},
+			gate:        &broker.Gate{},
+			concurrency: 2,
+		}
+		for _, msg := range msgs {
+			claim.messages <- msg
+		}
+		close(claim.messages)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			h.ConsumeClaim(session, claim)
+		}()
+		synctest.Wait()
+		if len(session.next) != 0 {
+			t.Fatalf("marked %v while the earlier record is in flight, want none", session.next)
+		}
+		close(release)
+		<-done
+		if got := session.next[len(session.next)-1]; got != "orders/0@2" || session.commits == 0 {
+			t.Errorf("marked %v with %d commits, want orders/0@2 committed", session.next, session.commits)
+		}
+	})
+}
+
+func TestConsumeClaim_FailedRecordsDoNotHoldTheOffsetUnderAutoAck(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprint("concurrency ", concurrency), func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				session := &fakeSession{}
+				claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 4)}
+				for i, v := range []string{"ok", "fail", "ok", "ok"} {
+					claim.messages <- &sarama.ConsumerMessage{Topic: "orders", Offset: int64(i), Key: []byte(fmt.Sprint(i)), Value: []byte(`"` + v + `"`)}
+				}
+				close(claim.messages)
+				h := &consumerGroupHandler[string]{
+					group: &group{commit: true},
+					handler: func(e broker.Event[string]) error {
+						if *e.Message() == "fail" {
+							return errors.New("handler failed")
+						}
+						return nil
+					},
+					opts:        broker.SubscribeOptions{AutoAck: true},
+					codec:       JSONCodec[string]{},
+					gate:        &broker.Gate{},
+					concurrency: concurrency,
+				}
+				h.ConsumeClaim(session, claim)
+				if concurrency == 1 {
+					if fmt.Sprint(session.marked) != "[0 1 2 3]" {
+						t.Errorf("marked offsets %v, want [0 1 2 3]", session.marked)
+					}
+					return
+				}
+				if len(session.next) == 0 || session.next[len(session.next)-1] != "orders/0@4" {
+					t.Errorf("marked %v, want the offset moved to orders/0@4", session.next)
+				}
+			})
+		})
+	}
+}
+
+func TestGroup_SetupSeeksUncommittedPartitionsAndCallsHooks(t *testing.T) {
+	mb := sarama.NewMockBroker(t, 1)
+	defer mb.Close()
+	start := time.Unix(1000, 0)
+	mb.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(mb.Addr(), mb.BrokerID()).
+			SetController(mb.BrokerID()).
+			SetLeader("orders", 0, mb.BrokerID()).
+			SetLeader("orders", 1, mb.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "g", mb),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("g", "orders", 0, 5, "", sarama.ErrNoError).
+			SetOffset("g", "orders", 1, -1, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("orders", 1, start.UnixMilli(), 42),
+	})
+	client, err := sarama.NewClient([]string{mb.Addr()}, sarama.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var calls []string
+	hook := func(name string) RebalanceHook {
+		return func(s sarama.ConsumerGroupSession) error {
+			calls = append(calls, fmt.Sprint(name, " ", s.Claims()))
+			return nil
+		}
+	}
+	g := &group{id: "g", client: client, startAt: start, commit: true, onAssigned: hook("assigned"), onRevoked: hook("revoked")}
+	session := &fakeSession{claims: map[string][]int32{"orders": {0, 1}}}
+	if err := g.Setup(session); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if fmt.Sprint(session.next) != "[orders/1@42]" {
+		t.Errorf("marked %v, want the uncommitted partition 1 at the offset of the start time", session.next)
+	}
+	if err := g.Cleanup(session); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if fmt.Sprint(calls) != "[assigned map[orders:[0 1]] revoked map[orders:[0 1]]]" || session.commits != 1 {
+		t.Errorf("got hook calls %v and %d commits, want assigned, revoked and a final commit", calls, session.commits)
+	}
+}
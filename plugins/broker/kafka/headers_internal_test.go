@@ -1,6 +1,11 @@
 package kafka
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/pthethanh/nano/broker"
+)
 
 func TestRecordHeadersFrom_ConvertsMap(t *testing.T) {
 	got := recordHeadersFrom(map[string]string{"a": "1"})
@@ -14,3 +19,51 @@ func TestRecordHeadersFrom_Empty(t *testing.T) {
 		t.Errorf("recordHeadersFrom(nil) = %+v, want nil", got)
 	}
 }
+
+func TestHeadersFrom_RoundTripsRecordHeaders(t *testing.T) {
+	records := recordHeadersFrom(map[string]string{"a": "1", "b": "2"})
+	in := make([]*sarama.RecordHeader, 0, len(records))
+	for i := range records {
+		in = append(in, &records[i])
+	}
+	got := headersFrom(in)
+	if len(got) != 2 || got["a"] != "1" || got["b"] != "2" {
+		t.Errorf("headersFrom() = %v, want map[a:1 b:2]", got)
+	}
+}
+
+// Propagators look trace context up by its lowercase W3C keys, so the keys
+// must come back exactly as published.
+func TestHeadersFrom_KeepsTraceContextKeys(t *testing.T) {
+	want := map[string]string{
+		"traceparent": "00-01000000000000000000000000000000-0100000000000000-01",
+		"tracestate":  "vendor=1",
+		"baggage":     "user=1",
+	}
+	records := recordHeadersFrom(want)
+	in := make([]*sarama.RecordHeader, 0, len(records))
+	for i := range records {
+		in = append(in, &records[i])
+	}
+	got := headersFrom(in)
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("headersFrom()[%s] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestProducerMessage_MapsKeyToRecordKey(t *testing.T) {
+	k := New[string]()
+	msg := "m"
+	m, err := k.producerMessage("orders", &msg, []broker.PublishOption{broker.Key("order-1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := m.Key.Encode(); string(key) != "order-1" {
+		t.Errorf("got record key %q, want order-1", key)
+	}
+	if m, _ = k.producerMessage("orders", &msg, nil); m.Key != nil {
+		t.Errorf("got record key %v, want none without broker.Key", m.Key)
+	}
+}
@@ -2,13 +2,18 @@
 package kafka
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"log/slog"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/google/uuid"
 	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
 )
 
 // Broker is an implementation of broker.Broker using Kafka (via sarama).
@@ -20,16 +25,33 @@ type Broker[T any] struct {
 	async            bool
 	onPublishFailure func(*PublishError[T])
 	onPublishSuccess func(*T)
+	schedule         *broker.Scheduler[T]
+	metrics          *broker.Metrics
 
-	client         sarama.Client
-	syncProducer   sarama.SyncProducer
-	asyncProducer  sarama.AsyncProducer
-	consumerGroups []sarama.ConsumerGroup
-	mu             sync.Mutex
+	// Consumer group settings, see the OffsetEarliest, OffsetAt,
+	// CommitOnAck, OnPartitionsAssigned and PartitionConcurrency options.
+	initialOffset int64
+	startAt       time.Time
+	commitOnAck   bool
+	onAssigned    RebalanceHook
+	onRevoked     RebalanceHook
+	concurrency   int
+
+	client        sarama.Client
+	syncProducer  sarama.SyncProducer
+	asyncProducer sarama.AsyncProducer
+	subscribers   []*subscriber[T]
+	mu            sync.Mutex
 }
 
 var (
-	_ broker.Broker[any] = (*Broker[any])(nil)
+	_ broker.Broker[any]          = (*Broker[any])(nil)
+	_ broker.BatchPublisher[any]  = (*Broker[any])(nil)
+	_ broker.BatchSubscriber[any] = (*Broker[any])(nil)
+	_ broker.Canceler             = (*Broker[any])(nil)
+
+	// ErrInvalidConnectionState indicates that the broker is not open.
+	ErrInvalidConnectionState = errors.New("invalid connection state")
 )
 
 // New returns a new Kafka message broker.
@@ -37,12 +59,20 @@ func New[T any](opts ...Option[T]) *Broker[T] {
 	k := &Broker[T]{
 		conf:  sarama.NewConfig(),
 		log:   slog.Default(),
-		codec: JSONCodec[T]{},
+		codec: codec.JSON[T]{},
 		addrs: []string{"127.0.0.1:9092"},
 	}
 	for _, o := range opts {
 		o(k)
 	}
+	// Only delayed messages go through the scheduler, once they are due.
+	k.schedule = broker.NewScheduler(func(ctx context.Context, topic string, msg *T, opts ...broker.PublishOption) error {
+		err := k.publish(topic, msg, opts)
+		if err != nil {
+			k.log.Log(ctx, slog.LevelError, "delayed publish failed", "topic", topic, "error", err)
+		}
+		return err
+	}, 0)
 	return k
 }
 
@@ -110,26 +140,48 @@ func (k *Broker[T]) Open(context.Context) error {
 		k.client = c
 		k.syncProducer = p
 	}
-	k.consumerGroups = make([]sarama.ConsumerGroup, 0)
+	k.subscribers = make([]*subscriber[T], 0)
 	k.log.Log(context.Background(), slog.LevelInfo, "connected", "address", k.addrs, "async", k.async)
 	return nil
 }
 
-// Publish implements broker.Broker interface.
+// Publish implements broker.Broker interface. broker.Key becomes the record
+// key, so records of the same key go to the same partition and are consumed
+// in order as long as the configured partitioner hashes keys, as sarama's
+// default one does.
+//
+// Kafka has no delayed delivery: messages delayed with broker.Delay or
+// broker.DeliverAt are held by an in-process broker.Scheduler until they are
+// due, so they are lost if the process stops before, and are dropped by
+// Close.
+//
+// Under AsyncPublish the publish metrics of the Metrics option measure the
+// queuing of the message; delivery failures are reported to
+// OnAsyncPublishFailure only.
 func (k *Broker[T]) Publish(ctx context.Context, topic string, msg *T, opts ...broker.PublishOption) error {
+	start := time.Now()
 	var popts broker.PublishOptions
 	popts.Apply(opts...)
+	var err error
+	if popts.Delayed() {
+		err = k.schedule.Publish(ctx, topic, msg, opts...)
+	} else {
+		err = k.publish(topic, msg, opts)
+	}
+	k.metrics.Publish(topic, 1, start, err)
+	return err
+}
+
+// Cancel implements broker.Canceler interface.
+func (k *Broker[T]) Cancel(ctx context.Context, id string) error {
+	return k.schedule.Cancel(id)
+}
 
-	b, err := k.codec.Marshal(msg)
+func (k *Broker[T]) publish(topic string, msg *T, opts []broker.PublishOption) error {
+	m, err := k.producerMessage(topic, msg, opts)
 	if err != nil {
 		return err
 	}
-	m := &sarama.ProducerMessage{
-		Topic:    topic,
-		Value:    sarama.ByteEncoder(b),
-		Headers:  recordHeadersFrom(popts.Headers),
-		Metadata: msg,
-	}
 	if k.async {
 		k.asyncProducer.Input() <- m
 		return nil
@@ -139,39 +191,195 @@ func (k *Broker[T]) Publish(ctx context.Context, topic string, msg *T, opts ...b
 	}
 }
 
+// PublishBatch implements broker.BatchPublisher interface. The sync producer
+// sends the messages in one call, returning sarama.ProducerErrors for those
+// that failed; the async producer queues them all, leaving sarama to group
+// them per Producer.Flush settings. Delayed messages are scheduled one by
+// one.
+func (k *Broker[T]) PublishBatch(ctx context.Context, topic string, msgs []*T, opts ...broker.PublishOption) error {
+	start := time.Now()
+	err := k.publishBatch(ctx, topic, msgs, opts)
+	k.metrics.Publish(topic, len(msgs), start, err)
+	return err
+}
+
+func (k *Broker[T]) publishBatch(ctx context.Context, topic string, msgs []*T, opts []broker.PublishOption) error {
+	var popts broker.PublishOptions
+	popts.Apply(opts...)
+	if popts.Delayed() {
+		for _, msg := range msgs {
+			if err := k.schedule.Publish(ctx, topic, msg, opts...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	ms := make([]*sarama.ProducerMessage, len(msgs))
+	for i, msg := range msgs {
+		m, err := k.producerMessage(topic, msg, opts)
+		if err != nil {
+			return err
+		}
+		ms[i] = m
+	}
+	if k.async {
+		for _, m := range ms {
+			k.asyncProducer.Input() <- m
+		}
+		return nil
+	}
+	return k.syncProducer.SendMessages(ms)
+}
+
+func (k *Broker[T]) producerMessage(topic string, msg *T, opts []broker.PublishOption) (*sarama.ProducerMessage, error) {
+	var popts broker.PublishOptions
+	popts.Apply(opts...)
+	popts.Stamp()
+
+	b, err := codec.Marshal(k.codec, msg, popts.Headers)
+	if err != nil {
+		return nil, err
+	}
+	m := &sarama.ProducerMessage{
+		Topic:    topic,
+		Value:    sarama.ByteEncoder(b),
+		Headers:  recordHeadersFrom(popts.Headers),
+		Metadata: msg,
+	}
+	if popts.Key != "" {
+		// The partitioner sends every record of a key to the same partition.
+		m.Key = sarama.StringEncoder(popts.Key)
+	}
+	return m, nil
+}
+
 // Subscribe implements broker.Broker interface. Each call creates its own
-// consumer group (defaulting to a random group ID via broker.Queue).
+// consumer group (defaulting to a random group ID via broker.Queue). A group
+// without committed offsets starts at the position set by OffsetEarliest,
+// OffsetLatest or OffsetAt; a random group ID never has any.
+//
+// A topic pattern is resolved against the topics of the cluster, like the
+// regex subscriptions of the Java client: topics created or deleted later are
+// picked up within the Metadata.RefreshFrequency of the sarama config, which
+// rejoins the consumer group.
 func (k *Broker[T]) Subscribe(ctx context.Context, topic string, handler func(broker.Event[T]) error, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	opt := k.subscribeOptions(opts)
+	handler = broker.MeasureHandler(k.metrics, handler)
+	return k.subscribe(ctx, topic, opt, handler, func(consumer sarama.ConsumerGroup, gate *broker.Gate, g *group) sarama.ConsumerGroupHandler {
+		return &consumerGroupHandler[T]{
+			group:       g,
+			concurrency: k.concurrency,
+			handler:     handler,
+			opts:        opt,
+			codec:       k.codec,
+			log:         k.log,
+			consumer:    consumer,
+			publish:     k.Publish,
+			gate:        gate,
+			metrics:     k.metrics,
+		}
+	})
+}
+
+// SubscribeBatch implements broker.BatchSubscriber interface. Batches are
+// collected per partition claim, so every batch holds records of a single
+// partition in offset order. Event.Nack has no effect on batched records,
+// and acknowledging a record commits past the earlier records of its
+// partition, as for Subscribe.
+func (k *Broker[T]) SubscribeBatch(ctx context.Context, topic string, handler broker.BatchHandler[T], opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	opt := k.subscribeOptions(opts)
+	handler = broker.MeasureBatchHandler(k.metrics, handler)
+	report := func(e broker.Event[T]) error {
+		return handler([]broker.Event[T]{e})
+	}
+	return k.subscribe(ctx, topic, opt, report, func(consumer sarama.ConsumerGroup, gate *broker.Gate, g *group) sarama.ConsumerGroupHandler {
+		return &batchConsumerGroupHandler[T]{
+			group:    g,
+			handler:  handler,
+			opts:     opt,
+			codec:    k.codec,
+			consumer: consumer,
+			publish:  k.Publish,
+			gate:     gate,
+			metrics:  k.metrics,
+		}
+	})
+}
+
+func (k *Broker[T]) subscribeOptions(opts []broker.SubscribeOption) broker.SubscribeOptions {
 	opt := broker.SubscribeOptions{
 		AutoAck: true,
 		Queue:   uuid.New().String(),
 	}
 	opt.Apply(opts...)
-	consumer, err := k.newConsumerGroup(opt.Queue)
+	return opt
+}
+
+// subscribe joins the consumer group of opt.Queue and consumes topic with the
+// handler built by newHandler until the group is closed, reporting
+// subscription failures to report. The handler must call the subscription's
+// handler between Enter and Leave of gate, and mark records through the
+// group.
+func (k *Broker[T]) subscribe(ctx context.Context, topic string, opt broker.SubscribeOptions, report func(broker.Event[T]) error, newHandler func(sarama.ConsumerGroup, *broker.Gate, *group) sarama.ConsumerGroupHandler) (broker.Subscriber, error) {
+	if err := broker.ValidatePattern(topic); err != nil {
+		return nil, err
+	}
+	consumer, err := sarama.NewConsumerGroup(k.addrs, opt.Queue, k.consumerConfig())
 	if err != nil {
 		return nil, err
 	}
-	consumerHandler := &consumerGroupHandler[T]{
-		handler:  handler,
-		opts:     opt,
-		codec:    k.codec,
-		log:      k.log,
+	sub := &subscriber[T]{
+		broker:   k,
 		consumer: consumer,
+		opts:     opt,
+		t:        topic,
+		gate:     &broker.Gate{},
 	}
-	topics := []string{topic}
+	sub.done, sub.stop = context.WithCancel(context.Background())
+	k.mu.Lock()
+	k.subscribers = append(k.subscribers, sub)
+	k.mu.Unlock()
+	consumerHandler := newHandler(consumer, sub.gate, &group{
+		id:         opt.Queue,
+		client:     k.client,
+		startAt:    k.startAt,
+		commit:     k.commitOnAck,
+		onAssigned: k.onAssigned,
+		onRevoked:  k.onRevoked,
+	})
 	go func() {
 		for {
 			select {
 			case err := <-consumer.Errors():
 				if err != nil {
-					handler(&event[T]{
+					report(&event[T]{
 						topic:  topic,
 						err:    err,
 						reason: broker.ReasonSubscriptionFailure,
 					})
 				}
 			default:
-				err := consumer.Consume(ctx, topics, consumerHandler)
+				topics, consumeCtx, stop := []string{topic}, ctx, context.CancelFunc(func() {})
+				if broker.IsPattern(topic) {
+					var err error
+					topics, consumeCtx, stop, err = k.watchTopics(ctx, topic)
+					if err != nil {
+						report(&event[T]{
+							err:    err,
+							topic:  topic,
+							reason: broker.ReasonSubscriptionFailure,
+						})
+					}
+					if len(topics) == 0 {
+						// Wait for a matching topic to be created.
+						if sleep(sub.done, k.topicRefresh()) != nil {
+							return
+						}
+						continue
+					}
+				}
+				err := consumer.Consume(consumeCtx, topics, consumerHandler)
+				stop()
 				switch err {
 				case nil:
 					// everything is ok, continue
@@ -180,7 +388,7 @@ func (k *Broker[T]) Subscribe(ctx context.Context, topic string, handler func(br
 					return
 				default:
 					// report error to handler
-					handler(&event[T]{
+					report(&event[T]{
 						err:    err,
 						topic:  topic,
 						reason: broker.ReasonSubscriptionFailure,
@@ -190,23 +398,65 @@ func (k *Broker[T]) Subscribe(ctx context.Context, topic string, handler func(br
 		}
 	}()
 	k.log.Log(ctx, slog.LevelInfo, "subscribed successfully", "topic", topic, "queue", opt.Queue)
-	return &subscriber[T]{
-		broker:   k,
-		consumer: consumer,
-		opts:     opt,
-		t:        topic,
-	}, nil
+	return sub, nil
+}
+
+// consumerConfig returns the sarama config of the consumer groups: the
+// config of the broker with the start offset and commit options applied.
+func (k *Broker[T]) consumerConfig() *sarama.Config {
+	if k.initialOffset == 0 && !k.commitOnAck {
+		return k.conf
+	}
+	conf := *k.conf
+	if k.initialOffset != 0 {
+		conf.Consumer.Offsets.Initial = k.initialOffset
+	}
+	if k.commitOnAck {
+		conf.Consumer.Offsets.AutoCommit.Enable = false
+	}
+	return &conf
 }
 
-func (k *Broker[T]) newConsumerGroup(groupID string) (sarama.ConsumerGroup, error) {
-	cg, err := sarama.NewConsumerGroup(k.addrs, groupID, k.conf)
+// watchTopics returns the topics matching pattern and a context derived from
+// ctx that is cancelled once they change, checked every topicRefresh.
+func (k *Broker[T]) watchTopics(ctx context.Context, pattern string) ([]string, context.Context, context.CancelFunc, error) {
+	topics, err := k.matchTopics(pattern)
+	if err != nil || len(topics) == 0 {
+		return nil, ctx, func() {}, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		for sleep(ctx, k.topicRefresh()) == nil {
+			if latest, err := k.matchTopics(pattern); err == nil && !slices.Equal(latest, topics) {
+				cancel()
+				return
+			}
+		}
+	}()
+	return topics, ctx, cancel, nil
+}
+
+// matchTopics returns the sorted topics of the cluster matching pattern.
+func (k *Broker[T]) matchTopics(pattern string) ([]string, error) {
+	if err := k.client.RefreshMetadata(); err != nil {
+		return nil, err
+	}
+	topics, err := k.client.Topics()
 	if err != nil {
 		return nil, err
 	}
-	k.mu.Lock()
-	defer k.mu.Unlock()
-	k.consumerGroups = append(k.consumerGroups, cg)
-	return cg, nil
+	topics = slices.DeleteFunc(topics, func(topic string) bool {
+		return !broker.MatchTopic(pattern, topic)
+	})
+	slices.Sort(topics)
+	return topics, nil
+}
+
+// topicRefresh returns how often the topics matching a pattern are listed
+// again: the metadata refresh frequency of the sarama config, or its default
+// if disabled.
+func (k *Broker[T]) topicRefresh() time.Duration {
+	return cmp.Or(max(k.conf.Metadata.RefreshFrequency, 0), 10*time.Minute)
 }
 
 // String returns the broker name.
@@ -214,28 +464,58 @@ func (k *Broker[T]) String() string {
 	return "kafka"
 }
 
-// Close flushes and closes producers and consumer groups, and closes the
-// underlying client connection.
+// CheckHealth implements health.Checker. The broker is healthy while it is
+// open and the controller of the cluster is reachable.
+func (k *Broker[T]) CheckHealth(ctx context.Context) error {
+	k.mu.Lock()
+	client := k.client
+	k.mu.Unlock()
+	if client == nil || client.Closed() {
+		return ErrInvalidConnectionState
+	}
+	_, err := client.Controller()
+	return err
+}
+
+// Close drops the delayed messages and drains the subscriptions, waiting for
+// the handlers in flight until ctx is done or for broker.DefaultCloseTimeout
+// if ctx has no deadline, then flushes and closes the producers and closes
+// the underlying client connection. It returns the context error if the
+// handlers did not return in time.
 func (k *Broker[T]) Close(ctx context.Context) error {
 	k.log.Log(ctx, slog.LevelInfo, "closing")
+	k.schedule.Close()
 	k.mu.Lock()
 	defer k.mu.Unlock()
 	if k.client == nil {
 		// Open() was never called (or never succeeded): nothing to close.
 		return nil
 	}
-	for _, consumer := range k.consumerGroups {
-		consumer.Close()
+	subs := k.subscribers
+	k.subscribers = nil
+	// Handlers in flight may still publish or subscribe.
+	k.mu.Unlock()
+	ctx, cancel := broker.CloseContext(ctx)
+	defer cancel()
+	for _, sub := range subs {
+		sub.gate.Close()
+	}
+	var err error
+	for _, sub := range subs {
+		if werr := sub.gate.Wait(ctx); werr != nil && err == nil {
+			err = werr
+		}
+		sub.consumer.Close()
 	}
-	k.consumerGroups = nil
+	k.mu.Lock()
 	if k.syncProducer != nil {
 		k.syncProducer.Close()
 	}
 	if k.asyncProducer != nil {
 		k.asyncProducer.Close()
 	}
-	if err := k.client.Close(); err != nil {
-		return err
+	if cerr := k.client.Close(); cerr != nil {
+		return cerr
 	}
-	return nil
+	return err
 }
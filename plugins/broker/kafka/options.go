@@ -1,8 +1,11 @@
 package kafka
 
 import (
+	"time"
+
 	"github.com/IBM/sarama"
 	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/metric"
 )
 
 type Option[T any] func(*Broker[T])
@@ -41,12 +44,97 @@ func OnAsyncPublishSuccess[T any](f func(*T)) Option[T] {
 	}
 }
 
+// Codec sets the codec of messages. Default is codec.JSON. Codecs from the
+// broker/codec package also publish the content-type header.
 func Codec[T any](c broker.Codec[T]) Option[T] {
 	return func(b *Broker[T]) {
 		b.codec = c
 	}
 }
 
+// Metrics reports the standard broker metrics through r, see
+// broker.Metrics. The lag of a subscription is its consumer lag on the
+// partitions claimed by the process.
+func Metrics[T any](r metric.Reporter) Option[T] {
+	return func(b *Broker[T]) {
+		b.metrics = broker.NewMetrics(r)
+	}
+}
+
+// OffsetEarliest starts the consumer groups without committed offsets at the
+// oldest record of their partitions.
+func OffsetEarliest[T any]() Option[T] {
+	return func(b *Broker[T]) {
+		b.initialOffset = sarama.OffsetOldest
+		b.startAt = time.Time{}
+	}
+}
+
+// OffsetLatest starts the consumer groups without committed offsets after
+// the newest record of their partitions, the default of sarama.
+func OffsetLatest[T any]() Option[T] {
+	return func(b *Broker[T]) {
+		b.initialOffset = sarama.OffsetNewest
+		b.startAt = time.Time{}
+	}
+}
+
+// OffsetAt starts the consumer groups without committed offsets at the first
+// record of their partitions with a timestamp at or after t, or after the
+// newest record if there is none. It needs Kafka 0.10.1 or later.
+func OffsetAt[T any](t time.Time) Option[T] {
+	return func(b *Broker[T]) {
+		b.initialOffset = 0
+		b.startAt = t
+	}
+}
+
+// CommitOnAck disables the periodic commit of the offsets of the consumer
+// groups and commits them synchronously on every Event.Ack instead, so that
+// an acknowledged record is not consumed again after a crash. Commit
+// failures are reported like other consumer errors.
+func CommitOnAck[T any]() Option[T] {
+	return func(b *Broker[T]) {
+		b.commitOnAck = true
+	}
+}
+
+// OnPartitionsAssigned sets a hook called when partitions are assigned to a
+// subscription, before their records are consumed. It may move the start
+// of the partitions with session.ResetOffset or session.MarkOffset, for
+// example to offsets stored along with the results of the handler; an error
+// ends the session and is reported to the handler as a subscription
+// failure.
+func OnPartitionsAssigned[T any](f RebalanceHook) Option[T] {
+	return func(b *Broker[T]) {
+		b.onAssigned = f
+	}
+}
+
+// OnPartitionsRevoked sets a hook called when partitions are revoked from a
+// subscription, once their handlers returned and before the offsets are
+// committed a last time.
+func OnPartitionsRevoked[T any](f RebalanceHook) Option[T] {
+	return func(b *Broker[T]) {
+		b.onRevoked = f
+	}
+}
+
+// PartitionConcurrency sets how many records of a partition Subscribe
+// handles at once, one by default. Records of the same key are still
+// handled in order, and the offset of a partition only moves past records
+// once they and the earlier ones were acknowledged: a record acknowledged
+// out of order is consumed again after a restart if an earlier one was not
+// acknowledged yet, and a record never acknowledged holds the offset back
+// until the partition is reassigned. Under auto-ack, records are
+// acknowledged once their handler returns, failed ones included.
+// Batch subscriptions ignore it.
+func PartitionConcurrency[T any](n int) Option[T] {
+	return func(b *Broker[T]) {
+		b.concurrency = n
+	}
+}
+
 func Logger[T any](l logger) Option[T] {
 	return func(b *Broker[T]) {
 		b.log = l
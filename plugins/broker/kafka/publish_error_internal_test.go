@@ -1,7 +1,9 @@
 package kafka
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 	"testing"
 
 	"github.com/IBM/sarama"
@@ -29,3 +31,22 @@ func TestPublishErrorFrom_PreservesTypedFailedMessage(t *testing.T) {
 		t.Errorf("Message = %v, want %v: the failed message must be recoverable from the failure callback for any T, not just T == broker.Message", got.Message, original)
 	}
 }
+
+type recordingLogger struct{ msgs []string }
+
+func (l *recordingLogger) Log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	l.msgs = append(l.msgs, msg)
+}
+
+func TestPublish_ImmediateFailureIsNotLoggedAsDelayed(t *testing.T) {
+	log := &recordingLogger{}
+	k := New(Logger[chan int](log))
+	defer k.schedule.Close()
+	ch := make(chan int)
+	if err := k.Publish(context.Background(), "orders", &ch); err == nil {
+		t.Fatal("Publish() error = nil, want the encoding error")
+	}
+	if len(log.msgs) != 0 {
+		t.Errorf("logged %q, want the error returned only", log.msgs)
+	}
+}
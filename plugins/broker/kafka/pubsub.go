@@ -1,6 +1,10 @@
 package kafka
 
 import (
+	"context"
+	"errors"
+	"time"
+
 	"github.com/IBM/sarama"
 	"github.com/pthethanh/nano/broker"
 )
@@ -10,16 +14,27 @@ type subscriber[T any] struct {
 	consumer sarama.ConsumerGroup
 	t        string
 	opts     broker.SubscribeOptions
+	gate     *broker.Gate
+	// done is cancelled by stop on Unsubscribe.
+	done context.Context
+	stop context.CancelFunc
 }
 
+var _ broker.LifecycleSubscriber = (*subscriber[any])(nil)
+
 type event[T any] struct {
 	topic    string
 	err      error
 	consumer sarama.ConsumerGroup
 	msg      *sarama.ConsumerMessage
+	headers  map[string]string
 	m        *T
 	session  sarama.ConsumerGroupSession
+	group    *group
+	offsets  *claimOffsets
 	reason   broker.Reason
+	attempt  int
+	delivery *broker.Delivery
 }
 
 func (p *event[T]) Topic() string {
@@ -30,8 +45,82 @@ func (p *event[T]) Message() *T {
 	return p.m
 }
 
+func (p *event[T]) Headers() map[string]string {
+	return p.headers
+}
+
+func (p *event[T]) ID() string {
+	return p.headers[broker.HeaderMessageID]
+}
+
+// Timestamp returns the timestamp of the Kafka record, falling back to the
+// broker.HeaderTimestamp header for records without one.
+func (p *event[T]) Timestamp() time.Time {
+	if p.msg != nil && !p.msg.Timestamp.IsZero() {
+		return p.msg.Timestamp
+	}
+	return broker.TimestampFrom(p.headers)
+}
+
+// Attempt counts redeliveries after Nack. A record redelivered after a
+// rebalance or restart carries no record of earlier attempts.
+func (p *event[T]) Attempt() int {
+	return max(p.attempt, 1)
+}
+
+// Key returns the record key, set by broker.Key when publishing.
+func (p *event[T]) Key() string {
+	if p.msg == nil {
+		return ""
+	}
+	return string(p.msg.Key)
+}
+
+// Partition returns the partition the record was read from.
+func (p *event[T]) Partition() int32 {
+	if p.msg == nil {
+		return 0
+	}
+	return p.msg.Partition
+}
+
+// Offset returns the offset of the record within its partition.
+func (p *event[T]) Offset() int64 {
+	if p.msg == nil {
+		return 0
+	}
+	return p.msg.Offset
+}
+
+// Ack marks the record as consumed, committing the group's offset past it,
+// right away under CommitOnAck. Kafka tracks a single offset per partition,
+// so acknowledging a record also commits past the earlier records of its
+// partition: a record left unacknowledged is only redelivered after a
+// rebalance or restart if no later record of its partition was acknowledged
+// before. Kafka has no per-record ack deadline, so broker.AckWait is
+// ignored. Under PartitionConcurrency, the offset only moves past the record
+// once every earlier record of its partition was acknowledged too: there, a
+// record left unacknowledged is redelivered after a rebalance or restart,
+// whatever was acknowledged after it.
 func (p *event[T]) Ack() error {
-	p.session.MarkMessage(p.msg, "")
+	if p.session == nil || !p.delivery.Ack() {
+		return nil
+	}
+	if p.offsets == nil {
+		p.group.mark(p.session, p.msg)
+		return nil
+	}
+	if next, ok := p.offsets.ack(p.msg.Offset); ok {
+		p.group.markOffset(p.session, p.msg.Topic, p.msg.Partition, next)
+	}
+	return nil
+}
+
+// Nack redelivers the record after delay, holding back later records of its
+// partition until then. It only has an effect while the handler runs, and
+// none on the records of a batch subscription.
+func (p *event[T]) Nack(delay time.Duration) error {
+	p.delivery.Nack(delay)
 	return nil
 }
 
@@ -52,6 +141,8 @@ func (s *subscriber[T]) Topic() string {
 }
 
 func (s *subscriber[T]) Unsubscribe() error {
+	s.gate.Close()
+	s.stop()
 	if err := s.consumer.Close(); err != nil {
 		return err
 	}
@@ -60,11 +151,36 @@ func (s *subscriber[T]) Unsubscribe() error {
 	k.mu.Lock()
 	defer k.mu.Unlock()
 
-	for i, cg := range k.consumerGroups {
-		if cg == s.consumer {
-			k.consumerGroups = append(k.consumerGroups[:i], k.consumerGroups[i+1:]...)
+	for i, sub := range k.subscribers {
+		if sub == s {
+			k.subscribers = append(k.subscribers[:i], k.subscribers[i+1:]...)
 			return nil
 		}
 	}
 	return nil
 }
+
+// Pause implements broker.LifecycleSubscriber interface. It stops fetching
+// the records of the claimed partitions, which stay in Kafka meanwhile, and
+// holds back the records already fetched.
+func (s *subscriber[T]) Pause() error {
+	s.gate.Pause()
+	s.consumer.PauseAll()
+	return nil
+}
+
+// Resume implements broker.LifecycleSubscriber interface.
+func (s *subscriber[T]) Resume() error {
+	s.consumer.ResumeAll()
+	s.gate.Resume()
+	return nil
+}
+
+// Drain implements broker.LifecycleSubscriber interface. The records fetched
+// but not handled yet are left unacknowledged, for the other members of the
+// consumer group to consume.
+func (s *subscriber[T]) Drain(ctx context.Context) error {
+	s.gate.Close()
+	err := s.gate.Wait(ctx)
+	return errors.Join(err, s.Unsubscribe())
+}
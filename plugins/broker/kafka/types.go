@@ -2,10 +2,10 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
 	"log/slog"
 
 	"github.com/IBM/sarama"
+	"github.com/pthethanh/nano/broker/codec"
 )
 
 type (
@@ -22,17 +22,12 @@ type (
 		Message *T
 	}
 
-	JSONCodec[T any] struct{}
+	// JSONCodec encodes messages with encoding/json.
+	//
+	// Deprecated: use codec.JSON.
+	JSONCodec[T any] = codec.JSON[T]
 )
 
-func (m JSONCodec[T]) Marshal(v *T) ([]byte, error) {
-	return json.Marshal(v)
-}
-
-func (m JSONCodec[T]) Unmarshal(data []byte, v *T) error {
-	return json.Unmarshal(data, v)
-}
-
 // recordHeadersFrom converts broker.PublishOptions.Headers into sarama
 // record headers.
 func recordHeadersFrom(headers map[string]string) []sarama.RecordHeader {
@@ -46,6 +41,21 @@ func recordHeadersFrom(headers map[string]string) []sarama.RecordHeader {
 	return out
 }
 
+// headersFrom converts sarama record headers into a header map, the inverse
+// of recordHeadersFrom.
+func headersFrom(headers []*sarama.RecordHeader) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(headers))
+	for _, h := range headers {
+		if h != nil {
+			out[string(h.Key)] = string(h.Value)
+		}
+	}
+	return out
+}
+
 // publishErrorFrom maps a sarama async-producer error to a PublishError[T],
 // recovering the original message from the producer message's Metadata
 // (set in Broker.Publish) when present.
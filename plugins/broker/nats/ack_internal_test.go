@@ -1,9 +1,15 @@
 package nats
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"testing/synctest"
+	"time"
 
 	natsgo "github.com/nats-io/nats.go"
+	"github.com/pthethanh/nano/broker"
 )
 
 // White-box test (same package): plain core-NATS messages (as delivered by
@@ -18,3 +24,80 @@ func TestEvent_AckIsANoOpOnPlainCoreNATSMessages(t *testing.T) {
 		t.Fatalf("Ack() error = %v, want nil: plain core NATS has no ack/redelivery concept, so Ack() must be a safe no-op instead of surfacing a confusing JetStream-only error", err)
 	}
 }
+
+func TestHandle_RedeliversNackedAndUnackedMessages(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		n := &Nats[string]{}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m := "hi"
+		var (
+			mu       sync.Mutex
+			attempts []int
+		)
+		got := func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			return fmt.Sprint(attempts)
+		}
+		h := func(e broker.Event[string]) error {
+			mu.Lock()
+			attempts = append(attempts, e.Attempt())
+			mu.Unlock()
+			switch e.Attempt() {
+			case 1:
+				return e.Nack(time.Second)
+			case 2:
+				// Left unacknowledged: redelivered after the ack wait.
+				return nil
+			default:
+				return e.Ack()
+			}
+		}
+		op := &broker.SubscribeOptions{}
+		op.Apply(broker.DisableAutoAck(), broker.AckWait(time.Minute))
+		n.handle(ctx, &event[string]{t: "topic", m: &m, attempt: 1}, h, op, &broker.Gate{})
+
+		time.Sleep(time.Second + time.Minute)
+		synctest.Wait()
+		if got() != "[1 2 3]" {
+			t.Fatalf("got attempts %v, want [1 2 3]", got())
+		}
+		time.Sleep(time.Hour)
+		synctest.Wait()
+		if got() != "[1 2 3]" {
+			t.Errorf("got attempts %v, want no redelivery once acknowledged", got())
+		}
+	})
+}
+
+func TestHandle_RedeliveryHeldBackByPause(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		n := &Nats[string]{}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		m := "hi"
+		attempts := make(chan int, 2)
+		h := func(e broker.Event[string]) error {
+			attempts <- e.Attempt()
+			if e.Attempt() == 1 {
+				return e.Nack(time.Second)
+			}
+			return nil
+		}
+		gate := &broker.Gate{}
+		n.handle(ctx, &event[string]{t: "topic", m: &m, attempt: 1}, h, &broker.SubscribeOptions{AutoAck: true}, gate)
+		<-attempts
+		gate.Pause()
+		time.Sleep(time.Minute)
+		synctest.Wait()
+		if len(attempts) != 0 {
+			t.Fatal("redelivered while paused")
+		}
+		gate.Resume()
+		if got := <-attempts; got != 2 {
+			t.Fatalf("got attempt %d after Resume, want 2", got)
+		}
+		synctest.Wait()
+	})
+}
@@ -2,8 +2,11 @@ package nats_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/pthethanh/nano/broker"
 	"github.com/pthethanh/nano/plugins/broker/nats"
 )
 
@@ -13,3 +16,31 @@ func TestClose_WithoutOpenDoesNotPanic(t *testing.T) {
 		t.Fatalf("Close() error = %v", err)
 	}
 }
+
+func TestClose_ClosesTheConnectionWhenDrainingTimesOut(t *testing.T) {
+	b := openBroker(t, runServer(t))
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	if _, err := b.Subscribe(context.Background(), "orders", func(broker.Event[string]) error {
+		close(started)
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	msg := "m"
+	if err := b.Publish(context.Background(), "orders", &msg); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := b.CheckHealth(context.Background()); err == nil {
+		t.Error("CheckHealth() = nil after Close, want the connection closed")
+	}
+}
@@ -0,0 +1,16 @@
+package nats_test
+
+import (
+	"testing"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/brokertest"
+	"github.com/pthethanh/nano/plugins/broker/nats"
+)
+
+func TestConformance(t *testing.T) {
+	url := runServer(t)
+	brokertest.Run(t, func(t *testing.T) broker.Broker[brokertest.Message] {
+		return nats.New(nats.Address[brokertest.Message](url))
+	})
+}
@@ -1,6 +1,12 @@
 package nats
 
-import "testing"
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pthethanh/nano/broker"
+)
 
 func TestNatsHeaderFrom_ConvertsMap(t *testing.T) {
 	got := natsHeaderFrom(map[string]string{"trace-id": "abc"})
@@ -14,3 +20,98 @@ func TestNatsHeaderFrom_Empty(t *testing.T) {
 		t.Errorf("natsHeaderFrom(nil) = %v, want nil", got)
 	}
 }
+
+func TestHeadersFrom_RoundTripsNatsHeader(t *testing.T) {
+	got := headersFrom(natsHeaderFrom(map[string]string{"trace-id": "abc"}))
+	if got["trace-id"] != "abc" {
+		t.Errorf("headersFrom()[trace-id] = %q, want %q", got["trace-id"], "abc")
+	}
+}
+
+func TestNatsMsgFrom_MapsReplyToHeader(t *testing.T) {
+	got := natsMsgFrom("topic", nil, map[string]string{broker.HeaderReplyTo: "replies"})
+	if got.Reply != "replies" {
+		t.Errorf("natsMsgFrom().Reply = %q, want %q", got.Reply, "replies")
+	}
+}
+
+func TestEventHeadersFrom_ExposesReplySubject(t *testing.T) {
+	got := eventHeadersFrom(&nats.Msg{Reply: "_INBOX.1"})
+	if got[broker.HeaderReplyTo] != "_INBOX.1" {
+		t.Errorf("eventHeadersFrom()[%s] = %q, want %q", broker.HeaderReplyTo, got[broker.HeaderReplyTo], "_INBOX.1")
+	}
+	got = eventHeadersFrom(&nats.Msg{Reply: "_INBOX.1", Header: natsHeaderFrom(map[string]string{broker.HeaderReplyTo: "replies"})})
+	if got[broker.HeaderReplyTo] != "replies" {
+		t.Errorf("eventHeadersFrom()[%s] = %q, want the explicit header %q", broker.HeaderReplyTo, got[broker.HeaderReplyTo], "replies")
+	}
+}
+
+// Propagators look trace context up by its lowercase W3C keys, so the keys
+// must come back exactly as published.
+func TestEventHeadersFrom_KeepsTraceContextKeys(t *testing.T) {
+	in := map[string]string{
+		"traceparent": "00-01000000000000000000000000000000-0100000000000000-01",
+		"tracestate":  "vendor=1",
+		"baggage":     "user=1",
+	}
+	got := eventHeadersFrom(natsMsgFrom("topic", nil, in))
+	for k, v := range in {
+		if got[k] != v {
+			t.Errorf("eventHeadersFrom()[%s] = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestSubjectFrom_AppendsKeyToken(t *testing.T) {
+	if got, err := subjectFrom("orders", ""); err != nil || got != "orders" {
+		t.Errorf("subjectFrom(orders, \"\") = %q, %v, want orders", got, err)
+	}
+	if got, err := subjectFrom("orders", "order-1"); err != nil || got != "orders.order-1" {
+		t.Errorf("subjectFrom(orders, order-1) = %q, %v, want orders.order-1", got, err)
+	}
+	for _, key := range []string{"a.b", "*", ">", "a b"} {
+		if _, err := subjectFrom("orders", key); err == nil {
+			t.Errorf("subjectFrom(orders, %q) error = nil, want an invalid token error", key)
+		}
+	}
+}
+
+func TestDeliver_ReportsConcreteTopicAndKey(t *testing.T) {
+	keyed := nats.Header{HeaderKey: []string{"k1"}}
+	tests := []struct {
+		keySubjects bool
+		subject     string
+		header      nats.Header
+		keyed       bool
+		topic       string
+		key         string
+		skipped     bool
+	}{
+		{subject: "orders.eu", topic: "orders.eu"},
+		{subject: "orders.eu", header: keyed, topic: "orders.eu", key: "k1"},
+		{keySubjects: true, subject: "orders.eu.k1", header: keyed, keyed: true, topic: "orders.eu", key: "k1"},
+		{keySubjects: true, subject: "orders.eu.k1", header: keyed, topic: "orders.eu", key: "k1"},
+		// Published to a deeper topic rather than with a key.
+		{keySubjects: true, subject: "orders.eu.created", keyed: true, skipped: true},
+		{keySubjects: true, subject: "orders.eu.created", header: keyed, keyed: true, skipped: true},
+	}
+	for _, tt := range tests {
+		n := New[string]()
+		n.keySubjects = tt.keySubjects
+		var got broker.Event[string]
+		h := func(e broker.Event[string]) error {
+			got = e
+			return nil
+		}
+		n.deliver(context.Background(), &nats.Msg{Subject: tt.subject, Header: tt.header, Data: []byte(`"m"`)}, tt.keyed, h, &broker.SubscribeOptions{AutoAck: true}, &broker.Gate{})
+		if tt.skipped {
+			if got != nil {
+				t.Errorf("got topic %q and key %q from %s, want it skipped", got.Topic(), broker.KeyFrom(got), tt.subject)
+			}
+			continue
+		}
+		if got == nil || got.Topic() != tt.topic || broker.KeyFrom(got) != tt.key {
+			t.Errorf("got %v from %s, want topic %q and key %q", got, tt.subject, tt.topic, tt.key)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package nats
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pthethanh/nano/broker"
+)
+
+type (
+	// jetStream is the configuration of the JetStream mode, see JetStream.
+	jetStream struct {
+		enabled  bool
+		opts     []nats.JSOpt
+		streams  []nats.StreamConfig
+		consumer nats.ConsumerConfig
+	}
+
+	// acker settles a JetStream message; *nats.Msg implements it.
+	acker interface {
+		Ack(opts ...nats.AckOpt) error
+		Nak(opts ...nats.AckOpt) error
+		NakWithDelay(delay time.Duration, opts ...nats.AckOpt) error
+		Term(opts ...nats.AckOpt) error
+		InProgress(opts ...nats.AckOpt) error
+	}
+)
+
+// defaultAckWait is the ack wait of the consumers created without one, which
+// the server defaults to 30 seconds.
+const defaultAckWait = 30 * time.Second
+
+// keyedSuffix is appended to the durable name of the consumer of the keyed
+// subjects of a queue subscription.
+const keyedSuffix = "_keyed"
+
+// ErrTermUnsupported is returned by Term for events that cannot be
+// terminated.
+var ErrTermUnsupported = errors.New("nats: event does not support Term")
+
+// Term terminates the delivery of e: the message is settled and never
+// redelivered, whatever the max deliver of the consumer. Use it for messages
+// that no retry can fix. Outside of the JetStream mode, it settles the
+// in-process delivery like Ack. It looks through events wrapped by
+// broker.Handle and broker.WithContext, and returns ErrTermUnsupported for
+// events of other brokers.
+func Term[T any](e broker.Event[T]) error {
+	for {
+		switch v := e.(type) {
+		case interface{ Term() error }:
+			return v.Term()
+		case interface{ Unwrap() broker.Event[T] }:
+			e = v.Unwrap()
+		default:
+			return ErrTermUnsupported
+		}
+	}
+}
+
+// openJetStream gets the JetStream context of the connection and provisions
+// the streams: they are created, or updated if they exist.
+func (n *Nats[T]) openJetStream() error {
+	js, err := n.conn.JetStream(n.jetStream.opts...)
+	if err != nil {
+		return err
+	}
+	for _, cfg := range n.jetStream.streams {
+		_, err := js.AddStream(&cfg)
+		if errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+			_, err = js.UpdateStream(&cfg)
+		}
+		if err != nil {
+			return fmt.Errorf("nats: provision stream %s: %w", cfg.Name, err)
+		}
+	}
+	n.js = js
+	return nil
+}
+
+// jsSubscribe subscribes h to the consumer of subject, see consumerFor.
+func (n *Nats[T]) jsSubscribe(subject string, keyed bool, op *broker.SubscribeOptions, h nats.MsgHandler) (*nats.Subscription, error) {
+	stream, consumer, err := n.consumerFor(subject, keyed, op)
+	if err != nil {
+		return nil, err
+	}
+	// Bound consumers are left on the server by Unsubscribe and Drain, so
+	// durable consumers keep their position.
+	opts := []nats.SubOpt{nats.Bind(stream, consumer), nats.ManualAck()}
+	if op.Queue != "" {
+		return n.js.QueueSubscribe(subject, op.Queue, h, opts...)
+	}
+	return n.js.Subscribe(subject, h, opts...)
+}
+
+// consumerFor returns the stream of subject and the name of the consumer to
+// bind to, creating the consumer if needed. Queue subscriptions share the
+// durable consumer named after the queue, which is only created once: the
+// start position and limits of an existing consumer are kept. Other
+// subscriptions get an ephemeral consumer, removed by the server once
+// nothing listens to it.
+func (n *Nats[T]) consumerFor(subject string, keyed bool, op *broker.SubscribeOptions) (stream, consumer string, err error) {
+	stream, err = n.js.StreamNameBySubject(subject)
+	if err != nil {
+		return "", "", err
+	}
+	cfg := consumerConfig(n.jetStream.consumer, subject, keyed, op)
+	if cfg.Durable != "" {
+		info, err := n.durableConsumer(stream, cfg)
+		if err == nil {
+			return stream, info.Name, nil
+		}
+		if !errors.Is(err, nats.ErrConsumerNotFound) {
+			return "", "", err
+		}
+	}
+	cfg.DeliverSubject = n.conn.NewInbox()
+	info, err := n.js.AddConsumer(stream, cfg)
+	if err != nil && cfg.Durable != "" {
+		// Another member of the queue may have created it meanwhile.
+		if info, ierr := n.durableConsumer(stream, cfg); ierr == nil {
+			return stream, info.Name, nil
+		}
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("nats: provision consumer of %s: %w", subject, err)
+	}
+	return stream, info.Name, nil
+}
+
+// durableConsumer returns the info of the durable consumer of cfg, checking
+// that it filters the same subject.
+func (n *Nats[T]) durableConsumer(stream string, cfg *nats.ConsumerConfig) (*nats.ConsumerInfo, error) {
+	info, err := n.js.ConsumerInfo(stream, cfg.Durable)
+	if err != nil {
+		return nil, err
+	}
+	if info.Config.FilterSubject != cfg.FilterSubject {
+		return nil, fmt.Errorf("nats: consumer %s of stream %s filters %q, not %q", cfg.Durable, stream, info.Config.FilterSubject, cfg.FilterSubject)
+	}
+	return info, nil
+}
+
+// consumerConfig returns the configuration of the consumer of subject, based
+// on tmpl. Messages are acknowledged explicitly, and the broker.Queue of op
+// names the durable consumer and its deliver group.
+func consumerConfig(tmpl nats.ConsumerConfig, subject string, keyed bool, op *broker.SubscribeOptions) *nats.ConsumerConfig {
+	cfg := tmpl
+	cfg.FilterSubject = subject
+	cfg.FilterSubjects = nil
+	cfg.AckPolicy = nats.AckExplicitPolicy
+	if op.AckWait > 0 {
+		cfg.AckWait = op.AckWait
+	}
+	cfg.Durable, cfg.Name, cfg.DeliverGroup = "", "", ""
+	if op.Queue != "" {
+		cfg.Durable, cfg.DeliverGroup = op.Queue, op.Queue
+		if keyed {
+			cfg.Durable += keyedSuffix
+		}
+		cfg.Name = cfg.Durable
+	}
+	return &cfg
+}
+
+// handleJetStream delivers e to h. The server tracks the delivery: with
+// auto-ack, the message is acknowledged once h succeeds and negatively
+// acknowledged when it fails, to be redelivered up to the max deliver of the
+// consumer. Otherwise h settles it, and the server redelivers it if it is
+// left unacknowledged past the ack wait of the consumer. Dead-lettered
+// messages are acknowledged in both cases. Between the attempts of
+// broker.Handle, the message is kept in progress, see inProgress.
+func (n *Nats[T]) handleJetStream(ctx context.Context, e *event[T], h func(broker.Event[T]) error, op *broker.SubscribeOptions) {
+	err := broker.Handle(ctx, e, h, n.inProgress(ctx, e, op), n.Publish)
+	switch {
+	case errors.Is(err, broker.ErrDeadLettered):
+		err = e.Ack()
+	case !op.AutoAck:
+		return
+	case err != nil:
+		err = e.Nack(0)
+	default:
+		err = e.Ack()
+	}
+	if err != nil {
+		n.log.Log(ctx, slog.LevelError, "settle message failed", "topic", e.t, "error", err)
+	}
+}
+
+// inProgress returns op with a backoff telling the server that e is still
+// being handled before each retry of broker.Handle, and again every half ack
+// wait while the backoff waits, so that the server does not redeliver the
+// message to another subscriber meanwhile, however long the backoff. The ack
+// wait is the one of op or of the Consumer option, or the server default:
+// a durable consumer created earlier with another one keeps it.
+func (n *Nats[T]) inProgress(ctx context.Context, e *event[T], op *broker.SubscribeOptions) *broker.SubscribeOptions {
+	if op.MaxAttempts <= 1 {
+		return op
+	}
+	ackWait := cmp.Or(op.AckWait, n.jetStream.consumer.AckWait, defaultAckWait)
+	backoff := op.Backoff
+	o := *op
+	o.Backoff = func(attempt int) time.Duration {
+		var d time.Duration
+		if backoff != nil {
+			d = backoff(attempt)
+		}
+		for step := ackWait / 2; ; d -= step {
+			_ = e.js.InProgress()
+			if d <= step {
+				return d
+			}
+			t := time.NewTimer(step)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				// broker.Handle sees ctx done and stops retrying.
+				return 0
+			case <-t.C:
+			}
+		}
+	}
+	return &o
+}
+
+// settled ignores the error of settling a message that is already settled,
+// which the broker.Event contract makes a no-op.
+func settled(err error) error {
+	if errors.Is(err, nats.ErrMsgAlreadyAckd) {
+		return nil
+	}
+	return err
+}
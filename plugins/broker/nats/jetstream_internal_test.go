@@ -0,0 +1,144 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pthethanh/nano/broker"
+)
+
+// fakeAcker records how a JetStream message is settled, like the server
+// does: the first settlement wins. It also records when the message is
+// reported in progress.
+type fakeAcker struct {
+	settled    []string
+	inProgress []time.Time
+}
+
+func (a *fakeAcker) settle(how string) error {
+	if len(a.settled) > 0 {
+		return nats.ErrMsgAlreadyAckd
+	}
+	a.settled = append(a.settled, how)
+	return nil
+}
+
+func (a *fakeAcker) Ack(...nats.AckOpt) error  { return a.settle("ack") }
+func (a *fakeAcker) Nak(...nats.AckOpt) error  { return a.settle("nak") }
+func (a *fakeAcker) Term(...nats.AckOpt) error { return a.settle("term") }
+func (a *fakeAcker) InProgress(...nats.AckOpt) error {
+	a.inProgress = append(a.inProgress, time.Now())
+	return nil
+}
+func (a *fakeAcker) NakWithDelay(delay time.Duration, _ ...nats.AckOpt) error {
+	return a.settle("nak " + delay.String())
+}
+
+func TestConsumerConfig_MapsQueueAndOptions(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	n := New(Consumer[string](nats.ConsumerConfig{MaxAckPending: 10, Durable: "ignored"}), MaxDeliver[string](5), DeliverFrom[string](start))
+	op := &broker.SubscribeOptions{}
+	op.Apply(broker.Queue("workers"), broker.AckWait(time.Minute))
+
+	cfg := consumerConfig(n.jetStream.consumer, "orders.*", true, op)
+	if !n.jetStream.enabled {
+		t.Error("Consumer did not enable the JetStream mode")
+	}
+	if cfg.Durable != "workers_keyed" || cfg.Name != cfg.Durable || cfg.DeliverGroup != "workers" || cfg.FilterSubject != "orders.*" {
+		t.Errorf("got durable %q, name %q, group %q and filter %q, want the queue naming the consumer of orders.*", cfg.Durable, cfg.Name, cfg.DeliverGroup, cfg.FilterSubject)
+	}
+	if cfg.AckPolicy != nats.AckExplicitPolicy || cfg.AckWait != time.Minute || cfg.MaxDeliver != 5 || cfg.MaxAckPending != 10 {
+		t.Errorf("got ack policy %v, ack wait %v, max deliver %d and max ack pending %d", cfg.AckPolicy, cfg.AckWait, cfg.MaxDeliver, cfg.MaxAckPending)
+	}
+	if cfg.DeliverPolicy != nats.DeliverByStartTimePolicy || !cfg.OptStartTime.Equal(start) {
+		t.Errorf("got deliver policy %v from %v, want by start time from %v", cfg.DeliverPolicy, cfg.OptStartTime, start)
+	}
+
+	cfg = consumerConfig(New(DeliverNew[string]()).jetStream.consumer, "orders", false, &broker.SubscribeOptions{})
+	if cfg.Durable != "" || cfg.DeliverGroup != "" || cfg.DeliverPolicy != nats.DeliverNewPolicy || cfg.OptStartTime != nil {
+		t.Errorf("got durable %q, group %q and deliver policy %v, want an ephemeral consumer of new messages", cfg.Durable, cfg.DeliverGroup, cfg.DeliverPolicy)
+	}
+}
+
+func TestHandleJetStream_SettlesWithTheServer(t *testing.T) {
+	fail := errors.New("boom")
+	for _, tc := range []struct {
+		name    string
+		opts    []broker.SubscribeOption
+		handler func(broker.Event[string]) error
+		want    string
+	}{
+		{name: "success is acked", handler: func(broker.Event[string]) error { return nil }, want: "[ack]"},
+		{name: "failure is nacked", handler: func(broker.Event[string]) error { return fail }, want: "[nak]"},
+		{
+			name:    "settled by the handler",
+			opts:    []broker.SubscribeOption{broker.DisableAutoAck()},
+			handler: func(e broker.Event[string]) error { return e.Nack(time.Second) },
+			want:    "[nak 1s]",
+		},
+		{
+			name: "terminated through the retries of Handle",
+			opts: []broker.SubscribeOption{broker.MaxAttempts(2)},
+			handler: func(e broker.Event[string]) error {
+				if e.Attempt() < 4 {
+					return fail
+				}
+				return Term(e)
+			},
+			want: "[term]",
+		},
+		{name: "left unacknowledged", opts: []broker.SubscribeOption{broker.DisableAutoAck()}, handler: func(broker.Event[string]) error { return nil }, want: "[]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := &Nats[string]{log: slog.Default()}
+			op := &broker.SubscribeOptions{AutoAck: true}
+			op.Apply(tc.opts...)
+			m := "hi"
+			acker := &fakeAcker{}
+			n.handleJetStream(context.Background(), &event[string]{t: "orders", m: &m, attempt: 3, js: acker}, tc.handler, op)
+			if got := fmt.Sprint(acker.settled); got != tc.want {
+				t.Errorf("settled %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandleJetStream_KeepsTheMessageInProgressBetweenAttempts(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		n := &Nats[string]{log: slog.Default()}
+		op := &broker.SubscribeOptions{AutoAck: true}
+		op.Apply(broker.AckWait(10*time.Second), broker.MaxAttempts(3), broker.Backoff(func(int) time.Duration { return time.Minute }))
+		m := "hi"
+		acker := &fakeAcker{}
+		var attempts []time.Time
+		n.handleJetStream(context.Background(), &event[string]{t: "orders", m: &m, js: acker}, func(broker.Event[string]) error {
+			attempts = append(attempts, time.Now())
+			return errors.New("boom")
+		}, op)
+
+		if len(attempts) != 3 || fmt.Sprint(acker.settled) != "[nak]" {
+			t.Fatalf("got %d attempts settled %v, want 3 attempts and a nak", len(attempts), acker.settled)
+		}
+		// The server must hear from the subscriber within every ack wait
+		// from the first attempt to the last.
+		last := attempts[0]
+		for _, at := range append(acker.inProgress, attempts[2]) {
+			if gap := at.Sub(last); gap > 10*time.Second {
+				t.Fatalf("message left without news for %v, longer than the ack wait", gap)
+			}
+			last = at
+		}
+	})
+}
+
+func TestTerm_UnsupportedEvent(t *testing.T) {
+	if err := Term[string](broker.Event[string](nil)); !errors.Is(err, ErrTermUnsupported) {
+		t.Fatalf("Term() error = %v, want %v", err, ErrTermUnsupported)
+	}
+}
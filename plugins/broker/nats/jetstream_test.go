@@ -0,0 +1,79 @@
+package nats_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/plugins/broker/nats"
+)
+
+func TestJetStream_RedeliversUntilAckedAndResumesDurableQueue(t *testing.T) {
+	url := runServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream := "NANO_TEST"
+	topic := "nano-test.orders"
+	b := nats.New(
+		nats.Address[string](url),
+		nats.Stream[string](natsgo.StreamConfig{Name: stream, Subjects: []string{topic, topic + ".*"}, Storage: natsgo.MemoryStorage}),
+		nats.MaxDeliver[string](3),
+		nats.DeliverAll[string](),
+	)
+	if err := b.Open(ctx); err != nil {
+		t.Fatalf("failed to open broker: %v", err)
+	}
+	defer b.Close(ctx)
+
+	msg := "hello jetstream"
+	if err := b.Publish(ctx, topic, &msg); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	attempts := make(chan int, 3)
+	sub, err := b.Subscribe(ctx, topic, func(e broker.Event[string]) error {
+		attempts <- e.Attempt()
+		if e.Attempt() == 1 {
+			return e.Nack(0)
+		}
+		return e.Ack()
+	}, broker.Queue("workers"), broker.DisableAutoAck())
+	if err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	for want := 1; want <= 2; want++ {
+		select {
+		case got := <-attempts:
+			if got != want {
+				t.Fatalf("got attempt %d, want %d", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("did not receive attempt %d in time", want)
+		}
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("unsubscribe failed: %v", err)
+	}
+
+	// The durable consumer of the queue resumes after the acked message.
+	next := "second"
+	if err := b.Publish(ctx, topic, &next); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	received := make(chan string, 2)
+	if _, err := b.Subscribe(ctx, topic, func(e broker.Event[string]) error {
+		received <- *e.Message()
+		return nil
+	}, broker.Queue("workers")); err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != next {
+			t.Fatalf("got %q, want %q", got, next)
+		}
+	case <-ctx.Done():
+		t.Fatal("did not receive message in time")
+	}
+}
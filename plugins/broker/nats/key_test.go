@@ -0,0 +1,113 @@
+package nats_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/plugins/broker/nats"
+)
+
+type delivery struct {
+	topic, key, msg string
+}
+
+// subscribeAll subscribes to topic on b and returns the deliveries.
+func subscribeAll(t *testing.T, b *nats.Nats[string], topic string) <-chan delivery {
+	t.Helper()
+	ch := make(chan delivery, 10)
+	if _, err := b.Subscribe(context.Background(), topic, func(e broker.Event[string]) error {
+		ch <- delivery{topic: e.Topic(), key: broker.KeyFrom(e), msg: *e.Message()}
+		return nil
+	}); err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	return ch
+}
+
+func openBroker(t *testing.T, url string, opts ...nats.Option[string]) *nats.Nats[string] {
+	t.Helper()
+	b := nats.New(append([]nats.Option[string]{nats.Address[string](url)}, opts...)...)
+	if err := b.Open(context.Background()); err != nil {
+		t.Fatalf("failed to open broker: %v", err)
+	}
+	t.Cleanup(func() { b.Close(context.Background()) })
+	return b
+}
+
+// expectOnly checks that ch receives want and nothing else.
+func expectOnly(t *testing.T, ch <-chan delivery, want ...delivery) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-ch:
+			if got != w {
+				t.Errorf("got %+v, want %+v", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("did not receive %+v in time", w)
+		}
+	}
+	select {
+	case got := <-ch:
+		t.Errorf("got unexpected %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestKey_TravelsInAHeader(t *testing.T) {
+	url := runServer(t)
+	b := openBroker(t, url)
+	orders := subscribeAll(t, b, "orders")
+	pattern := subscribeAll(t, b, "orders.*")
+
+	msg := "m"
+	if err := b.Publish(context.Background(), "orders", &msg, broker.Key("k1")); err != nil {
+		t.Fatal(err)
+	}
+	// Traffic of a deeper topic is not mistaken for a keyed message.
+	if err := b.Publish(context.Background(), "orders.created", &msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(context.Background(), "orders.created.eu", &msg); err != nil {
+		t.Fatal(err)
+	}
+	expectOnly(t, orders, delivery{topic: "orders", key: "k1", msg: "m"})
+	expectOnly(t, pattern, delivery{topic: "orders.created", msg: "m"})
+}
+
+func TestKeySubjects_PublishesToTheKeyToken(t *testing.T) {
+	url := runServer(t)
+	b := openBroker(t, url, nats.KeySubjects[string]())
+	orders := subscribeAll(t, b, "orders")
+	pattern := subscribeAll(t, b, "orders.*")
+
+	conn, err := natsgo.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw, err := conn.SubscribeSync("orders.k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := "m"
+	if err := b.Publish(context.Background(), "orders", &msg, broker.Key("k1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(context.Background(), "orders.created", &msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.NextMsg(time.Second); err != nil {
+		t.Errorf("keyed message was not published to orders.k1: %v", err)
+	}
+	expectOnly(t, orders, delivery{topic: "orders", key: "k1", msg: "m"})
+	// The keyed message matches the pattern subject as well.
+	expectOnly(t, pattern, delivery{topic: "orders", key: "k1", msg: "m"}, delivery{topic: "orders.created", msg: "m"})
+}
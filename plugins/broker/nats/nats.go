@@ -2,13 +2,19 @@
 package nats
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
 )
 
 type (
@@ -18,8 +24,17 @@ type (
 		opts []nats.Option
 		log  logger
 
-		addrs []string
-		codec broker.Codec[T]
+		// js is set in the JetStream mode, see JetStream.
+		js        nats.JetStreamContext
+		jetStream jetStream
+
+		addrs       []string
+		codec       broker.Codec[T]
+		metrics     *broker.Metrics
+		keySubjects bool
+
+		mu   sync.Mutex
+		subs []*subscriber
 	}
 
 	// Option is an optional configuration.
@@ -28,13 +43,18 @@ type (
 
 var (
 	_ broker.Broker[any] = (*Nats[any])(nil)
+
+	_ broker.LifecycleSubscriber = (*subscriber)(nil)
 )
 
+// flushTimeout bounds the flush of the published messages by Close.
+const flushTimeout = time.Second
+
 // New return a new NATs message broker.
 func New[T any](opts ...Option[T]) *Nats[T] {
 	n := &Nats[T]{
 		log:   slog.Default(),
-		codec: JSONCodec[T]{},
+		codec: codec.JSON[T]{},
 		addrs: []string{"127.0.0.1:4222"},
 	}
 	// apply the options.
@@ -44,97 +64,325 @@ func New[T any](opts ...Option[T]) *Nats[T] {
 	return n
 }
 
-// Open connect to target server.
+// Open connect to target server. In the JetStream mode, it also provisions
+// the streams given with Stream.
 func (n *Nats[T]) Open(ctx context.Context) error {
 	conn, err := nats.Connect(strings.Join(n.addrs, ","), n.opts...)
 	if err != nil {
 		return err
 	}
 	n.conn = conn
+	if n.jetStream.enabled {
+		if err := n.openJetStream(); err != nil {
+			conn.Close()
+			n.conn = nil
+			return err
+		}
+	}
 	return nil
 }
 
-// Publish implements broker.Broker interface.
+// Publish implements broker.Broker interface. The key of a message published
+// with broker.Key travels in the HeaderKey header; under the KeySubjects
+// option the message also goes to the subject topic.<key>, so server-side
+// subject mappings can partition messages by key. Core NATS queue groups do
+// not keep keys on one member. NATS has no delayed delivery: delayed
+// messages are rejected with broker.ErrDelayUnsupported. In the JetStream mode, Publish waits for
+// the stream to store the message, which the stream deduplicates by its
+// broker.HeaderMessageID.
 func (n *Nats[T]) Publish(ctx context.Context, topic string, m *T, opts ...broker.PublishOption) error {
+	start := time.Now()
+	err := n.publish(ctx, topic, m, opts)
+	n.metrics.Publish(topic, 1, start, err)
+	return err
+}
+
+func (n *Nats[T]) publish(ctx context.Context, topic string, m *T, opts []broker.PublishOption) error {
 	var popts broker.PublishOptions
 	popts.Apply(opts...)
+	if popts.Delayed() {
+		return broker.ErrDelayUnsupported
+	}
+	popts.Stamp()
 
-	b, err := n.codec.Marshal(m)
+	subject, err := n.subject(topic, &popts)
+	if err != nil {
+		return err
+	}
+	b, err := codec.Marshal(n.codec, m, popts.Headers)
 	if err != nil {
 		return err
 	}
-	if len(popts.Headers) == 0 {
-		return n.conn.Publish(topic, b)
+	msg := natsMsgFrom(subject, b, popts.Headers)
+	if n.js == nil {
+		return n.conn.PublishMsg(msg)
 	}
-	return n.conn.PublishMsg(&nats.Msg{
-		Subject: topic,
-		Data:    b,
-		Header:  natsHeaderFrom(popts.Headers),
-	})
+	// The reply subject is taken by the acknowledgement of the stream.
+	msg.Reply = ""
+	_, err = n.js.PublishMsg(msg, nats.MsgId(popts.Headers[broker.HeaderMessageID]), nats.Context(ctx))
+	return err
+}
+
+// Request publishes m to topic as a native NATS request and waits for a
+// single reply until ctx is done, returning the reply and its headers. It is
+// the fast path rpc.Client uses when requests and replies share the message
+// type T, avoiding a reply subscription and correlation IDs. Requests go
+// through core NATS in the JetStream mode too.
+func (n *Nats[T]) Request(ctx context.Context, topic string, m *T, opts ...broker.PublishOption) (*T, map[string]string, error) {
+	var popts broker.PublishOptions
+	popts.Apply(opts...)
+	popts.Stamp()
+
+	subject, err := n.subject(topic, &popts)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := codec.Marshal(n.codec, m, popts.Headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := natsMsgFrom(subject, b, popts.Headers)
+	// The connection picks its own reply inbox.
+	msg.Reply = ""
+	reply, err := n.conn.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var v T
+	headers := headersFrom(reply.Header)
+	if err := codec.Unmarshal(n.codec, headers, reply.Data, &v); err != nil {
+		return nil, nil, err
+	}
+	return &v, headers, nil
+}
+
+// subject returns the subject of a message published to topic with popts,
+// recording its key in the HeaderKey header.
+func (n *Nats[T]) subject(topic string, popts *broker.PublishOptions) (string, error) {
+	if popts.Key == "" {
+		return topic, nil
+	}
+	popts.Headers[HeaderKey] = popts.Key
+	if !n.keySubjects {
+		return topic, nil
+	}
+	return subjectFrom(topic, popts.Key)
 }
 
-// Subscribe implements broker.Broker interface.
+// Subscribe implements broker.Broker interface. Topic patterns share the
+// syntax of NATS wildcards and are subscribed to as is; events report the
+// subject they were published to as their topic. Under the KeySubjects
+// option, a subscription also receives the messages published to topic with
+// a key, see Publish, and events report their topic without the key token.
+//
+// In the JetStream mode, the subscription consumes from the stream of topic
+// through a consumer, see Consumer, and events are settled with the server:
+// Nack and unacknowledged messages are redelivered by it, and Term stops the
+// redeliveries of a message. A broker.Queue names a durable consumer shared
+// by the members of the queue, which resumes where it left off. The keyed
+// subjects are only consumed when a stream captures them.
 func (n *Nats[T]) Subscribe(ctx context.Context, topic string, h func(broker.Event[T]) error, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
 	op := &broker.SubscribeOptions{
 		AutoAck: true,
 	}
 	op.Apply(opts...)
-	msgHandler := func(msg *nats.Msg) {
-		var m T
-		if err := n.codec.Unmarshal(msg.Data, &m); err != nil {
-			h(&event[T]{
-				t:      topic,
-				m:      &m,
-				msg:    msg,
-				err:    err,
-				reason: broker.ReasonUnmarshalFailure,
-			})
-			return
+	h = broker.MeasureHandler(n.metrics, h)
+	// ctx bounds redelivery backoff and is cancelled on Unsubscribe, since
+	// the Subscribe ctx may be request-scoped.
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &subscriber{
+		t:      topic,
+		gate:   &broker.Gate{},
+		cancel: cancel,
+	}
+	// lags report the pending messages of every subscription.
+	var lags []*broker.Lag
+	s.remove = func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.subs = slices.DeleteFunc(n.subs, func(sub *subscriber) bool { return sub == s })
+		for _, lag := range lags {
+			lag.Reset()
 		}
-		h(&event[T]{
-			t:   topic,
-			m:   &m,
-			msg: msg,
-		})
 	}
-	if op.Queue != "" {
-		sub, err := n.conn.QueueSubscribe(topic, op.Queue, msgHandler)
+	// keyed is set for the subscription to the keyed subjects, whose last
+	// token is the key.
+	msgHandler := func(keyed bool) nats.MsgHandler {
+		lag := n.metrics.Lag(topic)
+		lags = append(lags, lag)
+		return func(msg *nats.Msg) {
+			if lag != nil {
+				if pending, ok := n.pending(msg); ok {
+					lag.Set(pending)
+				}
+			}
+			// Pause holds the messages back in the client's pending buffer.
+			if !s.gate.Enter(ctx) {
+				return
+			}
+			defer s.gate.Leave()
+			n.deliver(ctx, msg, keyed, h, op, s.gate)
+		}
+	}
+	subjects := []string{topic}
+	if n.keySubjects && !strings.HasSuffix(topic, ">") {
+		// Keyed messages; a full wildcard already matches them.
+		subjects = append(subjects, topic+".*")
+	}
+	for i, subject := range subjects {
+		keyed := i > 0
+		var (
+			sub *nats.Subscription
+			err error
+		)
+		switch {
+		case n.js != nil:
+			sub, err = n.jsSubscribe(subject, keyed, op, msgHandler(keyed))
+			if keyed && errors.Is(err, nats.ErrNoMatchingStream) {
+				continue
+			}
+		case op.Queue != "":
+			sub, err = n.conn.QueueSubscribe(subject, op.Queue, msgHandler(keyed))
+		default:
+			sub, err = n.conn.Subscribe(subject, msgHandler(keyed))
+		}
 		if err != nil {
+			s.Unsubscribe()
 			return nil, err
 		}
-		return &subscriber{
-			t: topic,
-			s: sub,
-		}, nil
+		s.subs = append(s.subs, sub)
 	}
-	sub, err := n.conn.Subscribe(topic, msgHandler)
-	if err != nil {
-		return nil, err
+	n.mu.Lock()
+	n.subs = append(n.subs, s)
+	n.mu.Unlock()
+	return s, nil
+}
+
+// deliver decodes msg into an event and hands it to h. The event reports
+// the subject as its topic, without the key token under the KeySubjects
+// option. The subscription to the keyed subjects of a topic skips the
+// messages that were published to one of them without the matching key,
+// which belong to another topic.
+func (n *Nats[T]) deliver(ctx context.Context, msg *nats.Msg, keyed bool, h func(broker.Event[T]) error, op *broker.SubscribeOptions, gate *broker.Gate) {
+	var m T
+	e := &event[T]{
+		t:       msg.Subject,
+		m:       &m,
+		msg:     msg,
+		headers: eventHeadersFrom(msg),
+		attempt: 1,
+	}
+	e.key = e.headers[HeaderKey]
+	if topic, ok := strings.CutSuffix(msg.Subject, "."+e.key); n.keySubjects && e.key != "" && ok {
+		e.t = topic
+	} else if keyed {
+		if n.js != nil {
+			// Acknowledged so that the consumer does not redeliver it.
+			_ = msg.Ack()
+		}
+		return
+	}
+	if n.js != nil {
+		e.js = msg
+		if md, err := msg.Metadata(); err == nil {
+			e.attempt = int(md.NumDelivered)
+		}
+	}
+	if err := codec.Unmarshal(n.codec, e.headers, msg.Data, &m); err != nil {
+		e.err, e.reason = err, broker.ReasonUnmarshalFailure
+		h(e)
+		if e.js != nil {
+			// No redelivery can decode it.
+			_ = e.Term()
+		}
+		return
+	}
+	if e.js != nil {
+		n.handleJetStream(ctx, e, h, op)
+		return
+	}
+	n.handle(ctx, e, h, op, gate)
+}
+
+// pending returns the number of messages waiting to be handled by the
+// subscription of msg: the pending messages of the consumer in the JetStream
+// mode, the client pending buffer otherwise.
+func (n *Nats[T]) pending(msg *nats.Msg) (int64, bool) {
+	if n.js != nil {
+		md, err := msg.Metadata()
+		if err != nil {
+			return 0, false
+		}
+		return int64(md.NumPending), true
+	}
+	pending, _, err := msg.Sub.Pending()
+	return int64(pending), err == nil
+}
+
+// handle delivers e to h. Core NATS has no application-level ack, so the
+// delivery is tracked in-process: a nacked message, or with auto-ack disabled
+// one left unacknowledged past the ack wait, is redelivered to h by this
+// process until ctx is done, through gate.
+func (n *Nats[T]) handle(ctx context.Context, e *event[T], h func(broker.Event[T]) error, op *broker.SubscribeOptions, gate *broker.Gate) {
+	var ackWait time.Duration
+	if !op.AutoAck {
+		ackWait = cmp.Or(op.AckWait, broker.DefaultAckWait)
+	}
+	e.delivery = broker.NewDelivery(ackWait, func(delay time.Duration) {
+		next := &event[T]{
+			t:       e.t,
+			m:       e.m,
+			msg:     e.msg,
+			headers: e.headers,
+			key:     e.key,
+			attempt: e.attempt + 1,
+		}
+		time.AfterFunc(delay, func() {
+			if ctx.Err() == nil && gate.Enter(ctx) {
+				n.handle(ctx, next, h, op, gate)
+				gate.Leave()
+			}
+		})
+	})
+	if err := broker.Handle(ctx, e, h, op, n.Publish); op.AutoAck || errors.Is(err, broker.ErrDeadLettered) {
+		e.delivery.Ack()
 	}
-	return &subscriber{
-		t: topic,
-		s: sub,
-	}, nil
 }
 
 // CheckHealth implements health.Checker.
 func (n *Nats[T]) CheckHealth(ctx context.Context) error {
+	if n.conn == nil {
+		return nats.ErrConnectionClosed
+	}
 	if !n.conn.IsConnected() {
 		return fmt.Errorf("nats: server status=%d", n.conn.Status())
 	}
 	return nil
 }
 
-// Close flush in-flight messages and close the underlying connection.
+// Close drains the subscriptions, flushes in-flight messages and closes the
+// underlying connection. The subscriptions are drained until ctx is done, or
+// for broker.DefaultCloseTimeout if ctx has no deadline; the flush gets its
+// own flushTimeout, so that a drain using up ctx does not lose the published
+// messages. The connection is closed in any case, and the errors of every
+// step are joined.
 func (n *Nats[T]) Close(ctx context.Context) error {
 	if n.conn == nil {
 		// Open() was never called (or never succeeded): nothing to close.
 		return nil
 	}
-	err := n.conn.FlushWithContext(ctx)
-	if err != nil {
-		return err
+	dctx, cancel := broker.CloseContext(ctx)
+	defer cancel()
+	n.mu.Lock()
+	subs := slices.Clone(n.subs)
+	n.mu.Unlock()
+	var errs []error
+	for _, s := range subs {
+		errs = append(errs, s.Drain(dctx))
 	}
+	fctx, fcancel := context.WithTimeout(context.WithoutCancel(ctx), flushTimeout)
+	defer fcancel()
+	errs = append(errs, n.conn.FlushWithContext(fctx))
 	n.conn.Close()
-	return err
+	return errors.Join(errs...)
 }
@@ -1,14 +1,18 @@
 package nats
 
 import (
+	"time"
+
 	"github.com/nats-io/nats.go"
 	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/metric"
 )
 
-// Codec is an option to provide a custom codec.
-func Codec[T any](codec broker.Codec[T]) Option[T] {
+// Codec is an option to provide a custom codec. Default is codec.JSON.
+// Codecs from the broker/codec package also publish the content-type header.
+func Codec[T any](c broker.Codec[T]) Option[T] {
 	return func(opts *Nats[T]) {
-		opts.codec = codec
+		opts.codec = c
 	}
 }
 
@@ -26,6 +30,98 @@ func Options[T any](opts ...nats.Option) Option[T] {
 	}
 }
 
+// KeySubjects is an option to publish the messages with a key to the subject
+// topic.<key> instead of topic, so server-side subject mappings can partition
+// them by key; the key must then be a valid subject token. Subscriptions to a
+// topic, or to a pattern not ending with ">", also listen to its keyed
+// subjects, topic.*, skipping the messages published there without the
+// matching key. Every publisher and subscriber of a topic must agree on it.
+func KeySubjects[T any]() Option[T] {
+	return func(n *Nats[T]) {
+		n.keySubjects = true
+	}
+}
+
+// JetStream is an option to enable the JetStream mode: messages are published
+// to streams and consumed through JetStream consumers, with real
+// acknowledgements and redeliveries, see Nats.Subscribe. The streams must
+// exist or be provisioned with Stream. opts configure the JetStream context.
+func JetStream[T any](opts ...nats.JSOpt) Option[T] {
+	return func(n *Nats[T]) {
+		n.jetStream.enabled = true
+		n.jetStream.opts = append(n.jetStream.opts, opts...)
+	}
+}
+
+// Stream is an option to provision a stream on Open, creating it or updating
+// its configuration. It enables the JetStream mode and may be given once per
+// stream.
+func Stream[T any](cfg nats.StreamConfig) Option[T] {
+	return func(n *Nats[T]) {
+		n.jetStream.enabled = true
+		n.jetStream.streams = append(n.jetStream.streams, cfg)
+	}
+}
+
+// Consumer is an option to provide the template of the consumers created by
+// Subscribe in the JetStream mode, for settings such as MaxAckPending,
+// BackOff or Replicas. The filter subject, ack policy, names and deliver
+// subject and group are set by Subscribe, and the ack wait by
+// broker.AckWait. It enables the JetStream mode and replaces the settings of
+// the MaxDeliver and Deliver options given before it.
+func Consumer[T any](cfg nats.ConsumerConfig) Option[T] {
+	return func(n *Nats[T]) {
+		n.jetStream.enabled = true
+		n.jetStream.consumer = cfg
+	}
+}
+
+// MaxDeliver is an option to set how many times the consumers created in the
+// JetStream mode deliver a message before giving up on it. Zero or below
+// means no limit, the default.
+func MaxDeliver[T any](count int) Option[T] {
+	return func(n *Nats[T]) {
+		n.jetStream.consumer.MaxDeliver = count
+	}
+}
+
+// DeliverAll is an option to start the consumers created in the JetStream
+// mode at the first message of the stream, the default.
+func DeliverAll[T any]() Option[T] {
+	return func(n *Nats[T]) {
+		n.jetStream.consumer.DeliverPolicy = nats.DeliverAllPolicy
+		n.jetStream.consumer.OptStartTime = nil
+	}
+}
+
+// DeliverNew is an option to start the consumers created in the JetStream
+// mode with the messages published after their creation.
+func DeliverNew[T any]() Option[T] {
+	return func(n *Nats[T]) {
+		n.jetStream.consumer.DeliverPolicy = nats.DeliverNewPolicy
+		n.jetStream.consumer.OptStartTime = nil
+	}
+}
+
+// DeliverFrom is an option to start the consumers created in the JetStream
+// mode at the first message stored at or after t.
+func DeliverFrom[T any](t time.Time) Option[T] {
+	return func(n *Nats[T]) {
+		n.jetStream.consumer.DeliverPolicy = nats.DeliverByStartTimePolicy
+		n.jetStream.consumer.OptStartTime = &t
+	}
+}
+
+// Metrics is an option to report the standard broker metrics through r, see
+// broker.Metrics. The lag of a subscription is the number of messages
+// waiting in its pending buffer in the client, or in its consumer in the
+// JetStream mode.
+func Metrics[T any](r metric.Reporter) Option[T] {
+	return func(n *Nats[T]) {
+		n.metrics = broker.NewMetrics(r)
+	}
+}
+
 // Logger is an option to provide custom logger.
 func Logger[T any](logger logger) Option[T] {
 	return func(opts *Nats[T]) {
@@ -0,0 +1,36 @@
+package nats_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/plugins/broker/nats"
+)
+
+func TestPattern_MatchesItsOwnTokensOnly(t *testing.T) {
+	for name, opts := range map[string][]nats.Option[string]{
+		"default":      nil,
+		"key subjects": {nats.KeySubjects[string]()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b := openBroker(t, runServer(t), opts...)
+			pattern := subscribeAll(t, b, "orders.*")
+
+			msg := "m"
+			for _, p := range []struct {
+				topic string
+				opts  []broker.PublishOption
+			}{
+				{topic: "orders.eu", opts: []broker.PublishOption{broker.Key("k1")}},
+				{topic: "orders.eu.created"},
+				{topic: "orders"},
+			} {
+				if err := b.Publish(context.Background(), p.topic, &msg, p.opts...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expectOnly(t, pattern, delivery{topic: "orders.eu", key: "k1", msg: "m"})
+		})
+	}
+}
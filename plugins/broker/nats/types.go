@@ -2,39 +2,51 @@ package nats
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
 )
 
+// HeaderKey carries the key of a message published with broker.Key.
+const HeaderKey = "message-key"
+
 type (
 	event[T any] struct {
-		t      string
-		m      *T
-		msg    *nats.Msg
-		err    error
-		reason broker.Reason
+		t        string
+		m        *T
+		msg      *nats.Msg
+		headers  map[string]string
+		key      string
+		err      error
+		reason   broker.Reason
+		attempt  int
+		delivery *broker.Delivery
+		// js settles the message with the server in the JetStream mode.
+		js acker
 	}
 	subscriber struct {
-		t string
-		s *nats.Subscription
+		t      string
+		subs   []*nats.Subscription
+		gate   *broker.Gate
+		cancel context.CancelFunc
+		// remove removes the subscriber from the ones Close drains.
+		remove func()
 	}
 	logger interface {
 		Log(ctx context.Context, level slog.Level, msg string, args ...any)
 	}
-	JSONCodec[T any] struct{}
+	// JSONCodec encodes messages with encoding/json.
+	//
+	// Deprecated: use codec.JSON.
+	JSONCodec[T any] = codec.JSON[T]
 )
 
-func (m JSONCodec[T]) Marshal(v *T) ([]byte, error) {
-	return json.Marshal(v)
-}
-
-func (m JSONCodec[T]) Unmarshal(data []byte, v *T) error {
-	return json.Unmarshal(data, v)
-}
-
 // natsHeaderFrom converts broker.PublishOptions.Headers into NATS message headers.
 func natsHeaderFrom(headers map[string]string) nats.Header {
 	if len(headers) == 0 {
@@ -47,6 +59,63 @@ func natsHeaderFrom(headers map[string]string) nats.Header {
 	return h
 }
 
+// natsMsgFrom builds the NATS message published for data. The
+// broker.HeaderReplyTo header also becomes the native reply subject, so plain
+// NATS responders can answer requests made through rpc.Client.
+func natsMsgFrom(topic string, data []byte, headers map[string]string) *nats.Msg {
+	return &nats.Msg{
+		Subject: topic,
+		Reply:   headers[broker.HeaderReplyTo],
+		Data:    data,
+		Header:  natsHeaderFrom(headers),
+	}
+}
+
+// subjectFrom returns the subject a message published to topic with key is
+// sent to under the KeySubjects option: topic itself, or topic.<key> for a
+// non-empty key.
+func subjectFrom(topic, key string) (string, error) {
+	if key == "" {
+		return topic, nil
+	}
+	if strings.ContainsAny(key, ".*> \t\r\n") {
+		return "", fmt.Errorf("nats: key %q is not a valid subject token", key)
+	}
+	return topic + "." + key, nil
+}
+
+// headersFrom converts NATS message headers into a header map, keeping the
+// first value of each key.
+func headersFrom(header nats.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(header))
+	for k, v := range header {
+		if len(v) > 0 {
+			out[k] = v[0]
+		}
+	}
+	return out
+}
+
+// eventHeadersFrom returns the headers of msg, adding its reply subject as
+// broker.HeaderReplyTo when that header is not already set.
+func eventHeadersFrom(msg *nats.Msg) map[string]string {
+	headers := headersFrom(msg.Header)
+	if msg.Reply == "" {
+		return headers
+	}
+	if _, ok := headers[broker.HeaderReplyTo]; ok {
+		return headers
+	}
+	if headers == nil {
+		headers = make(map[string]string, 1)
+	}
+	headers[broker.HeaderReplyTo] = msg.Reply
+	return headers
+}
+
 func (e *event[T]) Topic() string {
 	return e.t
 }
@@ -55,13 +124,68 @@ func (e *event[T]) Message() *T {
 	return e.m
 }
 
-// Ack is a no-op: this broker subscribes via plain core NATS
-// (Subscribe/QueueSubscribe), which has no application-level ack or
-// redelivery concept. The underlying nats.Msg.Ack is a JetStream-only
-// operation that would return an error (or, if the message happens to have
-// a Reply subject set for an unrelated request-reply exchange, send a
-// misleading response there) for messages delivered this way.
+// Headers returns the NATS message headers. The native reply subject of a
+// NATS request is exposed as broker.HeaderReplyTo so helpers such as rpc.Reply
+// can answer requests made with plain NATS clients.
+func (e *event[T]) Headers() map[string]string {
+	return e.headers
+}
+
+func (e *event[T]) ID() string {
+	return e.headers[broker.HeaderMessageID]
+}
+
+func (e *event[T]) Timestamp() time.Time {
+	return broker.TimestampFrom(e.headers)
+}
+
+// Key returns the ordering key of the message, from its HeaderKey header.
+func (e *event[T]) Key() string {
+	return e.key
+}
+
+// Attempt counts the in-process redeliveries of the message, see Nack, or
+// the deliveries of the consumer in the JetStream mode.
+func (e *event[T]) Attempt() int {
+	return max(e.attempt, 1)
+}
+
+// Ack acknowledges the message to the consumer in the JetStream mode.
+// Otherwise it settles the delivery in-process: plain core NATS
+// (Subscribe/QueueSubscribe) has no application-level ack or redelivery
+// concept. The underlying nats.Msg.Ack is a JetStream-only operation that
+// would return an error (or, if the message happens to have a Reply subject
+// set for an unrelated request-reply exchange, send a misleading response
+// there) for messages delivered this way.
 func (e *event[T]) Ack() error {
+	if e.js != nil {
+		return settled(e.js.Ack())
+	}
+	e.delivery.Ack()
+	return nil
+}
+
+// Nack redelivers the message to the same subscription after delay. In the
+// JetStream mode the server redelivers it, to any member of a queue.
+// Otherwise the redelivery happens in this process and is lost if it stops
+// or the subscription is closed first.
+func (e *event[T]) Nack(delay time.Duration) error {
+	if e.js != nil {
+		if delay > 0 {
+			return settled(e.js.NakWithDelay(delay))
+		}
+		return settled(e.js.Nak())
+	}
+	e.delivery.Nack(delay)
+	return nil
+}
+
+// Term settles the message for good, see the package-level Term.
+func (e *event[T]) Term() error {
+	if e.js != nil {
+		return settled(e.js.Term())
+	}
+	e.delivery.Ack()
 	return nil
 }
 
@@ -78,5 +202,64 @@ func (s *subscriber) Topic() string {
 }
 
 func (s *subscriber) Unsubscribe() error {
-	return s.s.Unsubscribe()
+	s.gate.Close()
+	s.cancel()
+	s.remove()
+	var errs []error
+	for _, sub := range s.subs {
+		errs = append(errs, sub.Unsubscribe())
+	}
+	return errors.Join(errs...)
+}
+
+// Pause implements broker.LifecycleSubscriber interface. The server keeps
+// sending messages meanwhile; they wait in the pending buffer of the client,
+// and are dropped by the client as a slow consumer once it is full. In the
+// JetStream mode, the server stops at the max ack pending of the consumer and
+// redelivers the dropped messages.
+func (s *subscriber) Pause() error {
+	s.gate.Pause()
+	return nil
+}
+
+// Resume implements broker.LifecycleSubscriber interface.
+func (s *subscriber) Resume() error {
+	s.gate.Resume()
+	return nil
+}
+
+// Drain implements broker.LifecycleSubscriber interface. It removes the
+// interest in the subject and handles the messages pending in the client,
+// resuming a paused subscription, before unsubscribing.
+func (s *subscriber) Drain(ctx context.Context) error {
+	s.gate.Resume()
+	var drained []chan struct{}
+	for _, sub := range s.subs {
+		done := make(chan struct{})
+		sub.SetClosedHandler(func(string) { close(done) })
+		if sub.Drain() == nil {
+			drained = append(drained, done)
+		}
+	}
+	err := func() error {
+		for _, done := range drained {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		// Redeliveries of nacked messages may still run.
+		s.gate.Close()
+		return s.gate.Wait(ctx)
+	}()
+	s.gate.Close()
+	s.cancel()
+	s.remove()
+	for _, sub := range s.subs {
+		if sub.IsValid() {
+			_ = sub.Unsubscribe()
+		}
+	}
+	return err
 }
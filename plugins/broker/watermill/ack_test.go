@@ -2,6 +2,7 @@ package watermill_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -48,3 +49,83 @@ func TestSubscribe_FailedUnmarshalEventCanStillBeAcked(t *testing.T) {
 		t.Fatal("Ack() on an unmarshal-failure event did not ack the underlying watermill message (event.raw was never set on the failure path, so Ack() silently no-ops)")
 	}
 }
+
+func TestSubscribe_FailedHandlerIsNackedUnderAutoAck(t *testing.T) {
+	sub := newFakeSubscriber()
+	b := watermill.New[testMsg](fakePublisher{}, sub)
+
+	_, err := b.Subscribe(context.Background(), "topic", func(ev broker.Event[testMsg]) error {
+		return errors.New("boom")
+	})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	msg := message.NewMessage(wm.NewUUID(), []byte(`{"ID":"1"}`))
+	sub.ch <- msg
+
+	select {
+	case <-msg.Nacked():
+	case <-msg.Acked():
+		t.Fatal("message was acked although the handler failed")
+	case <-time.After(time.Second):
+		t.Fatal("message was neither acked nor nacked")
+	}
+}
+
+func TestSubscribe_ExhaustedMessageIsDeadLettered(t *testing.T) {
+	sub := newFakeSubscriber()
+	pub := &capturingPublisher{}
+	b := watermill.New[testMsg](pub, sub)
+
+	_, err := b.Subscribe(context.Background(), "topic", func(ev broker.Event[testMsg]) error {
+		return errors.New("boom")
+	}, broker.MaxAttempts(2), broker.DeadLetter("topic.dlq"))
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	msg := message.NewMessage(wm.NewUUID(), []byte(`{"ID":"1"}`))
+	msg.Metadata.Set("trace-id", "abc")
+	sub.ch <- msg
+
+	select {
+	case <-msg.Acked():
+	case <-time.After(time.Second):
+		t.Fatal("dead-lettered message was not acked")
+	}
+	if len(pub.published) != 1 {
+		t.Fatalf("got %d published messages, want 1", len(pub.published))
+	}
+	md := pub.published[0].Metadata
+	if md.Get("trace-id") != "abc" || md.Get(broker.HeaderDeadLetterAttempts) != "2" || md.Get(broker.HeaderDeadLetterTopic) != "topic" {
+		t.Errorf("dead-letter metadata = %v, want original headers plus dead-letter headers", md)
+	}
+}
+
+func TestSubscribe_NackWithDelay(t *testing.T) {
+	sub := newFakeSubscriber()
+	b := watermill.New[testMsg](fakePublisher{}, sub)
+
+	_, err := b.Subscribe(context.Background(), "topic", func(ev broker.Event[testMsg]) error {
+		return ev.Nack(50 * time.Millisecond)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	start := time.Now()
+	msg := message.NewMessage(wm.NewUUID(), []byte(`{"ID":"1"}`))
+	sub.ch <- msg
+
+	select {
+	case <-msg.Nacked():
+		if time.Since(start) < 50*time.Millisecond {
+			t.Errorf("message nacked after %v, want after the 50ms delay", time.Since(start))
+		}
+	case <-msg.Acked():
+		t.Fatal("nacked message was auto-acked")
+	case <-time.After(time.Second):
+		t.Fatal("message was not nacked")
+	}
+}
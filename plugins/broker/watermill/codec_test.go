@@ -0,0 +1,57 @@
+package watermill_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	wm "github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
+	"github.com/pthethanh/nano/plugins/broker/watermill"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestCodec_NegotiatesContentType(t *testing.T) {
+	pub := &capturingPublisher{}
+	sub := newFakeSubscriber()
+	b := watermill.New(pub, sub, watermill.Codec(codec.Negotiate[broker.Message](
+		codec.Proto[broker.Message]{},
+		codec.ProtoJSON[broker.Message]{},
+	)))
+
+	if err := b.Publish(context.Background(), "topic", &broker.Message{Body: []byte("hello")}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := pub.published[0].Metadata.Get(broker.HeaderContentType); got != codec.ContentTypeProto {
+		t.Fatalf("Metadata[%s] = %q, want %q", broker.HeaderContentType, got, codec.ContentTypeProto)
+	}
+
+	received := make(chan broker.Event[broker.Message], 2)
+	if _, err := b.Subscribe(context.Background(), "topic", func(ev broker.Event[broker.Message]) error {
+		received <- ev
+		return nil
+	}); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	data, err := protojson.Marshal(&broker.Message{Body: []byte("from json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON := message.NewMessage(wm.NewUUID(), data)
+	fromJSON.Metadata.Set(broker.HeaderContentType, codec.ContentTypeProtoJSON)
+	sub.ch <- pub.published[0]
+	sub.ch <- fromJSON
+
+	for _, want := range []string{"hello", "from json"} {
+		select {
+		case ev := <-received:
+			if ev.Error() != nil || string(ev.Message().GetBody()) != want {
+				t.Errorf("got %v, %v, want body %q", ev.Message(), ev.Error(), want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("handler was not invoked")
+		}
+	}
+}
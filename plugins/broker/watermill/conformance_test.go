@@ -0,0 +1,18 @@
+package watermill_test
+
+import (
+	"testing"
+
+	wm "github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/brokertest"
+	"github.com/pthethanh/nano/plugins/broker/watermill"
+)
+
+func TestConformance(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) broker.Broker[brokertest.Message] {
+		ps := gochannel.NewGoChannel(gochannel.Config{OutputChannelBuffer: 64}, wm.NopLogger{})
+		return watermill.New[brokertest.Message](ps, ps)
+	}, brokertest.WithoutQueue())
+}
@@ -3,7 +3,9 @@ package watermill_test
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/ThreeDotsLabs/watermill/components/delay"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/pthethanh/nano/broker"
 	"github.com/pthethanh/nano/plugins/broker/watermill"
@@ -35,3 +37,37 @@ func TestPublish_HeadersBecomeMessageMetadata(t *testing.T) {
 		t.Errorf("Metadata[trace-id] = %q, want %q", got, "abc")
 	}
 }
+
+func TestPublish_AssignsMessageID(t *testing.T) {
+	pub := &capturingPublisher{}
+	b := watermill.New[testMsg](pub, newFakeSubscriber())
+
+	if err := b.Publish(context.Background(), "topic", &testMsg{ID: "1"}, broker.MessageID("m-1")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if err := b.Publish(context.Background(), "topic", &testMsg{ID: "2"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if got := pub.published[0]; got.UUID != "m-1" || got.Metadata.Get(broker.HeaderMessageID) != "m-1" {
+		t.Errorf("got UUID=%q, metadata ID=%q, want both m-1", got.UUID, got.Metadata.Get(broker.HeaderMessageID))
+	}
+	if got := pub.published[1]; got.UUID == "" || got.UUID != got.Metadata.Get(broker.HeaderMessageID) {
+		t.Errorf("got UUID=%q, metadata ID=%q, want a generated ID in both", got.UUID, got.Metadata.Get(broker.HeaderMessageID))
+	}
+	if broker.TimestampFrom(pub.published[1].Metadata).IsZero() {
+		t.Errorf("got no %s metadata, want the publish time", broker.HeaderTimestamp)
+	}
+}
+
+func TestPublish_DelayBecomesDelayMetadata(t *testing.T) {
+	pub := &capturingPublisher{}
+	b := watermill.New[testMsg](pub, newFakeSubscriber())
+	at := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if err := b.Publish(context.Background(), "topic", &testMsg{ID: "1"}, broker.DeliverAt(at)); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := pub.published[0].Metadata.Get(delay.DelayedUntilKey); got != at.Format(time.RFC3339) {
+		t.Errorf("Metadata[%s] = %q, want %q", delay.DelayedUntilKey, got, at.Format(time.RFC3339))
+	}
+}
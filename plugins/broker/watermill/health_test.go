@@ -0,0 +1,33 @@
+package watermill_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pthethanh/nano/plugins/broker/watermill"
+)
+
+type unhealthySubscriber struct {
+	*fakeSubscriber
+}
+
+func (unhealthySubscriber) CheckHealth(context.Context) error { return errors.New("disconnected") }
+
+func TestCheckHealth(t *testing.T) {
+	b := watermill.New[testMsg](fakePublisher{}, newFakeSubscriber())
+	if err := b.CheckHealth(context.Background()); err != nil {
+		t.Fatalf("CheckHealth() error = %v, want nil while open", err)
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CheckHealth(context.Background()); !errors.Is(err, watermill.ErrInvalidConnectionState) {
+		t.Fatalf("CheckHealth() error = %v after Close, want ErrInvalidConnectionState", err)
+	}
+
+	b = watermill.New[testMsg](fakePublisher{}, unhealthySubscriber{newFakeSubscriber()})
+	if err := b.CheckHealth(context.Background()); err == nil || err.Error() != "disconnected" {
+		t.Fatalf("CheckHealth() error = %v, want the error of the subscriber", err)
+	}
+}
@@ -0,0 +1,63 @@
+package watermill_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	wm "github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/plugins/broker/watermill"
+)
+
+func TestSubscribe_PauseResumeAndCloseDrains(t *testing.T) {
+	sub := newFakeSubscriber()
+	b := watermill.New[testMsg](fakePublisher{}, sub)
+	received := make(chan string, 2)
+	release := make(chan struct{})
+	subscription, err := b.Subscribe(context.Background(), "topic", func(ev broker.Event[testMsg]) error {
+		received <- ev.Message().ID
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if err := broker.Pause(subscription); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	msg := message.NewMessage(wm.NewUUID(), []byte(`{"ID":"1"}`))
+	sub.ch <- msg
+	select {
+	case id := <-received:
+		t.Fatalf("handled %s while paused", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := broker.Resume(subscription); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("message was not handled after Resume")
+	}
+
+	// Close waits for the handler in flight.
+	closed := make(chan error)
+	go func() { closed <- b.Close(context.Background()) }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close() = %v before the handler returned", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	select {
+	case <-msg.Acked():
+	default:
+		t.Fatal("message handled during Close was not acked")
+	}
+}
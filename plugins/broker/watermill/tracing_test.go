@@ -0,0 +1,56 @@
+package watermill_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/middleware/tracing"
+	"github.com/pthethanh/nano/plugins/broker/watermill"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestTracing_PropagatesSpanContextThroughMetadata(t *testing.T) {
+	pub := &capturingPublisher{}
+	sub := newFakeSubscriber()
+	b := broker.Wrap[testMsg](watermill.New[testMsg](pub, sub), tracing.Middleware[testMsg](
+		tracing.WithTracerProvider(noop.NewTracerProvider()),
+		tracing.WithPropagator(propagation.TraceContext{}),
+	))
+
+	received := make(chan map[string]string, 1)
+	if _, err := b.Subscribe(context.Background(), "topic", func(ev broker.Event[testMsg]) error {
+		received <- ev.Headers()
+		return nil
+	}); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	producer := oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    oteltrace.TraceID{1},
+		SpanID:     oteltrace.SpanID{1},
+		TraceFlags: oteltrace.FlagsSampled,
+	})
+	if err := b.Publish(oteltrace.ContextWithSpanContext(context.Background(), producer), "topic", &testMsg{ID: "1"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := pub.published[0].Metadata.Get("traceparent"); got == "" {
+		t.Fatal("published message carries no traceparent metadata")
+	}
+	sub.ch <- pub.published[0]
+
+	select {
+	case headers := <-received:
+		// The no-op tracer carries the caller's span forward as the
+		// producer span.
+		want := "00-01000000000000000000000000000000-0100000000000000-01"
+		if got := headers["traceparent"]; got != want {
+			t.Fatalf("got traceparent %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler was not invoked")
+	}
+}
@@ -2,8 +2,8 @@ package watermill
 
 import (
 	"context"
-	"encoding/json"
 	"log/slog"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/pthethanh/nano/broker"
@@ -14,23 +14,13 @@ type logger interface {
 	Log(ctx context.Context, level slog.Level, msg string, args ...any)
 }
 
-// jsonCodec implements broker.Codec using encoding/json.
-type jsonCodec[T any] struct{}
-
-func (jsonCodec[T]) Marshal(v *T) ([]byte, error) {
-	return json.Marshal(v)
-}
-
-func (jsonCodec[T]) Unmarshal(data []byte, v *T) error {
-	return json.Unmarshal(data, v)
-}
-
 type event[T any] struct {
-	topic   string
-	payload *T
-	raw     *message.Message
-	err     error
-	reason  broker.Reason
+	topic    string
+	payload  *T
+	raw      *message.Message
+	err      error
+	reason   broker.Reason
+	delivery *broker.Delivery
 }
 
 func (e *event[T]) Topic() string {
@@ -45,13 +35,52 @@ func (e *event[T]) RawMessage() *message.Message {
 	return e.raw
 }
 
+// Headers returns the metadata of the underlying Watermill message.
+func (e *event[T]) Headers() map[string]string {
+	if e.raw == nil {
+		return nil
+	}
+	return e.raw.Metadata
+}
+
+// ID returns the broker.HeaderMessageID metadata, falling back to the UUID
+// of the Watermill message.
+func (e *event[T]) ID() string {
+	if e.raw == nil {
+		return ""
+	}
+	if id := e.raw.Metadata.Get(broker.HeaderMessageID); id != "" {
+		return id
+	}
+	return e.raw.UUID
+}
+
+func (e *event[T]) Timestamp() time.Time {
+	return broker.TimestampFrom(e.Headers())
+}
+
+// Attempt returns 1: redeliveries after a Nack are driven by the Watermill
+// backend, which does not report them. broker.AckWait is likewise left to the
+// backend.
+func (e *event[T]) Attempt() int {
+	return 1
+}
+
 func (e *event[T]) Ack() error {
-	if e.raw != nil {
+	if e.raw != nil && e.delivery.Ack() {
 		e.raw.Ack()
 	}
 	return nil
 }
 
+// Nack nacks the underlying Watermill message after delay; redelivery is up
+// to the Watermill backend. Backends that deliver one message at a time hold
+// back later messages until then.
+func (e *event[T]) Nack(delay time.Duration) error {
+	e.delivery.Nack(delay)
+	return nil
+}
+
 func (e *event[T]) Error() error {
 	return e.err
 }
@@ -62,14 +91,58 @@ func (e *event[T]) Reason() broker.Reason {
 
 type subscriber[T any] struct {
 	topic  string
+	gate   broker.Gate
 	cancel func()
+	// remove removes the subscriber from the ones Close drains.
+	remove func()
 }
 
 func (s *subscriber[T]) Unsubscribe() error {
+	s.gate.Close()
 	s.cancel()
+	s.remove()
 	return nil
 }
 
+// Pause implements broker.LifecycleSubscriber interface. The message received
+// meanwhile is held back unacknowledged; whether the Watermill subscriber
+// keeps more messages waiting depends on its implementation.
+func (s *subscriber[T]) Pause() error {
+	s.gate.Pause()
+	return nil
+}
+
+// Resume implements broker.LifecycleSubscriber interface.
+func (s *subscriber[T]) Resume() error {
+	s.gate.Resume()
+	return nil
+}
+
+// Drain implements broker.LifecycleSubscriber interface. A message received
+// but not handled yet is nacked.
+func (s *subscriber[T]) Drain(ctx context.Context) error {
+	s.gate.Close()
+	err := s.gate.Wait(ctx)
+	_ = s.Unsubscribe()
+	return err
+}
+
 func (s *subscriber[T]) Topic() string {
 	return s.topic
 }
+
+// newEvent returns an event for raw whose Nack nacks raw after the delay.
+func newEvent[T any](topic string, payload *T, raw *message.Message) *event[T] {
+	return &event[T]{
+		topic:   topic,
+		payload: payload,
+		raw:     raw,
+		delivery: broker.NewDelivery(0, func(delay time.Duration) {
+			if delay <= 0 {
+				raw.Nack()
+				return
+			}
+			time.AfterFunc(delay, func() { raw.Nack() })
+		}),
+	}
+}
@@ -4,28 +4,46 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/components/delay"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/pthethanh/nano/broker"
+	"github.com/pthethanh/nano/broker/codec"
+	"github.com/pthethanh/nano/metric"
 )
 
 // Broker implements nano's broker plugin using Watermill.
 type Broker[T any] struct {
-	pub    message.Publisher
-	sub    message.Subscriber
-	codec  broker.Codec[T]
-	logger logger
+	pub     message.Publisher
+	sub     message.Subscriber
+	codec   broker.Codec[T]
+	logger  logger
+	metrics *broker.Metrics
+
+	mu     sync.Mutex
+	subs   []*subscriber[T]
+	closed atomic.Bool
 }
 
 // Ensure Broker implements broker.Broker interface
 var (
 	_ broker.Broker[any] = (*Broker[any])(nil)
+
+	_ broker.LifecycleSubscriber = (*subscriber[any])(nil)
+
+	// ErrInvalidConnectionState indicates that the broker is closed or lacks
+	// a publisher or a subscriber.
+	ErrInvalidConnectionState = errors.New("invalid connection state")
 )
 
 type Option[T any] func(*Broker[T])
 
-// Option to set a custom codec.
+// Option to set a custom codec. Default is codec.JSON. Codecs from the
+// broker/codec package also publish the content-type header.
 func Codec[T any](c broker.Codec[T]) Option[T] {
 	return func(b *Broker[T]) {
 		b.codec = c
@@ -39,11 +57,19 @@ func Logger[T any](l logger) Option[T] {
 	}
 }
 
+// Option to report the standard broker metrics through r, see
+// broker.Metrics. The Watermill interfaces expose no lag.
+func Metrics[T any](r metric.Reporter) Option[T] {
+	return func(b *Broker[T]) {
+		b.metrics = broker.NewMetrics(r)
+	}
+}
+
 func New[T any](pub message.Publisher, sub message.Subscriber, opts ...Option[T]) *Broker[T] {
 	b := &Broker[T]{
 		pub:    pub,
 		sub:    sub,
-		codec:  jsonCodec[T]{}, // Use JSONCodec as default
+		codec:  codec.JSON[T]{},
 		logger: defaultLogger{},
 	}
 	for _, opt := range opts {
@@ -57,8 +83,23 @@ func (b *Broker[T]) Open(ctx context.Context) error {
 	return nil
 }
 
+// Close drains the subscriptions, waiting for the handlers in flight until
+// ctx is done or for broker.DefaultCloseTimeout if ctx has no deadline, and
+// closes the publisher and the subscriber.
 func (b *Broker[T]) Close(ctx context.Context) error {
 	b.logger.Log(ctx, slog.LevelDebug, "broker closing")
+	b.closed.Store(true)
+	drainCtx, cancel := broker.CloseContext(ctx)
+	defer cancel()
+	b.mu.Lock()
+	subs := slices.Clone(b.subs)
+	b.mu.Unlock()
+	var errDrain error
+	for _, sub := range subs {
+		if err := sub.Drain(drainCtx); err != nil && errDrain == nil {
+			errDrain = err
+		}
+	}
 	var errPub, errSub error
 	if b.pub != nil {
 		errPub = b.pub.Close()
@@ -66,7 +107,7 @@ func (b *Broker[T]) Close(ctx context.Context) error {
 	if b.sub != nil {
 		errSub = b.sub.Close()
 	}
-	err := errors.Join(errPub, errSub)
+	err := errors.Join(errDrain, errPub, errSub)
 	if err != nil {
 		b.logger.Log(ctx, slog.LevelError, "broker close error", "error", err)
 		return err
@@ -75,23 +116,38 @@ func (b *Broker[T]) Close(ctx context.Context) error {
 	return nil
 }
 
+// Publish implements broker.Broker interface. The delivery time set by
+// broker.Delay or broker.DeliverAt is carried in the metadata of Watermill's
+// delay component, which delay-aware Pub/Subs such as the delayed PostgreSQL
+// one honor; other Pub/Subs deliver the message right away.
 func (b *Broker[T]) Publish(ctx context.Context, topic string, m *T, opts ...broker.PublishOption) error {
+	start := time.Now()
+	err := b.publish(ctx, topic, m, opts)
+	b.metrics.Publish(topic, 1, start, err)
+	return err
+}
+
+func (b *Broker[T]) publish(ctx context.Context, topic string, m *T, opts []broker.PublishOption) error {
 	if b.pub == nil {
 		b.logger.Log(ctx, slog.LevelError, "publish failed: publisher not initialized")
 		return errors.New("publisher not initialized")
 	}
 	var popts broker.PublishOptions
 	popts.Apply(opts...)
+	popts.Stamp()
 
-	data, err := b.codec.Marshal(m)
+	data, err := codec.Marshal(b.codec, m, popts.Headers)
 	if err != nil {
 		b.logger.Log(ctx, slog.LevelError, "publish failed: marshal error", "error", err)
 		return err
 	}
-	msg := message.NewMessage(watermill.NewUUID(), data)
+	msg := message.NewMessage(popts.Headers[broker.HeaderMessageID], data)
 	for k, v := range popts.Headers {
 		msg.Metadata.Set(k, v)
 	}
+	if popts.Delayed() {
+		delay.Message(msg, delay.Until(popts.DeliverAt))
+	}
 	b.logger.Log(ctx, slog.LevelDebug, "publishing message", "topic", topic, "msg_id", msg.UUID)
 	err = b.pub.Publish(topic, msg)
 	if err != nil {
@@ -102,15 +158,21 @@ func (b *Broker[T]) Publish(ctx context.Context, topic string, m *T, opts ...bro
 	return err
 }
 
+// Subscribe implements broker.Broker interface. The Watermill Subscriber
+// interface has no topic patterns: they return broker.ErrPatternUnsupported.
 func (b *Broker[T]) Subscribe(ctx context.Context, topic string, handler func(broker.Event[T]) error, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
 	if b.sub == nil {
 		b.logger.Log(ctx, slog.LevelError, "subscribe failed: subscriber not initialized")
 		return nil, errors.New("subscriber not initialized")
 	}
+	if broker.IsPattern(topic) {
+		return nil, broker.ErrPatternUnsupported
+	}
 	opt := broker.SubscribeOptions{
 		AutoAck: true,
 	}
 	opt.Apply(opts...)
+	handler = broker.MeasureHandler(b.metrics, handler)
 	if opt.Queue != "" {
 		// Queue groups are not supported by the watermill Publisher/Subscriber
 		// interfaces this broker is built on: every subscriber gets every
@@ -125,6 +187,14 @@ func (b *Broker[T]) Subscribe(ctx context.Context, topic string, handler func(br
 		return nil, err
 	}
 	sub := &subscriber[T]{topic: topic, cancel: cancel}
+	sub.remove = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.subs = slices.DeleteFunc(b.subs, func(s *subscriber[T]) bool { return s == sub })
+	}
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
 	b.logger.Log(ctx, slog.LevelDebug, "subscribed to topic", "topic", topic)
 	go func() {
 		for {
@@ -134,30 +204,14 @@ func (b *Broker[T]) Subscribe(ctx context.Context, topic string, handler func(br
 					b.logger.Log(ctx, slog.LevelDebug, "message channel closed", "topic", topic)
 					return
 				}
-				var v T
-				if err := b.codec.Unmarshal(msg.Payload, &v); err != nil {
-					if opt.AutoAck {
-						msg.Nack()
-					}
-					b.logger.Log(ctx, slog.LevelError, "failed to unmarshal message", "topic", topic, "error", err)
-					_ = handler(&event[T]{
-						topic:  topic,
-						raw:    msg,
-						err:    err,
-						reason: broker.ReasonUnmarshalFailure,
-					})
-					continue
-				}
-				b.logger.Log(ctx, slog.LevelDebug, "received message", "topic", topic, "msg_id", msg.UUID)
-				_ = handler(&event[T]{
-					topic:   topic,
-					payload: &v,
-					raw:     msg,
-				})
-				if opt.AutoAck {
-					msg.Ack()
-					b.logger.Log(ctx, slog.LevelDebug, "message acknowledged", "topic", topic, "msg_id", msg.UUID)
+				if !sub.gate.Enter(newCtx) {
+					// Drained or unsubscribed: leave the message for redelivery.
+					msg.Nack()
+					b.logger.Log(ctx, slog.LevelDebug, "subscription drained, stopping subscription", "topic", topic)
+					return
 				}
+				b.handle(ctx, newCtx, topic, msg, handler, &opt)
+				sub.gate.Leave()
 			case <-newCtx.Done():
 				b.logger.Log(ctx, slog.LevelDebug, "context done, stopping subscription", "topic", topic)
 				return
@@ -167,6 +221,53 @@ func (b *Broker[T]) Subscribe(ctx context.Context, topic string, handler func(br
 	return sub, nil
 }
 
+// handle hands msg to handler, settling it under auto-ack.
+func (b *Broker[T]) handle(ctx, handleCtx context.Context, topic string, msg *message.Message, handler func(broker.Event[T]) error, opt *broker.SubscribeOptions) {
+	var v T
+	e := newEvent(topic, &v, msg)
+	if err := codec.Unmarshal(b.codec, e.Headers(), msg.Payload, &v); err != nil {
+		if opt.AutoAck {
+			_ = e.Nack(0)
+		}
+		b.logger.Log(ctx, slog.LevelError, "failed to unmarshal message", "topic", topic, "error", err)
+		e.payload, e.err, e.reason = nil, err, broker.ReasonUnmarshalFailure
+		_ = handler(e)
+		return
+	}
+	b.logger.Log(ctx, slog.LevelDebug, "received message", "topic", topic, "msg_id", msg.UUID)
+	err := broker.Handle(handleCtx, e, handler, opt, b.Publish)
+	if errors.Is(err, broker.ErrDeadLettered) {
+		// Settled for good, with auto-ack or not.
+		err = nil
+	} else if !opt.AutoAck {
+		return
+	}
+	if err != nil {
+		_ = e.Nack(0)
+		b.logger.Log(ctx, slog.LevelError, "message handling failed", "topic", topic, "msg_id", msg.UUID, "error", err)
+		return
+	}
+	_ = e.Ack()
+	b.logger.Log(ctx, slog.LevelDebug, "message acknowledged", "topic", topic, "msg_id", msg.UUID)
+}
+
+// CheckHealth implements health.Checker. The broker is healthy while it has a
+// publisher and a subscriber and is not closed, and as long as those of them
+// implementing health.Checker are.
+func (b *Broker[T]) CheckHealth(ctx context.Context) error {
+	if b.pub == nil || b.sub == nil || b.closed.Load() {
+		return ErrInvalidConnectionState
+	}
+	for _, c := range []any{b.pub, b.sub} {
+		if c, ok := c.(interface{ CheckHealth(context.Context) error }); ok {
+			if err := c.CheckHealth(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // defaultLogger is a simple logger that uses slog.Default().
 type defaultLogger struct{}
 
@@ -0,0 +1,24 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pthethanh/nano/cache"
+	"github.com/pthethanh/nano/cache/cachetest"
+	cacheRedis "github.com/pthethanh/nano/plugins/cache/redis"
+)
+
+func TestConformance(t *testing.T) {
+	var s *miniredis.Miniredis
+	cachetest.Run(t, func(t *testing.T) cache.Cacher[string, []byte] {
+		s = miniredis.RunT(t)
+		return cacheRedis.New(
+			cacheRedis.Address[string, []byte](s.Addr()),
+			cacheRedis.CodecOption[string, []byte](cacheRedis.BytesCodec{}),
+		)
+	}, cachetest.WithClock(func(t *testing.T, d time.Duration) {
+		s.FastForward(d)
+	}))
+}
@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pthethanh/nano/cache"
+	"github.com/pthethanh/nano/cache/tiered"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+type (
+	// PubSub is a tiered.Channel over a Redis pub/sub channel, carrying the
+	// invalidation messages as JSON.
+	PubSub[K comparable] struct {
+		client  func() goredis.UniversalClient
+		channel string
+	}
+
+	pubSubscription struct {
+		ps   *goredis.PubSub
+		done chan struct{}
+	}
+)
+
+var _ tiered.Channel[string] = (*PubSub[string])(nil)
+
+// NewPubSub returns a tiered.Channel publishing to channel with client.
+func NewPubSub[K comparable](client goredis.UniversalClient, channel string) *PubSub[K] {
+	return &PubSub[K]{
+		client:  func() goredis.UniversalClient { return client },
+		channel: channel,
+	}
+}
+
+// PubSub returns a tiered.Channel publishing to channel with the client of
+// the Cacher, so that a tiered cache in front of c needs no other
+// connection. The Cacher must be open when the channel is used, which a
+// tiered.Cacher opening c does.
+func (c *Cacher[K, V]) PubSub(channel string) *PubSub[K] {
+	return &PubSub[K]{
+		client:  func() goredis.UniversalClient { return c.client },
+		channel: channel,
+	}
+}
+
+// Publish publishes m to the channel.
+func (p *PubSub[K]) Publish(ctx context.Context, m *tiered.Message[K]) error {
+	client := p.client()
+	if client == nil {
+		return cache.ErrInValidConnState
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return client.Publish(ctx, p.channel, b).Err()
+}
+
+// Subscribe subscribes to the channel, returning once Redis confirmed the
+// subscription. Messages that cannot be decoded are skipped.
+func (p *PubSub[K]) Subscribe(ctx context.Context, h func(m *tiered.Message[K])) (tiered.Subscription, error) {
+	client := p.client()
+	if client == nil {
+		return nil, cache.ErrInValidConnState
+	}
+	ps := client.Subscribe(ctx, p.channel)
+	if _, err := ps.Receive(ctx); err != nil {
+		_ = ps.Close()
+		return nil, err
+	}
+	sub := &pubSubscription{ps: ps, done: make(chan struct{})}
+	go func() {
+		defer close(sub.done)
+		for msg := range ps.Channel() {
+			var m tiered.Message[K]
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				continue
+			}
+			h(&m)
+		}
+	}()
+	return sub, nil
+}
+
+// Unsubscribe closes the subscription and waits for its last message to be
+// handled.
+func (s *pubSubscription) Unsubscribe() error {
+	err := s.ps.Close()
+	<-s.done
+	return err
+}
@@ -0,0 +1,69 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pthethanh/nano/cache"
+	"github.com/pthethanh/nano/cache/tiered"
+	cacheRedis "github.com/pthethanh/nano/plugins/cache/redis"
+)
+
+func TestPubSub_InvalidatesTieredCaches(t *testing.T) {
+	s := miniredis.RunT(t)
+	ctx := context.Background()
+	newInstance := func() *tiered.Cacher[string, string] {
+		far := cacheRedis.New(cacheRedis.Address[string, string](s.Addr()))
+		c := tiered.New[string, string](far,
+			tiered.NearTTL[string, string](time.Hour),
+			tiered.Invalidation[string, string](far.PubSub("invalidations")),
+		)
+		if err := c.Open(ctx); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		t.Cleanup(func() { c.Close(ctx) })
+		return c
+	}
+	writer, reader := newInstance(), newInstance()
+
+	if err := writer.Set(ctx, "k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := reader.Get(ctx, "k"); err != nil || v != "v1" {
+		t.Fatalf("Get() = %q, %v, want v1", v, err)
+	}
+	if err := writer.Set(ctx, "k", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		v, err := reader.Get(ctx, "k")
+		return err == nil && v == "v2"
+	})
+	if err := writer.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool {
+		_, err := reader.Get(ctx, "k")
+		return errors.Is(err, cache.ErrNotFound)
+	})
+}
+
+func TestPubSub_RequiresAnOpenCacher(t *testing.T) {
+	ch := cacheRedis.New[string, string]().PubSub("invalidations")
+	if err := ch.Publish(context.Background(), &tiered.Message[string]{Keys: []string{"k"}}); !errors.Is(err, cache.ErrInValidConnState) {
+		t.Fatalf("Publish() error = %v, want %v", err, cache.ErrInValidConnState)
+	}
+}
+
+func eventually(t *testing.T, ok func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !ok(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
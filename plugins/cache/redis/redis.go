@@ -3,6 +3,8 @@ package redis
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/pthethanh/nano/cache"
@@ -17,7 +19,11 @@ type Cacher[K comparable, V any] struct {
 	codec   Codec[V]
 }
 
-var _ cache.Cacher[string, []byte] = (*Cacher[string, []byte])(nil)
+var (
+	_ cache.Cacher[string, []byte]  = (*Cacher[string, []byte])(nil)
+	_ cache.Batcher[string, []byte] = (*Cacher[string, []byte])(nil)
+	_ cache.TTLReader[string]       = (*Cacher[string, []byte])(nil)
+)
 
 func New[K comparable, V any](opts ...Option[K, V]) *Cacher[K, V] {
 	c := &Cacher[K, V]{
@@ -89,6 +95,157 @@ func (c *Cacher[K, V]) Delete(ctx context.Context, k K) error {
 	return c.client.Del(ctx, c.keyFunc(k)).Err()
 }
 
+// GetMany retrieves the values of keys in one round trip: a single MGET, or
+// a pipeline of GET on a Redis Cluster, whose MGET cannot span hash slots.
+// Missing keys are absent from the result; the failures of the other keys
+// are returned, joined.
+func (c *Cacher[K, V]) GetMany(ctx context.Context, keys []K) (map[K]V, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	rs := make(map[K]V, len(keys))
+	if len(keys) == 0 {
+		return rs, nil
+	}
+	vals := make([]any, len(keys))
+	if c.cluster() {
+		cmds := make([]*goredis.StringCmd, len(keys))
+		// The pipeline only reports the error of its first failed command,
+		// which may be a missing key hiding the failure of a later one:
+		// every command is checked instead.
+		_, _ = c.client.Pipelined(ctx, func(p goredis.Pipeliner) error {
+			for i, k := range keys {
+				cmds[i] = p.Get(ctx, c.keyFunc(k))
+			}
+			return nil
+		})
+		var errs []error
+		for i, cmd := range cmds {
+			v, err := cmd.Result()
+			switch {
+			case err == nil:
+				vals[i] = v
+			case err != goredis.Nil:
+				errs = append(errs, fmt.Errorf("redis: get %s: %w", c.keyFunc(keys[i]), err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+	} else {
+		rkeys := make([]string, len(keys))
+		for i, k := range keys {
+			rkeys[i] = c.keyFunc(k)
+		}
+		var err error
+		if vals, err = c.client.MGet(ctx, rkeys...).Result(); err != nil {
+			return nil, err
+		}
+	}
+	for i, val := range vals {
+		s, ok := val.(string)
+		if !ok {
+			continue
+		}
+		var v V
+		if err := c.codec.Unmarshal([]byte(s), &v); err != nil {
+			return nil, err
+		}
+		rs[keys[i]] = v
+	}
+	return rs, nil
+}
+
+// SetMany stores the values of items with a pipeline of SET, in one round
+// trip.
+func (c *Cacher[K, V]) SetMany(ctx context.Context, items map[K]V, opts ...cache.SetOption) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	setOpts := &cache.SetOptions{}
+	setOpts.Apply(opts...)
+	vals := make(map[string][]byte, len(items))
+	for k, v := range items {
+		b, err := c.codec.Marshal(v)
+		if err != nil {
+			return err
+		}
+		vals[c.keyFunc(k)] = b
+	}
+	_, err := c.client.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		for k, b := range vals {
+			p.Set(ctx, k, b, ttl(setOpts.TTL))
+		}
+		return nil
+	})
+	return err
+}
+
+// DeleteMany removes the values of keys in one round trip: a single DEL, or
+// a pipeline of DEL on a Redis Cluster.
+func (c *Cacher[K, V]) DeleteMany(ctx context.Context, keys []K) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	rkeys := make([]string, len(keys))
+	for i, k := range keys {
+		rkeys[i] = c.keyFunc(k)
+	}
+	if !c.cluster() {
+		return c.client.Del(ctx, rkeys...).Err()
+	}
+	_, err := c.client.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		for _, k := range rkeys {
+			p.Del(ctx, k)
+		}
+		return nil
+	})
+	return err
+}
+
+// TTLs returns the remaining time to live of the values of keys with a
+// pipeline of PTTL, in one round trip, see cache.TTLReader.
+func (c *Cacher[K, V]) TTLs(ctx context.Context, keys []K) (map[K]time.Duration, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	rs := make(map[K]time.Duration, len(keys))
+	if len(keys) == 0 {
+		return rs, nil
+	}
+	cmds := make([]*goredis.DurationCmd, len(keys))
+	_, _ = c.client.Pipelined(ctx, func(p goredis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = p.PTTL(ctx, c.keyFunc(k))
+		}
+		return nil
+	})
+	var errs []error
+	for i, cmd := range cmds {
+		d, err := cmd.Result()
+		// PTTL replies -1 for keys without an expiry and -2 for missing
+		// keys, which go-redis passes on as is.
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("redis: pttl %s: %w", c.keyFunc(keys[i]), err))
+		case d == -2:
+			rs[keys[i]] = 0
+		case d >= 0:
+			rs[keys[i]] = d
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
 // Close closes the managed Redis client.
 func (c *Cacher[K, V]) Close(ctx context.Context) error {
 	if err := c.validate(); err != nil {
@@ -110,6 +267,13 @@ func (c *Cacher[K, V]) validate() error {
 	return nil
 }
 
+// cluster reports whether the client is a Redis Cluster client, whose
+// multi-key commands must not span hash slots.
+func (c *Cacher[K, V]) cluster() bool {
+	_, ok := c.client.(*goredis.ClusterClient)
+	return ok
+}
+
 func ttl(d time.Duration) time.Duration {
 	if d <= 0 {
 		return 0
@@ -3,12 +3,14 @@ package redis_test
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/pthethanh/nano/cache"
 	cacheRedis "github.com/pthethanh/nano/plugins/cache/redis"
+	goredis "github.com/redis/go-redis/v9"
 )
 
 func TestCache(t *testing.T) {
@@ -55,6 +57,32 @@ func TestCacheTimeout(t *testing.T) {
 	}
 }
 
+func TestTTLs(t *testing.T) {
+	s := miniredis.RunT(t)
+	c := cacheRedis.New[string, []byte](
+		cacheRedis.Address[string, []byte](s.Addr()),
+		cacheRedis.CodecOption[string, []byte](cacheRedis.BytesCodec{}),
+	)
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	if err := c.Set(context.Background(), "expiring", []byte("v"), cache.TTL(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(context.Background(), "persistent", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	ttls, err := c.TTLs(context.Background(), []string{"expiring", "persistent", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ttls["persistent"]; ok || len(ttls) != 2 || ttls["expiring"] != time.Minute || ttls["missing"] != 0 {
+		t.Errorf("TTLs() = %v, want expiring: 1m and missing: 0 only", ttls)
+	}
+}
+
 func TestCacheDelete(t *testing.T) {
 	s := miniredis.RunT(t)
 
@@ -121,3 +149,30 @@ func TestGenericCacheWithJSONCodec(t *testing.T) {
 		t.Fatalf("got result=%v, want result=%v", got, want)
 	}
 }
+
+func TestGetMany_ClusterReturnsTheErrorsOfEveryKey(t *testing.T) {
+	s := miniredis.RunT(t)
+	c := cacheRedis.New(
+		cacheRedis.Client[string, string](goredis.NewClusterClient(&goredis.ClusterOptions{Addrs: []string{s.Addr()}})),
+	)
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if err := c.Set(context.Background(), "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	// GET of a list fails; the missing key failing first must not hide it.
+	if _, err := s.Lpush("list", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.GetMany(context.Background(), []string{"missing", "list", "k"})
+	if err == nil || !strings.Contains(err.Error(), "get list") || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Fatalf("GetMany() error = %v, want the WRONGTYPE error of list", err)
+	}
+	rs, err := c.GetMany(context.Background(), []string{"missing", "k"})
+	if err != nil || len(rs) != 1 || rs["k"] != "v" {
+		t.Fatalf("GetMany() = %v, %v, want k only", rs, err)
+	}
+}